@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/api"
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	"golang.org/x/term"
+)
+
+// exportSigner signs requests to export the wallet at address of account with
+// VAULT_EXPORT_SECRET_KEY.
+func exportSigner(account string, address common.Address) (requestSigner, error) {
+	key := auth.AuthSecretKey(strings.TrimSpace(os.Getenv("VAULT_EXPORT_SECRET_KEY")))
+	if key == "" {
+		return nil, fmt.Errorf("VAULT_EXPORT_SECRET_KEY not configured, it is required to export wallets")
+	}
+
+	return func(req *http.Request, body []byte) error {
+		if signature, err := key.Sign(time.Now(), auth.ExportSignatureData(req.Method, req.URL.EscapedPath(), account, address, body)); err != nil {
+			return err
+		} else {
+			req.Header.Add("X-Vault-Export-Signature", signature.String())
+			return nil
+		}
+	}, nil
+}
+
+// readLine prompts on stderr and reads a line from stdin. The line isn't echoed
+// when stdin is a terminal, as it holds a passphrase or an unseal share.
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		line, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(line), err
+	} else if line, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil && line == "" {
+		return "", err
+	} else {
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// runExport writes the private key of a wallet to a V3 keystore file. The
+// passphrase is read from stdin, which keeps it out of the shell history.
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := flags.String("addr", defaultAddr(), "address of the running vault")
+	account := flags.String("account", "", "account of the wallet")
+	out := flags.String("out", "", "keystore file to write, defaults to the wallet address with a .json extension")
+	flags.Parse(args)
+
+	if *account == "" || !common.IsHexAddress(flags.Arg(0)) {
+		log.Fatal("usage: vault export -account <account> [-out <file>] <address>")
+	}
+	address := common.HexToAddress(flags.Arg(0))
+
+	if *out == "" {
+		*out = address.Hex() + ".json"
+	}
+	if _, err := os.Stat(*out); err == nil {
+		log.Fatalf("%s already exists", *out)
+	}
+
+	signer, err := exportSigner(*account, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passphrase, err := readLine("Keystore passphrase: ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if res, err := adminRequest[api.ExportWalletResponse](*addr, "POST", fmt.Sprintf("/accounts/%s/wallets/%s/export", *account, address.Hex()), api.ExportWalletRequest{Passphrase: passphrase}, signer); err != nil {
+		log.Fatal(err)
+	} else if err := os.WriteFile(*out, res.Keystore, 0600); err != nil {
+		log.Fatalf("unable to write keystore: %v", err)
+	} else {
+		log.Printf("🔑 exported wallet %s to %s", res.Address.Hex(), *out)
+	}
+}
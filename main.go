@@ -10,6 +10,9 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/grexie/signchain-vault/v2/pkg/api"
 	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	"github.com/grexie/signchain-vault/v2/pkg/kek"
+	kekinterfaces "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/rotation"
 	"github.com/grexie/signchain-vault/v2/pkg/signer"
 	"github.com/grexie/signchain-vault/v2/pkg/storage"
 	"github.com/grexie/signchain-vault/v2/pkg/tls"
@@ -32,6 +35,57 @@ func main() {
 	}
 	loadEnv(".env." + os.Getenv("ENV") + ".local", ".env." + os.Getenv("ENV"), ".env.local", ".env")
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "rotate":
+			runRotate(os.Args[2:])
+			return
+		case "rewrap":
+			runRewrap(os.Args[2:])
+			return
+		case "upgrade":
+			runUpgrade(os.Args[2:])
+			return
+		case "init":
+			runInit(os.Args[2:])
+			return
+		case "unseal":
+			runUnseal(os.Args[2:])
+			return
+		case "seal":
+			runSeal(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		default:
+			log.Fatalf("unknown command: %s", os.Args[1])
+		}
+	}
+
+	serve()
+}
+
+// sealer returns the key encrypting key provider when it can be sealed, and
+// drops cached keys when it is.
+func sealer(provider kekinterfaces.KeyEncryptingKeyProvider, vault vault.Vault, signer signer.Signer) kekinterfaces.KeyEncryptingKeySealer {
+	if sealer, ok := provider.(kekinterfaces.KeyEncryptingKeySealer); ok {
+		sealer.OnSeal(func() {
+			vault.PurgeCache()
+			signer.PurgeCache()
+		})
+		if sealer.SealStatus().Sealed {
+			log.Printf("🔒 vault is sealed, run \"vault init\" or \"vault unseal\"")
+		}
+		return sealer
+	}
+	return nil
+}
+
+func serve() {
 	port := "443"
 	if p, ok := os.LookupEnv("PORT"); ok {
 		port = p
@@ -39,7 +93,9 @@ func main() {
 
 	if auth, err := auth.NewAuth(); err != nil {
 		log.Fatal(err)
-	} else if vault, err := vault.NewVault(auth); err != nil {
+	} else if kek, err := kek.NewKeyEncryptingKeyProvider(auth); err != nil {
+		log.Fatal(err)
+	} else if vault, err := vault.NewVault(kek); err != nil {
 		log.Fatal(err)
 	} else if storage, err := storage.NewStorage(vault); err != nil {
 		log.Fatal(err)
@@ -47,7 +103,9 @@ func main() {
 		log.Fatal(err)
 	} else if signer, err := signer.NewSigner(vault); err != nil {
 		log.Fatal(err)
-	} else if api, err := api.NewAPI(auth, vault, signer); err != nil {
+	} else if options, err := rotation.NewOptionsFromEnv(); err != nil {
+		log.Fatal(err)
+	} else if api, err := api.NewAPI(auth, vault, signer, rotation.NewJob(vault, storage, options), sealer(kek, vault, signer)); err != nil {
 		log.Fatal(err)
 	} else {
 		app := fiber.New(fiber.Config{
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grexie/signchain-vault/v2/pkg/migrate"
+	"github.com/grexie/signchain-vault/v2/pkg/storage"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/joho/godotenv"
+)
+
+// withEnv calls f with the environment file at filename overriding the current
+// environment, and restores the environment afterwards. Backends and key
+// encrypting key providers read their configuration once when created, so two
+// of them can be configured with the same variables.
+func withEnv(filename string, f func() error) error {
+	env, err := godotenv.Read(filename)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", filename, err)
+	}
+
+	previous := map[string]*string{}
+	for k, v := range env {
+		if p, ok := os.LookupEnv(k); ok {
+			previous[k] = &p
+		} else {
+			previous[k] = nil
+		}
+		os.Setenv(k, v)
+	}
+	defer func() {
+		for k, p := range previous {
+			if p == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *p)
+			}
+		}
+	}()
+
+	return f()
+}
+
+// newStorageFromEnv creates a storage backend configured by the environment
+// file at filename, so both backends of a migration can use the same
+// variables.
+func newStorageFromEnv(filename string) (interfaces.IStorageBackend, error) {
+	var backend interfaces.IStorageBackend
+
+	// records are copied without decrypting them, so no vault service is needed
+	err := withEnv(filename, func() (err error) {
+		backend, err = storage.NewStorage(nil)
+		return
+	})
+	return backend, err
+}
+
+// replayProtectionEnabled reports whether VAULT_REPLAY_WINDOW enables the
+// replay protection ledger, in the environment or the environment file of the
+// source backend.
+func replayProtectionEnabled(filename string) (bool, error) {
+	var enabled bool
+
+	err := withEnv(filename, func() error {
+		if s := strings.TrimSpace(os.Getenv("VAULT_REPLAY_WINDOW")); s == "" {
+			return nil
+		} else if d, err := time.ParseDuration(s); err != nil {
+			return fmt.Errorf("invalid VAULT_REPLAY_WINDOW %s", s)
+		} else {
+			enabled = d > 0
+			return nil
+		}
+	})
+	return enabled, err
+}
+
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "environment file configuring the source storage backend")
+	to := flags.String("to", "", "environment file configuring the target storage backend")
+	checkpoint := flags.String("checkpoint", "migrate.checkpoint", "file recording progress, used to resume an interrupted migration")
+	batch := flags.Int64("batch", 100, "number of records read from the source at a time")
+	verifyOnly := flags.Bool("verify-only", false, "only compare the source and target backends")
+	withoutLedger := flags.Bool("without-ledger", false, "migrate although the replay protection ledger is enabled, signatures issued within VAULT_REPLAY_WINDOW can then be issued again by the target")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// issued signatures are not copied, so the target would sign a uniq again
+	// within the replay window
+	if !*verifyOnly && !*withoutLedger {
+		if enabled, err := replayProtectionEnabled(*from); err != nil {
+			log.Fatal(err)
+		} else if enabled {
+			log.Fatal("the replay protection ledger is enabled with VAULT_REPLAY_WINDOW and is not migrated, stop signing for the window before migrating and pass -without-ledger")
+		}
+	}
+
+	ctx := context.Background()
+
+	if source, err := newStorageFromEnv(*from); err != nil {
+		log.Fatal(err)
+	} else if target, err := newStorageFromEnv(*to); err != nil {
+		log.Fatal(err)
+	} else if m, err := migrate.NewMigration(source, target, *checkpoint, *batch); err != nil {
+		log.Fatal(err)
+	} else {
+		if !*verifyOnly {
+			if err := m.Run(ctx); err != nil {
+				log.Fatalf("migration interrupted, run again to resume: %v", err)
+			}
+		}
+
+		if report, err := m.Verify(ctx); err != nil {
+			log.Fatal(err)
+		} else {
+			for _, mismatch := range report.Mismatches {
+				log.Printf("mismatch %s: %s", mismatch.ID, mismatch.Reason)
+			}
+
+			log.Printf("source has %d data encrypting keys, %d wallets and %d seeds, target has %d data encrypting keys, %d wallets and %d seeds", report.SourceKeys, report.SourceWallets, report.SourceSeeds, report.TargetKeys, report.TargetWallets, report.TargetSeeds)
+
+			if !report.OK() {
+				log.Fatal("verification failed")
+			}
+
+			log.Printf("✅ verified %d data encrypting keys, %d wallets and %d seeds", report.CheckedKeys, report.CheckedWallets, report.CheckedSeeds)
+		}
+	}
+}
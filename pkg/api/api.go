@@ -7,6 +7,8 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
 	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/rotation"
 	"github.com/grexie/signchain-vault/v2/pkg/signer"
 	"github.com/grexie/signchain-vault/v2/pkg/vault"
 )
@@ -20,12 +22,16 @@ type api struct {
 	auth auth.Auth
 	vault vault.Vault
 	signer signer.Signer
+	rotation *rotation.Job
+	sealer kek.KeyEncryptingKeySealer
 }
 
 var _ API = &api{}
 
-func NewAPI(auth auth.Auth, vault vault.Vault, signer signer.Signer) (API, error) {
-	a := api{auth: auth, vault: vault, signer: signer}
+// NewAPI creates the API. sealer is nil when the key encrypting key provider
+// can't be sealed.
+func NewAPI(auth auth.Auth, vault vault.Vault, signer signer.Signer, rotation *rotation.Job, sealer kek.KeyEncryptingKeySealer) (API, error) {
+	a := api{auth: auth, vault: vault, signer: signer, rotation: rotation, sealer: sealer}
 
 	a.app = fiber.New(fiber.Config{
 		DisableStartupMessage: true,
@@ -46,16 +52,36 @@ func NewAPI(auth auth.Auth, vault vault.Vault, signer signer.Signer) (API, error
 		},
 	})
 
-	a.app.Post("/accounts/:account/wallets/:address/sign", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.Sign)
+	a.app.Post("/accounts/:account/wallets/:address/sign", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.RequireUnsealed, a.Sign)
+	a.app.Post("/accounts/:account/wallets/:address/sign-typed-data", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.RequireUnsealed, a.SignTypedData)
+	a.app.Post("/accounts/:account/wallets/:address/sign-message", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.RequireUnsealed, a.SignMessage)
+	a.app.Post("/accounts/:account/wallets/:address/sign-hash", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.RequireUnsealed, a.SignHash)
+	a.app.Post("/accounts/:account/wallets/:address/sign-transaction", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.RequireUnsealed, a.SignTransaction)
+	a.app.Get("/accounts/:account/wallets/:address/signatures/:sender/:uniq", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.GetIssuedSignature)
+	a.app.Get("/accounts/:account/wallets/:address/verifier", a.auth.RequireVaultKey, a.GetVerifier)
+	a.app.Post("/accounts/:account/rpc", a.auth.RequireRPCAuth, a.RPC)
 
-	a.app.Post("/accounts/:account/wallets", a.auth.RequireVaultKey, a.CreateWallet)
+	a.app.Post("/accounts/:account/wallets", a.auth.RequireVaultKey, a.RequireUnsealed, a.CreateWallet)
+	a.app.Post("/accounts/:account/wallets/derive", a.auth.RequireVaultKey, a.RequireUnsealed, a.DeriveWallets)
+	a.app.Post("/accounts/:account/wallets/import", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.RequireUnsealed, a.ImportWallet)
 	a.app.Get("/accounts/:account/wallets/:address", a.auth.RequireVaultKey, a.GetWallet)
 	a.app.Get("/accounts/:account/wallets", a.auth.RequireVaultKey, a.ListWallets)
 	a.app.Put("/accounts/:account/wallets/:address", a.auth.RequireVaultKey, a.UpdateWallet)
+	a.app.Put("/accounts/:account/wallets/:address/permissions", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.UpdateWalletPermissions)
 	a.app.Post("/accounts/:account/wallets/:address/expire", a.auth.RequireVaultKey, a.ExpireWallet)
 	a.app.Post("/accounts/:account/wallets/:address/unexpire", a.auth.RequireVaultKey, a.UnexpireWallet)
+	a.app.Post("/accounts/:account/wallets/:address/export", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireExportSignature, a.RequireUnsealed, a.ExportWallet)
 	a.app.Get("/accounts/:account/status", a.auth.RequireVaultKey, a.Status)
 
+	a.app.Post("/admin/rotation", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.StartRotation)
+	a.app.Get("/admin/rotation", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.GetRotation)
+	a.app.Delete("/admin/rotation", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.StopRotation)
+
+	a.app.Post("/admin/init", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.Init)
+	a.app.Post("/admin/unseal", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.Unseal)
+	a.app.Post("/admin/seal", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.Seal)
+	a.app.Get("/admin/seal", a.auth.RequireVaultKey, a.auth.RequireAuthSignature, a.auth.RequireOperatorSignature, a.GetSealStatus)
+
 	return &a, nil
 }
 
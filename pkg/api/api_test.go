@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/local"
+	"github.com/grexie/signchain-vault/v2/pkg/signer"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/memory"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+const (
+	testVaultKey = auth.VaultKey("vault-key-for-tests-0123456789abcdef")
+	testRPCToken = "rpc-token-for-account-0123456789abcdef"
+	testOtherRPCToken = "rpc-token-for-other-0123456789abcdef"
+)
+
+// newTestAPI returns an API over an unsealed vault with memory storage, with
+// a wallet in account and another in other. Requests are authenticated with
+// testVaultKey, or on the rpc endpoint with the token of their account.
+func newTestAPI(t *testing.T) (*api, kek.KeyEncryptingKeySealer, common.Address, common.Address) {
+	ctx := context.Background()
+
+	t.Setenv("VAULT_KEY", testVaultKey.String())
+	t.Setenv("VAULT_AUTH_SECRET_KEY", "")
+	t.Setenv("VAULT_EXPORT_SECRET_KEY", "")
+	t.Setenv("VAULT_OPERATOR_SECRET_KEY", "")
+	t.Setenv("VAULT_RPC_TOKENS", "account:" + testRPCToken + ",other:" + testOtherRPCToken)
+	t.Setenv("VAULT_REPLAY_WINDOW", "")
+	t.Setenv("VAULT_LOCAL_SEAL_FILE", filepath.Join(t.TempDir(), "seal.json"))
+	t.Setenv("VAULT_LOCAL_KEK_ID", "")
+
+	a, err := auth.NewAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := local.NewSealedKeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer := provider.(kek.KeyEncryptingKeySealer)
+	if shares, err := sealer.Init(3, 2); err != nil {
+		t.Fatal(err)
+	} else if _, err := sealer.Unseal(shares[0]); err != nil {
+		t.Fatal(err)
+	} else if _, err := sealer.Unseal(shares[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vault.NewVault(provider)
+	if err != nil {
+		t.Fatal(err)
+	} else if storage, err := memory.NewMemoryStorageBackend(v); err != nil {
+		t.Fatal(err)
+	} else if err := v.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	wallet, err := v.CreateWallet(ctx, "account", "wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := v.CreateWallet(ctx, "other", "wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := signer.NewSigner(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer.OnSeal(func() {
+		v.PurgeCache()
+		s.PurgeCache()
+	})
+
+	out, err := NewAPI(a, v, s, nil, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.(*api), sealer, wallet.Address(), other.Address()
+}
+
+// newRequest returns a request of body to path, signed with testVaultKey
+// unless a bearer token is given.
+func newRequest(t *testing.T, method string, path string, token string, body []byte) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer " + token)
+	} else if signature, err := testVaultKey.Sign(time.Now(), body); err != nil {
+		t.Fatal(err)
+	} else {
+		req.Header.Set("X-Vault-Key-Hash", testVaultKey.HashString())
+		req.Header.Set("X-Vault-Signature", signature.String())
+	}
+	return req
+}
+
+// send returns the status and body of the response to req.
+func send(t *testing.T, a *api, req *http.Request) (int, []byte) {
+	t.Helper()
+
+	if res, err := a.App().Test(req, -1); err != nil {
+		t.Fatal(err)
+		return 0, nil
+	} else if b, err := io.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+		return 0, nil
+	} else {
+		return res.StatusCode, b
+	}
+}
+
+func request(t *testing.T, a *api, method string, path string, token string, body []byte) (int, []byte) {
+	t.Helper()
+
+	return send(t, a, newRequest(t, method, path, token, body))
+}
+
+func decode[T any](t *testing.T, b []byte) T {
+	t.Helper()
+
+	var out T
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	return out
+}
+
+// requireRecovers checks that signature, r, s and v with v of 27 or 28, is a
+// signature of digest by address.
+func requireRecovers(t *testing.T, digest []byte, signature []byte, address common.Address) {
+	t.Helper()
+
+	signature = append([]byte{}, signature...)
+	signature[64] -= 27
+	if publicKey, err := crypto.SigToPub(digest, signature); err != nil {
+		t.Fatal(err)
+	} else if recovered := crypto.PubkeyToAddress(*publicKey); recovered != address {
+		t.Fatalf("expected the signature to recover to %s, got %s", address, recovered)
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type ExportWalletRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type ExportWalletResponse struct {
+	Address common.Address `json:"address"`
+	Keystore json.RawMessage `json:"keystore"`
+}
+
+// ExportWallet returns the private key of a wallet in a V3 keystore. Exports
+// are logged with an [export] prefix, whether or not they succeed, so that
+// they can be alerted on.
+func (a *api) ExportWallet(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+	var req ExportWalletRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if keystore, err := a.vault.ExportWallet(c.UserContext(), account, address, req.Passphrase); err != nil {
+		log.Warnf("[export] failed to export wallet %s of account %s to %s: %v", address, account, c.IP(), err)
+		return err
+	} else {
+		log.Warnf("[export] exported wallet %s of account %s to %s", address, account, c.IP())
+		return c.JSON(interop.NewResponse(ExportWalletResponse{Address: address, Keystore: keystore}))
+	}
+}
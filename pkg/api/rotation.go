@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	"github.com/grexie/signchain-vault/v2/pkg/rotation"
+)
+
+type RotationResponse = rotation.Status
+
+func (a *api) StartRotation(c *fiber.Ctx) error {
+	if status, err := a.rotation.Start(); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(status))
+	}
+}
+
+func (a *api) GetRotation(c *fiber.Ctx) error {
+	return c.JSON(interop.NewResponse(a.rotation.Status()))
+}
+
+func (a *api) StopRotation(c *fiber.Ctx) error {
+	return c.JSON(interop.NewResponse(a.rotation.Stop()))
+}
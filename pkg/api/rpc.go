@@ -0,0 +1,278 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/signer"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams = -32602
+	rpcServerError = -32000
+)
+
+type RPCRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID json.RawMessage `json:"id,omitempty"`
+	Method string `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type RPCError struct {
+	Code int `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+type RPCResponse struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID json.RawMessage `json:"id"`
+	Result any `json:"result,omitempty"`
+	Error *RPCError `json:"error,omitempty"`
+}
+
+type rpcMethod func(a *api, ctx context.Context, account interfaces.ID, params []json.RawMessage) (any, error)
+
+// rpcMethods are the signing methods of the Ethereum JSON-RPC API, as served by
+// Clef and Web3Signer, against the wallets of an account.
+var rpcMethods = map[string]rpcMethod{
+	"eth_accounts": (*api).rpcAccounts,
+	"eth_sign": (*api).rpcSign,
+	"personal_sign": (*api).rpcPersonalSign,
+	"eth_signTransaction": (*api).rpcSignTransaction,
+	"eth_signTypedData_v4": (*api).rpcSignTypedData,
+}
+
+func invalidParams(format string, args ...any) error {
+	return &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// toRPCError maps vault errors to JSON-RPC errors, bad requests being invalid
+// params and anything else a server error.
+func toRPCError(err error) *RPCError {
+	var r *RPCError
+	var e *fiber.Error
+
+	if errors.As(err, &r) {
+		return r
+	} else if errors.As(err, &e) && e.Code == fiber.StatusBadRequest {
+		return &RPCError{Code: rpcInvalidParams, Message: e.Message}
+	} else {
+		return &RPCError{Code: rpcServerError, Message: err.Error()}
+	}
+}
+
+// RPC serves a JSON-RPC 2.0 request or batch of requests for an account, so
+// that the vault can be configured as the external signer of a node or used
+// from tooling which speaks JSON-RPC. Errors are returned in the response body
+// with a 200 status, as JSON-RPC requires.
+func (a *api) RPC(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	body := bytes.TrimSpace(c.Body())
+
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return c.JSON(RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &RPCError{Code: rpcParseError, Message: err.Error()}})
+		} else if len(batch) == 0 {
+			return c.JSON(RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &RPCError{Code: rpcInvalidRequest, Message: "empty batch"}})
+		}
+
+		out := []*RPCResponse{}
+		for _, req := range batch {
+			if res := a.rpcCall(c.UserContext(), account, req); res != nil {
+				out = append(out, res)
+			}
+		}
+
+		if len(out) == 0 {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		return c.JSON(out)
+	} else if res := a.rpcCall(c.UserContext(), account, body); res != nil {
+		return c.JSON(res)
+	} else {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// rpcCall serves a single request, returning nil for notifications, which
+// have no id and expect no response.
+func (a *api) rpcCall(ctx context.Context, account interfaces.ID, b []byte) *RPCResponse {
+	var req RPCRequest
+
+	if err := json.Unmarshal(b, &req); err != nil {
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return &RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &RPCError{Code: rpcParseError, Message: err.Error()}}
+		}
+		return &RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &RPCError{Code: rpcInvalidRequest, Message: err.Error()}}
+	}
+
+	res := RPCResponse{JSONRPC: "2.0", ID: req.ID}
+	if res.ID == nil {
+		res.ID = json.RawMessage("null")
+	}
+
+	var params []json.RawMessage
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		res.Error = &RPCError{Code: rpcInvalidRequest, Message: "invalid request"}
+	} else if method, ok := rpcMethods[req.Method]; !ok {
+		res.Error = &RPCError{Code: rpcMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+	} else if req.Method != "eth_accounts" && a.sealer != nil && a.sealer.SealStatus().Sealed {
+		res.Error = &RPCError{Code: rpcServerError, Message: "vault is sealed"}
+	} else if len(req.Params) > 0 && string(req.Params) != "null" && json.Unmarshal(req.Params, &params) != nil {
+		res.Error = &RPCError{Code: rpcInvalidParams, Message: "params must be an array"}
+	} else if result, err := method(a, ctx, account, params); err != nil {
+		log.Debugf("rpc %s for account %s failed: %v", req.Method, account, err)
+		res.Error = toRPCError(err)
+	} else {
+		res.Result = result
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	return &res
+}
+
+// parseParams decodes positional params into out, requiring the first
+// required params to be present.
+func parseParams(params []json.RawMessage, required int, out ...any) error {
+	if len(params) < required {
+		return invalidParams("missing value for required argument %d", len(params))
+	} else if len(params) > len(out) {
+		return invalidParams("too many arguments, want at most %d", len(out))
+	}
+
+	for i, p := range params {
+		if err := json.Unmarshal(p, out[i]); err != nil {
+			return invalidParams("invalid argument %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (a *api) rpcAccounts(ctx context.Context, account interfaces.ID, params []json.RawMessage) (any, error) {
+	const pageSize = 100
+
+	addresses := []common.Address{}
+
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	for offset := int64(0); ; offset += pageSize {
+		if r, err := a.vault.ListWallets(ctx, account, offset, pageSize); err != nil {
+			return nil, err
+		} else {
+			for _, w := range r.Page() {
+				addresses = append(addresses, w.Address())
+			}
+
+			if len(r.Page()) < pageSize {
+				return addresses, nil
+			}
+		}
+	}
+}
+
+// rpcSign signs data as personal_sign does, as eth_sign has been prefixed
+// since EIP-191.
+func (a *api) rpcSign(ctx context.Context, account interfaces.ID, params []json.RawMessage) (any, error) {
+	var address common.Address
+	var data hexutil.Bytes
+
+	if err := parseParams(params, 2, &address, &data); err != nil {
+		return nil, err
+	} else if res, err := a.signer.SignMessage(ctx, account, address, data); err != nil {
+		return nil, err
+	} else {
+		return res.Signature, nil
+	}
+}
+
+// rpcPersonalSign signs a hex encoded message, or a UTF-8 message as some
+// wallets send them. The optional password is ignored.
+func (a *api) rpcPersonalSign(ctx context.Context, account interfaces.ID, params []json.RawMessage) (any, error) {
+	var message string
+	var address common.Address
+	var password string
+
+	if err := parseParams(params, 2, &message, &address, &password); err != nil {
+		return nil, err
+	}
+
+	data := []byte(message)
+	if strings.HasPrefix(message, "0x") {
+		if b, err := hexutil.Decode(message); err == nil {
+			data = b
+		}
+	}
+
+	if res, err := a.signer.SignMessage(ctx, account, address, data); err != nil {
+		return nil, err
+	} else {
+		return res.Signature, nil
+	}
+}
+
+type rpcTransactionArgs struct {
+	From *common.Address `json:"from"`
+	signer.TransactionArgs
+}
+
+func (a *api) rpcSignTransaction(ctx context.Context, account interfaces.ID, params []json.RawMessage) (any, error) {
+	var args rpcTransactionArgs
+
+	if err := parseParams(params, 1, &args); err != nil {
+		return nil, err
+	} else if args.From == nil {
+		return nil, invalidParams("from is required")
+	} else {
+		return a.signer.SignTransaction(ctx, account, *args.From, args.TransactionArgs)
+	}
+}
+
+// rpcSignTypedData signs typed data given as a JSON object, or as a JSON
+// encoded string as MetaMask sends it.
+func (a *api) rpcSignTypedData(ctx context.Context, account interfaces.ID, params []json.RawMessage) (any, error) {
+	var address common.Address
+	var raw json.RawMessage
+
+	if err := parseParams(params, 2, &address, &raw); err != nil {
+		return nil, err
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		raw = json.RawMessage(s)
+	}
+
+	if typedData, err := signer.ParseTypedData(raw); err != nil {
+		return nil, err
+	} else if res, err := a.signer.SignTypedData(ctx, account, address, typedData); err != nil {
+		return nil, err
+	} else {
+		return res.Signature, nil
+	}
+}
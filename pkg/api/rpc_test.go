@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+func rpcRequest(method string, id int, params ...any) string {
+	b, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	return string(b)
+}
+
+func TestRPC(t *testing.T) {
+	a, sealer, wallet, _ := newTestAPI(t)
+
+	call := func(t *testing.T, body string) RPCResponse {
+		t.Helper()
+
+		if status, b := request(t, a, "POST", "/accounts/account/rpc", testRPCToken, []byte(body)); status != fiber.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, b)
+			return RPCResponse{}
+		} else {
+			return decode[RPCResponse](t, b)
+		}
+	}
+
+	t.Run("eth_accounts", func(t *testing.T) {
+		if res := call(t, rpcRequest("eth_accounts", 1)); res.Error != nil {
+			t.Fatal(res.Error)
+		} else if got, _ := json.Marshal(res.Result); string(got) != `["` + strings.ToLower(wallet.Hex()) + `"]` {
+			t.Fatalf("expected the wallets of the account, got %s", got)
+		}
+	})
+
+	t.Run("personal_sign", func(t *testing.T) {
+		// a hex message is decoded, a UTF-8 message is signed as is
+		for _, message := range []string{hexutil.Encode([]byte("hello")), "hello"} {
+			if res := call(t, rpcRequest("personal_sign", 1, message, wallet)); res.Error != nil {
+				t.Fatal(res.Error)
+			} else if signature, err := hexutil.Decode(res.Result.(string)); err != nil {
+				t.Fatal(err)
+			} else {
+				requireRecovers(t, accounts.TextHash([]byte("hello")), signature, wallet)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, c := range map[string]struct {
+			body string
+			code int
+		}{
+			"malformed json": {`{"jsonrpc": "2.0", "id": 1, "method": `, rpcParseError},
+			"unknown method": {rpcRequest("eth_sendTransaction", 1), rpcMethodNotFound},
+			"missing version": {`{"id": 1, "method": "eth_accounts"}`, rpcInvalidRequest},
+			"missing params": {rpcRequest("personal_sign", 1, "hello"), rpcInvalidParams},
+			"params not an array": {`{"jsonrpc": "2.0", "id": 1, "method": "personal_sign", "params": {}}`, rpcInvalidParams},
+			"unknown wallet": {rpcRequest("personal_sign", 1, "hello", common.HexToAddress("0x01")), rpcServerError},
+		} {
+			if res := call(t, c.body); res.Error == nil || res.Error.Code != c.code {
+				t.Errorf("expected %s to fail with code %d, got %+v", name, c.code, res.Error)
+			}
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		body := "[" + strings.Join([]string{
+			rpcRequest("personal_sign", 1, "hello", wallet),
+			`{"jsonrpc": "2.0", "method": "eth_accounts"}`,
+			rpcRequest("eth_sendTransaction", 3),
+		}, ",") + "]"
+
+		status, b := request(t, a, "POST", "/accounts/account/rpc", testRPCToken, []byte(body))
+		if status != fiber.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, b)
+		}
+
+		// the notification gets no response
+		res := decode[[]RPCResponse](t, b)
+		if len(res) != 2 {
+			t.Fatalf("expected 2 responses, got %d: %s", len(res), b)
+		} else if string(res[0].ID) != "1" || res[0].Error != nil {
+			t.Fatalf("expected a signature for request 1, got %s", b)
+		} else if string(res[1].ID) != "3" || res[1].Error == nil || res[1].Error.Code != rpcMethodNotFound {
+			t.Fatalf("expected request 3 to fail with code %d, got %s", rpcMethodNotFound, b)
+		}
+
+		if status, b := request(t, a, "POST", "/accounts/account/rpc", testRPCToken, []byte("[]")); status != fiber.StatusOK || decode[RPCResponse](t, b).Error.Code != rpcInvalidRequest {
+			t.Fatalf("expected an empty batch to fail with code %d, got %s", rpcInvalidRequest, b)
+		}
+	})
+
+	t.Run("notification", func(t *testing.T) {
+		if status, b := request(t, a, "POST", "/accounts/account/rpc", testRPCToken, []byte(`{"jsonrpc": "2.0", "method": "eth_accounts"}`)); status != fiber.StatusNoContent || len(b) != 0 {
+			t.Fatalf("expected status %d with no body, got %d: %s", fiber.StatusNoContent, status, b)
+		}
+	})
+
+	t.Run("sealed", func(t *testing.T) {
+		sealer.Seal()
+
+		if res := call(t, rpcRequest("personal_sign", 1, "hello", wallet)); res.Error == nil || res.Error.Code != rpcServerError || res.Error.Message != "vault is sealed" {
+			t.Fatalf("expected signing to be refused while sealed, got %+v", res.Error)
+		} else if res := call(t, rpcRequest("eth_accounts", 1)); res.Error != nil {
+			t.Fatalf("expected eth_accounts to be served while sealed, got %+v", res.Error)
+		}
+	})
+}
+
+func TestRequireRPCAuth(t *testing.T) {
+	a, _, wallet, other := newTestAPI(t)
+	body := []byte(rpcRequest("eth_accounts", 1))
+
+	for name, c := range map[string]struct {
+		token string
+		unsigned bool
+		status int
+	}{
+		"token": {testRPCToken, false, fiber.StatusOK},
+		"vault key": {"", false, fiber.StatusOK},
+		"wrong token": {"rpc-token-which-is-not-configured-0123", false, fiber.StatusUnauthorized},
+		"token of another account": {testOtherRPCToken, false, fiber.StatusUnauthorized},
+		"missing token": {"", true, fiber.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := newRequest(t, "POST", "/accounts/account/rpc", c.token, body)
+			if c.unsigned {
+				req.Header.Del("X-Vault-Key-Hash")
+				req.Header.Del("X-Vault-Signature")
+			}
+
+			if status, b := send(t, a, req); status != c.status {
+				t.Fatalf("expected status %d, got %d: %s", c.status, status, b)
+			}
+		})
+	}
+
+	// a token only reaches the wallets of its own account
+	status, b := request(t, a, "POST", "/accounts/other/rpc", testOtherRPCToken, []byte(rpcRequest("personal_sign", 1, "hello", wallet)))
+	if status != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, b)
+	} else if res := decode[RPCResponse](t, b); res.Error == nil {
+		t.Fatalf("expected the wallet of another account not to sign, got %s", b)
+	}
+
+	status, b = request(t, a, "POST", "/accounts/other/rpc", testOtherRPCToken, []byte(rpcRequest("personal_sign", 1, "hello", other)))
+	if status != fiber.StatusOK || decode[RPCResponse](t, b).Error != nil {
+		t.Fatalf("expected the wallet of the account to sign, got %d: %s", status, b)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+type SealStatusResponse = kek.SealStatus
+
+type InitRequest struct {
+	Shares int `json:"shares"`
+	Threshold int `json:"threshold"`
+}
+
+type InitResponse struct {
+	Shares [][]byte `json:"shares"`
+	Status kek.SealStatus `json:"status"`
+}
+
+type UnsealRequest struct {
+	Share []byte `json:"share"`
+}
+
+func (a *api) requireSealer() error {
+	if a.sealer == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "key encrypting key provider can't be sealed, check online documentation for environment variable VAULT_LOCAL_SEAL_FILE")
+	}
+	return nil
+}
+
+// RequireUnsealed refuses requests which need the key encrypting key while the
+// vault is sealed.
+func (a *api) RequireUnsealed(c *fiber.Ctx) error {
+	if a.sealer != nil && a.sealer.SealStatus().Sealed {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "vault is sealed")
+	}
+	return c.Next()
+}
+
+func (a *api) Init(c *fiber.Ctx) error {
+	var req InitRequest
+
+	if err := a.requireSealer(); err != nil {
+		return err
+	} else if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if shares, err := a.sealer.Init(req.Shares, req.Threshold); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(InitResponse{
+			Shares: shares,
+			Status: a.sealer.SealStatus(),
+		}))
+	}
+}
+
+func (a *api) Unseal(c *fiber.Ctx) error {
+	var req UnsealRequest
+
+	if err := a.requireSealer(); err != nil {
+		return err
+	} else if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if status, err := a.sealer.Unseal(req.Share); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(status))
+	}
+}
+
+func (a *api) Seal(c *fiber.Ctx) error {
+	if err := a.requireSealer(); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(a.sealer.Seal()))
+	}
+}
+
+func (a *api) GetSealStatus(c *fiber.Ctx) error {
+	if err := a.requireSealer(); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(a.sealer.SealStatus()))
+	}
+}
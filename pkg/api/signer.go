@@ -1,18 +1,26 @@
 package api
 
 import (
+	"fmt"
+	"math"
+	"time"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
 	"github.com/grexie/signchain-vault/v2/pkg/signer"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 )
 
+// SignRequest signs a call for sender. When TTLSeconds is set the signature
+// carries a deadline ttl seconds from now.
 type SignRequest struct {
 	Sender common.Address `json:"sender"`
 	Uniq string `json:"uniq"`
 	ABI map[string]any `json:"abi"`
 	Args []any `json:"args"`
+	TTLSeconds int64 `json:"ttl,omitempty"`
 }
 
 type SignResponse = signer.SignResult
@@ -23,11 +31,153 @@ func (a *api) Sign(c *fiber.Ctx) error {
 
 	var req SignRequest
 
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid sign request: %v", err))
+	} else if req.TTLSeconds < 0 || req.TTLSeconds > int64(math.MaxInt64 / time.Second) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid ttl %d", req.TTLSeconds))
+	} else if res, err := a.signer.Sign(c.UserContext(), account, req.Sender, req.Uniq, address, req.ABI, req.Args, time.Duration(req.TTLSeconds) * time.Second); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(res))
+	}
+}
+
+type SignTypedDataResponse = signer.DigestSignature
+
+// SignTypedData signs an eth_signTypedData_v4 payload of domain, types,
+// primaryType and message, given as the request body.
+func (a *api) SignTypedData(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+
+	if typedData, err := signer.ParseTypedData(c.Body()); err != nil {
+		return err
+	} else if res, err := a.signer.SignTypedData(c.UserContext(), account, address, typedData); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(res))
+	}
+}
+
+// SignMessageRequest is either a UTF-8 message or hex encoded data, which is
+// signed as personal_sign would sign it.
+type SignMessageRequest struct {
+	Message *string `json:"message,omitempty"`
+	Data *string `json:"data,omitempty"`
+}
+
+type SignMessageResponse = signer.DigestSignature
+
+func (a *api) SignMessage(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+
+	var req SignMessageRequest
+	var message []byte
+
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if (req.Message == nil) == (req.Data == nil) {
+		return fiber.NewError(fiber.StatusBadRequest, "expected one of message or data")
+	} else if req.Message != nil {
+		message = []byte(*req.Message)
+	} else if b, err := hexutil.Decode(*req.Data); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid data: %v", err))
+	} else {
+		message = b
+	}
+
+	if res, err := a.signer.SignMessage(c.UserContext(), account, address, message); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(res))
+	}
+}
+
+type SignHashRequest struct {
+	Hash string `json:"hash"`
+}
+
+type SignHashResponse = signer.DigestSignature
+
+// SignHash signs a 32 byte hex encoded digest as is, for wallets granted the
+// sign-hash permission.
+func (a *api) SignHash(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+
+	var req SignHashRequest
+
 	if err := c.BodyParser(&req); err != nil {
 		return err
-	} else if res, err := a.signer.Sign(c.UserContext(), account, req.Sender, req.Uniq, address, req.ABI, req.Args); err != nil {
+	} else if b, err := hexutil.Decode(req.Hash); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid hash: %v", err))
+	} else if len(b) != common.HashLength {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid hash: expected %d bytes, got %d", common.HashLength, len(b)))
+	} else if res, err := a.signer.SignHash(c.UserContext(), account, address, common.BytesToHash(b)); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(res))
+	}
+}
+
+type SignTransactionRequest = signer.TransactionArgs
+type SignTransactionResponse = signer.SignedTransaction
+
+// SignTransaction signs a legacy, EIP-2930, EIP-1559 or EIP-4844 transaction
+// and returns it RLP encoded with its hash.
+func (a *api) SignTransaction(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+
+	var req SignTransactionRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid transaction: %v", err))
+	} else if res, err := a.signer.SignTransaction(c.UserContext(), account, address, req); err != nil {
 		return err
 	} else {
 		return c.JSON(interop.NewResponse(res))
 	}
-}
\ No newline at end of file
+}
+
+type GetIssuedSignatureResponse = signer.IssuedSignature
+
+// GetIssuedSignature returns the signature previously issued by the wallet to
+// sender for uniq, while replay protection holds it. Uniq is given as it was
+// to Sign.
+func (a *api) GetIssuedSignature(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+
+	if !common.IsHexAddress(c.Params("sender")) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid sender %s", c.Params("sender")))
+	} else if res, err := a.signer.GetIssuedSignature(c.UserContext(), account, address, common.HexToAddress(c.Params("sender")), signer.ParseUniq(c.Params("uniq"))); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(res))
+	}
+}
+
+type GetVerifierResponse struct {
+	Name string `json:"name"`
+	Source string `json:"source"`
+}
+
+// GetVerifier returns a Solidity library for contracts to verify signatures
+// with a deadline issued by the wallet.
+func (a *api) GetVerifier(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+
+	if w, err := a.vault.GetWallet(c.UserContext(), account, address); err != nil {
+		return err
+	} else if source, err := signer.Verifier(w.Address()); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(GetVerifierResponse{
+			Name: "SignchainVerifier.sol",
+			Source: source,
+		}))
+	}
+}
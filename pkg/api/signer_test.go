@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+)
+
+func TestSignTTL(t *testing.T) {
+	a, _, wallet, _ := newTestAPI(t)
+	path := "/accounts/account/wallets/" + wallet.Hex() + "/sign"
+
+	sign := func(ttl any) (int, []byte) {
+		body, _ := json.Marshal(map[string]any{
+			"sender": common.HexToAddress("0x00000000000000000000000000000000000000aa"),
+			"uniq": "0x01",
+			"abi": map[string]any{
+				"name": "mint",
+				"type": "function",
+				"inputs": []any{
+					map[string]any{"name": "to", "type": "address"},
+					map[string]any{"name": "signature", "type": "bytes"},
+				},
+				"outputs": []any{},
+			},
+			"args": []any{"0x000000000000000000000000000000000000bEEF"},
+			"ttl": ttl,
+		})
+		return request(t, a, "POST", path, "", body)
+	}
+
+	before := time.Now().Unix()
+	status, b := sign(60)
+	if status != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", fiber.StatusOK, status, b)
+	}
+
+	res := decode[interop.APIResponse[[]json.RawMessage]](t, b)
+	if len(res.Data) != 2 {
+		t.Fatalf("expected the arguments followed by the signature, got %s", b)
+	} else if sig := decode[struct{ Deadline int64 `json:"deadline"` }](t, res.Data[1]); sig.Deadline < before + 60 || sig.Deadline > time.Now().Unix() + 60 {
+		t.Fatalf("expected a deadline 60 seconds from now, got %d", sig.Deadline)
+	}
+
+	for _, ttl := range []any{-1, int64(math.MaxInt64 / int64(time.Second)) + 1, int64(math.MaxInt64)} {
+		if status, b := sign(ttl); status != fiber.StatusBadRequest {
+			t.Errorf("expected ttl %v to be rejected with status %d, got %d: %s", ttl, fiber.StatusBadRequest, status, b)
+		}
+	}
+
+	// ttl is whole seconds, fractions and strings are not accepted
+	for _, ttl := range []any{1.5, fmt.Sprint(60)} {
+		if status, b := sign(ttl); status != fiber.StatusBadRequest {
+			t.Errorf("expected ttl %#v to be rejected with status %d, got %d: %s", ttl, fiber.StatusBadRequest, status, b)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 	"github.com/carlmjohnson/versioninfo"
 )
@@ -11,6 +12,7 @@ type StatusResponse struct {
 	VaultKeys int `json:"vaultKeys"`
 	Wallets int64 `json:"wallets"`
 	Version string `json:"version"`
+	Seal *kek.SealStatus `json:"seal,omitempty"`
 }
 
 func (a *api) Status(c *fiber.Ctx) error {
@@ -21,10 +23,16 @@ func (a *api) Status(c *fiber.Ctx) error {
 	} else {
 		vaultKeys := len(a.auth.VaultKeys())
 
-		return c.JSON(interop.NewResponse(StatusResponse{
+		res := StatusResponse{
 			VaultKeys: vaultKeys,
 			Wallets: r.Count(),
 			Version: versioninfo.Short(),
-		}))
+		}
+		if a.sealer != nil {
+			seal := a.sealer.SealStatus()
+			res.Seal = &seal
+		}
+
+		return c.JSON(interop.NewResponse(res))
 	}
 }
\ No newline at end of file
@@ -29,6 +29,47 @@ func (a *api) CreateWallet(c *fiber.Ctx) error {
 	}
 }
 
+type DeriveWalletsRequest struct {
+	Name string `json:"name"`
+	Count int64 `json:"count"`
+}
+
+type DeriveWalletsResponse = []vault.Wallet
+
+func (a *api) DeriveWallets(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	var req DeriveWalletsRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if wallets, err := a.vault.DeriveWallets(c.UserContext(), account, req.Name, req.Count); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(wallets))
+	}
+}
+
+type ImportWalletRequest struct {
+	Name string `json:"name"`
+	vault.ImportKey
+}
+
+type ImportWalletResponse = vault.Wallet
+
+func (a *api) ImportWallet(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	var req ImportWalletRequest
+	defer req.Clear()
+
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if w, err := a.vault.ImportWallet(c.UserContext(), account, req.Name, req.ImportKey); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(w))
+	}
+}
+
 func (a *api) GetWallet(c *fiber.Ctx) error {
 	account := interfaces.ID(c.Params("account"))
 	address := common.HexToAddress(c.Params("address"))
@@ -70,6 +111,26 @@ func (a *api) UpdateWallet(c *fiber.Ctx) error {
 	}
 }
 
+type UpdateWalletPermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+// UpdateWalletPermissions replaces the permissions granted to a wallet, which
+// enable signing modes that are off by default.
+func (a *api) UpdateWalletPermissions(c *fiber.Ctx) error {
+	account := interfaces.ID(c.Params("account"))
+	address := common.HexToAddress(c.Params("address"))
+	var req UpdateWalletPermissionsRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	} else if w, err := a.vault.UpdateWalletPermissions(c.UserContext(), account, address, req.Permissions); err != nil {
+		return err
+	} else {
+		return c.JSON(interop.NewResponse(w))
+	}
+}
+
 type ExpireWalletRequest struct {
 	TTL time.Duration `json:"ttl"`
 }
@@ -18,6 +18,7 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 )
@@ -27,6 +28,9 @@ type Auth interface {
 
 	RequireVaultKey(c *fiber.Ctx) error
 	RequireAuthSignature(c *fiber.Ctx) error
+	RequireExportSignature(c *fiber.Ctx) error
+	RequireOperatorSignature(c *fiber.Ctx) error
+	RequireRPCAuth(c *fiber.Ctx) error
 	
 	NewRequest(method string, url string, o any) (*http.Request, error)
 	Get(url string, res any) error
@@ -39,6 +43,16 @@ type Auth interface {
 type auth struct {
 	vaultKeys VaultKeyCollection
 	authSecretKey *AuthSecretKey
+	exportSecretKey *AuthSecretKey
+	operatorSecretKey *AuthSecretKey
+	rpcTokens []rpcToken
+}
+
+// rpcToken is a JSON-RPC bearer token, scoped to an account. Only the hash of
+// the token is kept.
+type rpcToken struct {
+	account string
+	hash [32]byte
 }
 
 var _ Auth = &auth{}
@@ -167,6 +181,15 @@ func (v VaultKey) Verify(now time.Time, data []byte, signature VaultSignature) e
 } 
 
 func (v VaultKey) Sign(timestamp time.Time, data []byte) (VaultSignature, error) {
+	return sign(string(v), timestamp, data)
+}
+
+// Sign creates the X-Vault-Auth-Signature header for data.
+func (k AuthSecretKey) Sign(timestamp time.Time, data []byte) (VaultSignature, error) {
+	return sign(string(k), timestamp, data)
+}
+
+func sign(key string, timestamp time.Time, data []byte) (VaultSignature, error) {
 	var nonce [32]byte
 	var tb [8]byte
 	binary.PutVarint(tb[:], timestamp.UnixMicro())
@@ -178,7 +201,7 @@ func (v VaultKey) Sign(timestamp time.Time, data []byte) (VaultSignature, error)
 		hash.Write(data)
 		hash.Write(nonce[:])
 		hash.Write(tb[:])
-		hash.Write([]byte(v))
+		hash.Write([]byte(key))
 		
 		signature := strings.ToLower(base32.StdEncoding.EncodeToString(nonce[:])) + "." + strings.ToLower(base32.StdEncoding.EncodeToString(tb[:])) + "." + strings.ToLower(base32.StdEncoding.EncodeToString(hash.Sum([]byte{})))
 		
@@ -350,6 +373,14 @@ func (a *auth) Head(url string, _res any) error {
 }
 
 func (a *auth) RequireVaultKey(c *fiber.Ctx) error {
+	if err := a.verifyVaultKey(c); err != nil {
+		return err
+	} else {
+		return c.Next()
+	}
+}
+
+func (a *auth) verifyVaultKey(c *fiber.Ctx) error {
 	vaultKeys := a.vaultKeys
 
 	if len(vaultKeys) == 0 {
@@ -370,7 +401,7 @@ func (a *auth) RequireVaultKey(c *fiber.Ctx) error {
 	} else if err := v.Verify(time.Now(), c.BodyRaw(), VaultSignature(vaultSignature)); err != nil {
 			return fmt.Errorf("invalid vault signature: %s for key hash: %s, %v", vaultSignature, vaultKeyHash, err)
 	} else {
-		return c.Next()
+		return nil
 	}
 }
 
@@ -393,6 +424,45 @@ func NewAuth() (Auth, error) {
 		log.Warn("VAULT_AUTH_SECRET_KEY not configured. It is recommended for self-hosted vaults to configure this.")
 	}
 
+	// the export secret key is a separate credential, so it is never defaulted
+	// and export is disabled without it
+	if exportSecretKey := strings.TrimSpace(os.Getenv("VAULT_EXPORT_SECRET_KEY")); exportSecretKey != "" {
+		exportSecretKey := AuthSecretKey(exportSecretKey)
+		if err := exportSecretKey.Validate(); err != nil {
+			return nil, fmt.Errorf("VAULT_EXPORT_SECRET_KEY is too short, must be at least %d characters", minAuthSecretKeyLength)
+		} else if a.authSecretKey != nil && exportSecretKey == *a.authSecretKey {
+			return nil, fmt.Errorf("VAULT_EXPORT_SECRET_KEY must be different to VAULT_AUTH_SECRET_KEY")
+		}
+		a.exportSecretKey = &exportSecretKey
+	}
+
+	// the operator secret key guards the admin endpoints, which seal, unseal
+	// and rotate keys, so it is kept apart from the keys given to integrations
+	if operatorSecretKey := strings.TrimSpace(os.Getenv("VAULT_OPERATOR_SECRET_KEY")); operatorSecretKey != "" {
+		operatorSecretKey := AuthSecretKey(operatorSecretKey)
+		if err := operatorSecretKey.Validate(); err != nil {
+			return nil, fmt.Errorf("VAULT_OPERATOR_SECRET_KEY is too short, must be at least %d characters", minAuthSecretKeyLength)
+		} else if a.authSecretKey != nil && operatorSecretKey == *a.authSecretKey {
+			return nil, fmt.Errorf("VAULT_OPERATOR_SECRET_KEY must be different to VAULT_AUTH_SECRET_KEY")
+		} else if a.exportSecretKey != nil && operatorSecretKey == *a.exportSecretKey {
+			return nil, fmt.Errorf("VAULT_OPERATOR_SECRET_KEY must be different to VAULT_EXPORT_SECRET_KEY")
+		}
+		a.operatorSecretKey = &operatorSecretKey
+	}
+
+	// VAULT_RPC_TOKENS is a comma separated list of account:token pairs
+	if tokens := strings.TrimSpace(os.Getenv("VAULT_RPC_TOKENS")); tokens != "" {
+		for _, pair := range strings.Split(tokens, ",") {
+			if account, token, ok := strings.Cut(strings.TrimSpace(pair), ":"); !ok || account == "" {
+				return nil, fmt.Errorf("invalid VAULT_RPC_TOKENS entry, expected account:token")
+			} else if len(token) < minAuthSecretKeyLength {
+				return nil, fmt.Errorf("VAULT_RPC_TOKENS token for account %s is too short, must be at least %d characters", account, minAuthSecretKeyLength)
+			} else {
+				a.rpcTokens = append(a.rpcTokens, rpcToken{account: account, hash: sha256.Sum256([]byte(token))})
+			}
+		}
+	}
+
 	vaultKeys := strings.Split(env, ",")
 	a.vaultKeys = make(VaultKeyCollection, len(vaultKeys))
 	for i, k := range vaultKeys {
@@ -407,6 +477,14 @@ func (a *auth) VaultKeys() VaultKeyCollection {
 }
 
 func (a *auth) RequireAuthSignature(c *fiber.Ctx) error {
+	if err := a.verifyAuthSignature(c); err != nil {
+		return err
+	} else {
+		return c.Next()
+	}
+}
+
+func (a *auth) verifyAuthSignature(c *fiber.Ctx) error {
 	authSignature := strings.TrimSpace(c.Get("X-Vault-Auth-Signature"))
 
 	if authSignature != "" {
@@ -415,11 +493,105 @@ func (a *auth) RequireAuthSignature(c *fiber.Ctx) error {
 		} else if err := a.authSecretKey.Verify(time.Now(), c.BodyRaw(), VaultSignature(authSignature)); err != nil {
 			return fmt.Errorf("X-Vault-Auth-Signature: %v", err)
 		} else {
-			return c.Next()
+			return nil
 		}
 	} else if a.authSecretKey != nil {
 		return fmt.Errorf("VAULT_AUTH_SECRET_KEY configured, required X-Vault-Auth-Signature not provided")
+	} else {
+		return nil
+	}
+}
+
+// RequireRPCAuth authenticates JSON-RPC requests. Tooling plugged into a node
+// as an external signer can't sign requests, so a bearer token configured for
+// the account in VAULT_RPC_TOKENS is accepted in place of the vault key and
+// auth signatures. Requests failing either are refused as unauthorized.
+func (a *auth) RequireRPCAuth(c *fiber.Ctx) error {
+	if token, ok := strings.CutPrefix(c.Get("Authorization"), "Bearer "); ok {
+		hash := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		account := c.Params("account")
+
+		for _, t := range a.rpcTokens {
+			if t.account == account && hmac.Equal(t.hash[:], hash[:]) {
+				return c.Next()
+			}
+		}
+
+		log.Warnf("received rpc request from %s with invalid token for account %s", c.IP(), account)
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid rpc token")
+	} else if err := a.verifyVaultKey(c); err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	} else if err := a.verifyAuthSignature(c); err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
 	} else {
 		return c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// ExportSignatureData is the data signed in the X-Vault-Export-Signature
+// header. Besides the body it covers the method, path, account and wallet
+// address, so that a signature can't be replayed against another wallet or
+// endpoint within its validity window.
+func ExportSignatureData(method string, path string, account string, address common.Address, body []byte) []byte {
+	return requestSignatureData(body, method, path, account, strings.ToLower(address.Hex()))
+}
+
+// OperatorSignatureData is the data signed in the X-Vault-Operator-Signature
+// header, which covers the method and path as well as the body.
+func OperatorSignatureData(method string, path string, body []byte) []byte {
+	return requestSignatureData(body, method, path)
+}
+
+func requestSignatureData(body []byte, fields ...string) []byte {
+	var b bytes.Buffer
+	for _, s := range fields {
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	b.Write(body)
+	return b.Bytes()
+}
+
+// RequireExportSignature checks the X-Vault-Export-Signature header, signed
+// with VAULT_EXPORT_SECRET_KEY over ExportSignatureData, which guards endpoints
+// returning private keys. These endpoints are disabled when the export secret
+// key isn't configured.
+func (a *auth) RequireExportSignature(c *fiber.Ctx) error {
+	exportSignature := strings.TrimSpace(c.Get("X-Vault-Export-Signature"))
+
+	if a.exportSecretKey == nil {
+		log.Warnf("[export] rejected export request from %s, VAULT_EXPORT_SECRET_KEY not configured", c.IP())
+		return fiber.NewError(fiber.StatusForbidden, "wallet export disabled, VAULT_EXPORT_SECRET_KEY not configured")
+	} else if exportSignature == "" {
+		log.Warnf("[export] rejected export request from %s with missing header X-Vault-Export-Signature", c.IP())
+		return fiber.NewError(fiber.StatusForbidden, "X-Vault-Export-Signature header not provided")
+	} else if !common.IsHexAddress(c.Params("address")) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid address %s", c.Params("address")))
+	} else if err := a.exportSecretKey.Verify(time.Now(), ExportSignatureData(c.Method(), c.Path(), c.Params("account"), common.HexToAddress(c.Params("address")), c.BodyRaw()), VaultSignature(exportSignature)); err != nil {
+		log.Warnf("[export] rejected export request from %s with invalid X-Vault-Export-Signature", c.IP())
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("X-Vault-Export-Signature: %v", err))
+	} else {
+		return c.Next()
+	}
+}
+
+// RequireOperatorSignature checks the X-Vault-Operator-Signature header,
+// signed with VAULT_OPERATOR_SECRET_KEY over OperatorSignatureData, which
+// guards the admin endpoints. These endpoints are disabled when the operator
+// secret key isn't configured.
+func (a *auth) RequireOperatorSignature(c *fiber.Ctx) error {
+	operatorSignature := strings.TrimSpace(c.Get("X-Vault-Operator-Signature"))
+
+	if a.operatorSecretKey == nil {
+		log.Warnf("[admin] rejected %s %s from %s, VAULT_OPERATOR_SECRET_KEY not configured", c.Method(), c.Path(), c.IP())
+		return fiber.NewError(fiber.StatusForbidden, "admin endpoints disabled, VAULT_OPERATOR_SECRET_KEY not configured")
+	} else if operatorSignature == "" {
+		log.Warnf("[admin] rejected %s %s from %s with missing header X-Vault-Operator-Signature", c.Method(), c.Path(), c.IP())
+		return fiber.NewError(fiber.StatusForbidden, "X-Vault-Operator-Signature header not provided")
+	} else if err := a.operatorSecretKey.Verify(time.Now(), OperatorSignatureData(c.Method(), c.Path(), c.BodyRaw()), VaultSignature(operatorSignature)); err != nil {
+		log.Warnf("[admin] rejected %s %s from %s with invalid X-Vault-Operator-Signature", c.Method(), c.Path(), c.IP())
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("X-Vault-Operator-Signature: %v", err))
+	} else {
+		return c.Next()
+	}
+}
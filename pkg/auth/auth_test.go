@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequireExportSignature(t *testing.T) {
+	key := AuthSecretKey("export-secret-key-for-tests-0123456789")
+	a := &auth{exportSecretKey: &key}
+
+	app := fiber.New()
+	app.Post("/accounts/:account/wallets/:address/export", a.RequireExportSignature, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	wallet := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	other := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	body := []byte(`{"passphrase":"correct horse battery staple"}`)
+
+	sign := func(method string, path string, account string, address common.Address) VaultSignature {
+		signature, err := key.Sign(time.Now(), ExportSignatureData(method, path, account, address, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+
+	path := func(account string, address common.Address) string {
+		return "/accounts/" + account + "/wallets/" + address.Hex() + "/export"
+	}
+
+	tests := map[string]struct {
+		path string
+		signature VaultSignature
+		status int
+	}{
+		"valid": {path("account", wallet), sign("POST", path("account", wallet), "account", wallet), fiber.StatusOK},
+		"other wallet": {path("account", other), sign("POST", path("account", wallet), "account", wallet), fiber.StatusForbidden},
+		"other account": {path("other", wallet), sign("POST", path("account", wallet), "account", wallet), fiber.StatusForbidden},
+		"other method": {path("account", wallet), sign("GET", path("account", wallet), "account", wallet), fiber.StatusForbidden},
+		"missing": {path("account", wallet), "", fiber.StatusForbidden},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", test.path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.signature != "" {
+				req.Header.Set("X-Vault-Export-Signature", test.signature.String())
+			}
+
+			if res, err := app.Test(req); err != nil {
+				t.Fatal(err)
+			} else if res.StatusCode != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRequireOperatorSignature(t *testing.T) {
+	key := AuthSecretKey("operator-secret-key-for-tests-0123456789")
+	body := []byte(`{"shares":5,"threshold":3}`)
+
+	newApp := func(a *auth) *fiber.App {
+		app := fiber.New()
+		app.Post("/admin/init", a.RequireOperatorSignature, func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+		return app
+	}
+
+	sign := func(method string, path string) VaultSignature {
+		signature, err := key.Sign(time.Now(), OperatorSignatureData(method, path, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+
+	tests := map[string]struct {
+		auth *auth
+		signature VaultSignature
+		status int
+	}{
+		"valid": {&auth{operatorSecretKey: &key}, sign("POST", "/admin/init"), fiber.StatusOK},
+		"other path": {&auth{operatorSecretKey: &key}, sign("POST", "/admin/seal"), fiber.StatusForbidden},
+		"missing": {&auth{operatorSecretKey: &key}, "", fiber.StatusForbidden},
+		"not configured": {&auth{}, sign("POST", "/admin/init"), fiber.StatusForbidden},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/admin/init", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.signature != "" {
+				req.Header.Set("X-Vault-Operator-Signature", test.signature.String())
+			}
+
+			if res, err := newApp(test.auth).Test(req); err != nil {
+				t.Fatal(err)
+			} else if res.StatusCode != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, res.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Package hd derives private keys from a seed following BIP-32, as used by
+// wallets created from a BIP-39 mnemonic.
+package hd
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// HardenedOffset is added to the index of hardened children.
+const HardenedOffset = 0x80000000
+
+// DefaultPath is the path of the first Ethereum account of a mnemonic.
+const DefaultPath = "m/44'/60'/0'/0/0"
+
+// Path is a list of child indexes from the master key.
+type Path []uint32
+
+// AddressPath returns the BIP-44 path of the Ethereum address at index in the
+// first account, m/44'/60'/0'/0/index.
+func AddressPath(index uint32) Path {
+	return Path{44 + HardenedOffset, 60 + HardenedOffset, HardenedOffset, 0, index}
+}
+
+// ParsePath parses a derivation path such as m/44'/60'/0'/0/0, where hardened
+// indexes are marked with ' or h.
+func ParsePath(s string) (Path, error) {
+	components := strings.Split(strings.TrimSpace(s), "/")
+	if len(components) < 2 || components[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %s, expected m/...", s)
+	}
+
+	path := make(Path, 0, len(components) - 1)
+	for _, c := range components[1:] {
+		var offset uint32
+		if strings.HasSuffix(c, "'") || strings.HasSuffix(c, "h") {
+			offset = HardenedOffset
+			c = c[:len(c) - 1]
+		}
+
+		if index, err := strconv.ParseUint(strings.TrimSpace(c), 10, 32); err != nil || index >= HardenedOffset {
+			return nil, fmt.Errorf("invalid derivation path %s", s)
+		} else {
+			path = append(path, uint32(index) + offset)
+		}
+	}
+	return path, nil
+}
+
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, index := range p {
+		if index >= HardenedOffset {
+			fmt.Fprintf(&b, "/%d'", index - HardenedOffset)
+		} else {
+			fmt.Fprintf(&b, "/%d", index)
+		}
+	}
+	return b.String()
+}
+
+// Key is an extended private key.
+type Key struct {
+	key []byte
+	chainCode []byte
+}
+
+// NewMasterKey derives the master key of seed.
+func NewMasterKey(seed []byte) (*Key, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("invalid seed length %d", len(seed))
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	return newKey(mac.Sum(nil))
+}
+
+func newKey(i []byte) (*Key, error) {
+	k := &Key{key: i[:32], chainCode: i[32:]}
+
+	if n := new(big.Int).SetBytes(k.key); n.Sign() == 0 || n.Cmp(crypto.S256().Params().N) >= 0 {
+		k.Zero()
+		return nil, fmt.Errorf("invalid extended key, derive another index")
+	}
+	return k, nil
+}
+
+// Child derives the child key at index, which is hardened when it is at least
+// HardenedOffset.
+func (k *Key) Child(index uint32) (*Key, error) {
+	// cleared in a closure, as append reassigns data below
+	data := make([]byte, 0, 37)
+	defer func() {
+		clear(data)
+	}()
+
+	if index >= HardenedOffset {
+		data = append(data, 0)
+		data = append(data, k.key...)
+	} else if privateKey, err := k.PrivateKey(); err != nil {
+		return nil, err
+	} else {
+		data = append(data, crypto.CompressPubkey(&privateKey.PublicKey)...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	i := mac.Sum(nil)
+	defer clear(i[:32])
+
+	n := crypto.S256().Params().N
+	il := new(big.Int).SetBytes(i[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("invalid extended key at index %d, derive another index", index)
+	}
+
+	child := il.Add(il, new(big.Int).SetBytes(k.key))
+	child.Mod(child, n)
+
+	out := make([]byte, 64)
+	child.FillBytes(out[:32])
+	copy(out[32:], i[32:])
+	return newKey(out)
+}
+
+// Derive derives the key at path from k, usually the master key.
+func (k *Key) Derive(path Path) (*Key, error) {
+	key := k
+	for _, index := range path {
+		child, err := key.Child(index)
+		if key != k {
+			key.Zero()
+		}
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+	return key, nil
+}
+
+func (k *Key) PrivateKey() (*ecdsa.PrivateKey, error) {
+	return crypto.ToECDSA(k.key)
+}
+
+// Zero clears the key material.
+func (k *Key) Zero() {
+	clear(k.key)
+	clear(k.chainCode)
+}
@@ -0,0 +1,41 @@
+package hd
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestDerive checks derivation against BIP-32 test vector 1.
+func TestDerive(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	tests := map[string]string{
+		"m/0'": "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+		"m/0'/1": "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+		"m/0'/1/2'": "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca",
+		"m/0'/1/2'/2": "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4",
+		"m/0'/1/2'/2/1000000000": "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8",
+	}
+
+	for s, want := range tests {
+		t.Run(s, func(t *testing.T) {
+			master, err := NewMasterKey(seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer master.Zero()
+
+			if path, err := ParsePath(s); err != nil {
+				t.Fatal(err)
+			} else if key, err := master.Derive(path); err != nil {
+				t.Fatal(err)
+			} else if privateKey, err := key.PrivateKey(); err != nil {
+				t.Fatal(err)
+			} else if got := hex.EncodeToString(crypto.FromECDSA(privateKey)); got != want {
+				t.Fatalf("expected private key %s, got %s", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package interfaces
+
+import (
+	"context"
+)
+
+type ID = string
+
+// KeyEncryptingKeyProvider wraps and unwraps data encrypting keys. The id
+// returned by Encrypt is stored as DataEncryptingKey.KeyEncryptingKey() and
+// passed back to Decrypt. Providers may bind the wrapped key to the id of the
+// data encrypting key.
+type KeyEncryptingKeyProvider interface {
+	Encrypt(ctx context.Context, dataEncryptingKey ID, plaintext []byte) (ID, []byte, error)
+	Decrypt(ctx context.Context, dataEncryptingKey ID, keyEncryptingKey ID, ciphertext []byte) ([]byte, error)
+}
+
+// KeyEncryptingKeyRewrapper is implemented by providers which can re-encrypt a
+// data encrypting key under the latest version of its key encrypting key
+// without exposing the plaintext.
+type KeyEncryptingKeyRewrapper interface {
+	Rewrap(ctx context.Context, dataEncryptingKey ID, keyEncryptingKey ID, ciphertext []byte) (ID, []byte, error)
+}
+
+// SealStatus reports whether a sealable provider holds its master key.
+// Progress counts the unseal shares submitted towards the threshold.
+type SealStatus struct {
+	Initialized bool `json:"initialized"`
+	Sealed bool `json:"sealed"`
+	Shares int `json:"shares,omitempty"`
+	Threshold int `json:"threshold,omitempty"`
+	Progress int `json:"progress"`
+}
+
+// KeyEncryptingKeySealer is implemented by providers whose master key is split
+// into Shamir shares and only held in memory once enough shares have been
+// submitted. Sealed providers fail to wrap and unwrap data encrypting keys.
+type KeyEncryptingKeySealer interface {
+	Init(shares int, threshold int) ([][]byte, error)
+	Unseal(share []byte) (SealStatus, error)
+	Seal() SealStatus
+	SealStatus() SealStatus
+	// OnSeal registers a function called after the provider is sealed, used to
+	// drop keys cached outside the provider.
+	OnSeal(f func())
+}
@@ -0,0 +1,40 @@
+package kek
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/kms"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/local"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/pkcs11"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/signchain"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/transit"
+)
+
+func NewKeyEncryptingKeyProvider(auth auth.Auth) (interfaces.KeyEncryptingKeyProvider, error) {
+	provider := strings.TrimSpace(os.Getenv("VAULT_KEK_PROVIDER"))
+	if provider == "" {
+		provider = "signchain"
+	}
+
+	switch (provider) {
+	case "signchain":
+		return signchain.NewSignchainKeyEncryptingKeyProvider(auth)
+	case "local":
+		if strings.TrimSpace(os.Getenv("VAULT_LOCAL_SEAL_FILE")) != "" {
+			return local.NewSealedKeyEncryptingKeyProvider()
+		}
+		return local.NewLocalKeyEncryptingKeyProvider()
+	case "transit":
+		return transit.NewTransitKeyEncryptingKeyProvider()
+	case "kms":
+		return kms.NewKMSKeyEncryptingKeyProvider()
+	case "pkcs11":
+		return pkcs11.NewPKCS11KeyEncryptingKeyProvider()
+	default:
+		return nil, fmt.Errorf("invalid key encrypting key provider: %s, check online documentation for environment variable VAULT_KEK_PROVIDER", provider)
+	}
+}
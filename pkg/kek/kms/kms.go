@@ -0,0 +1,117 @@
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+// encryptionContextKey names the data encrypting key id in the KMS encryption
+// context, so a wrapped key only unwraps for the data encrypting key it was
+// created for and every use is attributed to it in CloudTrail.
+const encryptionContextKey = "signchain-vault:dek"
+
+// kmsKeyEncryptingKeyProvider wraps data encrypting keys with a symmetric AWS
+// KMS key. The key ARN returned by KMS is the key encrypting key id.
+type kmsKeyEncryptingKeyProvider struct {
+	client *kms.Client
+	keyID string
+}
+
+var _ interfaces.KeyEncryptingKeyProvider = &kmsKeyEncryptingKeyProvider{}
+var _ interfaces.KeyEncryptingKeyRewrapper = &kmsKeyEncryptingKeyProvider{}
+
+// NewKMSKeyEncryptingKeyProvider wraps new data encrypting keys with the KMS
+// key VAULT_KMS_KEY_ID, a key id, ARN or alias. AWS credentials and region are
+// read from the standard AWS environment. Set VAULT_KMS_ENDPOINT to use
+// LocalStack.
+func NewKMSKeyEncryptingKeyProvider() (interfaces.KeyEncryptingKeyProvider, error) {
+	p := &kmsKeyEncryptingKeyProvider{keyID: strings.TrimSpace(os.Getenv("VAULT_KMS_KEY_ID"))}
+
+	if p.keyID == "" {
+		return nil, fmt.Errorf("kms key not configured, check online documentation for environment variable VAULT_KMS_KEY_ID")
+	}
+
+	if cfg, err := config.LoadDefaultConfig(context.Background()); err != nil {
+		return nil, err
+	} else {
+		p.client = kms.NewFromConfig(cfg, func(o *kms.Options) {
+			if endpoint := strings.TrimSpace(os.Getenv("VAULT_KMS_ENDPOINT")); endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		})
+	}
+
+	if out, err := p.client.DescribeKey(context.Background(), &kms.DescribeKeyInput{KeyId: aws.String(p.keyID)}); err != nil {
+		return nil, fmt.Errorf("unable to describe kms key %s: %v", p.keyID, err)
+	} else if out.KeyMetadata.KeySpec != types.KeySpecSymmetricDefault {
+		return nil, fmt.Errorf("kms key %s must be a symmetric encryption key, got %s", p.keyID, out.KeyMetadata.KeySpec)
+	}
+
+	return p, nil
+}
+
+func encryptionContext(dataEncryptingKey interfaces.ID) map[string]string {
+	return map[string]string{encryptionContextKey: dataEncryptingKey}
+}
+
+func kmsError(err error) error {
+	var notFound *types.NotFoundException
+	var invalid *types.InvalidCiphertextException
+
+	if errors.As(err, &notFound) {
+		return fiber.NewError(fiber.StatusNotFound, notFound.ErrorMessage())
+	} else if errors.As(err, &invalid) {
+		return fmt.Errorf("unable to decrypt with kms, the ciphertext or encryption context does not match: %v", err)
+	}
+	return err
+}
+
+func (p *kmsKeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	if out, err := p.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId: aws.String(p.keyID),
+		Plaintext: plaintext,
+		EncryptionContext: encryptionContext(dataEncryptingKey),
+	}); err != nil {
+		return "", nil, kmsError(err)
+	} else {
+		return aws.ToString(out.KeyId), out.CiphertextBlob, nil
+	}
+}
+
+func (p *kmsKeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	if out, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId: aws.String(keyEncryptingKey),
+		CiphertextBlob: ciphertext,
+		EncryptionContext: encryptionContext(dataEncryptingKey),
+	}); err != nil {
+		return nil, kmsError(err)
+	} else {
+		return out.Plaintext, nil
+	}
+}
+
+// Rewrap re-encrypts a data encrypting key under VAULT_KMS_KEY_ID inside KMS,
+// which also moves keys wrapped by a retired KMS key to the current one.
+func (p *kmsKeyEncryptingKeyProvider) Rewrap(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) (interfaces.ID, []byte, error) {
+	if out, err := p.client.ReEncrypt(ctx, &kms.ReEncryptInput{
+		SourceKeyId: aws.String(keyEncryptingKey),
+		DestinationKeyId: aws.String(p.keyID),
+		CiphertextBlob: ciphertext,
+		SourceEncryptionContext: encryptionContext(dataEncryptingKey),
+		DestinationEncryptionContext: encryptionContext(dataEncryptingKey),
+	}); err != nil {
+		return "", nil, kmsError(err)
+	} else {
+		return aws.ToString(out.KeyId), out.CiphertextBlob, nil
+	}
+}
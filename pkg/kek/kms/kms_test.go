@@ -0,0 +1,113 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+// newTestProvider creates a KMS key on LocalStack, scheduled for deletion
+// when the test ends, and returns a provider which wraps with it, e.g.
+//
+//	docker run -p 4566:4566 localstack/localstack
+//	VAULT_KMS_ENDPOINT=http://localhost:4566 go test ./pkg/kek/kms
+func newTestProvider(t *testing.T) *kmsKeyEncryptingKeyProvider {
+	endpoint := os.Getenv("VAULT_KMS_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("VAULT_KMS_ENDPOINT not set")
+	}
+
+	// LocalStack accepts any credentials
+	for name, value := range map[string]string{
+		"AWS_REGION": "us-east-1",
+		"AWS_ACCESS_KEY_ID": "test",
+		"AWS_SECRET_ACCESS_KEY": "test",
+	} {
+		if os.Getenv(name) == "" {
+			t.Setenv(name, value)
+		}
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := kms.NewFromConfig(cfg, func(o *kms.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	out, err := client.CreateKey(context.Background(), &kms.CreateKeyInput{KeySpec: types.KeySpecSymmetricDefault})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := aws.ToString(out.KeyMetadata.Arn)
+
+	t.Cleanup(func() {
+		client.ScheduleKeyDeletion(context.Background(), &kms.ScheduleKeyDeletionInput{KeyId: aws.String(keyID), PendingWindowInDays: aws.Int32(7)})
+	})
+
+	t.Setenv("VAULT_KMS_KEY_ID", keyID)
+
+	provider, err := NewKMSKeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*kmsKeyEncryptingKeyProvider)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	id, ciphertext, err := p.Encrypt(ctx, "dek-1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != p.keyID {
+		t.Fatalf("expected key encrypting key %s, got %s", p.keyID, id)
+	}
+
+	if decrypted, err := p.Decrypt(ctx, "dek-1", id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted key differs from the encrypted key")
+	}
+
+	// the encryption context binds the wrapped key to its data encrypting key
+	if _, err := p.Decrypt(ctx, "dek-2", id, ciphertext); err == nil {
+		t.Fatal("expected decrypt for another data encrypting key to fail")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+	previous := newTestProvider(t)
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	id, ciphertext, err := previous.Encrypt(ctx, "dek-1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := newTestProvider(t)
+
+	var rewrapped interfaces.ID
+	if rewrapped, ciphertext, err = current.Rewrap(ctx, "dek-1", id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if rewrapped != current.keyID {
+		t.Fatalf("expected key encrypting key %s, got %s", current.keyID, rewrapped)
+	}
+
+	if decrypted, err := current.Decrypt(ctx, "dek-1", rewrapped, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("rewrapped key decrypts to a different key")
+	}
+}
@@ -0,0 +1,156 @@
+package local
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+// keySize is the size of a master key, used as an AES-256 key.
+const keySize = 32
+
+// localKeyEncryptingKeyProvider wraps data encrypting keys with AES-256-GCM
+// master keys held in memory, so the vault needs no network access to unwrap
+// them. The id of the master key is the key encrypting key id and is bound to
+// the ciphertext as additional authenticated data, with the id of the data
+// encrypting key, so that a wrapped key can't be moved to another data
+// encrypting key. Keys wrapped before the data encrypting key id was bound
+// still unwrap.
+type localKeyEncryptingKeyProvider struct {
+	keys map[interfaces.ID][]byte
+	active interfaces.ID
+}
+
+var _ interfaces.KeyEncryptingKeyProvider = &localKeyEncryptingKeyProvider{}
+
+// NewLocalKeyEncryptingKeyProvider loads master keys from VAULT_LOCAL_KEKS, a
+// comma separated list of id:base64 pairs, and from VAULT_LOCAL_KEK_FILES, a
+// comma separated list of files containing a base64 key, identified by their
+// file name without extension. New data encrypting keys are wrapped with
+// VAULT_LOCAL_KEK_ID, which may be omitted when only one key is configured.
+// Retired keys stay configured so that existing data encrypting keys can be
+// unwrapped.
+func NewLocalKeyEncryptingKeyProvider() (interfaces.KeyEncryptingKeyProvider, error) {
+	p := &localKeyEncryptingKeyProvider{keys: map[interfaces.ID][]byte{}}
+
+	for _, entry := range split(os.Getenv("VAULT_LOCAL_KEKS")) {
+		if id, encoded, ok := strings.Cut(entry, ":"); !ok {
+			return nil, fmt.Errorf("invalid local key encrypting key, expected id:base64 in VAULT_LOCAL_KEKS")
+		} else if err := p.addKey(strings.TrimSpace(id), encoded); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, filename := range split(os.Getenv("VAULT_LOCAL_KEK_FILES")) {
+		id := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+		if b, err := os.ReadFile(filename); err != nil {
+			return nil, fmt.Errorf("unable to read local key encrypting key: %v", err)
+		} else if err := p.addKey(id, string(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(p.keys) == 0 {
+		return nil, fmt.Errorf("no local key encrypting keys configured, check online documentation for environment variables VAULT_LOCAL_KEKS and VAULT_LOCAL_KEK_FILES")
+	}
+
+	if active := strings.TrimSpace(os.Getenv("VAULT_LOCAL_KEK_ID")); active != "" {
+		if _, ok := p.keys[active]; !ok {
+			return nil, fmt.Errorf("local key encrypting key %s not configured", active)
+		}
+		p.active = active
+	} else if len(p.keys) == 1 {
+		for id := range p.keys {
+			p.active = id
+		}
+	} else {
+		return nil, fmt.Errorf("VAULT_LOCAL_KEK_ID must be set when more than one local key encrypting key is configured")
+	}
+
+	return p, nil
+}
+
+func split(s string) []string {
+	var out []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func (p *localKeyEncryptingKeyProvider) addKey(id interfaces.ID, encoded string) error {
+	if id == "" {
+		return fmt.Errorf("local key encrypting key id must not be empty")
+	} else if _, ok := p.keys[id]; ok {
+		return fmt.Errorf("local key encrypting key %s configured more than once", id)
+	} else if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded)); err != nil {
+		return fmt.Errorf("invalid local key encrypting key %s: %v", id, err)
+	} else if len(key) != keySize {
+		return fmt.Errorf("invalid local key encrypting key %s, expected %d bytes, got %d", id, keySize, len(key))
+	} else {
+		p.keys[id] = key
+		return nil
+	}
+}
+
+func (p *localKeyEncryptingKeyProvider) gcm(id interfaces.ID) (cipher.AEAD, error) {
+	if key, ok := p.keys[id]; !ok {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("local key encrypting key %s not configured", id))
+	} else if block, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	} else {
+		return cipher.NewGCM(block)
+	}
+}
+
+// additionalData length prefixes the ids so that they can't be shifted into one
+// another.
+func additionalData(keyEncryptingKey interfaces.ID, dataEncryptingKey interfaces.ID) []byte {
+	var additionalData []byte
+	for _, id := range []interfaces.ID{keyEncryptingKey, dataEncryptingKey} {
+		additionalData = binary.BigEndian.AppendUint32(additionalData, uint32(len(id)))
+		additionalData = append(additionalData, id...)
+	}
+	return additionalData
+}
+
+func (p *localKeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	if gcm, err := p.gcm(p.active); err != nil {
+		return "", nil, err
+	} else {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", nil, err
+		}
+
+		return p.active, gcm.Seal(nonce, nonce, plaintext, additionalData(p.active, dataEncryptingKey)), nil
+	}
+}
+
+func (p *localKeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	if gcm, err := p.gcm(keyEncryptingKey); err != nil {
+		return nil, err
+	} else if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid ciphertext for local key encrypting key %s", keyEncryptingKey)
+	} else if plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], additionalData(keyEncryptingKey, dataEncryptingKey)); err == nil {
+		return plaintext, nil
+	} else if plaintext, legacyErr := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], []byte(keyEncryptingKey)); legacyErr == nil {
+		// wrapped before the data encrypting key id was bound
+		return plaintext, nil
+	} else {
+		return nil, fmt.Errorf("unable to decrypt with local key encrypting key %s: %v", keyEncryptingKey, err)
+	}
+}
@@ -0,0 +1,152 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+func newKey() string {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestProvider(t *testing.T, keks string, files string, active string) (*localKeyEncryptingKeyProvider, error) {
+	t.Setenv("VAULT_LOCAL_KEKS", keks)
+	t.Setenv("VAULT_LOCAL_KEK_FILES", files)
+	t.Setenv("VAULT_LOCAL_KEK_ID", active)
+
+	if provider, err := NewLocalKeyEncryptingKeyProvider(); err != nil {
+		return nil, err
+	} else {
+		return provider.(*localKeyEncryptingKeyProvider), nil
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kek-file.key")
+	if err := os.WriteFile(file, []byte(newKey() + "\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := newTestProvider(t, "kek-1:" + newKey(), "", ""); err != nil {
+		t.Fatal(err)
+	} else if p.active != "kek-1" {
+		t.Fatalf("expected the only key to be active, got %s", p.active)
+	}
+
+	if p, err := newTestProvider(t, " kek-1:" + newKey() + ", kek-2:" + newKey(), file, "kek-file"); err != nil {
+		t.Fatal(err)
+	} else if p.active != "kek-file" || len(p.keys) != 3 {
+		t.Fatalf("expected 3 keys with kek-file active, got %d with %s active", len(p.keys), p.active)
+	}
+
+	for name, env := range map[string][3]string{
+		"no keys": {"", "", ""},
+		"no active key": {"kek-1:" + newKey() + ",kek-2:" + newKey(), "", ""},
+		"unknown active key": {"kek-1:" + newKey(), "", "kek-2"},
+		"missing id": {newKey(), "", ""},
+		"empty id": {":" + newKey(), "", ""},
+		"duplicate id": {"kek-file:" + newKey(), file, "kek-file"},
+		"invalid base64": {"kek-1:not base64", "", ""},
+		"short key": {"kek-1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), "", ""},
+		"missing file": {"", filepath.Join(t.TempDir(), "missing.key"), ""},
+	} {
+		if _, err := newTestProvider(t, env[0], env[1], env[2]); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	retired, current := newKey(), newKey()
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	previous, err := newTestProvider(t, "kek-1:" + retired, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, old, err := previous.Encrypt(ctx, "dek-1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newTestProvider(t, "kek-1:" + retired + ",kek-2:" + current, "", "kek-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new keys are wrapped with the active key, existing keys unwrap with the
+	// retired key
+	if id, ciphertext, err := p.Encrypt(ctx, "dek-2", plaintext); err != nil {
+		t.Fatal(err)
+	} else if id != "kek-2" {
+		t.Fatalf("expected key encrypting key kek-2, got %s", id)
+	} else if decrypted, err := p.Decrypt(ctx, "dek-2", id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted key differs from the encrypted key")
+	}
+
+	if decrypted, err := p.Decrypt(ctx, "dek-1", "kek-1", old); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("key wrapped by the retired key differs from the encrypted key")
+	}
+
+	tampered := append([]byte{}, old...)
+	tampered[len(tampered) - 1] ^= 1
+
+	for name, c := range map[string]struct {
+		dataEncryptingKey interfaces.ID
+		keyEncryptingKey interfaces.ID
+		ciphertext []byte
+	}{
+		"another data encrypting key": {"dek-2", "kek-1", old},
+		"another key encrypting key": {"dek-1", "kek-2", old},
+		"an unknown key encrypting key": {"dek-1", "kek-3", old},
+		"a tampered ciphertext": {"dek-1", "kek-1", tampered},
+		"a short ciphertext": {"dek-1", "kek-1", old[:8]},
+	} {
+		if _, err := p.Decrypt(ctx, c.dataEncryptingKey, c.keyEncryptingKey, c.ciphertext); err == nil {
+			t.Errorf("expected decrypt with %s to fail", name)
+		}
+	}
+}
+
+// TestDecryptLegacy unwraps a key wrapped before the data encrypting key id was
+// bound as additional authenticated data.
+func TestDecryptLegacy(t *testing.T) {
+	p, err := newTestProvider(t, "kek-1:" + newKey(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	block, err := aes.NewCipher(p.keys["kek-1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	if decrypted, err := p.Decrypt(context.Background(), "dek-1", "kek-1", gcm.Seal(nonce, nonce, plaintext, []byte("kek-1"))); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted key differs from the encrypted key")
+	}
+}
@@ -0,0 +1,241 @@
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/shamir"
+)
+
+// sealCheck is encrypted with the master key when the vault is initialized, so
+// that unseal shares which combine to the wrong key are rejected.
+var sealCheck = []byte("signchain-vault seal check")
+
+// sealConfig is stored in the seal file. It holds no key material.
+type sealConfig struct {
+	KeyEncryptingKey interfaces.ID `json:"keyEncryptingKey"`
+	Shares int `json:"shares"`
+	Threshold int `json:"threshold"`
+	Check []byte `json:"check"`
+}
+
+// sealedKeyEncryptingKeyProvider holds a local master key which is split into
+// Shamir shares when the vault is initialized. The vault starts sealed and the
+// master key is only held in memory once a threshold of shares is submitted.
+type sealedKeyEncryptingKeyProvider struct {
+	path string
+	id interfaces.ID
+
+	mutex sync.RWMutex
+	config *sealConfig
+	provider *localKeyEncryptingKeyProvider
+	shares [][]byte
+	onSeal []func()
+}
+
+var _ interfaces.KeyEncryptingKeyProvider = &sealedKeyEncryptingKeyProvider{}
+var _ interfaces.KeyEncryptingKeySealer = &sealedKeyEncryptingKeyProvider{}
+
+// NewSealedKeyEncryptingKeyProvider reads the seal file at
+// VAULT_LOCAL_SEAL_FILE, which is created when the vault is initialized. The
+// master key is identified by VAULT_LOCAL_KEK_ID, sealed by default.
+func NewSealedKeyEncryptingKeyProvider() (interfaces.KeyEncryptingKeyProvider, error) {
+	p := &sealedKeyEncryptingKeyProvider{
+		path: strings.TrimSpace(os.Getenv("VAULT_LOCAL_SEAL_FILE")),
+		id: strings.TrimSpace(os.Getenv("VAULT_LOCAL_KEK_ID")),
+	}
+
+	if p.path == "" {
+		return nil, fmt.Errorf("seal file not configured, check online documentation for environment variable VAULT_LOCAL_SEAL_FILE")
+	} else if p.id == "" {
+		p.id = "sealed"
+	}
+
+	if b, err := os.ReadFile(p.path); errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read seal file: %v", err)
+	} else if err := json.Unmarshal(b, &p.config); err != nil {
+		return nil, fmt.Errorf("invalid seal file %s: %v", p.path, err)
+	} else {
+		return p, nil
+	}
+}
+
+func (p *sealedKeyEncryptingKeyProvider) status() interfaces.SealStatus {
+	s := interfaces.SealStatus{Sealed: p.provider == nil, Progress: len(p.shares)}
+	if p.config != nil {
+		s.Initialized = true
+		s.Shares = p.config.Shares
+		s.Threshold = p.config.Threshold
+	}
+	return s
+}
+
+func (p *sealedKeyEncryptingKeyProvider) SealStatus() interfaces.SealStatus {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.status()
+}
+
+// Init generates the master key and returns its shares, which are not stored.
+// The vault stays sealed until the shares are submitted.
+func (p *sealedKeyEncryptingKeyProvider) Init(shares int, threshold int) ([][]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.config != nil {
+		return nil, fiber.NewError(fiber.StatusConflict, "vault already initialized")
+	}
+
+	key := make([]byte, keySize)
+	defer clear(key)
+
+	provider := &localKeyEncryptingKeyProvider{keys: map[interfaces.ID][]byte{p.id: key}, active: p.id}
+	config := sealConfig{KeyEncryptingKey: p.id, Shares: shares, Threshold: threshold}
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	} else if out, err := shamir.Split(key, shares, threshold); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	} else if _, config.Check, err = provider.Encrypt(context.Background(), "", sealCheck); err != nil {
+		return nil, err
+	} else if b, err := json.MarshalIndent(config, "", "  "); err != nil {
+		return nil, err
+	} else if err := writeSealFile(p.path, b); err != nil {
+		return nil, err
+	} else {
+		p.config = &config
+		return out, nil
+	}
+}
+
+func writeSealFile(path string, b []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seal file %s already exists", path))
+	} else if err := os.WriteFile(path + ".tmp", b, 0600); err != nil {
+		return fmt.Errorf("unable to write seal file: %v", err)
+	} else if err := os.Rename(path + ".tmp", path); err != nil {
+		return fmt.Errorf("unable to write seal file: %v", err)
+	} else {
+		return nil
+	}
+}
+
+// Unseal adds a share and unseals the vault once the threshold is reached.
+// Shares which don't combine to the master key are discarded, so unsealing
+// starts again.
+func (p *sealedKeyEncryptingKeyProvider) Unseal(share []byte) (interfaces.SealStatus, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.config == nil {
+		return p.status(), fiber.NewError(fiber.StatusBadRequest, "vault not initialized")
+	} else if p.provider != nil {
+		return p.status(), nil
+	} else if len(share) != keySize + 1 {
+		return p.status(), fiber.NewError(fiber.StatusBadRequest, "invalid unseal share")
+	}
+
+	for _, s := range p.shares {
+		if subtle.ConstantTimeCompare(s, share) == 1 {
+			return p.status(), fiber.NewError(fiber.StatusBadRequest, "unseal share already submitted")
+		}
+	}
+	p.shares = append(p.shares, append([]byte{}, share...))
+
+	if len(p.shares) < p.config.Threshold {
+		return p.status(), nil
+	}
+
+	key, err := shamir.Combine(p.shares)
+	p.clearShares()
+	if err != nil {
+		return p.status(), fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	provider := &localKeyEncryptingKeyProvider{keys: map[interfaces.ID][]byte{p.config.KeyEncryptingKey: key}, active: p.config.KeyEncryptingKey}
+	if check, err := provider.Decrypt(context.Background(), "", p.config.KeyEncryptingKey, p.config.Check); err != nil || subtle.ConstantTimeCompare(check, sealCheck) != 1 {
+		clear(key)
+		return p.status(), fiber.NewError(fiber.StatusBadRequest, "unseal shares do not match the master key, submit the shares again")
+	}
+
+	p.provider = provider
+	return p.status(), nil
+}
+
+func (p *sealedKeyEncryptingKeyProvider) clearShares() {
+	for _, s := range p.shares {
+		clear(s)
+	}
+	p.shares = nil
+}
+
+// Seal zeroes the master key and any submitted shares, then calls the
+// functions registered with OnSeal.
+func (p *sealedKeyEncryptingKeyProvider) Seal() interfaces.SealStatus {
+	p.mutex.Lock()
+
+	if p.provider != nil {
+		for _, key := range p.provider.keys {
+			clear(key)
+		}
+		p.provider = nil
+	}
+	p.clearShares()
+	status := p.status()
+	onSeal := p.onSeal
+
+	p.mutex.Unlock()
+
+	for _, f := range onSeal {
+		f()
+	}
+	return status
+}
+
+func (p *sealedKeyEncryptingKeyProvider) OnSeal(f func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.onSeal = append(p.onSeal, f)
+}
+
+func (p *sealedKeyEncryptingKeyProvider) unsealed() (*localKeyEncryptingKeyProvider, error) {
+	if p.provider == nil {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, "vault is sealed")
+	}
+	return p.provider, nil
+}
+
+func (p *sealedKeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if provider, err := p.unsealed(); err != nil {
+		return "", nil, err
+	} else {
+		return provider.Encrypt(ctx, dataEncryptingKey, plaintext)
+	}
+}
+
+func (p *sealedKeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if provider, err := p.unsealed(); err != nil {
+		return nil, err
+	} else {
+		return provider.Decrypt(ctx, dataEncryptingKey, keyEncryptingKey, ciphertext)
+	}
+}
@@ -0,0 +1,159 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+func newSealedProvider(t *testing.T, path string) *sealedKeyEncryptingKeyProvider {
+	t.Setenv("VAULT_LOCAL_SEAL_FILE", path)
+	t.Setenv("VAULT_LOCAL_KEK_ID", "")
+
+	provider, err := NewSealedKeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*sealedKeyEncryptingKeyProvider)
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var e *fiber.Error
+	if !errors.As(err, &e) || e.Code != status {
+		t.Fatalf("expected status %d, got %v", status, err)
+	}
+}
+
+func unseal(t *testing.T, p *sealedKeyEncryptingKeyProvider, shares ...[]byte) interfaces.SealStatus {
+	t.Helper()
+
+	var status interfaces.SealStatus
+	for _, share := range shares {
+		var err error
+		if status, err = p.Unseal(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return status
+}
+
+func TestInitUnseal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "seal.json")
+	p := newSealedProvider(t, path)
+
+	_, err := p.Unseal(make([]byte, keySize + 1))
+	requireStatus(t, err, fiber.StatusBadRequest)
+
+	shares, err := p.Init(5, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	} else if status := p.SealStatus(); !status.Initialized || !status.Sealed || status.Threshold != 3 {
+		t.Fatalf("expected an initialized sealed vault, got %+v", status)
+	}
+
+	_, err = p.Init(5, 3)
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, _, err = p.Encrypt(ctx, "dek-1", make([]byte, 32))
+	requireStatus(t, err, fiber.StatusServiceUnavailable)
+
+	if status := unseal(t, p, shares[0], shares[1]); !status.Sealed || status.Progress != 2 {
+		t.Fatalf("expected the vault to stay sealed below the threshold, got %+v", status)
+	}
+	_, err = p.Unseal(shares[1])
+	requireStatus(t, err, fiber.StatusBadRequest)
+
+	if status := unseal(t, p, shares[2]); status.Sealed || status.Progress != 0 {
+		t.Fatalf("expected the vault to be unsealed, got %+v", status)
+	}
+
+	id, ciphertext, err := p.Encrypt(ctx, "dek-1", bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	} else if id != "sealed" {
+		t.Fatalf("expected key encrypting key sealed, got %s", id)
+	}
+
+	// the seal file holds no key material, another subset of the shares
+	// unseals the vault after a restart
+	restarted := newSealedProvider(t, path)
+	if status := restarted.SealStatus(); !status.Initialized || !status.Sealed {
+		t.Fatalf("expected an initialized sealed vault after a restart, got %+v", status)
+	}
+	unseal(t, restarted, shares[4], shares[1], shares[3])
+
+	if decrypted, err := restarted.Decrypt(ctx, "dek-1", id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, bytes.Repeat([]byte{7}, 32)) {
+		t.Fatal("decrypted key differs from the encrypted key")
+	}
+}
+
+// TestUnsealWrongShare submits a share of another master key, which combines
+// to the wrong key, and checks that unsealing starts again.
+func TestUnsealWrongShare(t *testing.T) {
+	p := newSealedProvider(t, filepath.Join(t.TempDir(), "seal.json"))
+	shares, err := p.Init(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := newSealedProvider(t, filepath.Join(t.TempDir(), "seal.json")).Init(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unseal(t, p, shares[0])
+	_, err = p.Unseal(other[1])
+	requireStatus(t, err, fiber.StatusBadRequest)
+
+	if status := p.SealStatus(); !status.Sealed || status.Progress != 0 {
+		t.Fatalf("expected unsealing to start again, got %+v", status)
+	} else if status := unseal(t, p, shares[2], shares[0]); status.Sealed {
+		t.Fatalf("expected the vault to be unsealed, got %+v", status)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	ctx := context.Background()
+	p := newSealedProvider(t, filepath.Join(t.TempDir(), "seal.json"))
+	shares, err := p.Init(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unseal(t, p, shares[0], shares[1])
+
+	var purged int
+	p.OnSeal(func() { purged++ })
+	p.OnSeal(func() { purged++ })
+
+	key := p.provider.keys["sealed"]
+	if status := p.Seal(); !status.Sealed || status.Progress != 0 {
+		t.Fatalf("expected the vault to be sealed, got %+v", status)
+	} else if !bytes.Equal(key, make([]byte, keySize)) {
+		t.Fatal("expected the master key to be zeroed")
+	} else if purged != 2 {
+		t.Fatalf("expected 2 seal callbacks, got %d", purged)
+	}
+
+	_, err = p.Decrypt(ctx, "dek-1", "sealed", make([]byte, 60))
+	requireStatus(t, err, fiber.StatusServiceUnavailable)
+
+	// submitted shares are zeroed on seal as well
+	unseal(t, p, shares[2])
+	submitted := p.shares[0]
+	p.Seal()
+	if !bytes.Equal(submitted, make([]byte, keySize + 1)) {
+		t.Fatal("expected submitted shares to be zeroed")
+	}
+}
@@ -0,0 +1,285 @@
+//go:build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/miekg/pkcs11"
+)
+
+const (
+	idPrefix = "pkcs11:"
+	nonceSize = 12
+	tagBits = 128
+)
+
+// pkcs11KeyEncryptingKeyProvider wraps data encrypting keys with CKM_AES_GCM
+// using AES keys which never leave a PKCS#11 token. Key encrypting key ids have
+// the form pkcs11:<key label> and the data encrypting key id is bound to the
+// ciphertext as additional authenticated data.
+//
+// The GCM IV is generated by the vault and stored in front of the ciphertext.
+// Tokens which only accept IVs they generate themselves reject it with
+// CKR_MECHANISM_PARAM_INVALID, in which case the token is given a zeroed IV to
+// fill in. Tokens which silently replace the IV are also supported, as the IV
+// is read back after encrypting. The provider is tested with SoftHSMv2.
+type pkcs11KeyEncryptingKeyProvider struct {
+	ctx *pkcs11.Ctx
+	slot uint
+	label string
+
+	// login state is shared by all sessions of the application and lasts as
+	// long as one session is open, so this session is held open
+	session pkcs11.SessionHandle
+
+	mutex sync.Mutex
+	keys map[string]pkcs11.ObjectHandle
+}
+
+var _ interfaces.KeyEncryptingKeyProvider = &pkcs11KeyEncryptingKeyProvider{}
+
+// NewPKCS11KeyEncryptingKeyProvider loads the PKCS#11 module at
+// VAULT_PKCS11_MODULE and logs in to the token in VAULT_PKCS11_SLOT, or the
+// token labelled VAULT_PKCS11_TOKEN_LABEL, with VAULT_PKCS11_PIN. New data
+// encrypting keys are wrapped with the AES key labelled VAULT_PKCS11_KEY_LABEL,
+// which is generated on the token when VAULT_PKCS11_GENERATE_KEY is true and
+// no key has the label.
+func NewPKCS11KeyEncryptingKeyProvider() (interfaces.KeyEncryptingKeyProvider, error) {
+	module := strings.TrimSpace(os.Getenv("VAULT_PKCS11_MODULE"))
+	pin := os.Getenv("VAULT_PKCS11_PIN")
+
+	p := &pkcs11KeyEncryptingKeyProvider{
+		label: strings.TrimSpace(os.Getenv("VAULT_PKCS11_KEY_LABEL")),
+		keys: map[string]pkcs11.ObjectHandle{},
+	}
+
+	if module == "" {
+		return nil, fmt.Errorf("pkcs11 module not configured, check online documentation for environment variable VAULT_PKCS11_MODULE")
+	} else if p.label == "" {
+		return nil, fmt.Errorf("pkcs11 key label not configured, check online documentation for environment variable VAULT_PKCS11_KEY_LABEL")
+	} else if p.ctx = pkcs11.New(module); p.ctx == nil {
+		return nil, fmt.Errorf("unable to load pkcs11 module %s", module)
+	} else if err := p.ctx.Initialize(); err != nil {
+		p.ctx.Destroy()
+		return nil, fmt.Errorf("unable to initialize pkcs11 module %s: %v", module, err)
+	}
+
+	if slot, err := p.findSlot(); err != nil {
+		p.close()
+		return nil, err
+	} else if session, err := p.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION); err != nil {
+		p.close()
+		return nil, fmt.Errorf("unable to open pkcs11 session on slot %d: %v", slot, err)
+	} else {
+		p.slot = slot
+		p.session = session
+	}
+
+	if err := p.ctx.Login(p.session, pkcs11.CKU_USER, pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		p.close()
+		return nil, fmt.Errorf("unable to log in to pkcs11 token on slot %d: %v", p.slot, err)
+	}
+
+	var e *fiber.Error
+	if _, err := p.key(p.label); err == nil {
+		return p, nil
+	} else if !errors.As(err, &e) || e.Code != fiber.StatusNotFound || os.Getenv("VAULT_PKCS11_GENERATE_KEY") != "true" {
+		p.close()
+		return nil, err
+	} else if err := p.generateKey(p.label); err != nil {
+		p.close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// close logs out and closes the session, if one was opened, before finalizing
+// and unloading the module, as sessions can't be closed once finalized.
+func (p *pkcs11KeyEncryptingKeyProvider) close() {
+	if p.session != 0 {
+		p.ctx.Logout(p.session)
+		p.ctx.CloseSession(p.session)
+		p.session = 0
+	}
+	p.ctx.Finalize()
+	p.ctx.Destroy()
+}
+
+func (p *pkcs11KeyEncryptingKeyProvider) findSlot() (uint, error) {
+	if s := strings.TrimSpace(os.Getenv("VAULT_PKCS11_SLOT")); s != "" {
+		if slot, err := strconv.ParseUint(s, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid VAULT_PKCS11_SLOT: %v", err)
+		} else {
+			return uint(slot), nil
+		}
+	}
+
+	label := strings.TrimSpace(os.Getenv("VAULT_PKCS11_TOKEN_LABEL"))
+	if label == "" {
+		return 0, fmt.Errorf("pkcs11 token not configured, check online documentation for environment variables VAULT_PKCS11_SLOT and VAULT_PKCS11_TOKEN_LABEL")
+	}
+
+	if slots, err := p.ctx.GetSlotList(true); err != nil {
+		return 0, fmt.Errorf("unable to list pkcs11 slots: %v", err)
+	} else {
+		for _, slot := range slots {
+			if info, err := p.ctx.GetTokenInfo(slot); err != nil {
+				return 0, fmt.Errorf("unable to get pkcs11 token info for slot %d: %v", slot, err)
+			} else if strings.TrimSpace(info.Label) == label {
+				return slot, nil
+			}
+		}
+		return 0, fmt.Errorf("pkcs11 token %s not found", label)
+	}
+}
+
+// key returns the handle of the AES key with label, caching handles, which
+// remain valid for token objects across sessions.
+func (p *pkcs11KeyEncryptingKeyProvider) key(label string) (pkcs11.ObjectHandle, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if handle, ok := p.keys[label]; ok {
+		return handle, nil
+	}
+
+	if err := p.ctx.FindObjectsInit(p.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}); err != nil {
+		return 0, err
+	}
+	defer p.ctx.FindObjectsFinal(p.session)
+
+	if handles, _, err := p.ctx.FindObjects(p.session, 2); err != nil {
+		return 0, err
+	} else if len(handles) == 0 {
+		return 0, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("pkcs11 key %s not found", label))
+	} else if len(handles) > 1 {
+		return 0, fmt.Errorf("more than one pkcs11 key labelled %s", label)
+	} else {
+		p.keys[label] = handles[0]
+		return handles[0], nil
+	}
+}
+
+func (p *pkcs11KeyEncryptingKeyProvider) generateKey(label string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if handle, err := p.ctx.GenerateKey(p.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+	}); err != nil {
+		return fmt.Errorf("unable to generate pkcs11 key %s: %v", label, err)
+	} else {
+		p.keys[label] = handle
+		return nil
+	}
+}
+
+// encrypt runs a single GCM encryption in its own session, as sessions must not
+// be used concurrently, and returns the IV the token used.
+func (p *pkcs11KeyEncryptingKeyProvider) encrypt(key pkcs11.ObjectHandle, aad []byte, plaintext []byte) ([]byte, []byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	session, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer p.ctx.CloseSession(session)
+
+	params := pkcs11.NewGCMParams(nonce, aad, tagBits)
+	defer func() {
+		params.Free()
+	}()
+
+	err = p.ctx.EncryptInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, key)
+	if err == pkcs11.Error(pkcs11.CKR_MECHANISM_PARAM_INVALID) {
+		// the token generates IVs itself and writes them into a zeroed IV
+		params.Free()
+		params = pkcs11.NewGCMParams(make([]byte, nonceSize), aad, tagBits)
+		err = p.ctx.EncryptInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// tokens which generate IVs themselves write back the IV they used
+	if ciphertext, err := p.ctx.Encrypt(session, plaintext); err != nil {
+		return nil, nil, err
+	} else if nonce := params.IV(); len(nonce) != nonceSize || bytes.Equal(nonce, make([]byte, nonceSize)) {
+		return nil, nil, fmt.Errorf("pkcs11 token returned an invalid GCM IV")
+	} else {
+		return nonce, ciphertext, nil
+	}
+}
+
+// decrypt runs a single GCM decryption in its own session.
+func (p *pkcs11KeyEncryptingKeyProvider) decrypt(key pkcs11.ObjectHandle, nonce []byte, aad []byte, ciphertext []byte) ([]byte, error) {
+	params := pkcs11.NewGCMParams(nonce, aad, tagBits)
+	defer params.Free()
+
+	session, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, err
+	}
+	defer p.ctx.CloseSession(session)
+
+	if err := p.ctx.DecryptInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, key); err != nil {
+		return nil, err
+	}
+	return p.ctx.Decrypt(session, ciphertext)
+}
+
+func (p *pkcs11KeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	if key, err := p.key(p.label); err != nil {
+		return "", nil, err
+	} else if nonce, ciphertext, err := p.encrypt(key, []byte(dataEncryptingKey), plaintext); err != nil {
+		return "", nil, fmt.Errorf("unable to encrypt with pkcs11 key %s: %v", p.label, err)
+	} else {
+		return idPrefix + p.label, append(nonce, ciphertext...), nil
+	}
+}
+
+func (p *pkcs11KeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	if !strings.HasPrefix(keyEncryptingKey, idPrefix) {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("key encrypting key %s is not a pkcs11 key", keyEncryptingKey))
+	} else if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("invalid ciphertext for pkcs11 key encrypting key %s", keyEncryptingKey)
+	}
+
+	label := strings.TrimPrefix(keyEncryptingKey, idPrefix)
+
+	if key, err := p.key(label); err != nil {
+		return nil, err
+	} else if plaintext, err := p.decrypt(key, ciphertext[:nonceSize], []byte(dataEncryptingKey), ciphertext[nonceSize:]); err != nil {
+		return nil, fmt.Errorf("unable to decrypt with pkcs11 key %s: %v", label, err)
+	} else {
+		return plaintext, nil
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package pkcs11
+
+import (
+	"fmt"
+
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+// PKCS#11 modules are shared libraries loaded through cgo.
+func NewPKCS11KeyEncryptingKeyProvider() (interfaces.KeyEncryptingKeyProvider, error) {
+	return nil, fmt.Errorf("pkcs11 key encrypting key provider requires a build with CGO_ENABLED=1")
+}
@@ -0,0 +1,101 @@
+//go:build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+// setTestEnv configures a SoftHSMv2 token and a random key label, e.g.
+//
+//	softhsm2-util --init-token --free --label signchain-vault-test --pin 1234 --so-pin 1234
+//	VAULT_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so go test ./pkg/kek/pkcs11
+//
+// The token label and pin default to signchain-vault-test and 1234.
+func setTestEnv(t *testing.T) {
+	if os.Getenv("VAULT_PKCS11_MODULE") == "" {
+		t.Skip("VAULT_PKCS11_MODULE not set")
+	}
+
+	if os.Getenv("VAULT_PKCS11_SLOT") == "" && os.Getenv("VAULT_PKCS11_TOKEN_LABEL") == "" {
+		t.Setenv("VAULT_PKCS11_TOKEN_LABEL", "signchain-vault-test")
+	}
+	if os.Getenv("VAULT_PKCS11_PIN") == "" {
+		t.Setenv("VAULT_PKCS11_PIN", "1234")
+	}
+
+	label := make([]byte, 8)
+	rand.Read(label)
+	t.Setenv("VAULT_PKCS11_KEY_LABEL", "signchain-vault-test-" + hex.EncodeToString(label))
+}
+
+// newTestProvider generates an AES key with a random label on a SoftHSMv2
+// token and destroys it when the test ends.
+func newTestProvider(t *testing.T) *pkcs11KeyEncryptingKeyProvider {
+	setTestEnv(t)
+	t.Setenv("VAULT_PKCS11_GENERATE_KEY", "true")
+
+	provider, err := NewPKCS11KeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := provider.(*pkcs11KeyEncryptingKeyProvider)
+
+	t.Cleanup(func() {
+		if key, err := p.key(p.label); err == nil {
+			p.ctx.DestroyObject(p.session, key)
+		}
+		p.close()
+	})
+
+	return p
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	id, ciphertext, err := p.Encrypt(ctx, "dek-1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != idPrefix + p.label {
+		t.Fatalf("expected key encrypting key %s, got %s", idPrefix + p.label, id)
+	}
+
+	if decrypted, err := p.Decrypt(ctx, "dek-1", id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted key differs from the encrypted key")
+	}
+
+	// the data encrypting key id is bound as additional authenticated data
+	if _, err := p.Decrypt(ctx, "dek-2", id, ciphertext); err == nil {
+		t.Fatal("expected decrypt for another data encrypting key to fail")
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered) - 1] ^= 1
+	if _, err := p.Decrypt(ctx, "dek-1", id, tampered); err == nil {
+		t.Fatal("expected decrypt of a tampered key to fail")
+	}
+}
+
+// TestMissingKey checks that a provider which fails to start closes its session
+// and finalizes the module, so that the module can be initialized again.
+func TestMissingKey(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("VAULT_PKCS11_GENERATE_KEY", "false")
+
+	if _, err := NewPKCS11KeyEncryptingKeyProvider(); err == nil {
+		t.Fatal("expected a missing key to fail")
+	}
+
+	// initializing a module which wasn't finalized fails
+	newTestProvider(t)
+}
@@ -0,0 +1,129 @@
+// Package shamir splits a secret into shares with Shamir's secret sharing over
+// GF(2^8), so that any threshold of the shares recovers the secret and fewer
+// reveal nothing about it. Each share is the secret sized evaluation of a
+// random polynomial per byte followed by the x coordinate of the share.
+package shamir
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Split divides secret into the given number of shares, any threshold of which
+// recover the secret with Combine.
+func Split(secret []byte, shares int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret must not be empty")
+	} else if threshold < 2 {
+		return nil, fmt.Errorf("threshold must be at least 2")
+	} else if shares < threshold {
+		return nil, fmt.Errorf("shares must be at least the threshold")
+	} else if shares > 255 {
+		return nil, fmt.Errorf("shares must be at most 255")
+	}
+
+	out := make([][]byte, shares)
+	for i := range out {
+		out[i] = make([]byte, len(secret) + 1)
+		out[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	defer clear(coefficients)
+
+	for i, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for _, share := range out {
+			share[i] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+
+	return out, nil
+}
+
+// Combine recovers a secret from threshold or more shares created by Split.
+// Fewer shares recover a different secret, which the caller has to detect.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least 2 shares are required")
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("invalid share")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := map[byte]bool{}
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("shares must be the same length")
+		} else if x := share[size - 1]; x == 0 {
+			return nil, fmt.Errorf("invalid share")
+		} else if seen[x] {
+			return nil, fmt.Errorf("duplicate share")
+		} else {
+			seen[x] = true
+			xs[i] = x
+		}
+	}
+
+	secret := make([]byte, size - 1)
+	for i := range secret {
+		// Lagrange interpolation at x = 0
+		var b byte
+		for j, share := range shares {
+			basis := byte(1)
+			for k, x := range xs {
+				if k != j {
+					basis = mul(basis, div(x, x ^ xs[j]))
+				}
+			}
+			b ^= mul(share[i], basis)
+		}
+		secret[i] = b
+	}
+
+	return secret, nil
+}
+
+// evaluate returns the polynomial with the given coefficients, lowest degree
+// first, at x using Horner's method.
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1,
+// without branching on secret values.
+func mul(a byte, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a << 1 ^ -(a >> 7) & 0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// inverse returns a^254, which is the multiplicative inverse of a non zero a.
+func inverse(a byte) byte {
+	b := mul(a, a)
+	p := b
+	for i := 0; i < 6; i++ {
+		b = mul(b, b)
+		p = mul(p, b)
+	}
+	return p
+}
+
+func div(a byte, b byte) byte {
+	return mul(a, inverse(b))
+}
@@ -0,0 +1,105 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// subsets returns every subset of size k of the indexes 0 to n - 1.
+func subsets(n int, k int) [][]int {
+	if k == 0 {
+		return [][]int{{}}
+	} else if n < k {
+		return nil
+	}
+
+	// subsets without n - 1, then those with it
+	out := subsets(n - 1, k)
+	for _, s := range subsets(n - 1, k - 1) {
+		out = append(out, append(append([]int{}, s...), n - 1))
+	}
+	return out
+}
+
+func pick(shares [][]byte, indexes []int) [][]byte {
+	out := make([][]byte, len(indexes))
+	for i, index := range indexes {
+		out[i] = append([]byte{}, shares[index]...)
+	}
+	return out
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	for _, c := range [][2]int{{2, 2}, {3, 2}, {5, 3}, {6, 6}} {
+		shares, err := Split(secret, c[0], c[1])
+		if err != nil {
+			t.Fatal(err)
+		} else if len(shares) != c[0] {
+			t.Fatalf("expected %d shares, got %d", c[0], len(shares))
+		}
+
+		for k := c[1]; k <= c[0]; k++ {
+			for _, s := range subsets(c[0], k) {
+				if combined, err := Combine(pick(shares, s)); err != nil {
+					t.Fatal(err)
+				} else if !bytes.Equal(combined, secret) {
+					t.Fatalf("expected shares %v of a %d of %d split to recover the secret", s, c[1], c[0])
+				}
+			}
+		}
+
+		for _, s := range subsets(c[0], c[1] - 1) {
+			if len(s) < 2 {
+				continue
+			} else if combined, err := Combine(pick(shares, s)); err != nil {
+				t.Fatal(err)
+			} else if bytes.Equal(combined, secret) {
+				t.Fatalf("expected shares %v of a %d of %d split not to recover the secret", s, c[1], c[0])
+			}
+		}
+	}
+}
+
+func TestSplitInvalid(t *testing.T) {
+	for _, c := range []struct {
+		secret []byte
+		shares int
+		threshold int
+	}{
+		{nil, 3, 2},
+		{[]byte{1}, 3, 1},
+		{[]byte{1}, 2, 3},
+		{[]byte{1}, 256, 2},
+	} {
+		if _, err := Split(c.secret, c.shares, c.threshold); err == nil {
+			t.Errorf("expected %d of %d shares of a %d byte secret to be rejected", c.threshold, c.shares, len(c.secret))
+		}
+	}
+}
+
+func TestCombineInvalid(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zero := append([]byte{}, shares[1]...)
+	zero[len(zero) - 1] = 0
+
+	for name, shares := range map[string][][]byte{
+		"one share": {shares[0]},
+		"a duplicate share": {shares[0], shares[0]},
+		"a duplicate x coordinate": {shares[0], append(append([]byte{}, shares[1][:len(shares[1]) - 1]...), shares[0][len(shares[0]) - 1])},
+		"a zero x coordinate": {shares[0], zero},
+		"shares of different lengths": {shares[0], shares[1][1:]},
+		"a share without a secret": {{1}, {2}},
+	} {
+		if _, err := Combine(shares); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+}
@@ -1,6 +1,6 @@
-package vault
+package signchain
 
-import "github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+import "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
 
 type EncryptRequest struct {
 	Data []byte `json:"data"`
@@ -0,0 +1,42 @@
+package signchain
+
+import (
+	"context"
+
+	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+// signchainKeyEncryptingKeyProvider wraps data encrypting keys with key
+// encrypting keys held by the Signchain cloud, authenticating with the first
+// configured vault key.
+type signchainKeyEncryptingKeyProvider struct {
+	auth auth.Auth
+}
+
+var _ interfaces.KeyEncryptingKeyProvider = &signchainKeyEncryptingKeyProvider{}
+
+func NewSignchainKeyEncryptingKeyProvider(auth auth.Auth) (interfaces.KeyEncryptingKeyProvider, error) {
+	return &signchainKeyEncryptingKeyProvider{auth: auth}, nil
+}
+
+func (p *signchainKeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	var res interop.APIResponse[EncryptResponse]
+
+	if err := p.auth.Post("/vault/encrypt", &EncryptRequest{Data: plaintext}, &res); err != nil {
+		return "", nil, err
+	} else {
+		return res.Data.KeyEncryptingKey, res.Data.EncryptedData, nil
+	}
+}
+
+func (p *signchainKeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	var res interop.APIResponse[DecryptResponse]
+
+	if err := p.auth.Post("/vault/decrypt", &DecryptRequest{KeyEncryptingKey: keyEncryptingKey, EncryptedData: ciphertext}, &res); err != nil {
+		return nil, err
+	} else {
+		return res.Data, nil
+	}
+}
@@ -0,0 +1,268 @@
+package transit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+const idPrefix = "transit:"
+
+// transitKeyEncryptingKeyProvider wraps data encrypting keys with the transit
+// secrets engine of HashiCorp Vault. Key encrypting key ids have the form
+// transit:<key name>:v<key version> and the transit ciphertext, which repeats
+// the version, is stored as the encrypted key.
+type transitKeyEncryptingKeyProvider struct {
+	client *http.Client
+	addr string
+	namespace string
+	mount string
+	key string
+
+	roleID string
+	secretID string
+	approleMount string
+
+	mutex sync.Mutex
+	token string
+	tokenExpires time.Time
+}
+
+var _ interfaces.KeyEncryptingKeyProvider = &transitKeyEncryptingKeyProvider{}
+var _ interfaces.KeyEncryptingKeyRewrapper = &transitKeyEncryptingKeyProvider{}
+
+type transitResponse struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+		LeaseDuration int64 `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+type ciphertextResponse struct {
+	Ciphertext string `json:"ciphertext"`
+	KeyVersion int64 `json:"key_version"`
+}
+
+type plaintextResponse struct {
+	Plaintext string `json:"plaintext"`
+}
+
+// NewTransitKeyEncryptingKeyProvider connects to the Vault server at
+// VAULT_TRANSIT_ADDR and wraps data encrypting keys with the transit key
+// VAULT_TRANSIT_KEY mounted at VAULT_TRANSIT_MOUNT. It authenticates with
+// VAULT_TRANSIT_TOKEN or, when VAULT_TRANSIT_ROLE_ID is set, by logging in with
+// AppRole.
+func NewTransitKeyEncryptingKeyProvider() (interfaces.KeyEncryptingKeyProvider, error) {
+	p := &transitKeyEncryptingKeyProvider{
+		client: &http.Client{Timeout: 30 * time.Second},
+		addr: strings.TrimSuffix(strings.TrimSpace(os.Getenv("VAULT_TRANSIT_ADDR")), "/"),
+		namespace: strings.TrimSpace(os.Getenv("VAULT_TRANSIT_NAMESPACE")),
+		mount: strings.Trim(strings.TrimSpace(os.Getenv("VAULT_TRANSIT_MOUNT")), "/"),
+		key: strings.TrimSpace(os.Getenv("VAULT_TRANSIT_KEY")),
+		roleID: strings.TrimSpace(os.Getenv("VAULT_TRANSIT_ROLE_ID")),
+		secretID: strings.TrimSpace(os.Getenv("VAULT_TRANSIT_SECRET_ID")),
+		approleMount: strings.Trim(strings.TrimSpace(os.Getenv("VAULT_TRANSIT_APPROLE_MOUNT")), "/"),
+		token: strings.TrimSpace(os.Getenv("VAULT_TRANSIT_TOKEN")),
+	}
+
+	if p.mount == "" {
+		p.mount = "transit"
+	}
+	if p.approleMount == "" {
+		p.approleMount = "approle"
+	}
+
+	if p.addr == "" {
+		return nil, fmt.Errorf("transit address not configured, check online documentation for environment variable VAULT_TRANSIT_ADDR")
+	} else if p.key == "" {
+		return nil, fmt.Errorf("transit key not configured, check online documentation for environment variable VAULT_TRANSIT_KEY")
+	} else if p.roleID == "" && p.token == "" {
+		return nil, fmt.Errorf("transit authentication not configured, check online documentation for environment variables VAULT_TRANSIT_TOKEN and VAULT_TRANSIT_ROLE_ID")
+	}
+
+	// a token configured alongside AppRole is replaced on the first login
+	if p.roleID != "" {
+		p.token = ""
+	}
+
+	if _, err := p.getToken(context.Background()); err != nil {
+		return nil, fmt.Errorf("unable to authenticate with transit: %v", err)
+	}
+
+	return p, nil
+}
+
+func (p *transitKeyEncryptingKeyProvider) do(ctx context.Context, token string, path string, body any) (*transitResponse, int, error) {
+	var res transitResponse
+
+	if b, err := json.Marshal(body); err != nil {
+		return nil, 0, err
+	} else if req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.addr + "/v1/" + path, bytes.NewReader(b)); err != nil {
+		return nil, 0, err
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("X-Vault-Token", token)
+		}
+		if p.namespace != "" {
+			req.Header.Set("X-Vault-Namespace", p.namespace)
+		}
+
+		if r, err := p.client.Do(req); err != nil {
+			return nil, 0, err
+		} else {
+			defer r.Body.Close()
+
+			if b, err := io.ReadAll(r.Body); err != nil {
+				return nil, r.StatusCode, err
+			} else if len(b) > 0 {
+				if err := json.Unmarshal(b, &res); err != nil {
+					return nil, r.StatusCode, fmt.Errorf("invalid transit response: %v", err)
+				}
+			}
+
+			if r.StatusCode < 200 || r.StatusCode >= 300 {
+				return nil, r.StatusCode, fiber.NewError(r.StatusCode, fmt.Sprintf("transit %s: %s", path, strings.Join(res.Errors, ", ")))
+			}
+
+			return &res, r.StatusCode, nil
+		}
+	}
+}
+
+// getToken returns the configured token, or logs in with AppRole when the
+// current token is missing or within a minute of expiring.
+func (p *transitKeyEncryptingKeyProvider) getToken(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.roleID == "" || (p.token != "" && time.Now().Add(time.Minute).Before(p.tokenExpires)) {
+		return p.token, nil
+	}
+
+	if res, _, err := p.do(ctx, "", "auth/" + p.approleMount + "/login", map[string]string{
+		"role_id": p.roleID,
+		"secret_id": p.secretID,
+	}); err != nil {
+		return "", err
+	} else if res.Auth == nil || res.Auth.ClientToken == "" {
+		return "", fmt.Errorf("approle login returned no token")
+	} else {
+		p.token = res.Auth.ClientToken
+		if res.Auth.LeaseDuration > 0 {
+			p.tokenExpires = time.Now().Add(time.Duration(res.Auth.LeaseDuration) * time.Second)
+		} else {
+			p.tokenExpires = time.Now().Add(100 * 365 * 24 * time.Hour)
+		}
+		return p.token, nil
+	}
+}
+
+func (p *transitKeyEncryptingKeyProvider) invalidateToken(token string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.roleID != "" && p.token == token {
+		p.token = ""
+	}
+}
+
+// call posts to the transit engine, logging in again once if an AppRole token
+// has been revoked before its lease expired.
+func (p *transitKeyEncryptingKeyProvider) call(ctx context.Context, operation string, key string, body any, data any) error {
+	path := p.mount + "/" + operation + "/" + key
+
+	for attempt := 0; ; attempt++ {
+		if token, err := p.getToken(ctx); err != nil {
+			return err
+		} else if res, status, err := p.do(ctx, token, path, body); status == http.StatusForbidden && p.roleID != "" && attempt == 0 {
+			p.invalidateToken(token)
+		} else if err != nil {
+			return err
+		} else {
+			return json.Unmarshal(res.Data, data)
+		}
+	}
+}
+
+func formatID(key string, version int64) interfaces.ID {
+	return fmt.Sprintf("%s%s:v%d", idPrefix, key, version)
+}
+
+func parseID(id interfaces.ID) (key string, version int64, err error) {
+	if !strings.HasPrefix(id, idPrefix) {
+		err = fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("key encrypting key %s is not a transit key", id))
+	} else if i := strings.LastIndex(id, ":v"); i <= len(idPrefix) {
+		err = fmt.Errorf("invalid transit key encrypting key %s", id)
+	} else if version, err = strconv.ParseInt(id[i + 2:], 10, 64); err != nil {
+		err = fmt.Errorf("invalid transit key encrypting key %s", id)
+	} else {
+		key = id[len(idPrefix):i]
+	}
+	return
+}
+
+func (p *transitKeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	var res ciphertextResponse
+
+	if err := p.call(ctx, "encrypt", p.key, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	}, &res); err != nil {
+		return "", nil, err
+	} else {
+		return formatID(p.key, res.KeyVersion), []byte(res.Ciphertext), nil
+	}
+}
+
+func (p *transitKeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	var res plaintextResponse
+
+	if key, _, err := parseID(keyEncryptingKey); err != nil {
+		return nil, err
+	} else if err := p.call(ctx, "decrypt", key, map[string]string{
+		"ciphertext": string(ciphertext),
+	}, &res); err != nil {
+		return nil, err
+	} else {
+		return base64.StdEncoding.DecodeString(res.Plaintext)
+	}
+}
+
+// Rewrap re-encrypts a data encrypting key under the latest version of
+// VAULT_TRANSIT_KEY. Keys wrapped by the same transit key are rewrapped without
+// the plaintext leaving Vault. Transit can't rewrap across keys, so keys
+// wrapped by a previous VAULT_TRANSIT_KEY are decrypted and encrypted again.
+func (p *transitKeyEncryptingKeyProvider) Rewrap(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) (interfaces.ID, []byte, error) {
+	var res ciphertextResponse
+
+	if key, _, err := parseID(keyEncryptingKey); err != nil {
+		return "", nil, err
+	} else if key != p.key {
+		if plaintext, err := p.Decrypt(ctx, dataEncryptingKey, keyEncryptingKey, ciphertext); err != nil {
+			return "", nil, err
+		} else {
+			defer clear(plaintext)
+			return p.Encrypt(ctx, dataEncryptingKey, plaintext)
+		}
+	} else if err := p.call(ctx, "rewrap", p.key, map[string]string{
+		"ciphertext": string(ciphertext),
+	}, &res); err != nil {
+		return "", nil, err
+	} else {
+		return formatID(p.key, res.KeyVersion), []byte(res.Ciphertext), nil
+	}
+}
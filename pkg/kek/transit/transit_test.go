@@ -0,0 +1,139 @@
+package transit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+)
+
+// newTestProvider returns a provider for key on its own transit mount of a
+// Vault dev server, disabled when the test ends, e.g.
+//
+//	vault server -dev -dev-root-token-id=root
+//	VAULT_ADDR=http://127.0.0.1:8200 VAULT_TOKEN=root go test ./pkg/kek/transit
+func newTestProvider(t *testing.T, mount string, key string) *transitKeyEncryptingKeyProvider {
+	if os.Getenv("VAULT_ADDR") == "" {
+		t.Skip("VAULT_ADDR not set")
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		token = "root"
+	}
+
+	t.Setenv("VAULT_TRANSIT_ADDR", os.Getenv("VAULT_ADDR"))
+	t.Setenv("VAULT_TRANSIT_TOKEN", token)
+	t.Setenv("VAULT_TRANSIT_MOUNT", mount)
+	t.Setenv("VAULT_TRANSIT_KEY", key)
+
+	provider, err := NewTransitKeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*transitKeyEncryptingKeyProvider)
+}
+
+// newTestMount enables a transit mount with a random name.
+func newTestMount(t *testing.T) string {
+	p := newTestProvider(t, "transit", "unused")
+
+	name := make([]byte, 8)
+	rand.Read(name)
+	mount := "transit-test-" + hex.EncodeToString(name)
+
+	if _, _, err := p.do(context.Background(), p.token, "sys/mounts/" + mount, map[string]string{"type": "transit"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if req, err := http.NewRequest(http.MethodDelete, p.addr + "/v1/sys/mounts/" + mount, nil); err == nil {
+			req.Header.Set("X-Vault-Token", p.token)
+			if res, err := p.client.Do(req); err == nil {
+				res.Body.Close()
+			}
+		}
+	})
+
+	return mount
+}
+
+func (p *transitKeyEncryptingKeyProvider) rotate(t *testing.T, key string) {
+	if _, _, err := p.do(context.Background(), p.token, p.mount + "/keys/" + key + "/rotate", struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t, newTestMount(t), "signchain-vault")
+	dataEncryptingKey := interfaces.ID("dek-transit-test")
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	// encrypting creates the transit key at version 1
+	id, ciphertext, err := p.Encrypt(ctx, dataEncryptingKey, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != "transit:signchain-vault:v1" {
+		t.Fatalf("expected transit:signchain-vault:v1, got %s", id)
+	} else if !strings.HasPrefix(string(ciphertext), "vault:v1:") {
+		t.Fatalf("unexpected transit ciphertext %s", ciphertext)
+	}
+
+	if decrypted, err := p.Decrypt(ctx, dataEncryptingKey, id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted key differs")
+	}
+
+	if _, err := p.Decrypt(ctx, dataEncryptingKey, "local:1", ciphertext); err == nil {
+		t.Fatal("expected a key encrypting key of another provider to be rejected")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t, newTestMount(t), "signchain-vault")
+	dataEncryptingKey := interfaces.ID("dek-transit-test")
+	plaintext := bytes.Repeat([]byte{7}, 32)
+
+	id, ciphertext, err := p.Encrypt(ctx, dataEncryptingKey, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.rotate(t, "signchain-vault")
+
+	if rewrapped, rewrappedCiphertext, err := p.Rewrap(ctx, dataEncryptingKey, id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if rewrapped != "transit:signchain-vault:v2" {
+		t.Fatalf("expected transit:signchain-vault:v2, got %s", rewrapped)
+	} else if decrypted, err := p.Decrypt(ctx, dataEncryptingKey, rewrapped, rewrappedCiphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("rewrapped key decrypts to a different key")
+	}
+
+	// a provider configured with another transit key moves keys to it
+	current := newTestProvider(t, p.mount, "current")
+
+	if _, _, err := current.Encrypt(ctx, dataEncryptingKey, plaintext); err != nil {
+		t.Fatal(err)
+	}
+
+	if rewrapped, rewrappedCiphertext, err := current.Rewrap(ctx, dataEncryptingKey, id, ciphertext); err != nil {
+		t.Fatal(err)
+	} else if rewrapped != "transit:current:v1" {
+		t.Fatalf("expected transit:current:v1, got %s", rewrapped)
+	} else if decrypted, err := current.Decrypt(ctx, dataEncryptingKey, rewrapped, rewrappedCiphertext); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("rewrapped key decrypts to a different key")
+	}
+}
@@ -0,0 +1,160 @@
+// Package keystore reads Ethereum V3 keystore files, which encrypt a private
+// key with AES-128-CTR under a key derived from a password with scrypt or
+// PBKDF2. Keystores are written with go-ethereum's keystore.EncryptKey.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const version = 3
+
+const dkLen = 32
+
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+type cryptoJSON struct {
+	Cipher string `json:"cipher"`
+	CipherText string `json:"ciphertext"`
+	CipherParams cipherParams `json:"cipherparams"`
+	KDF string `json:"kdf"`
+	KDFParams map[string]any `json:"kdfparams"`
+	MAC string `json:"mac"`
+}
+
+type keyJSON struct {
+	Address string `json:"address,omitempty"`
+	Crypto cryptoJSON `json:"crypto"`
+	ID string `json:"id,omitempty"`
+	Version int `json:"version"`
+}
+
+// Decrypt decrypts the private key in a V3 keystore with password and checks
+// that it derives to the address recorded in the keystore, when present.
+func Decrypt(keystore []byte, password string) (*ecdsa.PrivateKey, error) {
+	var k keyJSON
+
+	if err := json.Unmarshal(keystore, &k); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %v", err)
+	} else if k.Version != version {
+		return nil, fmt.Errorf("unsupported keystore version %d", k.Version)
+	} else if k.Crypto.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("unsupported keystore cipher %s", k.Crypto.Cipher)
+	}
+
+	derivedKey, err := deriveKey(k.Crypto, password)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(derivedKey)
+
+	var privateKeyBytes []byte
+	defer func() {
+		clear(privateKeyBytes)
+	}()
+
+	if ciphertext, err := hex.DecodeString(k.Crypto.CipherText); err != nil {
+		return nil, fmt.Errorf("invalid keystore ciphertext: %v", err)
+	} else if iv, err := hex.DecodeString(k.Crypto.CipherParams.IV); err != nil {
+		return nil, fmt.Errorf("invalid keystore iv: %v", err)
+	} else if mac, err := hex.DecodeString(k.Crypto.MAC); err != nil {
+		return nil, fmt.Errorf("invalid keystore mac: %v", err)
+	} else if subtle.ConstantTimeCompare(crypto.Keccak256(derivedKey[16:32], ciphertext), mac) != 1 {
+		return nil, fmt.Errorf("could not decrypt keystore with the given password")
+	} else if block, err := aes.NewCipher(derivedKey[:16]); err != nil {
+		return nil, err
+	} else if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid keystore iv length %d", len(iv))
+	} else {
+		privateKeyBytes = make([]byte, len(ciphertext))
+		cipher.NewCTR(block, iv).XORKeyStream(privateKeyBytes, ciphertext)
+	}
+
+	if privateKey, err := crypto.ToECDSA(privateKeyBytes); err != nil {
+		return nil, fmt.Errorf("invalid keystore private key: %v", err)
+	} else if k.Address != "" && common.HexToAddress(k.Address) != crypto.PubkeyToAddress(privateKey.PublicKey) {
+		return nil, fmt.Errorf("keystore private key does not derive to address %s", k.Address)
+	} else {
+		return privateKey, nil
+	}
+}
+
+// Limits on the key derivation parameters of keystores read by Decrypt, which
+// come from the uploaded keystore. scrypt work, and with it memory, is capped at
+// that of the standard geth parameters n=2^18, r=8, p=1.
+const (
+	maxScryptN = 1 << 18
+	maxScryptWork = maxScryptN * 8
+	maxPBKDF2C = 10_000_000
+)
+
+func deriveKey(c cryptoJSON, password string) ([]byte, error) {
+	salt, err := hex.DecodeString(stringParam(c.KDFParams, "salt"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore salt: %v", err)
+	}
+
+	if dklen, err := intParam(c.KDFParams, "dklen", dkLen); err != nil {
+		return nil, err
+	} else if dklen != dkLen {
+		return nil, fmt.Errorf("invalid keystore dklen %d", dklen)
+	}
+
+	switch c.KDF {
+	case "scrypt":
+		if n, err := intParam(c.KDFParams, "n", maxScryptN); err != nil {
+			return nil, err
+		} else if n < 2 || n & (n - 1) != 0 {
+			return nil, fmt.Errorf("invalid keystore n %d", n)
+		} else if r, err := intParam(c.KDFParams, "r", maxScryptWork); err != nil {
+			return nil, err
+		} else if p, err := intParam(c.KDFParams, "p", maxScryptWork); err != nil {
+			return nil, err
+		} else if n * r * p > maxScryptWork {
+			return nil, fmt.Errorf("keystore scrypt parameters n=%d r=%d p=%d exceed the supported work", n, r, p)
+		} else {
+			return scrypt.Key([]byte(password), salt, n, r, p, dkLen)
+		}
+	case "pbkdf2":
+		if prf := stringParam(c.KDFParams, "prf"); prf != "hmac-sha256" {
+			return nil, fmt.Errorf("unsupported keystore pbkdf2 prf %s", prf)
+		} else if iterations, err := intParam(c.KDFParams, "c", maxPBKDF2C); err != nil {
+			return nil, err
+		} else {
+			return pbkdf2.Key([]byte(password), salt, iterations, dkLen, sha256.New), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported keystore kdf %s", c.KDF)
+	}
+}
+
+func stringParam(params map[string]any, name string) string {
+	s, _ := params[name].(string)
+	return strings.TrimPrefix(s, "0x")
+}
+
+// intParam reads a number, which JSON decodes as float64, requiring a whole
+// number between 1 and max.
+func intParam(params map[string]any, name string, max int) (int, error) {
+	if f, ok := params[name].(float64); !ok || f != math.Trunc(f) || f < 1 || f > float64(max) {
+		return 0, fmt.Errorf("invalid keystore %s %v", name, params[name])
+	} else {
+		return int(f), nil
+	}
+}
@@ -0,0 +1,91 @@
+package keystore
+
+import (
+	"encoding/json"
+	"testing"
+
+	gethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+)
+
+func gethKeystore(t *testing.T, password string) ([]byte, *gethkeystore.Key) {
+	t.Helper()
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := &gethkeystore.Key{
+		Id: uuid.New(),
+		Address: crypto.PubkeyToAddress(privateKey.PublicKey),
+		PrivateKey: privateKey,
+	}
+
+	keystore, err := gethkeystore.EncryptKey(key, password, gethkeystore.LightScryptN, gethkeystore.LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keystore, key
+}
+
+func TestDecryptGethKeystore(t *testing.T) {
+	keystore, key := gethKeystore(t, "password")
+
+	if privateKey, err := Decrypt(keystore, "password"); err != nil {
+		t.Fatal(err)
+	} else if crypto.PubkeyToAddress(privateKey.PublicKey) != key.Address {
+		t.Fatalf("decrypted key derives to %s, expected %s", crypto.PubkeyToAddress(privateKey.PublicKey), key.Address)
+	}
+
+	if _, err := Decrypt(keystore, "wrong"); err == nil {
+		t.Fatal("expected decrypt with the wrong password to fail")
+	}
+}
+
+func TestDecryptRejectsKDFParams(t *testing.T) {
+	keystore, _ := gethKeystore(t, "password")
+
+	tests := map[string]struct {
+		kdf string
+		params map[string]any
+	}{
+		"scrypt n too large": {"scrypt", map[string]any{"n": 1 << 30}},
+		"scrypt n not a power of two": {"scrypt", map[string]any{"n": 4097}},
+		"scrypt n fractional": {"scrypt", map[string]any{"n": 4096.5}},
+		"scrypt r missing": {"scrypt", map[string]any{"r": nil}},
+		"scrypt r zero": {"scrypt", map[string]any{"r": 0}},
+		"scrypt p negative": {"scrypt", map[string]any{"p": -1}},
+		"scrypt work too large": {"scrypt", map[string]any{"n": 1 << 18, "r": 8, "p": 2}},
+		"scrypt r overflows int": {"scrypt", map[string]any{"r": 1e300}},
+		"dklen too small": {"scrypt", map[string]any{"dklen": 16}},
+		"dklen too large": {"scrypt", map[string]any{"dklen": 1 << 20}},
+		"pbkdf2 c too large": {"pbkdf2", map[string]any{"c": 1e8, "prf": "hmac-sha256"}},
+		"pbkdf2 c zero": {"pbkdf2", map[string]any{"c": 0, "prf": "hmac-sha256"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var k keyJSON
+			if err := json.Unmarshal(keystore, &k); err != nil {
+				t.Fatal(err)
+			}
+
+			k.Crypto.KDF = test.kdf
+			for param, value := range test.params {
+				if value == nil {
+					delete(k.Crypto.KDFParams, param)
+				} else {
+					k.Crypto.KDFParams[param] = value
+				}
+			}
+
+			if b, err := json.Marshal(k); err != nil {
+				t.Fatal(err)
+			} else if _, err := Decrypt(b, "password"); err == nil {
+				t.Fatal("expected decrypt to reject the kdf parameters")
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+const (
+	phaseKeys = "keys"
+	phaseWallets = "wallets"
+	phaseSeeds = "seeds"
+	phaseDone = "done"
+)
+
+// Checkpoint records how far a migration has progressed so that an
+// interrupted migration resumes where it stopped. Copies are idempotent, so
+// records copied after the last checkpoint was saved are simply copied again.
+// Data encrypting keys are paged after the id of the last key copied, and
+// wallets after the address of the last wallet copied.
+type Checkpoint struct {
+	Phase string `json:"phase"`
+	Offset int64 `json:"offset"`
+	AfterKey interfaces.ID `json:"afterKey"`
+	After common.Address `json:"after"`
+	Keys int64 `json:"keys"`
+	Wallets int64 `json:"wallets"`
+	Seeds int64 `json:"seeds"`
+}
+
+// Migration copies data encrypting keys, wallets and seeds between storage
+// backends without decrypting them. Ids, timestamps, expiry and data encrypting key
+// references are preserved. Signatures recorded by the replay protection ledger
+// are not copied.
+type Migration struct {
+	source interfaces.IStorageBackend
+	target interfaces.IStorageBackend
+	checkpointPath string
+	batchSize int64
+	checkpoint Checkpoint
+	copiedKeys map[interfaces.ID]bool
+}
+
+func NewMigration(source interfaces.IStorageBackend, target interfaces.IStorageBackend, checkpointPath string, batchSize int64) (*Migration, error) {
+	m := &Migration{
+		source: source,
+		target: target,
+		checkpointPath: checkpointPath,
+		batchSize: batchSize,
+		checkpoint: Checkpoint{Phase: phaseKeys},
+		copiedKeys: map[interfaces.ID]bool{},
+	}
+
+	if m.batchSize <= 0 {
+		m.batchSize = 100
+	}
+
+	if err := m.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Migration) Checkpoint() Checkpoint {
+	return m.checkpoint
+}
+
+func (m *Migration) loadCheckpoint() error {
+	if m.checkpointPath == "" {
+		return nil
+	} else if b, err := os.ReadFile(m.checkpointPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err := json.Unmarshal(b, &m.checkpoint); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %v", m.checkpointPath, err)
+	} else {
+		return nil
+	}
+}
+
+// saveCheckpoint replaces the checkpoint file atomically, so a crash while
+// saving leaves the previous checkpoint in place.
+func (m *Migration) saveCheckpoint() error {
+	if m.checkpointPath == "" {
+		return nil
+	}
+
+	tmp := m.checkpointPath + ".tmp"
+
+	if b, err := json.Marshal(&m.checkpoint); err != nil {
+		return err
+	} else if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	} else {
+		return os.Rename(tmp, m.checkpointPath)
+	}
+}
+
+func (m *Migration) advance(phase string) error {
+	m.checkpoint.Phase = phase
+	m.checkpoint.Offset = 0
+	m.checkpoint.AfterKey = ""
+	m.checkpoint.After = common.Address{}
+	return m.saveCheckpoint()
+}
+
+// Run copies every data encrypting key, then every wallet and then every seed,
+// saving a checkpoint after each batch.
+func (m *Migration) Run(ctx context.Context) error {
+	if m.checkpoint.Phase == phaseKeys {
+		if err := m.copyKeys(ctx); err != nil {
+			return err
+		} else if err := m.advance(phaseWallets); err != nil {
+			return err
+		}
+	}
+
+	if m.checkpoint.Phase == phaseWallets {
+		if err := m.copyWallets(ctx); err != nil {
+			return err
+		} else if err := m.advance(phaseSeeds); err != nil {
+			return err
+		}
+	}
+
+	if m.checkpoint.Phase == phaseSeeds {
+		if err := m.copySeeds(ctx); err != nil {
+			return err
+		} else if err := m.advance(phaseDone); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("migrated %d data encrypting keys, %d wallets and %d seeds", m.checkpoint.Keys, m.checkpoint.Wallets, m.checkpoint.Seeds)
+	return nil
+}
+
+func (m *Migration) copyKeys(ctx context.Context) error {
+	for {
+		if r, err := m.source.ListDataEncryptingKeys(ctx, m.checkpoint.AfterKey, m.batchSize); err != nil {
+			return err
+		} else if len(r.Page()) == 0 {
+			return nil
+		} else {
+			for _, k := range r.Page() {
+				if _, err := m.target.PutDataEncryptingKey(ctx, k); err != nil {
+					return fmt.Errorf("unable to copy data encrypting key %s: %v", k.ID(), err)
+				}
+				m.copiedKeys[k.ID()] = true
+			}
+
+			m.checkpoint.Offset += int64(len(r.Page()))
+			m.checkpoint.AfterKey = r.Page()[len(r.Page()) - 1].ID()
+			m.checkpoint.Keys += int64(len(r.Page()))
+			if err := m.saveCheckpoint(); err != nil {
+				return err
+			}
+			log.Infof("copied %d of %d data encrypting keys", m.checkpoint.Offset, r.Count())
+		}
+	}
+}
+
+// ensureKey copies the data encrypting key of a wallet if it is not yet in the
+// target, which covers keys created in the source after they were copied.
+func (m *Migration) ensureKey(ctx context.Context, id interfaces.ID) error {
+	var e *fiber.Error
+
+	if m.copiedKeys[id] {
+		return nil
+	} else if _, err := m.target.GetDataEncryptingKey(ctx, id); err == nil {
+		m.copiedKeys[id] = true
+		return nil
+	} else if !errors.As(err, &e) || e.Code != fiber.StatusNotFound {
+		return err
+	} else if k, err := m.source.GetDataEncryptingKey(ctx, id); err != nil {
+		return err
+	} else if _, err := m.target.PutDataEncryptingKey(ctx, k); err != nil {
+		return err
+	} else {
+		m.copiedKeys[id] = true
+		m.checkpoint.Keys++
+		return nil
+	}
+}
+
+func (m *Migration) copyWallets(ctx context.Context) error {
+	for {
+		if r, err := m.source.ListAllWallets(ctx, m.checkpoint.After, m.batchSize); err != nil {
+			return err
+		} else if len(r.Page()) == 0 {
+			return nil
+		} else {
+			for _, w := range r.Page() {
+				if err := m.ensureKey(ctx, w.DataEncryptingKey()); err != nil {
+					return fmt.Errorf("unable to copy data encrypting key %s of wallet %s: %v", w.DataEncryptingKey(), w.ID(), err)
+				} else if _, err := m.target.PutWallet(ctx, w); err != nil {
+					return fmt.Errorf("unable to copy wallet %s: %v", w.ID(), err)
+				}
+			}
+
+			m.checkpoint.Offset += int64(len(r.Page()))
+			m.checkpoint.After = r.Page()[len(r.Page()) - 1].Address()
+			m.checkpoint.Wallets += int64(len(r.Page()))
+			if err := m.saveCheckpoint(); err != nil {
+				return err
+			}
+			log.Infof("copied %d of %d wallets", m.checkpoint.Offset, r.Count())
+		}
+	}
+}
+
+// copySeeds copies the seeds of accounts with derived wallets. The next index
+// of a seed is copied as read, so the source should no longer derive wallets
+// once the seeds are copied.
+func (m *Migration) copySeeds(ctx context.Context) error {
+	for {
+		if r, err := m.source.ListAllSeeds(ctx, m.checkpoint.Offset, m.batchSize); err != nil {
+			return err
+		} else if len(r.Page()) == 0 {
+			return nil
+		} else {
+			for _, s := range r.Page() {
+				if err := m.ensureKey(ctx, s.DataEncryptingKey()); err != nil {
+					return fmt.Errorf("unable to copy data encrypting key %s of the seed of account %s: %v", s.DataEncryptingKey(), s.Account(), err)
+				} else if _, err := m.target.PutSeed(ctx, s); err != nil {
+					return fmt.Errorf("unable to copy the seed of account %s: %v", s.Account(), err)
+				}
+			}
+
+			m.checkpoint.Offset += int64(len(r.Page()))
+			m.checkpoint.Seeds += int64(len(r.Page()))
+			if err := m.saveCheckpoint(); err != nil {
+				return err
+			}
+			log.Infof("copied %d of %d seeds", m.checkpoint.Offset, r.Count())
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/file"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/memory"
+)
+
+func newBackend(t *testing.T) interfaces.IStorageBackend {
+	backend, err := memory.NewMemoryStorageBackend(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// newFileBackend opens a file backend in a temporary directory with the sweep
+// loop disabled.
+func newFileBackend(t *testing.T) interfaces.IStorageBackend {
+	t.Setenv("VAULT_FILE_PATH", filepath.Join(t.TempDir(), "vault.db"))
+	t.Setenv("VAULT_FILE_SWEEP_INTERVAL", "0")
+
+	backend, err := file.NewFileStorageBackend(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func newAddress() common.Address {
+	address := make([]byte, common.AddressLength)
+	rand.Read(address)
+	return common.BytesToAddress(address)
+}
+
+// interruptedTarget fails to put wallets once wallets have been put, as a
+// migration interrupted part way through the wallets.
+type interruptedTarget struct {
+	interfaces.IStorageBackend
+	wallets int
+}
+
+func (b *interruptedTarget) PutWallet(ctx context.Context, w interfaces.Wallet) (interfaces.Wallet, error) {
+	if b.wallets == 0 {
+		return nil, errors.New("interrupted")
+	}
+	b.wallets--
+	return b.IStorageBackend.PutWallet(ctx, w)
+}
+
+// TestMigration copies wallets across several batches, resuming from the
+// checkpoint of an earlier migration, and verifies the copy.
+func TestMigration(t *testing.T) {
+	ctx := context.Background()
+	source := newBackend(t)
+	target := newBackend(t)
+	checkpoint := filepath.Join(t.TempDir(), "migrate.checkpoint")
+
+	k, err := source.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		address := make([]byte, common.AddressLength)
+		rand.Read(address)
+		if _, err := source.CreateWallet(ctx, "account", "wallet", common.BytesToAddress(address), k.ID(), make([]byte, 60)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if m, err := NewMigration(source, target, checkpoint, 2); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	} else if c := m.Checkpoint(); c.Keys != 1 || c.Wallets != 5 {
+		t.Fatalf("expected 1 key and 5 wallets copied, got %+v", c)
+	}
+
+	// a finished migration resumes at the end without copying again
+	if m, err := NewMigration(source, target, checkpoint, 2); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	} else if report, err := m.Verify(ctx); err != nil {
+		t.Fatal(err)
+	} else if !report.OK() || report.CheckedWallets != 5 {
+		t.Fatalf("expected 5 wallets verified, got %+v", report)
+	}
+
+	if keys, err := target.ListDataEncryptingKeys(ctx, "", 10); err != nil {
+		t.Fatal(err)
+	} else if k, err := target.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatal(err)
+	} else if refCount, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if keys.Count() != 1 || refCount != 5 {
+		t.Fatalf("expected 1 key referenced by 5 wallets, got %d keys and %d references", keys.Count(), refCount)
+	}
+}
+
+// TestMigrationToFile interrupts a migration from memory to a file backend
+// while copying wallets, creates a wallet with a new data encrypting key in the
+// source, and resumes from the checkpoint. The new key is only copied with its
+// wallet, as the keys were copied before it was created.
+func TestMigrationToFile(t *testing.T) {
+	ctx := context.Background()
+	source := newBackend(t)
+	target := newFileBackend(t)
+	checkpoint := filepath.Join(t.TempDir(), "migrate.checkpoint")
+
+	for i := 0; i < 3; i++ {
+		if k, err := source.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60)); err != nil {
+			t.Fatal(err)
+		} else if _, err := source.CreateWallet(ctx, "account", "wallet", newAddress(), k.ID(), make([]byte, 60)); err != nil {
+			t.Fatal(err)
+		} else if _, err := source.CreateWallet(ctx, "account", "wallet", newAddress(), k.ID(), make([]byte, 60)); err != nil {
+			t.Fatal(err)
+		} else if i == 0 {
+			if _, err := source.CreateSeed(ctx, "account", k.ID(), make([]byte, 60)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if m, err := NewMigration(source, &interruptedTarget{IStorageBackend: target, wallets: 3}, checkpoint, 2); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(ctx); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected the migration to be interrupted, got %v", err)
+	} else if c := m.Checkpoint(); c.Phase != phaseWallets || c.Keys != 3 || c.Wallets != 2 {
+		t.Fatalf("expected 3 keys and 2 wallets copied, got %+v", c)
+	}
+
+	// wallets are copied in address order, so the new wallet is still ahead
+	late, err := source.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := source.CreateWallet(ctx, "account", "wallet", common.HexToAddress("0xffffffffffffffffffffffffffffffffffffffff"), late.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMigration(source, target, checkpoint, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if c := m.Checkpoint(); c.Phase != phaseWallets || c.Wallets != 2 {
+		t.Fatalf("expected the migration to resume at wallet 2, got %+v", c)
+	} else if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	} else if c := m.Checkpoint(); c.Phase != phaseDone || c.Keys != 4 || c.Wallets != 7 || c.Seeds != 1 {
+		t.Fatalf("expected 4 keys, 7 wallets and 1 seed copied, got %+v", c)
+	}
+
+	if k, err := target.GetDataEncryptingKey(ctx, late.ID()); err != nil {
+		t.Fatalf("expected the key created during the migration to be copied: %v", err)
+	} else if refCount, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if refCount != 1 {
+		t.Fatalf("expected 1 reference to the key created during the migration, got %d", refCount)
+	}
+
+	if report, err := m.Verify(ctx); err != nil {
+		t.Fatal(err)
+	} else if !report.OK() || report.CheckedKeys != 4 || report.CheckedWallets != 7 || report.CheckedSeeds != 1 {
+		t.Fatalf("expected 4 keys, 7 wallets and 1 seed verified, got %+v", report)
+	}
+}
+
+// TestVerifyMismatches changes the copies in the target after a migration and
+// checks that Verify reports each change.
+func TestVerifyMismatches(t *testing.T) {
+	ctx := context.Background()
+	source := newBackend(t)
+	target := newFileBackend(t)
+
+	k, err := source.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := source.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := source.CreateWallet(ctx, "account", "wallet", newAddress(), k.ID(), make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := source.CreateSeed(ctx, "account", k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMigration(source, target, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the target key is rewrapped, its wallet moved to another key, the source
+	// derives another wallet and gains a key the target doesn't have
+	if _, err := target.UpdateDataEncryptingKey(ctx, k.ID(), "kek-1", "kek-2", make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	} else if _, err := target.UpdateWalletDataEncryptingKey(ctx, "account", w.Address(), k.ID(), other.ID(), make([]byte, 61)); err != nil {
+		t.Fatal(err)
+	} else if _, err := source.ReserveSeedIndexes(ctx, "account", 1); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := source.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := m.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if report.OK() {
+		t.Fatalf("expected verification to fail, got %+v", report)
+	} else if report.SourceKeys != 3 || report.TargetKeys != 2 {
+		t.Fatalf("expected 3 source and 2 target keys, got %+v", report)
+	}
+
+	reasons := map[interfaces.ID]string{}
+	for _, mismatch := range report.Mismatches {
+		reasons[mismatch.ID] = mismatch.Reason
+	}
+
+	for id, reason := range map[interfaces.ID]string{
+		k.ID(): "key encrypting key kek-2 does not match kek-1",
+		missing.ID(): "data encrypting key missing from target",
+		w.ID(): "data encrypting key " + other.ID() + " does not match " + k.ID(),
+		"account": "seed next index 0 is behind 1",
+	} {
+		if !strings.HasPrefix(reasons[id], reason) {
+			t.Errorf("expected mismatch %q for %s, got %q", reason, id, reasons[id])
+		}
+	}
+
+	if len(report.Mismatches) != 4 {
+		t.Fatalf("expected 4 mismatches, got %+v", report.Mismatches)
+	}
+}
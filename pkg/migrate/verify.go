@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type Mismatch struct {
+	ID interfaces.ID `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// Report is the result of verifying a migration. Counts differ when the
+// source is still serving requests, in which case the migration should be run
+// again before verifying.
+type Report struct {
+	SourceKeys int64 `json:"sourceKeys"`
+	TargetKeys int64 `json:"targetKeys"`
+	SourceWallets int64 `json:"sourceWallets"`
+	TargetWallets int64 `json:"targetWallets"`
+	SourceSeeds int64 `json:"sourceSeeds"`
+	TargetSeeds int64 `json:"targetSeeds"`
+	CheckedKeys int64 `json:"checkedKeys"`
+	CheckedWallets int64 `json:"checkedWallets"`
+	CheckedSeeds int64 `json:"checkedSeeds"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+func (r *Report) OK() bool {
+	return r.SourceKeys == r.TargetKeys && r.SourceWallets == r.TargetWallets && r.SourceSeeds == r.TargetSeeds && len(r.Mismatches) == 0
+}
+
+func (r *Report) mismatch(id interfaces.ID, format string, args ...any) {
+	r.Mismatches = append(r.Mismatches, Mismatch{ID: id, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Verify compares the number of keys, wallets and seeds in both backends and
+// the sha256 hash of every ciphertext in the source with its copy in the
+// target.
+func (m *Migration) Verify(ctx context.Context) (*Report, error) {
+	var r Report
+
+	if keys, err := m.source.ListDataEncryptingKeys(ctx, "", 1); err != nil {
+		return nil, err
+	} else if targetKeys, err := m.target.ListDataEncryptingKeys(ctx, "", 1); err != nil {
+		return nil, err
+	} else if wallets, err := m.source.ListAllWallets(ctx, common.Address{}, 1); err != nil {
+		return nil, err
+	} else if targetWallets, err := m.target.ListAllWallets(ctx, common.Address{}, 1); err != nil {
+		return nil, err
+	} else if seeds, err := m.source.ListAllSeeds(ctx, 0, 1); err != nil {
+		return nil, err
+	} else if targetSeeds, err := m.target.ListAllSeeds(ctx, 0, 1); err != nil {
+		return nil, err
+	} else {
+		r.SourceKeys = keys.Count()
+		r.TargetKeys = targetKeys.Count()
+		r.SourceWallets = wallets.Count()
+		r.TargetWallets = targetWallets.Count()
+		r.SourceSeeds = seeds.Count()
+		r.TargetSeeds = targetSeeds.Count()
+	}
+
+	for after := interfaces.ID(""); ; {
+		page, err := m.source.ListDataEncryptingKeys(ctx, after, m.batchSize)
+		if err != nil {
+			return nil, err
+		} else if len(page.Page()) == 0 {
+			break
+		}
+
+		for _, k := range page.Page() {
+			r.CheckedKeys++
+			if copied, err := m.target.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+				r.mismatch(k.ID(), "data encrypting key missing from target: %v", err)
+			} else if copied.KeyEncryptingKey() != k.KeyEncryptingKey() {
+				r.mismatch(k.ID(), "key encrypting key %s does not match %s", copied.KeyEncryptingKey(), k.KeyEncryptingKey())
+			} else if sha256.Sum256(copied.EncryptedKey()) != sha256.Sum256(k.EncryptedKey()) {
+				r.mismatch(k.ID(), "encrypted key hash does not match")
+			}
+		}
+
+		after = page.Page()[len(page.Page()) - 1].ID()
+	}
+
+	for after := (common.Address{}); ; {
+		page, err := m.source.ListAllWallets(ctx, after, m.batchSize)
+		if err != nil {
+			return nil, err
+		} else if len(page.Page()) == 0 {
+			break
+		}
+
+		for _, w := range page.Page() {
+			r.CheckedWallets++
+			if copied, err := m.target.GetWallet(ctx, w.Account(), w.Address()); err != nil {
+				r.mismatch(w.ID(), "wallet missing from target: %v", err)
+			} else if copied.ID() != w.ID() {
+				r.mismatch(w.ID(), "wallet id %s does not match", copied.ID())
+			} else if copied.DataEncryptingKey() != w.DataEncryptingKey() {
+				r.mismatch(w.ID(), "data encrypting key %s does not match %s", copied.DataEncryptingKey(), w.DataEncryptingKey())
+			} else if sha256.Sum256(copied.EncryptedPrivateKey()) != sha256.Sum256(w.EncryptedPrivateKey()) {
+				r.mismatch(w.ID(), "encrypted private key hash does not match")
+			} else if !slices.Equal(copied.Permissions(), w.Permissions()) {
+				r.mismatch(w.ID(), "permissions %v do not match %v", copied.Permissions(), w.Permissions())
+			}
+		}
+
+		after = page.Page()[len(page.Page()) - 1].Address()
+	}
+
+	for offset := int64(0); ; {
+		page, err := m.source.ListAllSeeds(ctx, offset, m.batchSize)
+		if err != nil {
+			return nil, err
+		} else if len(page.Page()) == 0 {
+			break
+		}
+
+		for _, s := range page.Page() {
+			r.CheckedSeeds++
+			if copied, err := m.target.GetSeed(ctx, s.Account()); err != nil {
+				r.mismatch(s.Account(), "seed missing from target: %v", err)
+			} else if copied.DataEncryptingKey() != s.DataEncryptingKey() {
+				r.mismatch(s.Account(), "seed data encrypting key %s does not match %s", copied.DataEncryptingKey(), s.DataEncryptingKey())
+			} else if copied.NextIndex() < s.NextIndex() {
+				r.mismatch(s.Account(), "seed next index %d is behind %d", copied.NextIndex(), s.NextIndex())
+			} else if sha256.Sum256(copied.EncryptedSeed()) != sha256.Sum256(s.EncryptedSeed()) {
+				r.mismatch(s.Account(), "encrypted seed hash does not match")
+			}
+		}
+
+		offset += int64(len(page.Page()))
+	}
+
+	log.Infof("verified %d data encrypting keys, %d wallets and %d seeds, %d mismatches", r.CheckedKeys, r.CheckedWallets, r.CheckedSeeds, len(r.Mismatches))
+	return &r, nil
+}
@@ -0,0 +1,52 @@
+package rotation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// readCheckpoint reads the checkpoint at path into v, leaving v unchanged when
+// there is no checkpoint.
+func readCheckpoint(path string, v any) error {
+	if path == "" {
+		return nil
+	} else if b, err := os.ReadFile(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %v", path, err)
+	} else {
+		return nil
+	}
+}
+
+// writeCheckpoint replaces the checkpoint file atomically, so a crash while
+// saving leaves the previous checkpoint in place.
+func writeCheckpoint(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+
+	if b, err := json.Marshal(v); err != nil {
+		return err
+	} else if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	} else {
+		return os.Rename(tmp, path)
+	}
+}
+
+func removeCheckpoint(path string) error {
+	if path == "" {
+		return nil
+	} else if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else {
+		return nil
+	}
+}
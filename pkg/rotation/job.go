@@ -0,0 +1,123 @@
+package rotation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// Status reports the progress of the rotation most recently started by a Job.
+type Status struct {
+	Running bool `json:"running"`
+	Started *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error string `json:"error,omitempty"`
+	Progress *Checkpoint `json:"progress,omitempty"`
+}
+
+// Job runs one rotation at a time in the background. A stopped or failed
+// rotation resumes from its checkpoint when the job is started again.
+type Job struct {
+	vault vault.Vault
+	storage interfaces.IStorageBackend
+	options Options
+
+	mutex sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	done chan struct{}
+}
+
+func NewJob(vault vault.Vault, storage interfaces.IStorageBackend, options Options) *Job {
+	return &Job{vault: vault, storage: storage, options: options}
+}
+
+func (j *Job) Start() (Status, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.status.Running {
+		return j.status, fiber.NewError(fiber.StatusConflict, "rotation already running")
+	}
+
+	r, err := NewRotation(j.vault, j.storage, j.options)
+	if err != nil {
+		return j.status, err
+	}
+
+	progress := make(chan Checkpoint, 1)
+	r.OnProgress(progress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := time.Now()
+	checkpoint := r.Checkpoint()
+
+	j.status = Status{Running: true, Started: &started, Progress: &checkpoint}
+	j.cancel = cancel
+	j.done = make(chan struct{})
+
+	go j.run(ctx, r, progress)
+
+	return j.status, nil
+}
+
+func (j *Job) watch(progress chan Checkpoint, watched chan struct{}) {
+	for c := range progress {
+		j.mutex.Lock()
+		j.status.Progress = &c
+		j.mutex.Unlock()
+	}
+	close(watched)
+}
+
+func (j *Job) run(ctx context.Context, r *Rotation, progress chan Checkpoint) {
+	watched := make(chan struct{})
+	go j.watch(progress, watched)
+
+	err := r.Run(ctx)
+	close(progress)
+	<-watched
+
+	checkpoint := r.Checkpoint()
+	finished := time.Now()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.status.Running = false
+	j.status.Finished = &finished
+	j.status.Progress = &checkpoint
+	if err != nil {
+		log.Errorf("rotation stopped: %v", err)
+		j.status.Error = err.Error()
+	}
+	j.cancel()
+	close(j.done)
+}
+
+// Stop cancels a running rotation after the wallet being rotated and waits for
+// it to stop.
+func (j *Job) Stop() Status {
+	j.mutex.Lock()
+	running, cancel, done := j.status.Running, j.cancel, j.done
+	j.mutex.Unlock()
+
+	if running {
+		cancel()
+		<-done
+	}
+
+	return j.Status()
+}
+
+func (j *Job) Status() Status {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.status
+}
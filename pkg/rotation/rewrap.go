@@ -0,0 +1,255 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// RewrapOptions controls which data encrypting keys a rewrap covers and its
+// pace. When From is empty every data encrypting key is rewrapped. When Source
+// is set, keys are unwrapped with it rather than the vault's provider, to move
+// them from another key encrypting key provider.
+type RewrapOptions struct {
+	CheckpointPath string
+	BatchSize int64
+	Rate float64
+	From []interfaces.ID
+	Source kek.KeyEncryptingKeyProvider
+}
+
+// NewRewrapOptionsFromEnv reads options from VAULT_REWRAP_CHECKPOINT,
+// VAULT_REWRAP_FROM, a comma separated list of key encrypting key ids, and the
+// batch size and rate of key rotation.
+func NewRewrapOptionsFromEnv() (RewrapOptions, error) {
+	o := RewrapOptions{CheckpointPath: "rewrap.checkpoint"}
+
+	if options, err := NewOptionsFromEnv(); err != nil {
+		return o, err
+	} else {
+		o.BatchSize = options.BatchSize
+		o.Rate = options.Rate
+	}
+
+	if s := os.Getenv("VAULT_REWRAP_CHECKPOINT"); s != "" {
+		o.CheckpointPath = s
+	}
+
+	for _, id := range strings.Split(os.Getenv("VAULT_REWRAP_FROM"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			o.From = append(o.From, id)
+		}
+	}
+
+	return o, nil
+}
+
+// RewrapCheckpoint records how far a rewrap has progressed. Keys are paged
+// after the id of the last key checked, so that keys created or removed during
+// the rewrap, such as keys deleted by a storage TTL once they expire, don't
+// shift the pages. KeyEncryptingKeys counts the checked data encrypting keys by
+// the key encrypting key which wraps them after the rewrap, and Retired counts
+// the keys moved away from each key encrypting key.
+type RewrapCheckpoint struct {
+	Offset int64 `json:"offset"`
+	After interfaces.ID `json:"after"`
+	Keys int64 `json:"keys"`
+	Rewrapped int64 `json:"rewrapped"`
+	Unchanged int64 `json:"unchanged"`
+	Skipped int64 `json:"skipped"`
+	KeyEncryptingKeys map[interfaces.ID]int64 `json:"keyEncryptingKeys"`
+	Retired map[interfaces.ID]int64 `json:"retired"`
+}
+
+// Rewrap wraps data encrypting keys with the key encrypting key the provider
+// currently wraps new keys with, so that a retired key encrypting key can be
+// removed. Wallet ciphertexts are not touched.
+type Rewrap struct {
+	vault vault.Vault
+	storage interfaces.IStorageBackend
+	options RewrapOptions
+	from map[interfaces.ID]bool
+	checkpoint RewrapCheckpoint
+}
+
+func NewRewrap(vault vault.Vault, storage interfaces.IStorageBackend, options RewrapOptions) (*Rewrap, error) {
+	r := &Rewrap{
+		vault: vault,
+		storage: storage,
+		options: options,
+		from: map[interfaces.ID]bool{},
+		checkpoint: RewrapCheckpoint{KeyEncryptingKeys: map[interfaces.ID]int64{}, Retired: map[interfaces.ID]int64{}},
+	}
+
+	if r.options.BatchSize <= 0 {
+		r.options.BatchSize = 100
+	}
+
+	for _, id := range options.From {
+		r.from[id] = true
+	}
+
+	if err := readCheckpoint(r.options.CheckpointPath, &r.checkpoint); err != nil {
+		return nil, err
+	}
+
+	if r.checkpoint.KeyEncryptingKeys == nil {
+		r.checkpoint.KeyEncryptingKeys = map[interfaces.ID]int64{}
+	}
+	if r.checkpoint.Retired == nil {
+		r.checkpoint.Retired = map[interfaces.ID]int64{}
+	}
+
+	return r, nil
+}
+
+func (r *Rewrap) Checkpoint() RewrapCheckpoint {
+	return r.checkpoint
+}
+
+// Run rewraps every data encrypting key, checks that none is left wrapped by a
+// key encrypting key the rewrap moves keys from, and removes the checkpoint
+// once done, so the next run starts a new rewrap.
+func (r *Rewrap) Run(ctx context.Context) error {
+	throttle := newThrottle(r.options.Rate)
+	defer throttle.stop()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if res, err := r.storage.ListDataEncryptingKeys(ctx, r.checkpoint.After, r.options.BatchSize); err != nil {
+			return err
+		} else if len(res.Page()) == 0 {
+			break
+		} else {
+			for _, k := range res.Page() {
+				if len(r.from) > 0 && !r.from[k.KeyEncryptingKey()] {
+					r.checkpoint.KeyEncryptingKeys[k.KeyEncryptingKey()]++
+					continue
+				}
+
+				if err := throttle.wait(ctx); err != nil {
+					return err
+				} else if err := r.rewrap(ctx, k); err != nil {
+					return err
+				}
+			}
+
+			r.checkpoint.Offset += int64(len(res.Page()))
+			r.checkpoint.After = res.Page()[len(res.Page()) - 1].ID()
+			r.checkpoint.Keys = res.Count()
+			if err := writeCheckpoint(r.options.CheckpointPath, &r.checkpoint); err != nil {
+				return err
+			}
+			log.Infof("checked %d of %d data encrypting keys, rewrapped %d", r.checkpoint.Offset, res.Count(), r.checkpoint.Rewrapped)
+		}
+	}
+
+	if err := r.verify(ctx); err != nil {
+		return err
+	} else if err := removeCheckpoint(r.options.CheckpointPath); err != nil {
+		return err
+	}
+
+	log.Infof("rewrapped %d data encrypting keys, %d already wrapped by the current key encrypting key, %d skipped", r.checkpoint.Rewrapped, r.checkpoint.Unchanged, r.checkpoint.Skipped)
+	for _, id := range r.keyEncryptingKeys() {
+		log.Infof("%d data encrypting keys wrapped by %s", r.checkpoint.KeyEncryptingKeys[id], id)
+	}
+	return nil
+}
+
+// retired reports whether keys wrapped by keyEncryptingKey should have been
+// rewrapped: it is one of From, or keys were moved away from it during the
+// rewrap, as from a retired key version or a source provider. Key encrypting
+// keys that checked keys are wrapped by after the rewrap are current.
+func (r *Rewrap) retired(keyEncryptingKey interfaces.ID) bool {
+	return (r.from[keyEncryptingKey] || r.checkpoint.Retired[keyEncryptingKey] > 0) && r.checkpoint.KeyEncryptingKeys[keyEncryptingKey] == 0
+}
+
+// verify lists the data encrypting keys again once every page has been checked
+// and fails if any is still wrapped by a retired key encrypting key, such as a
+// key created by an instance still running with the previous provider. The
+// checkpoint is reset to the first page, so the next run checks every key
+// again.
+func (r *Rewrap) verify(ctx context.Context) error {
+	var after interfaces.ID
+
+	for {
+		if res, err := r.storage.ListDataEncryptingKeys(ctx, after, r.options.BatchSize); err != nil {
+			return err
+		} else if len(res.Page()) == 0 {
+			return nil
+		} else {
+			for _, k := range res.Page() {
+				if expires := k.Expires(); expires != nil && expires.Before(time.Now()) {
+					continue
+				} else if r.retired(k.KeyEncryptingKey()) {
+					r.checkpoint.Offset = 0
+					r.checkpoint.After = ""
+					r.checkpoint.Unchanged = 0
+					r.checkpoint.KeyEncryptingKeys = map[interfaces.ID]int64{}
+					if err := writeCheckpoint(r.options.CheckpointPath, &r.checkpoint); err != nil {
+						return err
+					}
+					return fmt.Errorf("data encrypting key %s is still wrapped by %s, check no instance wraps new keys with it and run the rewrap again", k.ID(), k.KeyEncryptingKey())
+				}
+			}
+
+			after = res.Page()[len(res.Page()) - 1].ID()
+		}
+	}
+}
+
+func (r *Rewrap) keyEncryptingKeys() []interfaces.ID {
+	ids := make([]interfaces.ID, 0, len(r.checkpoint.KeyEncryptingKeys))
+	for id := range r.checkpoint.KeyEncryptingKeys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// rewrap counts data encrypting keys rewrapped elsewhere after the batch was
+// read, and keys removed since, as skipped. Other errors, including a failure
+// to unwrap with the source provider, stop the rewrap.
+func (r *Rewrap) rewrap(ctx context.Context, k interfaces.DataEncryptingKey) error {
+	var e *fiber.Error
+
+	updated, changed, err := r.vault.RewrapDataEncryptingKey(ctx, r.options.Source, k)
+	if errors.As(err, &e) && e.Code == fiber.StatusConflict {
+		r.checkpoint.Skipped++
+		return nil
+	} else if errors.As(err, &e) && e.Code == fiber.StatusNotFound {
+		// providers also report unknown key encrypting keys as not found, so
+		// the key is only skipped if it is gone from storage
+		if _, err := r.storage.GetDataEncryptingKey(ctx, k.ID()); errors.As(err, &e) && e.Code == fiber.StatusNotFound {
+			r.checkpoint.Skipped++
+			return nil
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to rewrap data encrypting key %s wrapped by %s: %v", k.ID(), k.KeyEncryptingKey(), err)
+	}
+
+	if changed {
+		r.checkpoint.Rewrapped++
+		if updated.KeyEncryptingKey() != k.KeyEncryptingKey() {
+			r.checkpoint.Retired[k.KeyEncryptingKey()]++
+		}
+	} else {
+		r.checkpoint.Unchanged++
+	}
+	r.checkpoint.KeyEncryptingKeys[updated.KeyEncryptingKey()]++
+	return nil
+}
@@ -0,0 +1,244 @@
+package rotation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/local"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/memory"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+func newLocalProvider(t *testing.T, id string) kek.KeyEncryptingKeyProvider {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	t.Setenv("VAULT_LOCAL_KEKS", id + ":" + base64.StdEncoding.EncodeToString(key))
+	t.Setenv("VAULT_LOCAL_KEK_ID", id)
+
+	provider, err := local.NewLocalKeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// listHookStorage calls hook with each page of data encrypting keys after
+// listing it, to change the keys while a rewrap runs.
+type listHookStorage struct {
+	interfaces.IStorageBackend
+	hook func(ctx context.Context, page []interfaces.DataEncryptingKey)
+}
+
+func (s *listHookStorage) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	r, err := s.IStorageBackend.ListDataEncryptingKeys(ctx, after, count)
+	if err == nil && s.hook != nil {
+		s.hook(ctx, r.Page())
+	}
+	return r, err
+}
+
+// newSourceKeys creates count data encrypting keys wrapped by source and
+// returns a vault wrapping keys with current over the same storage.
+func newSourceKeys(t *testing.T, source kek.KeyEncryptingKeyProvider, current kek.KeyEncryptingKeyProvider, count int) (vault.Vault, interfaces.IStorageBackend) {
+	ctx := context.Background()
+
+	previous, err := vault.NewVault(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := memory.NewMemoryStorageBackend(previous)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := previous.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err := previous.CreateDataEncryptingKey(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err := vault.NewVault(current)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := v.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	}
+	return v, storage
+}
+
+// requireWrappedBy checks that every listed data encrypting key is wrapped by
+// keyEncryptingKey.
+func requireWrappedBy(t *testing.T, storage interfaces.IStorageBackend, keyEncryptingKey interfaces.ID) {
+	t.Helper()
+
+	if r, err := storage.ListDataEncryptingKeys(context.Background(), "", 100); err != nil {
+		t.Fatal(err)
+	} else {
+		for _, k := range r.Page() {
+			if k.KeyEncryptingKey() != keyEncryptingKey {
+				t.Errorf("expected key %s wrapped by %s, got %s", k.ID(), keyEncryptingKey, k.KeyEncryptingKey())
+			}
+		}
+	}
+}
+
+// TestRewrapKeysExpiring expires keys of each page once it is listed, as a
+// storage TTL deleting expired keys would, and checks that no other key is
+// skipped.
+func TestRewrapKeysExpiring(t *testing.T) {
+	ctx := context.Background()
+	source := newLocalProvider(t, "source")
+	current := newLocalProvider(t, "current")
+	v, storage := newSourceKeys(t, source, current, 7)
+
+	hooked := &listHookStorage{IStorageBackend: storage}
+	hooked.hook = func(ctx context.Context, page []interfaces.DataEncryptingKey) {
+		if len(page) > 0 {
+			if _, err := storage.ExpireDataEncryptingKey(ctx, page[0].ID(), -time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r, err := NewRewrap(v, hooked, RewrapOptions{CheckpointPath: filepath.Join(t.TempDir(), "rewrap.checkpoint"), BatchSize: 2, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := r.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	hooked.hook = nil
+	requireWrappedBy(t, storage, "current")
+
+	// every key is either rewrapped or skipped once expired, none is missed
+	if c := r.Checkpoint(); c.Rewrapped + c.Skipped != 7 || c.Skipped == 0 {
+		t.Fatalf("expected 7 keys rewrapped or skipped, got %+v", c)
+	}
+}
+
+// TestRewrapVerify creates a key wrapped by the source provider behind the
+// rewrap, as an instance still running with the source provider might, and
+// checks that the rewrap fails rather than removing its checkpoint and then
+// rewraps the key when run again.
+func TestRewrapVerify(t *testing.T) {
+	ctx := context.Background()
+	source := newLocalProvider(t, "source")
+	current := newLocalProvider(t, "current")
+	v, storage := newSourceKeys(t, source, current, 4)
+	path := filepath.Join(t.TempDir(), "rewrap.checkpoint")
+
+	var late interfaces.ID
+	hooked := &listHookStorage{IStorageBackend: storage}
+	hooked.hook = func(ctx context.Context, page []interfaces.DataEncryptingKey) {
+		if late == "" && len(page) > 0 {
+			// sorts between the first and second key, behind the rewrap
+			late = page[0].ID() + "-late"
+			if keyEncryptingKey, encryptedKey, err := source.Encrypt(ctx, late, make([]byte, 32)); err != nil {
+				t.Fatal(err)
+			} else if _, err := storage.CreateDataEncryptingKey(ctx, late, keyEncryptingKey, encryptedKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r, err := NewRewrap(v, hooked, RewrapOptions{CheckpointPath: path, BatchSize: 2, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := r.Run(ctx); err == nil || !strings.Contains(err.Error(), late) {
+		t.Fatalf("expected key %s to fail verification, got %v", late, err)
+	} else if c := r.Checkpoint(); c.After != "" {
+		t.Fatalf("expected the checkpoint to restart from the first key, got %+v", c)
+	}
+
+	if r, err := NewRewrap(v, storage, RewrapOptions{CheckpointPath: path, BatchSize: 2, Source: source}); err != nil {
+		t.Fatal(err)
+	} else if err := r.Run(ctx); err != nil {
+		t.Fatal(err)
+	} else if c := r.Checkpoint(); c.Rewrapped != 5 || c.KeyEncryptingKeys["current"] != 5 {
+		t.Fatalf("expected 5 keys rewrapped, got %+v", c)
+	}
+
+	requireWrappedBy(t, storage, "current")
+}
+
+// TestRewrapFromSource moves data encrypting keys from one provider to another,
+// leaving keys already wrapped by the current provider unchanged.
+func TestRewrapFromSource(t *testing.T) {
+	ctx := context.Background()
+	source := newLocalProvider(t, "source")
+	current := newLocalProvider(t, "current")
+
+	previous, err := vault.NewVault(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := memory.NewMemoryStorageBackend(previous)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := previous.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := previous.CreateWallet(ctx, "account", "wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := storage.GetDataEncryptingKey(ctx, w.DataEncryptingKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := source.Decrypt(ctx, k.ID(), k.KeyEncryptingKey(), k.EncryptedKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vault.NewVault(current)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := v.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	} else if _, err := v.CreateDataEncryptingKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRewrap(v, storage, RewrapOptions{CheckpointPath: filepath.Join(t.TempDir(), "rewrap.checkpoint"), Source: source})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := r.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := r.Checkpoint(); c.Rewrapped != 1 || c.Unchanged != 1 || c.KeyEncryptingKeys["current"] != 2 {
+		t.Fatalf("expected 1 key rewrapped and 1 unchanged, got %+v", c)
+	}
+
+	if k, err := storage.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatal(err)
+	} else if k.KeyEncryptingKey() != "current" {
+		t.Fatalf("expected key wrapped by current, got %s", k.KeyEncryptingKey())
+	} else if got, err := current.Decrypt(ctx, k.ID(), k.KeyEncryptingKey(), k.EncryptedKey()); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, want) {
+		t.Fatal("rewrapped key differs from the source key")
+	}
+
+	if w, err := v.GetWallet(ctx, "account", w.Address()); err != nil {
+		t.Fatal(err)
+	} else if privateKey, err := w.PrivateKey(ctx); err != nil {
+		t.Fatal(err)
+	} else if crypto.PubkeyToAddress(privateKey.PublicKey) != w.Address() {
+		t.Fatal("wallet no longer decrypts after the rewrap")
+	}
+}
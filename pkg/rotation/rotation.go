@@ -0,0 +1,449 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+const (
+	phaseKeys = "keys"
+	phaseSeeds = "seeds"
+	phaseWallets = "wallets"
+	phaseExpire = "expire"
+	phaseDone = "done"
+)
+
+// Options controls the pace of a rotation and the lifetime of the data
+// encrypting keys it retires.
+type Options struct {
+	CheckpointPath string
+	BatchSize int64
+	// Rate limits rotated wallets per second, zero rotates without limit.
+	Rate float64
+	MaxRefCount int64
+	// KeyTTL is how long retired data encrypting keys remain readable, which
+	// covers requests that read a wallet before it was rotated.
+	KeyTTL time.Duration
+}
+
+// NewOptionsFromEnv reads options from VAULT_ROTATION_CHECKPOINT,
+// VAULT_ROTATION_BATCH, VAULT_ROTATION_RATE and VAULT_ROTATION_KEY_TTL.
+func NewOptionsFromEnv() (Options, error) {
+	o := Options{
+		CheckpointPath: "rotation.checkpoint",
+		BatchSize: 100,
+		Rate: 50,
+		MaxRefCount: 1000,
+		KeyTTL: 24 * time.Hour,
+	}
+
+	if s := os.Getenv("VAULT_ROTATION_CHECKPOINT"); s != "" {
+		o.CheckpointPath = s
+	}
+
+	if s := os.Getenv("VAULT_ROTATION_BATCH"); s != "" {
+		if batch, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return o, fmt.Errorf("invalid VAULT_ROTATION_BATCH: %v", err)
+		} else {
+			o.BatchSize = batch
+		}
+	}
+
+	if s := os.Getenv("VAULT_ROTATION_RATE"); s != "" {
+		if rate, err := strconv.ParseFloat(s, 64); err != nil {
+			return o, fmt.Errorf("invalid VAULT_ROTATION_RATE: %v", err)
+		} else {
+			o.Rate = rate
+		}
+	}
+
+	if s := os.Getenv("VAULT_ROTATION_KEY_TTL"); s != "" {
+		if ttl, err := time.ParseDuration(s); err != nil {
+			return o, fmt.Errorf("invalid VAULT_ROTATION_KEY_TTL: %v", err)
+		} else {
+			o.KeyTTL = ttl
+		}
+	}
+
+	return o, nil
+}
+
+// Checkpoint records the data encrypting keys being retired and how far a
+// rotation has progressed, so that an interrupted rotation resumes where it
+// stopped. Wallets are moved with a compare and swap, so wallets rotated after
+// the last checkpoint was saved are skipped when the batch is read again.
+// Wallets are paged after the address of the last wallet checked, so that
+// wallets created or removed during the rotation don't shift the pages.
+type Checkpoint struct {
+	Phase string `json:"phase"`
+	Keys []interfaces.ID `json:"keys"`
+	Current interfaces.ID `json:"current,omitempty"`
+	Offset int64 `json:"offset"`
+	After common.Address `json:"after"`
+	Wallets int64 `json:"wallets"`
+	Rotated int64 `json:"rotated"`
+	Seeds int64 `json:"seeds"`
+	Skipped int64 `json:"skipped"`
+	Created int64 `json:"created"`
+	Expired int64 `json:"expired"`
+	Remaining int64 `json:"remaining"`
+}
+
+// Rotation moves every seed and wallet to freshly created data encrypting keys
+// and expires the previous data encrypting keys once nothing references them.
+type Rotation struct {
+	vault vault.Vault
+	storage interfaces.IStorageBackend
+	options Options
+	checkpoint Checkpoint
+	retiring map[interfaces.ID]bool
+	current interfaces.DataEncryptingKey
+	currentRefCount int64
+	progress chan Checkpoint
+}
+
+func NewRotation(vault vault.Vault, storage interfaces.IStorageBackend, options Options) (*Rotation, error) {
+	r := &Rotation{
+		vault: vault,
+		storage: storage,
+		options: options,
+		checkpoint: Checkpoint{Phase: phaseKeys},
+		retiring: map[interfaces.ID]bool{},
+	}
+
+	if r.options.BatchSize <= 0 {
+		r.options.BatchSize = 100
+	}
+	if r.options.MaxRefCount <= 0 {
+		r.options.MaxRefCount = 1000
+	}
+
+	if err := readCheckpoint(r.options.CheckpointPath, &r.checkpoint); err != nil {
+		return nil, err
+	}
+
+	for _, id := range r.checkpoint.Keys {
+		r.retiring[id] = true
+	}
+
+	return r, nil
+}
+
+// OnProgress registers a channel which receives the checkpoint after every
+// batch. Sends never block, so a slow reader misses intermediate progress.
+func (r *Rotation) OnProgress(progress chan Checkpoint) {
+	r.progress = progress
+}
+
+func (r *Rotation) Checkpoint() Checkpoint {
+	return r.checkpoint
+}
+
+func (r *Rotation) saveCheckpoint() error {
+	if r.progress != nil {
+		c := r.checkpoint
+		c.Keys = append([]interfaces.ID{}, r.checkpoint.Keys...)
+		select {
+		case r.progress <- c:
+		default:
+		}
+	}
+
+	return writeCheckpoint(r.options.CheckpointPath, &r.checkpoint)
+}
+
+func (r *Rotation) advance(phase string) error {
+	r.checkpoint.Phase = phase
+	r.checkpoint.Offset = 0
+	r.checkpoint.After = common.Address{}
+	return r.saveCheckpoint()
+}
+
+// Run retires every data encrypting key which exists when the rotation starts.
+// The checkpoint is removed once the rotation completes, so the next run starts
+// a new rotation.
+func (r *Rotation) Run(ctx context.Context) error {
+	if r.checkpoint.Phase == phaseKeys {
+		if err := r.listKeys(ctx); err != nil {
+			return err
+		} else if err := r.advance(phaseSeeds); err != nil {
+			return err
+		}
+	}
+
+	// seeds are rotated first, so that wallets derived from them move straight
+	// to the new data encrypting key of their seed
+	if r.checkpoint.Phase == phaseSeeds {
+		if err := r.rotateSeeds(ctx); err != nil {
+			return err
+		} else if err := r.advance(phaseWallets); err != nil {
+			return err
+		}
+	}
+
+	if r.checkpoint.Phase == phaseWallets {
+		if err := r.rotateWallets(ctx); err != nil {
+			return err
+		} else if err := r.advance(phaseExpire); err != nil {
+			return err
+		}
+	}
+
+	if r.checkpoint.Phase == phaseExpire {
+		if err := r.expireKeys(ctx); err != nil {
+			return err
+		} else if err := r.advance(phaseDone); err != nil {
+			return err
+		}
+	}
+
+	if err := removeCheckpoint(r.options.CheckpointPath); err != nil {
+		return err
+	}
+
+	log.Infof("rotated %d wallets and %d seeds to %d data encrypting keys, expired %d data encrypting keys", r.checkpoint.Rotated, r.checkpoint.Seeds, r.checkpoint.Created, r.checkpoint.Expired)
+	if r.checkpoint.Remaining > 0 {
+		log.Warnf("%d data encrypting keys are still referenced by wallets created during the rotation, run the rotation again to retire them", r.checkpoint.Remaining)
+	}
+	return nil
+}
+
+// listKeys snapshots the data encrypting keys to retire. Keys created after
+// this point, including those created by the rotation, are kept.
+func (r *Rotation) listKeys(ctx context.Context) error {
+	r.checkpoint.Keys = nil
+	r.retiring = map[interfaces.ID]bool{}
+
+	for after := interfaces.ID(""); ; {
+		if res, err := r.storage.ListDataEncryptingKeys(ctx, after, r.options.BatchSize); err != nil {
+			return err
+		} else if len(res.Page()) == 0 {
+			log.Infof("retiring %d data encrypting keys", len(r.checkpoint.Keys))
+			return nil
+		} else {
+			for _, k := range res.Page() {
+				if !r.retiring[k.ID()] {
+					r.checkpoint.Keys = append(r.checkpoint.Keys, k.ID())
+					r.retiring[k.ID()] = true
+				}
+			}
+			after = res.Page()[len(res.Page()) - 1].ID()
+		}
+	}
+}
+
+// target returns the data encrypting key wallets are rotated to, creating a
+// new key once the current key reaches the maximum reference count.
+func (r *Rotation) target(ctx context.Context) (interfaces.DataEncryptingKey, error) {
+	if r.current == nil && r.checkpoint.Current != "" {
+		var e *fiber.Error
+
+		if k, err := r.storage.GetDataEncryptingKey(ctx, r.checkpoint.Current); errors.As(err, &e) && e.Code == fiber.StatusNotFound {
+			r.checkpoint.Current = ""
+		} else if err != nil {
+			return nil, err
+		} else if count, err := k.RefCount(ctx); err != nil {
+			return nil, err
+		} else {
+			r.current = k
+			r.currentRefCount = count
+		}
+	}
+
+	if r.current != nil && r.currentRefCount < r.options.MaxRefCount {
+		return r.current, nil
+	}
+
+	if k, err := r.vault.CreateDataEncryptingKey(ctx); err != nil {
+		return nil, err
+	} else {
+		r.current = k
+		r.currentRefCount = 0
+		r.checkpoint.Current = k.ID()
+		r.checkpoint.Created++
+		return k, nil
+	}
+}
+
+func (r *Rotation) rotateWallets(ctx context.Context) error {
+	throttle := newThrottle(r.options.Rate)
+	defer throttle.stop()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if res, err := r.storage.ListAllWallets(ctx, r.checkpoint.After, r.options.BatchSize); err != nil {
+			return err
+		} else if len(res.Page()) == 0 {
+			return nil
+		} else {
+			touched := map[interfaces.ID]bool{}
+
+			for _, w := range res.Page() {
+				if !r.retiring[w.DataEncryptingKey()] {
+					continue
+				}
+
+				if err := throttle.wait(ctx); err != nil {
+					return err
+				} else if rotated, err := r.rotateWallet(ctx, w); err != nil {
+					return err
+				} else if rotated {
+					r.checkpoint.Rotated++
+				} else {
+					r.checkpoint.Skipped++
+				}
+				touched[w.DataEncryptingKey()] = true
+			}
+
+			for id := range touched {
+				if err := r.expireKey(ctx, id); err != nil {
+					return err
+				}
+			}
+
+			r.checkpoint.Offset += int64(len(res.Page()))
+			r.checkpoint.After = res.Page()[len(res.Page()) - 1].Address()
+			r.checkpoint.Wallets = res.Count()
+			if err := r.saveCheckpoint(); err != nil {
+				return err
+			}
+			log.Infof("checked %d of %d wallets, rotated %d", r.checkpoint.Offset, res.Count(), r.checkpoint.Rotated)
+		}
+	}
+}
+
+func (r *Rotation) rotateSeeds(ctx context.Context) error {
+	throttle := newThrottle(r.options.Rate)
+	defer throttle.stop()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if res, err := r.storage.ListAllSeeds(ctx, r.checkpoint.Offset, r.options.BatchSize); err != nil {
+			return err
+		} else if len(res.Page()) == 0 {
+			return nil
+		} else {
+			touched := map[interfaces.ID]bool{}
+
+			for _, s := range res.Page() {
+				if !r.retiring[s.DataEncryptingKey()] {
+					continue
+				}
+
+				if err := throttle.wait(ctx); err != nil {
+					return err
+				} else if rotated, err := r.rotateSeed(ctx, s); err != nil {
+					return err
+				} else if rotated {
+					r.checkpoint.Seeds++
+				}
+				touched[s.DataEncryptingKey()] = true
+			}
+
+			for id := range touched {
+				if err := r.expireKey(ctx, id); err != nil {
+					return err
+				}
+			}
+
+			r.checkpoint.Offset += int64(len(res.Page()))
+			if err := r.saveCheckpoint(); err != nil {
+				return err
+			}
+			log.Infof("checked %d of %d seeds, rotated %d", r.checkpoint.Offset, res.Count(), r.checkpoint.Seeds)
+		}
+	}
+}
+
+// rotateSeed returns false when the seed was rotated elsewhere after the batch
+// was read.
+func (r *Rotation) rotateSeed(ctx context.Context, s interfaces.Seed) (bool, error) {
+	var e *fiber.Error
+
+	if to, err := r.target(ctx); err != nil {
+		return false, err
+	} else if _, err := r.vault.RotateSeed(ctx, s, to); errors.As(err, &e) && (e.Code == fiber.StatusNotFound || e.Code == fiber.StatusConflict) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to rotate seed of account %s: %v", s.Account(), err)
+	} else {
+		r.currentRefCount++
+		return true, nil
+	}
+}
+
+// rotateWallet returns false when the wallet was removed or rotated elsewhere
+// after the batch was read.
+func (r *Rotation) rotateWallet(ctx context.Context, w interfaces.Wallet) (bool, error) {
+	var e *fiber.Error
+
+	if to, err := r.target(ctx); err != nil {
+		return false, err
+	} else if _, err := r.vault.RotateWallet(ctx, w, to); errors.As(err, &e) && (e.Code == fiber.StatusNotFound || e.Code == fiber.StatusConflict) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to rotate wallet %s: %v", w.ID(), err)
+	} else {
+		r.currentRefCount++
+		return true, nil
+	}
+}
+
+// expireKey expires a retired data encrypting key which is no longer
+// referenced. Keys scheduled to expire are not handed out to new wallets, but
+// a wallet may have been created with the key just before it was expired, in
+// which case the expiry is reverted and the key remains.
+func (r *Rotation) expireKey(ctx context.Context, id interfaces.ID) error {
+	var e *fiber.Error
+
+	if k, err := r.storage.GetDataEncryptingKey(ctx, id); errors.As(err, &e) && e.Code == fiber.StatusNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	} else if k.Expires() != nil {
+		return nil
+	} else if count, err := k.RefCount(ctx); err != nil {
+		return err
+	} else if count > 0 {
+		return nil
+	} else if k, err := r.vault.ExpireDataEncryptingKey(ctx, id, r.options.KeyTTL); err != nil {
+		return err
+	} else if count, err := k.RefCount(ctx); err != nil {
+		return err
+	} else if count > 0 {
+		_, err := r.storage.UnexpireDataEncryptingKey(ctx, id)
+		return err
+	} else {
+		r.checkpoint.Expired++
+		return nil
+	}
+}
+
+// expireKeys expires retired keys which were not referenced by any wallet or
+// seed and counts those still referenced by wallets created during the
+// rotation.
+func (r *Rotation) expireKeys(ctx context.Context) error {
+	r.checkpoint.Remaining = 0
+
+	for _, id := range r.checkpoint.Keys {
+		if err := r.expireKey(ctx, id); err != nil {
+			return err
+		} else if k, err := r.storage.GetDataEncryptingKey(ctx, id); err == nil && k.Expires() == nil {
+			r.checkpoint.Remaining++
+		}
+	}
+
+	return nil
+}
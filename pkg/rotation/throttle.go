@@ -0,0 +1,37 @@
+package rotation
+
+import (
+	"context"
+	"time"
+)
+
+// throttle limits operations to rate per second, a rate of zero is unlimited.
+type throttle struct {
+	ticker *time.Ticker
+}
+
+func newThrottle(rate float64) *throttle {
+	if rate <= 0 {
+		return &throttle{}
+	}
+	return &throttle{ticker: time.NewTicker(time.Duration(float64(time.Second) / rate))}
+}
+
+func (t *throttle) wait(ctx context.Context) error {
+	if t.ticker == nil {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.ticker.C:
+		return nil
+	}
+}
+
+func (t *throttle) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
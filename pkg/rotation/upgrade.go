@@ -0,0 +1,143 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// UpgradeOptions controls the pace of an upgrade.
+type UpgradeOptions struct {
+	CheckpointPath string
+	BatchSize int64
+	Rate float64
+}
+
+// NewUpgradeOptionsFromEnv reads options from VAULT_UPGRADE_CHECKPOINT and the
+// batch size and rate of key rotation.
+func NewUpgradeOptionsFromEnv() (UpgradeOptions, error) {
+	o := UpgradeOptions{CheckpointPath: "upgrade.checkpoint"}
+
+	if options, err := NewOptionsFromEnv(); err != nil {
+		return o, err
+	} else {
+		o.BatchSize = options.BatchSize
+		o.Rate = options.Rate
+	}
+
+	if s := os.Getenv("VAULT_UPGRADE_CHECKPOINT"); s != "" {
+		o.CheckpointPath = s
+	}
+
+	return o, nil
+}
+
+// UpgradeCheckpoint records how far an upgrade has progressed. Wallets are
+// paged after the address of the last wallet checked.
+type UpgradeCheckpoint struct {
+	Offset int64 `json:"offset"`
+	After common.Address `json:"after"`
+	Wallets int64 `json:"wallets"`
+	Upgraded int64 `json:"upgraded"`
+	Unchanged int64 `json:"unchanged"`
+	Skipped int64 `json:"skipped"`
+}
+
+// Upgrade re-encrypts wallet private keys stored in a legacy envelope in the
+// current envelope, which binds them to their wallet. Wallets are also upgraded
+// as they are read, so an upgrade is only needed to remove legacy envelopes
+// from wallets which are rarely used.
+type Upgrade struct {
+	vault vault.Vault
+	storage interfaces.IStorageBackend
+	options UpgradeOptions
+	checkpoint UpgradeCheckpoint
+}
+
+func NewUpgrade(vault vault.Vault, storage interfaces.IStorageBackend, options UpgradeOptions) (*Upgrade, error) {
+	u := &Upgrade{
+		vault: vault,
+		storage: storage,
+		options: options,
+	}
+
+	if u.options.BatchSize <= 0 {
+		u.options.BatchSize = 100
+	}
+
+	if err := readCheckpoint(u.options.CheckpointPath, &u.checkpoint); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (u *Upgrade) Checkpoint() UpgradeCheckpoint {
+	return u.checkpoint
+}
+
+// Run upgrades every wallet and removes the checkpoint once done, so the next
+// run starts a new upgrade.
+func (u *Upgrade) Run(ctx context.Context) error {
+	throttle := newThrottle(u.options.Rate)
+	defer throttle.stop()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if res, err := u.storage.ListAllWallets(ctx, u.checkpoint.After, u.options.BatchSize); err != nil {
+			return err
+		} else if len(res.Page()) == 0 {
+			break
+		} else {
+			for _, w := range res.Page() {
+				if err := throttle.wait(ctx); err != nil {
+					return err
+				} else if err := u.upgrade(ctx, w); err != nil {
+					return err
+				}
+			}
+
+			u.checkpoint.Offset += int64(len(res.Page()))
+			u.checkpoint.After = res.Page()[len(res.Page()) - 1].Address()
+			u.checkpoint.Wallets = res.Count()
+			if err := writeCheckpoint(u.options.CheckpointPath, &u.checkpoint); err != nil {
+				return err
+			}
+			log.Infof("checked %d of %d wallets, upgraded %d", u.checkpoint.Offset, res.Count(), u.checkpoint.Upgraded)
+		}
+	}
+
+	if err := removeCheckpoint(u.options.CheckpointPath); err != nil {
+		return err
+	}
+
+	log.Infof("upgraded %d wallets, %d already upgraded, %d skipped", u.checkpoint.Upgraded, u.checkpoint.Unchanged, u.checkpoint.Skipped)
+	return nil
+}
+
+// upgrade counts wallets removed or rotated elsewhere after the batch was read
+// as skipped.
+func (u *Upgrade) upgrade(ctx context.Context, w interfaces.Wallet) error {
+	var e *fiber.Error
+
+	if _, upgraded, err := u.vault.UpgradeWallet(ctx, w); errors.As(err, &e) && (e.Code == fiber.StatusNotFound || e.Code == fiber.StatusConflict) {
+		u.checkpoint.Skipped++
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to upgrade wallet %s: %v", w.Address(), err)
+	} else if upgraded {
+		u.checkpoint.Upgraded++
+		return nil
+	} else {
+		u.checkpoint.Unchanged++
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// DigestSignature is a signature of a 32 byte digest, such as an EIP-712 or
+// EIP-191 hash. Signature is r, s and v concatenated, as returned by
+// eth_signTypedData_v4 and personal_sign.
+type DigestSignature struct {
+	Digest string `json:"digest"`
+	R string `json:"r"`
+	S string `json:"s"`
+	V byte `json:"v"`
+	Signature string `json:"signature"`
+}
+
+// SignMessage signs the EIP-191 personal_sign hash of message, which is
+// prefixed with "\x19Ethereum Signed Message:\n" and its length so that it
+// can't be mistaken for a transaction.
+func (s *signer) SignMessage(ctx context.Context, account interfaces.ID, signer common.Address, message []byte) (*DigestSignature, error) {
+	return s.signDigest(ctx, account, signer, accounts.TextHash(message))
+}
+
+// SignHash signs a precomputed digest as is. The vault can't tell what the
+// digest commits to, so the signer wallet must be granted
+// vault.PermissionSignHash. The permission is read on every call rather than
+// cached with the private key, so that revoking it takes effect immediately.
+func (s *signer) SignHash(ctx context.Context, account interfaces.ID, signer common.Address, hash common.Hash) (*DigestSignature, error) {
+	if wallet, err := s.vault.GetWallet(ctx, account, signer); err != nil {
+		return nil, err
+	} else if !wallet.HasPermission(vault.PermissionSignHash) {
+		return nil, fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("wallet %s is not permitted to sign hashes", signer))
+	} else {
+		return s.signDigest(ctx, account, signer, hash.Bytes())
+	}
+}
+
+func (s *signer) signDigest(ctx context.Context, account interfaces.ID, signer common.Address, digest []byte) (*DigestSignature, error) {
+	if privateKey, err := s.privateKey(ctx, account, signer); err != nil {
+		return nil, err
+	} else if signatureBytes, err := crypto.Sign(digest, &privateKey); err != nil {
+		return nil, err
+	} else {
+		signatureBytes[64] += 27
+
+		return &DigestSignature{
+			Digest: "0x" + common.Bytes2Hex(digest),
+			R: "0x" + common.Bytes2Hex(signatureBytes[:32]),
+			S: "0x" + common.Bytes2Hex(signatureBytes[32:64]),
+			V: signatureBytes[64],
+			Signature: "0x" + common.Bytes2Hex(signatureBytes),
+		}, nil
+	}
+}
@@ -0,0 +1,66 @@
+package signer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// requireSignedBy checks that sig is a signature of its digest by address.
+func requireSignedBy(t *testing.T, sig *DigestSignature, address common.Address) {
+	t.Helper()
+
+	signature := common.FromHex(sig.Signature)
+	signature[64] -= 27
+	if publicKey, err := crypto.SigToPub(common.FromHex(sig.Digest), signature); err != nil {
+		t.Fatal(err)
+	} else if recovered := crypto.PubkeyToAddress(*publicKey); recovered != address {
+		t.Fatalf("expected the signature to recover to %s, got %s", address, recovered)
+	}
+}
+
+func TestSignHash(t *testing.T) {
+	ctx := context.Background()
+	s, v := newTestSigner(t)
+	hash := crypto.Keccak256Hash([]byte("hash"))
+
+	_, err := s.SignHash(ctx, "account", cowAddress, hash)
+	requireStatus(t, err, fiber.StatusForbidden)
+
+	if _, err := v.UpdateWalletPermissions(ctx, "account", cowAddress, []string{vault.PermissionSignHash}); err != nil {
+		t.Fatal(err)
+	} else if sig, err := s.SignHash(ctx, "account", cowAddress, hash); err != nil {
+		t.Fatal(err)
+	} else if sig.Digest != hash.Hex() {
+		t.Fatalf("expected the hash to be signed as is, got %s", sig.Digest)
+	} else {
+		requireSignedBy(t, sig, cowAddress)
+	}
+
+	// revoking the permission takes effect although the private key is cached
+	if _, err := v.UpdateWalletPermissions(ctx, "account", cowAddress, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.SignHash(ctx, "account", cowAddress, hash)
+	requireStatus(t, err, fiber.StatusForbidden)
+
+	_, err = s.SignHash(ctx, "other", cowAddress, hash)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func TestSignMessage(t *testing.T) {
+	s, _ := newTestSigner(t)
+
+	if sig, err := s.SignMessage(context.Background(), "account", cowAddress, []byte("hello")); err != nil {
+		t.Fatal(err)
+	} else if sig.Digest != "0x" + common.Bytes2Hex(accounts.TextHash([]byte("hello"))) {
+		t.Fatalf("expected the personal_sign hash of the message, got %s", sig.Digest)
+	} else {
+		requireSignedBy(t, sig, cowAddress)
+	}
+}
@@ -0,0 +1,84 @@
+package signer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// IssuedSignature is a signature recorded in the replay protection ledger.
+type IssuedSignature struct {
+	Wallet common.Address `json:"wallet"`
+	Sender common.Address `json:"sender"`
+	Uniq common.Hash `json:"uniq"`
+	Signature Signature `json:"signature"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// replayWindowFromEnv reads VAULT_REPLAY_WINDOW, the time for which Sign
+// refuses to sign a uniq again for the same wallet and sender. Unset or zero
+// disables the ledger.
+func replayWindowFromEnv() (time.Duration, error) {
+	if s := strings.TrimSpace(os.Getenv("VAULT_REPLAY_WINDOW")); s == "" {
+		return 0, nil
+	} else if d, err := time.ParseDuration(s); err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid VAULT_REPLAY_WINDOW %s", s)
+	} else {
+		return d, nil
+	}
+}
+
+// ParseUniq returns uniq as Sign encodes it into the signed bytes32. Uniq is
+// hex, with or without 0x, as an unprefixed value has always been accepted.
+func ParseUniq(uniq string) common.Hash {
+	if has0xPrefix(uniq) {
+		uniq = uniq[2:]
+	}
+	return common.BytesToHash(common.Hex2BytesFixed(uniq, 32))
+}
+
+func has0xPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
+// issue records sig in the ledger when replay protection is enabled, failing
+// with a conflict if a signature was already issued for uniq within the
+// window.
+func (s *signer) issue(ctx context.Context, account interfaces.ID, signer common.Address, sender common.Address, uniq common.Hash, sig *signature) error {
+	if s.replayWindow <= 0 {
+		return nil
+	} else if b, err := json.Marshal(sig); err != nil {
+		return err
+	} else {
+		_, err := s.vault.CreateIssuedSignature(ctx, account, signer, sender, uniq, b, s.replayWindow)
+		return err
+	}
+}
+
+// GetIssuedSignature returns the signature issued by signer to sender for
+// uniq, while it is held by the ledger.
+func (s *signer) GetIssuedSignature(ctx context.Context, account interfaces.ID, signer common.Address, sender common.Address, uniq common.Hash) (*IssuedSignature, error) {
+	var sig signature
+
+	if issued, err := s.vault.GetIssuedSignature(ctx, account, signer, sender, uniq); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(issued.Signature(), &sig); err != nil {
+		return nil, err
+	} else {
+		return &IssuedSignature{
+			Wallet: issued.Wallet(),
+			Sender: issued.Sender(),
+			Uniq: issued.Uniq(),
+			Signature: &sig,
+			Created: issued.Created(),
+			Expires: issued.Expires(),
+		}, nil
+	}
+}
@@ -0,0 +1,102 @@
+package signer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestReplayWindowFromEnv(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"": 0,
+		"0": 0,
+		" 1h ": time.Hour,
+	} {
+		t.Setenv("VAULT_REPLAY_WINDOW", value)
+		if d, err := replayWindowFromEnv(); err != nil {
+			t.Fatal(err)
+		} else if d != expected {
+			t.Fatalf("expected %q to be %s, got %s", value, expected, d)
+		}
+	}
+
+	for _, value := range []string{"1", "-1h", "soon"} {
+		t.Setenv("VAULT_REPLAY_WINDOW", value)
+		if _, err := replayWindowFromEnv(); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestLedger(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("VAULT_REPLAY_WINDOW", "200ms")
+	s, _ := newTestSigner(t)
+	uniq := ParseUniq("0x01")
+
+	sig, err := mint(t, s, "0x01", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same uniq is refused within the window, another uniq or sender isn't
+	_, err = mint(t, s, "0x01", time.Hour)
+	requireStatus(t, err, fiber.StatusConflict)
+	if _, err := mint(t, s, "0x02", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Sign(ctx, "account", cowAddress, "0x01", cowAddress, mintABI, []any{mintTo.Hex()}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if issued, err := s.GetIssuedSignature(ctx, "account", cowAddress, mintSender, uniq); err != nil {
+		t.Fatal(err)
+	} else if issued.Wallet != cowAddress || issued.Sender != mintSender || issued.Uniq != uniq {
+		t.Fatalf("expected the issued signature of %s to %s for %s, got %+v", cowAddress, mintSender, uniq, issued)
+	} else if *issued.Signature.(*signature) != *sig {
+		t.Fatalf("expected the issued signature %+v, got %+v", sig, issued.Signature)
+	} else if window := issued.Expires.Sub(issued.Created); window != 200 * time.Millisecond {
+		t.Fatalf("expected the signature to be held for the window, got %s", window)
+	}
+
+	_, err = s.GetIssuedSignature(ctx, "other", cowAddress, mintSender, uniq)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	// once the window has passed the entry expires and uniq can be signed again
+	time.Sleep(250 * time.Millisecond)
+
+	_, err = s.GetIssuedSignature(ctx, "account", cowAddress, mintSender, uniq)
+	requireStatus(t, err, fiber.StatusNotFound)
+	if again, err := mint(t, s, "0x01", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if again.Nonce_ == sig.Nonce_ {
+		t.Fatal("expected a new nonce")
+	}
+}
+
+// TestLedgerDisabled signs a uniq twice without a replay window.
+func TestLedgerDisabled(t *testing.T) {
+	t.Setenv("VAULT_REPLAY_WINDOW", "")
+	s, _ := newTestSigner(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := mint(t, s, "0x01", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := s.GetIssuedSignature(context.Background(), "account", cowAddress, mintSender, ParseUniq("0x01"))
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func TestParseUniq(t *testing.T) {
+	expected := common.HexToHash("0x0102")
+
+	for _, uniq := range []string{"0102", "0x0102", "0X0102", "0x" + expected.Hex()[2:]} {
+		if parsed := ParseUniq(uniq); parsed != expected {
+			t.Errorf("expected %q to parse to %s, got %s", uniq, expected, parsed)
+		}
+	}
+}
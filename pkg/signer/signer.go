@@ -10,10 +10,13 @@ import (
 	"math/big"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 	"github.com/grexie/signchain-vault/v2/pkg/vault"
 	lru "github.com/hashicorp/golang-lru/v2"
@@ -26,6 +29,7 @@ type Signature interface {
 	S() string
 	V() byte
 	Nonce() string
+	Deadline() uint64
 }
 
 type signature struct {
@@ -33,17 +37,30 @@ type signature struct {
 	R_ string `json:"r"`
 	S_ string `json:"s"`
 	V_ byte `json:"v"`
+	Deadline_ uint64 `json:"deadline,omitempty"`
 }
 
 var _ Signature = &signature{}
 
 type Signer interface {
-	Sign(ctx context.Context, account interfaces.ID, sender common.Address, uniq string, signer common.Address, abi map[string]any, args []any) (SignResult, error)
+	Sign(ctx context.Context, account interfaces.ID, sender common.Address, uniq string, signer common.Address, abi map[string]any, args []any, ttl time.Duration) (SignResult, error)
+	SignTypedData(ctx context.Context, account interfaces.ID, signer common.Address, typedData apitypes.TypedData) (*DigestSignature, error)
+	SignMessage(ctx context.Context, account interfaces.ID, signer common.Address, message []byte) (*DigestSignature, error)
+	SignHash(ctx context.Context, account interfaces.ID, signer common.Address, hash common.Hash) (*DigestSignature, error)
+	SignTransaction(ctx context.Context, account interfaces.ID, signer common.Address, args TransactionArgs) (*SignedTransaction, error)
+	GetIssuedSignature(ctx context.Context, account interfaces.ID, signer common.Address, sender common.Address, uniq common.Hash) (*IssuedSignature, error)
+	PurgeCache()
 }
 
+// signer caches decrypted private keys. A purge advances the cache generation
+// and keys are only cached if it hasn't moved since they were decrypted, so a
+// key decrypted before the vault was sealed is not cached after the purge.
 type signer struct {
 	vault vault.Vault
+	mutex sync.Mutex
 	cache *lru.Cache[cacheKey, ecdsa.PrivateKey]
+	generation uint64
+	replayWindow time.Duration
 }
 
 var _ Signer = &signer{}
@@ -62,9 +79,25 @@ func NewSigner(vault vault.Vault) (Signer, error) {
 		s.cache = c
 	}
 
+	if d, err := replayWindowFromEnv(); err != nil {
+		return nil, err
+	} else {
+		s.replayWindow = d
+	}
+
 	return &s, nil
 }
 
+// PurgeCache drops every cached private key, used when the key encrypting key
+// provider is sealed.
+func (s *signer) PurgeCache() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generation++
+	s.cache.Purge()
+}
+
 func packConvert(arg *abi.Type, param any) (any, error) {
 	t := arg.GetType()
 	if t.ConvertibleTo(reflect.TypeOf(&big.Int{})) {
@@ -105,7 +138,12 @@ func packConvert(arg *abi.Type, param any) (any, error) {
 	}
 }
 
-func (s *signer) Sign(ctx context.Context, account interfaces.ID, sender common.Address, _uniq string, _signer common.Address, _abi map[string]any, _args []any) (SignResult, error) {
+// Sign signs keccak256(abi.encode(uniq, nonce, sender, params)), where params
+// is the call encoded without its trailing signature argument. When ttl is
+// positive the signature expires at a deadline ttl from now, which is returned
+// with the signature and signed as keccak256(abi.encode(uniq, nonce, sender,
+// deadline, params)). See Verifier for the matching Solidity library.
+func (s *signer) Sign(ctx context.Context, account interfaces.ID, sender common.Address, _uniq string, _signer common.Address, _abi map[string]any, _args []any, ttl time.Duration) (SignResult, error) {
 	if b, err := json.Marshal(_abi); err != nil {
 		return nil, err
 	} else if a, err := abi.JSON(bytes.NewReader([]byte("[" + string(b) + "]"))); err != nil {
@@ -135,52 +173,51 @@ func (s *signer) Sign(ctx context.Context, account interfaces.ID, sender common.
 		} else {
 			bytes32, _ := abi.NewType("bytes32", "", nil)
 			address, _ := abi.NewType("address", "", nil)
+			uint256, _ := abi.NewType("uint256", "", nil)
 			bytesTy, _ := abi.NewType("bytes", "", nil)
 
-			args = abi.Arguments{
-				{ Type: bytes32 },
-				{ Type: bytes32 },
-				{ Type: address },
-				{ Type: bytesTy },
-			}
-
 			var nonce [32]byte
 			rand.Read(nonce[:])
 
 			var uniq [32]byte
 			var signer common.Address
+			var deadline uint64
 
-			copy(uniq[:], common.Hex2BytesFixed(_uniq, 32))
+			uniq = ParseUniq(_uniq)
 			signer = _signer
-			
-			buffer, err := args.Pack(uniq, nonce, sender, encodedParams)
+
+			var buffer []byte
+			var err error
+
+			if ttl > 0 {
+				deadline = uint64(time.Now().Add(ttl).Unix())
+
+				args = abi.Arguments{
+					{ Type: bytes32 },
+					{ Type: bytes32 },
+					{ Type: address },
+					{ Type: uint256 },
+					{ Type: bytesTy },
+				}
+				buffer, err = args.Pack(uniq, nonce, sender, new(big.Int).SetUint64(deadline), encodedParams)
+			} else {
+				args = abi.Arguments{
+					{ Type: bytes32 },
+					{ Type: bytes32 },
+					{ Type: address },
+					{ Type: bytesTy },
+				}
+				buffer, err = args.Pack(uniq, nonce, sender, encodedParams)
+			}
 			if err != nil {
 				return nil, err
 			}
 			
 			hash := crypto.Keccak256(buffer)
 			
-			var privateKey ecdsa.PrivateKey
-
-			if pk, ok := s.cache.Get(cacheKey{Account: account, Signer: signer}); ok {
-				privateKey = pk
-			} else {
-				wallet, err := s.vault.GetWallet(ctx, account, signer)
-				if err != nil {
-					return nil, err
-				}
-
-				if wallet.Address() != signer {
-					return nil, fmt.Errorf("invalid signer: %s", signer)
-				}
-
-				pk, err := wallet.PrivateKey(ctx)
-				if err != nil {
-					return nil, err
-				}
-
-				privateKey = pk
-				s.cache.Add(cacheKey{Account: account, Signer: signer}, pk)
+			privateKey, err := s.privateKey(ctx, account, signer)
+			if err != nil {
+				return nil, err
 			}
 
 			signatureBytes, err := crypto.Sign(hash, &privateKey)
@@ -190,17 +227,22 @@ func (s *signer) Sign(ctx context.Context, account interfaces.ID, sender common.
 
 			var v byte
 			var r [32]byte
-			var s [32]byte
+			var _s [32]byte
 
 			v = signatureBytes[64] + 27
 			copy(r[:], signatureBytes[:32])
-			copy(s[:], signatureBytes[32:64])
+			copy(_s[:], signatureBytes[32:64])
 
 			sig := signature{
 				Nonce_: "0x" + common.Bytes2Hex(nonce[:]),
 				R_: "0x" + common.Bytes2Hex(r[:]),
-				S_: "0x" + common.Bytes2Hex(s[:]),
+				S_: "0x" + common.Bytes2Hex(_s[:]),
 				V_: v,
+				Deadline_: deadline,
+			}
+
+			if err := s.issue(ctx, account, signer, sender, uniq, &sig); err != nil {
+				return nil, err
 			}
 
 			out := SignResult{}
@@ -215,6 +257,40 @@ func (s *signer) Sign(ctx context.Context, account interfaces.ID, sender common.
 	}
 }
 
+// privateKey returns the private key of the signer wallet from the cache,
+// decrypting and caching it on a miss.
+func (s *signer) privateKey(ctx context.Context, account interfaces.ID, signer common.Address) (ecdsa.PrivateKey, error) {
+	if pk, ok := s.cache.Get(cacheKey{Account: account, Signer: signer}); ok {
+		return pk, nil
+	}
+
+	s.mutex.Lock()
+	generation := s.generation
+	s.mutex.Unlock()
+
+	wallet, err := s.vault.GetWallet(ctx, account, signer)
+	if err != nil {
+		return ecdsa.PrivateKey{}, err
+	}
+
+	if wallet.Address() != signer {
+		return ecdsa.PrivateKey{}, fmt.Errorf("invalid signer: %s", signer)
+	}
+
+	pk, err := wallet.PrivateKey(ctx)
+	if err != nil {
+		return ecdsa.PrivateKey{}, err
+	}
+
+	s.mutex.Lock()
+	if generation == s.generation {
+		s.cache.Add(cacheKey{Account: account, Signer: signer}, pk)
+	}
+	s.mutex.Unlock()
+
+	return pk, nil
+}
+
 func (s *signature) R() string {
 	return s.R_
 }
@@ -229,4 +305,10 @@ func (s *signature) V() byte {
 
 func (s *signature) Nonce() string {
 	return s.Nonce_
+}
+
+// Deadline returns the unix time after which the signature is no longer
+// valid, or zero when it has no deadline.
+func (s *signature) Deadline() uint64 {
+	return s.Deadline_
 }
\ No newline at end of file
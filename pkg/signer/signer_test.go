@@ -0,0 +1,185 @@
+package signer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/kek/local"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/memory"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// cowKey is the private key keccak256("cow") used by the EIP-712 example.
+var cowKey = common.Bytes2Hex(crypto.Keccak256([]byte("cow")))
+
+var cowAddress = common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826")
+
+// newTestSigner returns a signer over a vault with memory storage holding the
+// cow wallet in account.
+func newTestSigner(t *testing.T) (*signer, vault.Vault) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	t.Setenv("VAULT_LOCAL_KEKS", "kek-1:" + base64.StdEncoding.EncodeToString(key))
+	t.Setenv("VAULT_LOCAL_KEK_ID", "kek-1")
+
+	provider, err := local.NewLocalKeyEncryptingKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := vault.NewVault(provider)
+	if err != nil {
+		t.Fatal(err)
+	} else if storage, err := memory.NewMemoryStorageBackend(v); err != nil {
+		t.Fatal(err)
+	} else if err := v.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	} else if _, err := v.ImportWallet(context.Background(), "account", "cow", vault.ImportKey{PrivateKey: cowKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSigner(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*signer), v
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var e *fiber.Error
+	if !errors.As(err, &e) || e.Code != status {
+		t.Fatalf("expected status %d, got %v", status, err)
+	}
+}
+
+// mintABI is mint(address to, Signature signature), with the signature as the
+// trailing argument as Sign expects.
+var mintABI = map[string]any{
+	"name": "mint",
+	"type": "function",
+	"stateMutability": "nonpayable",
+	"inputs": []any{
+		map[string]any{"name": "to", "type": "address"},
+		map[string]any{"name": "signature", "type": "tuple", "components": []any{
+			map[string]any{"name": "nonce", "type": "bytes32"},
+			map[string]any{"name": "r", "type": "bytes32"},
+			map[string]any{"name": "s", "type": "bytes32"},
+			map[string]any{"name": "v", "type": "uint8"},
+			map[string]any{"name": "deadline", "type": "uint256"},
+		}},
+	},
+	"outputs": []any{},
+}
+
+var mintTo = common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+var mintSender = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+// mint signs a mint to mintTo by mintSender for uniq, returning its signature.
+func mint(t *testing.T, s *signer, uniq string, ttl time.Duration) (*signature, error) {
+	t.Helper()
+
+	if result, err := s.Sign(context.Background(), "account", mintSender, uniq, cowAddress, mintABI, []any{mintTo.Hex()}, ttl); err != nil {
+		return nil, err
+	} else if len(result) != 2 {
+		t.Fatalf("expected the arguments followed by the signature, got %v", result)
+		return nil, nil
+	} else {
+		sig := result[1].(signature)
+		return &sig, nil
+	}
+}
+
+// mintHash recomputes the hash signed by a mint for uniq, with the deadline
+// of sig when it has one.
+func mintHash(t *testing.T, uniq string, sig *signature) []byte {
+	t.Helper()
+
+	bytes4, _ := abi.NewType("bytes4", "", nil)
+	bytes32, _ := abi.NewType("bytes32", "", nil)
+	address, _ := abi.NewType("address", "", nil)
+	uint256, _ := abi.NewType("uint256", "", nil)
+	bytesTy, _ := abi.NewType("bytes", "", nil)
+
+	var selector [4]byte
+	copy(selector[:], crypto.Keccak256([]byte("mint(address,(bytes32,bytes32,bytes32,uint8,uint256))")))
+
+	params, err := abi.Arguments{{Type: bytes4}, {Type: address}}.Pack(selector, mintTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer []byte
+	nonce := common.HexToHash(sig.Nonce_)
+	if sig.Deadline_ > 0 {
+		buffer, err = abi.Arguments{{Type: bytes32}, {Type: bytes32}, {Type: address}, {Type: uint256}, {Type: bytesTy}}.Pack(ParseUniq(uniq), nonce, mintSender, new(big.Int).SetUint64(sig.Deadline_), params)
+	} else {
+		buffer, err = abi.Arguments{{Type: bytes32}, {Type: bytes32}, {Type: address}, {Type: bytesTy}}.Pack(ParseUniq(uniq), nonce, mintSender, params)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.Keccak256(buffer)
+}
+
+// ecrecover returns the signer of hash as the ecrecover precompile does, or
+// the zero address.
+func ecrecover(hash []byte, v byte, r string, s string) common.Address {
+	signature := append(common.FromHex(r), common.FromHex(s)...)
+	if len(signature) != 64 || v < 27 {
+		return common.Address{}
+	} else if publicKey, err := crypto.SigToPub(hash, append(signature, v - 27)); err != nil {
+		return common.Address{}
+	} else {
+		return crypto.PubkeyToAddress(*publicKey)
+	}
+}
+
+func TestSignDeadline(t *testing.T) {
+	s, _ := newTestSigner(t)
+
+	before := time.Now().Unix()
+	sig, err := mint(t, s, "0x01", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().Unix()
+
+	if sig.Deadline() < uint64(before + 3600) || sig.Deadline() > uint64(after + 3600) {
+		t.Fatalf("expected a deadline an hour from now, got %d", sig.Deadline())
+	} else if signer := ecrecover(mintHash(t, "0x01", sig), sig.V(), sig.R(), sig.S()); signer != cowAddress {
+		t.Fatalf("expected the signature with its deadline to recover to %s, got %s", cowAddress, signer)
+	}
+
+	// the deadline is signed, so moving it changes the signer
+	moved := *sig
+	moved.Deadline_++
+	if signer := ecrecover(mintHash(t, "0x01", &moved), moved.V(), moved.R(), moved.S()); signer == cowAddress {
+		t.Fatal("expected a signature with a moved deadline not to recover")
+	}
+
+	// without a ttl the signature has no deadline and the hash omits it
+	if sig, err := mint(t, s, "0x01", 0); err != nil {
+		t.Fatal(err)
+	} else if sig.Deadline() != 0 {
+		t.Fatalf("expected no deadline, got %d", sig.Deadline())
+	} else if signer := ecrecover(mintHash(t, "0x01", sig), sig.V(), sig.R(), sig.S()); signer != cowAddress {
+		t.Fatalf("expected the signature to recover to %s, got %s", cowAddress, signer)
+	} else if b, err := json.Marshal(sig); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(b), "deadline") {
+		t.Fatalf("expected no deadline in the signature, got %s", b)
+	}
+}
@@ -0,0 +1,222 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/holiman/uint256"
+)
+
+// TransactionArgs is a transaction to sign, in the hex encoding used by
+// eth_signTransaction. The type is inferred from the fee fields when it is not
+// given: blob versioned hashes make an EIP-4844 transaction, max fees an
+// EIP-1559 transaction, an access list an EIP-2930 transaction and otherwise
+// a legacy transaction.
+type TransactionArgs struct {
+	Type *hexutil.Uint64 `json:"type,omitempty"`
+	ChainID *hexutil.Big `json:"chainId"`
+	Nonce *hexutil.Uint64 `json:"nonce"`
+	Gas *hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big `json:"gasPrice,omitempty"`
+	MaxFeePerGas *hexutil.Big `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big `json:"maxPriorityFeePerGas,omitempty"`
+	MaxFeePerBlobGas *hexutil.Big `json:"maxFeePerBlobGas,omitempty"`
+	To *common.Address `json:"to,omitempty"`
+	Value *hexutil.Big `json:"value,omitempty"`
+	Data *hexutil.Bytes `json:"data,omitempty"`
+	Input *hexutil.Bytes `json:"input,omitempty"`
+	AccessList *types.AccessList `json:"accessList,omitempty"`
+	BlobVersionedHashes []common.Hash `json:"blobVersionedHashes,omitempty"`
+}
+
+// SignedTransaction is a signed transaction, with raw as the RLP encoding to
+// pass to eth_sendRawTransaction. Blob transactions are encoded without their
+// sidecar.
+type SignedTransaction struct {
+	Raw hexutil.Bytes `json:"raw"`
+	Hash common.Hash `json:"hash"`
+	Tx *types.Transaction `json:"tx"`
+}
+
+func invalidTransaction(format string, args ...any) error {
+	return fiber.NewError(fiber.StatusBadRequest, "invalid transaction: " + fmt.Sprintf(format, args...))
+}
+
+func (args *TransactionArgs) txType() uint64 {
+	if args.Type != nil {
+		return uint64(*args.Type)
+	} else if args.BlobVersionedHashes != nil || args.MaxFeePerBlobGas != nil {
+		return types.BlobTxType
+	} else if args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil {
+		return types.DynamicFeeTxType
+	} else if args.AccessList != nil {
+		return types.AccessListTxType
+	} else {
+		return types.LegacyTxType
+	}
+}
+
+func (args *TransactionArgs) data() ([]byte, error) {
+	if args.Data != nil && args.Input != nil && !bytes.Equal(*args.Data, *args.Input) {
+		return nil, invalidTransaction("both data and input given and they differ")
+	} else if args.Input != nil {
+		return *args.Input, nil
+	} else if args.Data != nil {
+		return *args.Data, nil
+	} else {
+		return nil, nil
+	}
+}
+
+func toBig(v *hexutil.Big) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v.ToInt()
+}
+
+func toUint256(name string, v *hexutil.Big) (*uint256.Int, error) {
+	if v == nil {
+		return new(uint256.Int), nil
+	} else if out, overflow := uint256.FromBig(v.ToInt()); overflow || v.ToInt().Sign() < 0 {
+		return nil, invalidTransaction("%s out of range", name)
+	} else {
+		return out, nil
+	}
+}
+
+// transaction builds the unsigned transaction, checking that the fields
+// required by its type are present and that no fields of other types are
+// given.
+func (args *TransactionArgs) transaction() (*types.Transaction, error) {
+	var accessList types.AccessList
+	if args.AccessList != nil {
+		accessList = *args.AccessList
+	}
+
+	data, err := args.data()
+	if err != nil {
+		return nil, err
+	} else if args.ChainID == nil || args.ChainID.ToInt().Sign() <= 0 {
+		return nil, invalidTransaction("chainId is required")
+	} else if args.Nonce == nil {
+		return nil, invalidTransaction("nonce is required")
+	} else if args.Gas == nil || *args.Gas == 0 {
+		return nil, invalidTransaction("gas is required")
+	}
+
+	switch t := args.txType(); t {
+	case types.LegacyTxType, types.AccessListTxType:
+		if args.GasPrice == nil {
+			return nil, invalidTransaction("gasPrice is required for type %d transactions", t)
+		} else if args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil || args.MaxFeePerBlobGas != nil || args.BlobVersionedHashes != nil {
+			return nil, invalidTransaction("unexpected fee fields for type %d transactions", t)
+		} else if t == types.LegacyTxType && args.AccessList != nil {
+			return nil, invalidTransaction("legacy transactions have no access list")
+		} else if t == types.LegacyTxType {
+			return types.NewTx(&types.LegacyTx{
+				Nonce: uint64(*args.Nonce),
+				GasPrice: toBig(args.GasPrice),
+				Gas: uint64(*args.Gas),
+				To: args.To,
+				Value: toBig(args.Value),
+				Data: data,
+			}), nil
+		} else {
+			return types.NewTx(&types.AccessListTx{
+				ChainID: toBig(args.ChainID),
+				Nonce: uint64(*args.Nonce),
+				GasPrice: toBig(args.GasPrice),
+				Gas: uint64(*args.Gas),
+				To: args.To,
+				Value: toBig(args.Value),
+				Data: data,
+				AccessList: accessList,
+			}), nil
+		}
+
+	case types.DynamicFeeTxType:
+		if args.MaxFeePerGas == nil || args.MaxPriorityFeePerGas == nil {
+			return nil, invalidTransaction("maxFeePerGas and maxPriorityFeePerGas are required for type %d transactions", t)
+		} else if args.GasPrice != nil || args.MaxFeePerBlobGas != nil || args.BlobVersionedHashes != nil {
+			return nil, invalidTransaction("unexpected fee fields for type %d transactions", t)
+		} else {
+			return types.NewTx(&types.DynamicFeeTx{
+				ChainID: toBig(args.ChainID),
+				Nonce: uint64(*args.Nonce),
+				GasTipCap: toBig(args.MaxPriorityFeePerGas),
+				GasFeeCap: toBig(args.MaxFeePerGas),
+				Gas: uint64(*args.Gas),
+				To: args.To,
+				Value: toBig(args.Value),
+				Data: data,
+				AccessList: accessList,
+			}), nil
+		}
+
+	case types.BlobTxType:
+		if args.MaxFeePerGas == nil || args.MaxPriorityFeePerGas == nil || args.MaxFeePerBlobGas == nil {
+			return nil, invalidTransaction("maxFeePerGas, maxPriorityFeePerGas and maxFeePerBlobGas are required for type %d transactions", t)
+		} else if len(args.BlobVersionedHashes) == 0 {
+			return nil, invalidTransaction("blobVersionedHashes are required for type %d transactions", t)
+		} else if args.GasPrice != nil {
+			return nil, invalidTransaction("unexpected fee fields for type %d transactions", t)
+		} else if args.To == nil {
+			return nil, invalidTransaction("blob transactions can't create contracts")
+		} else if chainID, err := toUint256("chainId", args.ChainID); err != nil {
+			return nil, err
+		} else if gasTipCap, err := toUint256("maxPriorityFeePerGas", args.MaxPriorityFeePerGas); err != nil {
+			return nil, err
+		} else if gasFeeCap, err := toUint256("maxFeePerGas", args.MaxFeePerGas); err != nil {
+			return nil, err
+		} else if blobFeeCap, err := toUint256("maxFeePerBlobGas", args.MaxFeePerBlobGas); err != nil {
+			return nil, err
+		} else if value, err := toUint256("value", args.Value); err != nil {
+			return nil, err
+		} else {
+			return types.NewTx(&types.BlobTx{
+				ChainID: chainID,
+				Nonce: uint64(*args.Nonce),
+				GasTipCap: gasTipCap,
+				GasFeeCap: gasFeeCap,
+				Gas: uint64(*args.Gas),
+				To: *args.To,
+				Value: value,
+				Data: data,
+				AccessList: accessList,
+				BlobFeeCap: blobFeeCap,
+				BlobHashes: args.BlobVersionedHashes,
+			}), nil
+		}
+
+	default:
+		return nil, invalidTransaction("unsupported transaction type %d", t)
+	}
+}
+
+// SignTransaction signs a transaction with the private key of the signer
+// wallet, using the latest signer for the chain id of the transaction.
+func (s *signer) SignTransaction(ctx context.Context, account interfaces.ID, signer common.Address, args TransactionArgs) (*SignedTransaction, error) {
+	if tx, err := args.transaction(); err != nil {
+		return nil, err
+	} else if privateKey, err := s.privateKey(ctx, account, signer); err != nil {
+		return nil, err
+	} else if tx, err := types.SignTx(tx, types.LatestSignerForChainID(args.ChainID.ToInt()), &privateKey); err != nil {
+		return nil, invalidTransaction("%v", err)
+	} else if raw, err := tx.MarshalBinary(); err != nil {
+		return nil, err
+	} else {
+		return &SignedTransaction{
+			Raw: raw,
+			Hash: tx.Hash(),
+			Tx: tx,
+		}, nil
+	}
+}
@@ -0,0 +1,130 @@
+package signer
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTransactionArgs(t uint64) TransactionArgs {
+	txType := hexutil.Uint64(t)
+	nonce := hexutil.Uint64(7)
+	gas := hexutil.Uint64(21000)
+	to := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+	data := hexutil.Bytes{1, 2, 3}
+
+	args := TransactionArgs{
+		Type: &txType,
+		ChainID: (*hexutil.Big)(big.NewInt(11155111)),
+		Nonce: &nonce,
+		Gas: &gas,
+		To: &to,
+		Value: (*hexutil.Big)(big.NewInt(1000)),
+		Data: &data,
+	}
+
+	switch t {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(big.NewInt(1e9))
+	case types.AccessListTxType:
+		args.GasPrice = (*hexutil.Big)(big.NewInt(1e9))
+		args.AccessList = &types.AccessList{{Address: to, StorageKeys: []common.Hash{{1}}}}
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(big.NewInt(2e9))
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(big.NewInt(1e9))
+	case types.BlobTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(big.NewInt(2e9))
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(big.NewInt(1e9))
+		args.MaxFeePerBlobGas = (*hexutil.Big)(big.NewInt(3e9))
+		args.BlobVersionedHashes = []common.Hash{{1}}
+	}
+	return args
+}
+
+func TestSignTransaction(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSigner(t)
+
+	for _, txType := range []uint64{types.LegacyTxType, types.AccessListTxType, types.DynamicFeeTxType, types.BlobTxType} {
+		args := newTransactionArgs(txType)
+
+		signed, err := s.SignTransaction(ctx, "account", cowAddress, args)
+		if err != nil {
+			t.Fatalf("type %d: %v", txType, err)
+		}
+
+		var tx types.Transaction
+		if err := tx.UnmarshalBinary(signed.Raw); err != nil {
+			t.Fatalf("type %d: %v", txType, err)
+		} else if tx.Type() != uint8(txType) {
+			t.Fatalf("expected a type %d transaction, got %d", txType, tx.Type())
+		} else if tx.Hash() != signed.Hash {
+			t.Fatalf("type %d: expected hash %s, got %s", txType, signed.Hash, tx.Hash())
+		} else if tx.ChainId().Cmp(args.ChainID.ToInt()) != 0 || tx.Nonce() != 7 || tx.Gas() != 21000 || *tx.To() != *args.To || tx.Value().Cmp(big.NewInt(1000)) != 0 {
+			t.Fatalf("type %d: expected the fields as given", txType)
+		} else if sender, err := types.Sender(types.LatestSignerForChainID(args.ChainID.ToInt()), &tx); err != nil {
+			t.Fatalf("type %d: %v", txType, err)
+		} else if sender != cowAddress {
+			t.Fatalf("type %d: expected the transaction to be signed by %s, got %s", txType, cowAddress, sender)
+		}
+
+		// the type is inferred from the fee fields when it isn't given
+		args.Type = nil
+		if signed, err := s.SignTransaction(ctx, "account", cowAddress, args); err != nil {
+			t.Fatalf("type %d: %v", txType, err)
+		} else if signed.Tx.Type() != uint8(txType) {
+			t.Fatalf("expected a type %d transaction to be inferred, got %d", txType, signed.Tx.Type())
+		}
+	}
+}
+
+func TestSignTransactionMissingFields(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSigner(t)
+
+	for _, c := range []struct {
+		name string
+		txType uint64
+		clear func(args *TransactionArgs)
+	}{
+		{"chainId", types.LegacyTxType, func(args *TransactionArgs) { args.ChainID = nil }},
+		{"nonce", types.LegacyTxType, func(args *TransactionArgs) { args.Nonce = nil }},
+		{"gas", types.LegacyTxType, func(args *TransactionArgs) { args.Gas = nil }},
+		{"gasPrice", types.LegacyTxType, func(args *TransactionArgs) { args.GasPrice = nil }},
+		{"chainId", types.AccessListTxType, func(args *TransactionArgs) { args.ChainID = nil }},
+		{"gasPrice", types.AccessListTxType, func(args *TransactionArgs) { args.GasPrice = nil }},
+		{"chainId", types.DynamicFeeTxType, func(args *TransactionArgs) { args.ChainID = nil }},
+		{"maxFeePerGas", types.DynamicFeeTxType, func(args *TransactionArgs) { args.MaxFeePerGas = nil }},
+		{"maxPriorityFeePerGas", types.DynamicFeeTxType, func(args *TransactionArgs) { args.MaxPriorityFeePerGas = nil }},
+		{"chainId", types.BlobTxType, func(args *TransactionArgs) { args.ChainID = nil }},
+		{"maxFeePerBlobGas", types.BlobTxType, func(args *TransactionArgs) { args.MaxFeePerBlobGas = nil }},
+		{"blobVersionedHashes", types.BlobTxType, func(args *TransactionArgs) { args.BlobVersionedHashes = nil }},
+		{"to", types.BlobTxType, func(args *TransactionArgs) { args.To = nil }},
+	} {
+		args := newTransactionArgs(c.txType)
+		c.clear(&args)
+
+		if _, err := s.SignTransaction(ctx, "account", cowAddress, args); err == nil {
+			t.Errorf("expected a type %d transaction without %s to be rejected", c.txType, c.name)
+		} else {
+			requireStatus(t, err, fiber.StatusBadRequest)
+		}
+	}
+
+	// fields of another type are rejected rather than ignored
+	args := newTransactionArgs(types.LegacyTxType)
+	args.MaxFeePerGas = (*hexutil.Big)(big.NewInt(2e9))
+	_, err := s.SignTransaction(ctx, "account", cowAddress, args)
+	requireStatus(t, err, fiber.StatusBadRequest)
+
+	args = newTransactionArgs(types.DynamicFeeTxType)
+	input := hexutil.Bytes{4}
+	args.Input = &input
+	_, err = s.SignTransaction(ctx, "account", cowAddress, args)
+	requireStatus(t, err, fiber.StatusBadRequest)
+}
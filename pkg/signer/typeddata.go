@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// ParseTypedData parses an eth_signTypedData_v4 payload. Numbers are kept as
+// decimal strings, so integers beyond the precision of a float64 are encoded
+// exactly.
+func ParseTypedData(b []byte) (apitypes.TypedData, error) {
+	var typedData apitypes.TypedData
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	if err := d.Decode(&typedData); err != nil {
+		return typedData, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid typed data: %v", err))
+	} else if typedData.PrimaryType == "" || typedData.Types[typedData.PrimaryType] == nil {
+		return typedData, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid typed data: primary type %q is not defined", typedData.PrimaryType))
+	} else if typedData.Types["EIP712Domain"] == nil {
+		return typedData, fiber.NewError(fiber.StatusBadRequest, "invalid typed data: EIP712Domain is not defined")
+	} else {
+		typedData.Message = numbersToStrings(typedData.Message).(map[string]any)
+		return typedData, nil
+	}
+}
+
+func numbersToStrings(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]any:
+		for k, e := range v {
+			v[k] = numbersToStrings(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = numbersToStrings(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// SignTypedData signs the EIP-712 digest of typedData with the private key of
+// the signer wallet.
+func (s *signer) SignTypedData(ctx context.Context, account interfaces.ID, signer common.Address, typedData apitypes.TypedData) (*DigestSignature, error) {
+	if digest, _, err := apitypes.TypedDataAndHash(typedData); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid typed data: %v", err))
+	} else {
+		return s.signDigest(ctx, account, signer, digest)
+	}
+}
@@ -0,0 +1,102 @@
+package signer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+)
+
+// mail is the example of EIP-712, signed by the cow wallet.
+const mail = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+func TestSignTypedData(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestSigner(t)
+
+	typedData, err := ParseTypedData([]byte(mail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := s.SignTypedData(ctx, "account", cowAddress, typedData)
+	if err != nil {
+		t.Fatal(err)
+	} else if sig.Digest != "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2" {
+		t.Fatalf("expected the digest of the EIP-712 example, got %s", sig.Digest)
+	} else if sig.R != "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d" || sig.S != "0x07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b91562" || sig.V != 28 {
+		t.Fatalf("expected the signature of the EIP-712 example, got r %s s %s v %d", sig.R, sig.S, sig.V)
+	} else if sig.Signature != sig.R + sig.S[2:] + "1c" {
+		t.Fatalf("expected the signature to be r, s and v, got %s", sig.Signature)
+	}
+
+	signature := common.FromHex(sig.Signature)
+	signature[64] -= 27
+	if publicKey, err := crypto.SigToPub(common.FromHex(sig.Digest), signature); err != nil {
+		t.Fatal(err)
+	} else if address := crypto.PubkeyToAddress(*publicKey); address != cowAddress {
+		t.Fatalf("expected the signature to recover to %s, got %s", cowAddress, address)
+	}
+
+	_, err = s.SignTypedData(ctx, "other", cowAddress, typedData)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func TestParseTypedData(t *testing.T) {
+	// numbers are kept exactly, beyond the precision of a float64
+	if typedData, err := ParseTypedData([]byte(`{
+		"types": {"EIP712Domain": [], "Amount": [{"name": "value", "type": "uint256"}]},
+		"primaryType": "Amount",
+		"domain": {},
+		"message": {"value": 123456789012345678901234567890}
+	}`)); err != nil {
+		t.Fatal(err)
+	} else if value := typedData.Message["value"]; value != "123456789012345678901234567890" {
+		t.Fatalf("expected the value as a decimal string, got %v", value)
+	}
+
+	for name, b := range map[string]string{
+		"invalid json": `{`,
+		"no primary type": `{"types": {"EIP712Domain": []}, "domain": {}, "message": {}}`,
+		"undefined primary type": `{"types": {"EIP712Domain": []}, "primaryType": "Mail", "domain": {}, "message": {}}`,
+		"no domain type": `{"types": {"Mail": []}, "primaryType": "Mail", "domain": {}, "message": {}}`,
+	} {
+		if _, err := ParseTypedData([]byte(b)); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		} else {
+			requireStatus(t, err, fiber.StatusBadRequest)
+		}
+	}
+}
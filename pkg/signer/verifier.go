@@ -0,0 +1,74 @@
+package signer
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// verifierTemplate is a Solidity library verifying signatures issued by Sign
+// with a ttl. Signatures without a deadline are signed without it and are not
+// accepted by the library.
+var verifierTemplate = template.Must(template.New("SignchainVerifier.sol").Parse(`// SPDX-License-Identifier: MIT
+// Generated by signchain-vault for signer {{.Signer}}.
+pragma solidity ^0.8.4;
+
+/// @notice Verifies signatures issued by the vault with a ttl. The vault signs
+/// keccak256(abi.encode(uniq, nonce, sender, deadline, params)), where params
+/// is abi.encode(selector, args...) of the call without its trailing signature
+/// argument, and uniq and sender are the values given to the sign endpoint.
+///
+/// function mint(address to, SignchainVerifier.Signature calldata signature) external {
+///     bytes32 uniq = keccak256(abi.encode(to));
+///     SignchainVerifier.verify(uniq, msg.sender, abi.encode(this.mint.selector, to), signature);
+///     ...
+/// }
+library SignchainVerifier {
+    address internal constant SIGNER = {{.Signer}};
+
+    struct Signature {
+        bytes32 nonce;
+        bytes32 r;
+        bytes32 s;
+        uint8 v;
+        uint256 deadline;
+    }
+
+    error SignatureExpired(uint256 deadline);
+    error InvalidSignature();
+
+    function verify(bytes32 uniq, address sender, bytes memory params, Signature memory signature) internal view {
+        verify(SIGNER, uniq, sender, params, signature);
+    }
+
+    function verify(address signer, bytes32 uniq, address sender, bytes memory params, Signature memory signature) internal view {
+        if (block.timestamp > signature.deadline) {
+            revert SignatureExpired(signature.deadline);
+        }
+
+        // the vault only issues signatures with a low s value
+        if (uint256(signature.s) > 0x7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF5D576E7357A4501DDFE92F46681B20A0) {
+            revert InvalidSignature();
+        }
+
+        bytes32 hash = keccak256(abi.encode(uniq, signature.nonce, sender, signature.deadline, params));
+        address recovered = ecrecover(hash, signature.v, signature.r, signature.s);
+        if (recovered == address(0) || recovered != signer) {
+            revert InvalidSignature();
+        }
+    }
+}
+`))
+
+// Verifier returns the source of a Solidity library which verifies signatures
+// with a deadline issued by signer.
+func Verifier(signer common.Address) (string, error) {
+	var b bytes.Buffer
+
+	if err := verifierTemplate.Execute(&b, struct{ Signer string }{signer.Hex()}); err != nil {
+		return "", err
+	} else {
+		return b.String(), nil
+	}
+}
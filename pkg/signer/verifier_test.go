@@ -0,0 +1,110 @@
+package signer
+
+import (
+	"flag"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var update = flag.Bool("update", false, "update the golden verifier source")
+
+// TestVerifier compares the verifier of the cow wallet with the reviewed
+// source in testdata, and compiles it when solc is installed.
+func TestVerifier(t *testing.T) {
+	golden := filepath.Join("testdata", "SignchainVerifier.sol")
+
+	source, err := Verifier(cowAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := os.WriteFile(golden, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expected, err := os.ReadFile(golden); err != nil {
+		t.Fatal(err)
+	} else if source != string(expected) {
+		t.Fatalf("verifier differs from %s, review it and run go test -update:\n%s", golden, source)
+	}
+
+	if solc, err := exec.LookPath("solc"); err != nil {
+		t.Log("solc not installed, skipping compilation")
+	} else if out, err := exec.Command(solc, "--bin", golden).CombinedOutput(); err != nil {
+		t.Fatalf("solc: %v\n%s", err, out)
+	}
+}
+
+// sBound returns the largest s accepted by the verifier.
+func sBound(t *testing.T, source string) *big.Int {
+	t.Helper()
+
+	if match := regexp.MustCompile(`uint256\(signature\.s\) > 0x([0-9A-F]{64})`).FindStringSubmatch(source); match == nil {
+		t.Fatal("expected the verifier to bound s")
+		return nil
+	} else {
+		bound, _ := new(big.Int).SetString(match[1], 16)
+		return bound
+	}
+}
+
+// verify checks sig as SignchainVerifier.verify does.
+func verify(bound *big.Int, now time.Time, hash []byte, sig *signature) bool {
+	if uint64(now.Unix()) > sig.Deadline() {
+		return false
+	} else if new(big.Int).SetBytes(common.FromHex(sig.S())).Cmp(bound) > 0 {
+		return false
+	} else {
+		return ecrecover(hash, sig.V(), sig.R(), sig.S()) == cowAddress
+	}
+}
+
+// TestVerifierRecovers checks a signature issued by Sign against the checks
+// of the verifier.
+func TestVerifierRecovers(t *testing.T) {
+	s, _ := newTestSigner(t)
+
+	source, err := Verifier(cowAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := mint(t, s, "0x01", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := mintHash(t, "0x01", sig)
+
+	// the bound is half the curve order, so only low s signatures pass
+	bound := sBound(t, source)
+	if halfOrder := new(big.Int).Rsh(crypto.S256().Params().N, 1); bound.Cmp(halfOrder) != 0 {
+		t.Fatalf("expected the s bound to be half the curve order %x, got %x", halfOrder, bound)
+	}
+
+	if !verify(bound, time.Now(), hash, sig) {
+		t.Fatal("expected the signature to verify")
+	} else if verify(bound, time.Now().Add(2 * time.Minute), hash, sig) {
+		t.Fatal("expected the signature to expire after its deadline")
+	}
+
+	// the high s form of the signature recovers to the signer as well, but is
+	// refused
+	highS := *sig
+	highS.S_ = "0x" + common.Bytes2Hex(common.LeftPadBytes(new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(common.FromHex(sig.S()))).Bytes(), 32))
+	highS.V_ = 55 - sig.V()
+	if ecrecover(hash, highS.V(), highS.R(), highS.S()) != cowAddress {
+		t.Fatal("expected the high s signature to recover to the signer")
+	} else if verify(bound, time.Now(), hash, &highS) {
+		t.Fatal("expected the high s signature to be refused")
+	}
+}
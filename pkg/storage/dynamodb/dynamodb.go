@@ -1,11 +1,238 @@
 package dynamodb
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 )
 
-func NewDynamoDBStorageBackend() (interfaces.IStorageBackend, error) {
-	return nil, fmt.Errorf("not implemented")
-}
\ No newline at end of file
+// The backend uses a single table. Keys are stored under "key#<id>" and
+// wallets under "wallet#<address>", which makes the address unique across the
+// table. Issued signatures are stored under "signature#<wallet>#<sender>" with
+// the uniq as sort key. The "account" index serves ListWallets and the "keys" index, sorted
+// by reference count, serves GetOrCreateRandomKey without a scan.
+const (
+	accountIndex = "account"
+	keysIndex = "keys"
+	ttlAttribute = "ttl"
+)
+
+type dynamoDBStorageBackend struct {
+	client *dynamodb.Client
+	table string
+	vault interfaces.IVaultService
+}
+
+var _ interfaces.IStorageBackend = &dynamoDBStorageBackend{}
+
+func NewDynamoDBStorageBackend(vault interfaces.IVaultService) (interfaces.IStorageBackend, error) {
+	b := &dynamoDBStorageBackend{vault: vault, table: "signchain-vault"}
+
+	if t := strings.TrimSpace(os.Getenv("VAULT_DYNAMODB_TABLE")); t != "" {
+		b.table = t
+	}
+
+	if cfg, err := config.LoadDefaultConfig(context.Background()); err != nil {
+		return nil, err
+	} else {
+		b.client = dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+			if endpoint := strings.TrimSpace(os.Getenv("VAULT_DYNAMODB_ENDPOINT")); endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		})
+	}
+
+	if err := b.EnsureTable(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *dynamoDBStorageBackend) EnsureTable(ctx context.Context) error {
+	var notFound *types.ResourceNotFoundException
+
+	if _, err := b.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(b.table)}); err == nil {
+		return nil
+	} else if !errors.As(err, &notFound) {
+		return fmt.Errorf("unable to describe table %s: %v", b.table, err)
+	}
+
+	if _, err := b.client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(b.table),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("sk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("gsi1pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("gsi1sk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("gsi2pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("refCount"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("sk"), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(accountIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("gsi1pk"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("gsi1sk"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+			{
+				IndexName: aws.String(keysIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("gsi2pk"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("refCount"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to create table %s: %v", b.table, err)
+	}
+
+	if err := dynamodb.NewTableExistsWaiter(b.client).Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(b.table)}, 5 * time.Minute); err != nil {
+		return err
+	}
+
+	if _, err := b.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(b.table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlAttribute),
+			Enabled: aws.Bool(true),
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to enable time to live on table %s: %v", b.table, err)
+	}
+
+	return nil
+}
+
+// DynamoDB removes items some time after their TTL has passed, so reads
+// filter out items that have expired but not yet been deleted.
+const notExpiredFilter = "(attribute_not_exists(#ttl) OR #ttl > :now)"
+
+func notExpiredValues(now time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		":now": &types.AttributeValueMemberN{Value: fmt.Sprint(now.Unix())},
+	}
+}
+
+func expired(ttl *int64, now time.Time) bool {
+	return ttl != nil && *ttl <= now.Unix()
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var e *types.ConditionalCheckFailedException
+	return errors.As(err, &e)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// query pages through a query, skipping offset items and returning at most
+// count items, or all remaining items when count is zero.
+func (b *dynamoDBStorageBackend) query(ctx context.Context, input *dynamodb.QueryInput, offset int64, count int64) ([]map[string]types.AttributeValue, error) {
+	var out []map[string]types.AttributeValue
+	var skipped int64
+
+	p := dynamodb.NewQueryPaginator(b.client, input)
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			out = append(out, item)
+			if count > 0 && int64(len(out)) >= count {
+				return out, nil
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (b *dynamoDBStorageBackend) count(ctx context.Context, input *dynamodb.QueryInput) (int64, error) {
+	var total int64
+
+	input.Select = types.SelectCount
+	p := dynamodb.NewQueryPaginator(b.client, input)
+	for p.HasMorePages() {
+		if page, err := p.NextPage(ctx); err != nil {
+			return 0, err
+		} else {
+			total += int64(page.Count)
+		}
+	}
+
+	return total, nil
+}
+
+// scan pages through a scan in the same way as query. Scans return items in
+// the order of their partition, which is stable while the table is unchanged.
+func (b *dynamoDBStorageBackend) scan(ctx context.Context, input *dynamodb.ScanInput, offset int64, count int64) ([]map[string]types.AttributeValue, error) {
+	var out []map[string]types.AttributeValue
+	var skipped int64
+
+	p := dynamodb.NewScanPaginator(b.client, input)
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			out = append(out, item)
+			if count > 0 && int64(len(out)) >= count {
+				return out, nil
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (b *dynamoDBStorageBackend) scanCount(ctx context.Context, input *dynamodb.ScanInput) (int64, error) {
+	var total int64
+
+	input.Select = types.SelectCount
+	p := dynamodb.NewScanPaginator(b.client, input)
+	for p.HasMorePages() {
+		if page, err := p.NextPage(ctx); err != nil {
+			return 0, err
+		} else {
+			total += int64(page.Count)
+		}
+	}
+
+	return total, nil
+}
+
+func notFoundError(format string, args ...any) error {
+	return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf(format, args...))
+}
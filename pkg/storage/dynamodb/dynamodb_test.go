@@ -0,0 +1,150 @@
+package dynamodb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/storagetest"
+)
+
+// newTestBackend returns a backend with its own table on DynamoDB Local,
+// deleted when the test ends, e.g.
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	VAULT_DYNAMODB_ENDPOINT=http://localhost:8000 go test ./pkg/storage/dynamodb
+func newTestBackend(t *testing.T, vault interfaces.IVaultService) *dynamoDBStorageBackend {
+	if os.Getenv("VAULT_DYNAMODB_ENDPOINT") == "" {
+		t.Skip("VAULT_DYNAMODB_ENDPOINT not set")
+	}
+
+	// DynamoDB Local accepts any credentials
+	for name, value := range map[string]string{
+		"AWS_REGION": "us-east-1",
+		"AWS_ACCESS_KEY_ID": "local",
+		"AWS_SECRET_ACCESS_KEY": "local",
+	} {
+		if os.Getenv(name) == "" {
+			t.Setenv(name, value)
+		}
+	}
+
+	table := make([]byte, 8)
+	rand.Read(table)
+	t.Setenv("VAULT_DYNAMODB_TABLE", "signchain-vault-test-" + hex.EncodeToString(table))
+
+	backend, err := NewDynamoDBStorageBackend(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := backend.(*dynamoDBStorageBackend)
+	t.Cleanup(func() {
+		b.client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(b.table)})
+	})
+
+	return b
+}
+
+// TestConformance creates a table for each subtest.
+func TestConformance(t *testing.T) {
+	if os.Getenv("VAULT_DYNAMODB_ENDPOINT") == "" {
+		t.Skip("VAULT_DYNAMODB_ENDPOINT not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+		return newTestBackend(t, vault)
+	})
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var e *fiber.Error
+	if !errors.As(err, &e) || e.Code != status {
+		t.Fatalf("expected status %d, got %v", status, err)
+	}
+}
+
+func TestWallets(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackend(t, nil)
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60))
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = b.CreateWallet(ctx, "account", "wallet", common.HexToAddress("0x01"), "missing", make([]byte, 60))
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	_, err = b.GetWallet(ctx, "other", address)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if r, err := b.ListWallets(ctx, "account", 0, 10); err != nil {
+		t.Fatal(err)
+	} else if r.Count() != 1 || len(r.Page()) != 1 || r.Page()[0].Address() != address {
+		t.Fatalf("expected the wallet to be listed, got %d", r.Count())
+	}
+
+	if count, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expected ref count 1, got %d", count)
+	}
+
+	// expired wallets are hidden before the TTL process deletes them
+	if _, err := b.ExpireWallet(ctx, "account", address, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = b.GetWallet(ctx, "account", address)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+// TestRefCountExcludesExpiredWallets checks that a wallet past its TTL, which
+// the TTL process deletes without decrementing the refCount attribute, no
+// longer keeps its data encrypting key from being retired.
+func TestRefCountExcludesExpiredWallets(t *testing.T) {
+	b := newTestBackend(t, nil)
+	ctx := context.Background()
+	account := "acct-refcount"
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), storagetest.KeyEncryptingKey, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, account, "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expected ref count 1, got %d", count)
+	}
+
+	if _, err := b.ExpireWallet(ctx, account, address, -time.Second); err != nil {
+		t.Fatal(err)
+	} else if count, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("expected ref count 0 once the wallet expired, got %d", count)
+	}
+}
@@ -0,0 +1,324 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type dataEncryptingKey struct {
+	backend *dynamoDBStorageBackend
+	PK string `dynamodbav:"pk"`
+	SK string `dynamodbav:"sk"`
+	GSI2PK string `dynamodbav:"gsi2pk"`
+	ID_ interfaces.ID `dynamodbav:"id"`
+	KeyEncryptingKey_ interfaces.ID `dynamodbav:"keyEncryptingKey"`
+	EncryptedKey_ []byte `dynamodbav:"encryptedKey"`
+	RefCount_ int64 `dynamodbav:"refCount"`
+	Expires_ *time.Time `dynamodbav:"expires,omitempty"`
+	TTL *int64 `dynamodbav:"ttl,omitempty"`
+}
+
+var _ interfaces.DataEncryptingKey = &dataEncryptingKey{}
+
+func keyPK(id interfaces.ID) string {
+	return "key#" + id
+}
+
+func keyItemKey(id interfaces.ID) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: keyPK(id)},
+		"sk": &types.AttributeValueMemberS{Value: "key"},
+	}
+}
+
+func (k *dataEncryptingKey) ID() interfaces.ID {
+	return k.ID_
+}
+
+func (k *dataEncryptingKey) KeyEncryptingKey() interfaces.ID {
+	return k.KeyEncryptingKey_
+}
+
+func (k *dataEncryptingKey) EncryptedKey() []byte {
+	return k.EncryptedKey_
+}
+
+func (k *dataEncryptingKey) Expires() *time.Time {
+	return k.Expires_
+}
+
+// RefCount counts the wallets and seeds referencing the key which have not
+// expired, rather than reading the refCount attribute. The attribute is
+// maintained with atomic counters, but wallets deleted by the DynamoDB TTL
+// process are never subtracted, so it is only an upper bound, which is enough
+// to spread new wallets over keys but would keep a retired key from ever
+// reaching zero. Counting scans the table, as ListAllWallets does.
+func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
+	values := notExpiredValues(time.Now())
+	values[":key"] = &types.AttributeValueMemberS{Value: k.ID_}
+	values[":wallet"] = &types.AttributeValueMemberS{Value: "wallet"}
+	values[":seed"] = &types.AttributeValueMemberS{Value: "seed"}
+
+	if out, err := k.backend.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(k.backend.table),
+		Key: keyItemKey(k.ID_),
+		ProjectionExpression: aws.String("pk"),
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return 0, err
+	} else if out.Item == nil {
+		return 0, notFoundError("data encrypting key %s not found", k.ID_)
+	} else {
+		return k.backend.scanCount(ctx, &dynamodb.ScanInput{
+			TableName: aws.String(k.backend.table),
+			FilterExpression: aws.String("dataEncryptingKey = :key AND (sk = :wallet OR sk = :seed) AND " + notExpiredFilter),
+			ExpressionAttributeNames: map[string]string{"#ttl": ttlAttribute},
+			ExpressionAttributeValues: values,
+			ConsistentRead: aws.Bool(true),
+		})
+	}
+}
+
+type listDataEncryptingKeysResult struct {
+	Count_ int64
+	Page_ []*dataEncryptingKey
+}
+
+var _ interfaces.ListDataEncryptingKeysResult = &listDataEncryptingKeysResult{}
+
+func (r *listDataEncryptingKeysResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listDataEncryptingKeysResult) Page() []interfaces.DataEncryptingKey {
+	out := make([]interfaces.DataEncryptingKey, len(r.Page_))
+	for i, k := range r.Page_ {
+		out[i] = k
+	}
+	return out
+}
+
+func (b *dynamoDBStorageBackend) decodeDataEncryptingKey(item map[string]types.AttributeValue) (*dataEncryptingKey, error) {
+	var k dataEncryptingKey
+	if err := attributevalue.UnmarshalMap(item, &k); err != nil {
+		return nil, err
+	}
+	k.backend = b
+	return &k, nil
+}
+
+func (b *dynamoDBStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (interfaces.DataEncryptingKey, error) {
+	var candidates []*dataEncryptingKey
+
+	if items, err := b.query(ctx, &dynamodb.QueryInput{
+		TableName: aws.String(b.table),
+		IndexName: aws.String(keysIndex),
+		KeyConditionExpression: aws.String("gsi2pk = :pk AND refCount < :max"),
+		// keys scheduled to expire are not handed out to new wallets
+		FilterExpression: aws.String("attribute_not_exists(expires)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "key"},
+			":max": &types.AttributeValueMemberN{Value: fmt.Sprint(maxRefCount)},
+		},
+	}, 0, 100); err != nil {
+		return nil, err
+	} else {
+		for _, item := range items {
+			if k, err := b.decodeDataEncryptingKey(item); err != nil {
+				return nil, err
+			} else {
+				candidates = append(candidates, k)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return b.vault.CreateDataEncryptingKey(ctx)
+	}
+
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+func (b *dynamoDBStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		PK: keyPK(id),
+		SK: "key",
+		GSI2PK: "key",
+		ID_: id,
+		KeyEncryptingKey_: keyEncryptingKey,
+		EncryptedKey_: encryptedKey,
+		RefCount_: 0,
+	}
+
+	if item, err := attributevalue.MarshalMap(&k); err != nil {
+		return nil, err
+	} else if _, err := b.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(b.table),
+		Item: item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	}); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+// PutDataEncryptingKey keeps the refCount of an existing key, which is
+// maintained by PutWallet and CreateWallet.
+func (b *dynamoDBStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	update := "SET gsi2pk = :gsi2pk, #id = :id, keyEncryptingKey = :keyEncryptingKey, encryptedKey = :encryptedKey, refCount = if_not_exists(refCount, :zero)"
+	values := map[string]types.AttributeValue{
+		":gsi2pk": &types.AttributeValueMemberS{Value: "key"},
+		":id": &types.AttributeValueMemberS{Value: src.ID()},
+		":keyEncryptingKey": &types.AttributeValueMemberS{Value: src.KeyEncryptingKey()},
+		":encryptedKey": &types.AttributeValueMemberB{Value: src.EncryptedKey()},
+		":zero": &types.AttributeValueMemberN{Value: "0"},
+	}
+
+	if expires := src.Expires(); expires != nil {
+		update += ", expires = :expires, #ttl = :ttl"
+		values[":expires"] = &types.AttributeValueMemberS{Value: formatTime(*expires)}
+		values[":ttl"] = &types.AttributeValueMemberN{Value: fmt.Sprint(expires.Unix())}
+	} else {
+		update += " REMOVE expires, #ttl"
+	}
+
+	if out, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: keyItemKey(src.ID()),
+		UpdateExpression: aws.String(update),
+		ExpressionAttributeNames: map[string]string{"#id": "id", "#ttl": ttlAttribute},
+		ExpressionAttributeValues: values,
+		ReturnValues: types.ReturnValueAllNew,
+	}); err != nil {
+		return nil, err
+	} else {
+		return b.decodeDataEncryptingKey(out.Attributes)
+	}
+}
+
+func (b *dynamoDBStorageBackend) GetDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.table),
+		Key: keyItemKey(id),
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return nil, err
+	} else if out.Item == nil {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if k, err := b.decodeDataEncryptingKey(out.Item); err != nil {
+		return nil, err
+	} else if expired(k.TTL, time.Now()) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else {
+		return k, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) allKeysScan(now time.Time) *dynamodb.ScanInput {
+	values := notExpiredValues(now)
+	values[":sk"] = &types.AttributeValueMemberS{Value: "key"}
+
+	return &dynamodb.ScanInput{
+		TableName: aws.String(b.table),
+		FilterExpression: aws.String("sk = :sk AND " + notExpiredFilter),
+		ExpressionAttributeNames: map[string]string{"#ttl": ttlAttribute},
+		ExpressionAttributeValues: values,
+		ConsistentRead: aws.Bool(true),
+	}
+}
+
+// ListDataEncryptingKeys scans the table rather than querying the keys index,
+// which is ordered by the changing reference count. The scan resumes from the
+// key of the data encrypting key at after, which DynamoDB accepts whether or
+// not the key still exists.
+func (b *dynamoDBStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	var r listDataEncryptingKeysResult
+	now := time.Now()
+
+	input := b.allKeysScan(now)
+	if after != "" {
+		input.ExclusiveStartKey = keyItemKey(after)
+	}
+
+	if total, err := b.scanCount(ctx, b.allKeysScan(now)); err != nil {
+		return nil, err
+	} else if items, err := b.scan(ctx, input, 0, count); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, item := range items {
+			if k, err := b.decodeDataEncryptingKey(item); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, k)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) updateDataEncryptingKey(ctx context.Context, id interfaces.ID, update string, values map[string]types.AttributeValue) (interfaces.DataEncryptingKey, error) {
+	if out, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: keyItemKey(id),
+		UpdateExpression: aws.String(update),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeNames: map[string]string{"#ttl": ttlAttribute},
+		ExpressionAttributeValues: values,
+		ReturnValues: types.ReturnValueAllNew,
+	}); isConditionalCheckFailed(err) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return b.decodeDataEncryptingKey(out.Attributes)
+	}
+}
+
+func (b *dynamoDBStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	if out, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: keyItemKey(id),
+		UpdateExpression: aws.String("SET keyEncryptingKey = :keyEncryptingKey, encryptedKey = :encryptedKey"),
+		ConditionExpression: aws.String("attribute_exists(pk) AND keyEncryptingKey = :from"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: from},
+			":keyEncryptingKey": &types.AttributeValueMemberS{Value: keyEncryptingKey},
+			":encryptedKey": &types.AttributeValueMemberB{Value: encryptedKey},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	}); isConditionalCheckFailed(err) {
+		if _, err := b.GetDataEncryptingKey(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("data encrypting key %s is no longer wrapped by %s", id, from))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return b.decodeDataEncryptingKey(out.Attributes)
+	}
+}
+
+func (b *dynamoDBStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	expires := time.Now().Add(ttl)
+
+	return b.updateDataEncryptingKey(ctx, id, "SET expires = :expires, #ttl = :ttl", map[string]types.AttributeValue{
+		":expires": &types.AttributeValueMemberS{Value: formatTime(expires)},
+		":ttl": &types.AttributeValueMemberN{Value: fmt.Sprint(expires.Unix())},
+	})
+}
+
+func (b *dynamoDBStorageBackend) UnexpireDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	return b.updateDataEncryptingKey(ctx, id, "REMOVE expires, #ttl", nil)
+}
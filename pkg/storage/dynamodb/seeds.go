@@ -0,0 +1,349 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type seed struct {
+	PK string `dynamodbav:"pk"`
+	SK string `dynamodbav:"sk"`
+	Account_ interfaces.ID `dynamodbav:"account"`
+	DataEncryptingKey_ interfaces.ID `dynamodbav:"dataEncryptingKey"`
+	EncryptedSeed_ []byte `dynamodbav:"encryptedSeed"`
+	NextIndex_ int64 `dynamodbav:"nextIndex"`
+	Created_ time.Time `dynamodbav:"created"`
+	Updated_ time.Time `dynamodbav:"updated"`
+}
+
+var _ interfaces.Seed = &seed{}
+
+func seedPK(account interfaces.ID) string {
+	return "seed#" + account
+}
+
+func seedItemKey(account interfaces.ID) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: seedPK(account)},
+		"sk": &types.AttributeValueMemberS{Value: "seed"},
+	}
+}
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func decodeSeed(item map[string]types.AttributeValue) (*seed, error) {
+	var s seed
+	if err := attributevalue.UnmarshalMap(item, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func seedNotFound(account interfaces.ID) error {
+	return notFoundError("seed not found for account %s", account)
+}
+
+// refCountUpdate adds delta to the reference count of a data encrypting key,
+// requiring the key to exist when exists is set.
+func (b *dynamoDBStorageBackend) refCountUpdate(id interfaces.ID, delta string, exists bool) types.TransactWriteItem {
+	update := &types.Update{
+		TableName: aws.String(b.table),
+		Key: keyItemKey(id),
+		UpdateExpression: aws.String("ADD refCount :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: delta},
+		},
+	}
+	if exists {
+		update.ConditionExpression = aws.String("attribute_exists(pk)")
+	}
+	return types.TransactWriteItem{Update: update}
+}
+
+// CreateSeed stores the seed and counts it against its data encrypting key in
+// a single transaction, in the same way as CreateWallet.
+func (b *dynamoDBStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	s := seed{
+		PK: seedPK(account),
+		SK: "seed",
+		Account_: account,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedSeed_: encryptedSeed,
+		NextIndex_: 0,
+		Created_: now,
+		Updated_: now,
+	}
+
+	item, err := attributevalue.MarshalMap(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := b.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName: aws.String(b.table),
+					Item: item,
+					ConditionExpression: aws.String("attribute_not_exists(pk)"),
+				},
+			},
+			b.refCountUpdate(dataEncryptingKey, "1", true),
+		},
+	}); err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) == 2 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed already exists for account %s", account))
+			} else if aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, notFoundError("data encrypting key %s not found", dataEncryptingKey)
+			}
+		}
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) getSeedItem(ctx context.Context, account interfaces.ID) (*seed, error) {
+	if out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.table),
+		Key: seedItemKey(account),
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return nil, err
+	} else if out.Item == nil {
+		return nil, nil
+	} else {
+		return decodeSeed(out.Item)
+	}
+}
+
+// PutSeed replaces the seed of the account, moving the key reference count
+// when the data encrypting key changes.
+func (b *dynamoDBStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	s := seed{
+		PK: seedPK(src.Account()),
+		SK: "seed",
+		Account_: src.Account(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedSeed_: src.EncryptedSeed(),
+		NextIndex_: src.NextIndex(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+	}
+
+	item, err := attributevalue.MarshalMap(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := b.getSeedItem(ctx, s.Account_)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName: aws.String(b.table),
+				Item: item,
+			},
+		},
+	}
+
+	if existing == nil || existing.DataEncryptingKey_ != s.DataEncryptingKey_ {
+		items = append(items, b.refCountUpdate(s.DataEncryptingKey_, "1", true))
+
+		if existing != nil {
+			if _, err := b.GetDataEncryptingKey(ctx, existing.DataEncryptingKey_); err == nil {
+				items = append(items, b.refCountUpdate(existing.DataEncryptingKey_, "-1", false))
+			}
+		}
+	} else {
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName: aws.String(b.table),
+				Key: keyItemKey(s.DataEncryptingKey_),
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+			},
+		})
+	}
+
+	if _, err := b.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}); err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) >= 2 && aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+			return nil, notFoundError("data encrypting key %s not found", s.DataEncryptingKey_)
+		}
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	if s, err := b.getSeedItem(ctx, account); err != nil {
+		return nil, err
+	} else if s == nil {
+		return nil, seedNotFound(account)
+	} else {
+		return s, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) allSeedsScan() *dynamodb.ScanInput {
+	return &dynamodb.ScanInput{
+		TableName: aws.String(b.table),
+		FilterExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sk": &types.AttributeValueMemberS{Value: "seed"},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+}
+
+// ListAllSeeds scans the table, it is intended for migrations and key
+// rotation rather than serving requests.
+func (b *dynamoDBStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var r listSeedsResult
+
+	if total, err := b.scanCount(ctx, b.allSeedsScan()); err != nil {
+		return nil, err
+	} else if items, err := b.scan(ctx, b.allSeedsScan(), offset, count); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, item := range items {
+			if s, err := decodeSeed(item); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, s)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	if out, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: seedItemKey(account),
+		UpdateExpression: aws.String("ADD nextIndex :count SET updated = :updated"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":count": &types.AttributeValueMemberN{Value: fmt.Sprint(count)},
+			":updated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	}); isConditionalCheckFailed(err) {
+		return nil, seedNotFound(account)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return decodeSeed(out.Attributes)
+	}
+}
+
+// UpdateSeedDataEncryptingKey moves the seed and its key reference count to
+// another data encrypting key in a single transaction, conditional on the seed
+// still referencing from.
+func (b *dynamoDBStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	items := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				TableName: aws.String(b.table),
+				Key: seedItemKey(account),
+				UpdateExpression: aws.String("SET dataEncryptingKey = :to, encryptedSeed = :encryptedSeed, updated = :updated"),
+				ConditionExpression: aws.String("attribute_exists(pk) AND dataEncryptingKey = :from"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":from": &types.AttributeValueMemberS{Value: from},
+					":to": &types.AttributeValueMemberS{Value: to},
+					":encryptedSeed": &types.AttributeValueMemberB{Value: encryptedSeed},
+					":updated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+				},
+			},
+		},
+		b.refCountUpdate(to, "1", true),
+	}
+
+	// a transaction can't update the same item twice, and the reference count
+	// is unchanged when the seed stays on its data encrypting key
+	if from == to {
+		items = items[:1]
+	} else if _, err := b.GetDataEncryptingKey(ctx, from); err == nil {
+		items = append(items, b.refCountUpdate(from, "-1", false))
+	}
+
+	if _, err := b.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}); err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) >= 2 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				if _, err := b.GetSeed(ctx, account); err != nil {
+					return nil, err
+				}
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed data encrypting key for account %s is no longer %s", account, from))
+			} else if aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, notFoundError("data encrypting key %s not found", to)
+			}
+		}
+		return nil, err
+	} else {
+		return b.GetSeed(ctx, account)
+	}
+}
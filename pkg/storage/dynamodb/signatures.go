@@ -0,0 +1,127 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type issuedSignature struct {
+	PK string `dynamodbav:"pk"`
+	SK string `dynamodbav:"sk"`
+	Account_ interfaces.ID `dynamodbav:"account"`
+	Wallet_ string `dynamodbav:"wallet"`
+	Sender_ string `dynamodbav:"sender"`
+	Uniq_ string `dynamodbav:"uniq"`
+	Signature_ []byte `dynamodbav:"signature"`
+	Created_ time.Time `dynamodbav:"created"`
+	Expires_ time.Time `dynamodbav:"expires"`
+	TTL int64 `dynamodbav:"ttl"`
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+func issuedSignaturePK(wallet common.Address, sender common.Address) string {
+	return "signature#" + wallet.Hex() + "#" + sender.Hex()
+}
+
+func issuedSignatureItemKey(wallet common.Address, sender common.Address, uniq common.Hash) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: issuedSignaturePK(wallet, sender)},
+		"sk": &types.AttributeValueMemberS{Value: uniq.Hex()},
+	}
+}
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return common.HexToAddress(s.Wallet_)
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return common.HexToAddress(s.Sender_)
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return common.HexToHash(s.Uniq_)
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+// CreateIssuedSignature puts the signature unless an unexpired one exists for
+// the same key. An expired item DynamoDB has not deleted yet is replaced.
+func (b *dynamoDBStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	s := issuedSignature{
+		PK: issuedSignaturePK(wallet, sender),
+		SK: uniq.Hex(),
+		Account_: account,
+		Wallet_: wallet.Hex(),
+		Sender_: sender.Hex(),
+		Uniq_: uniq.Hex(),
+		Signature_: signature,
+		Created_: now,
+		Expires_: now.Add(ttl),
+		TTL: now.Add(ttl).Unix(),
+	}
+
+	item, err := attributevalue.MarshalMap(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := b.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(b.table),
+		Item: item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR #ttl < :now"),
+		ExpressionAttributeNames: map[string]string{"#ttl": ttlAttribute},
+		ExpressionAttributeValues: notExpiredValues(now),
+	}); isConditionalCheckFailed(err) {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("signature already issued for uniq %s", uniq))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	var s issuedSignature
+
+	if out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.table),
+		Key: issuedSignatureItemKey(wallet, sender, uniq),
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return nil, err
+	} else if out.Item == nil {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else if err := attributevalue.UnmarshalMap(out.Item, &s); err != nil {
+		return nil, err
+	} else if s.Account_ != account || !s.Expires_.After(time.Now()) {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else {
+		return &s, nil
+	}
+}
@@ -0,0 +1,525 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+)
+
+type wallet struct {
+	PK string `dynamodbav:"pk"`
+	SK string `dynamodbav:"sk"`
+	GSI1PK string `dynamodbav:"gsi1pk"`
+	GSI1SK string `dynamodbav:"gsi1sk"`
+	ID_ interfaces.ID `dynamodbav:"id"`
+	Account_ interfaces.ID `dynamodbav:"account"`
+	Name_ string `dynamodbav:"name"`
+	Address_ string `dynamodbav:"address"`
+	DataEncryptingKey_ interfaces.ID `dynamodbav:"dataEncryptingKey"`
+	EncryptedPrivateKey_ []byte `dynamodbav:"encryptedPrivateKey"`
+	Permissions_ []string `dynamodbav:"permissions,omitempty"`
+	Created_ time.Time `dynamodbav:"created"`
+	Updated_ time.Time `dynamodbav:"updated"`
+	Expires_ *time.Time `dynamodbav:"expires,omitempty"`
+	TTL *int64 `dynamodbav:"ttl,omitempty"`
+}
+
+var _ interfaces.Wallet = &wallet{}
+
+func walletPK(address common.Address) string {
+	return "wallet#" + address.Hex()
+}
+
+func walletItemKey(address common.Address) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: walletPK(address)},
+		"sk": &types.AttributeValueMemberS{Value: "wallet"},
+	}
+}
+
+func accountPK(account interfaces.ID) string {
+	return "account#" + account
+}
+
+func (w *wallet) ID() interfaces.ID {
+	return w.ID_
+}
+
+func (w *wallet) Name() string {
+	return w.Name_
+}
+
+func (w *wallet) Account() interfaces.ID {
+	return w.Account_
+}
+
+func (w *wallet) Address() common.Address {
+	return common.HexToAddress(w.Address_)
+}
+
+func (w *wallet) DataEncryptingKey() interfaces.ID {
+	return w.DataEncryptingKey_
+}
+
+func (w *wallet) EncryptedPrivateKey() []byte {
+	return w.EncryptedPrivateKey_
+}
+
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
+func (w *wallet) Created() time.Time {
+	return w.Created_
+}
+
+func (w *wallet) Updated() time.Time {
+	return w.Updated_
+}
+
+func (w *wallet) Expires() *time.Time {
+	return w.Expires_
+}
+
+type listWalletsResult struct {
+	Count_ int64
+	Page_ []*wallet
+}
+
+var _ interfaces.ListWalletsResult = &listWalletsResult{}
+
+func (r *listWalletsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listWalletsResult) Page() []interfaces.Wallet {
+	out := make([]interfaces.Wallet, len(r.Page_))
+	for i, w := range r.Page_ {
+		out[i] = w
+	}
+	return out
+}
+
+func decodeWallet(item map[string]types.AttributeValue) (*wallet, error) {
+	var w wallet
+	if err := attributevalue.UnmarshalMap(item, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func walletNotFound(account interfaces.ID, address common.Address) error {
+	return notFoundError("wallet %s not found for account %s", address, account)
+}
+
+func (b *dynamoDBStorageBackend) CreateWallet(ctx context.Context, account interfaces.ID, name string, address common.Address, dataEncryptingKey interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	now := time.Now()
+	id := anonymize.NewIDWithPrefix("wlt")
+
+	w := wallet{
+		PK: walletPK(address),
+		SK: "wallet",
+		GSI1PK: accountPK(account),
+		GSI1SK: formatTime(now) + "#" + id,
+		ID_: id,
+		Account_: account,
+		Name_: name,
+		Address_: address.Hex(),
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedPrivateKey_: encryptedPrivateKey,
+		Created_: now,
+		Updated_: now,
+		Expires_: nil,
+	}
+
+	item, err := attributevalue.MarshalMap(&w)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := b.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName: aws.String(b.table),
+					Item: item,
+					ConditionExpression: aws.String("attribute_not_exists(pk)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(b.table),
+					Key: keyItemKey(dataEncryptingKey),
+					UpdateExpression: aws.String("ADD refCount :one"),
+					ConditionExpression: aws.String("attribute_exists(pk)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":one": &types.AttributeValueMemberN{Value: "1"},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) == 2 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+			} else if aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, notFoundError("data encrypting key %s not found", dataEncryptingKey)
+			}
+		}
+		return nil, err
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) getWalletItem(ctx context.Context, address common.Address) (*wallet, error) {
+	if out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.table),
+		Key: walletItemKey(address),
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return nil, err
+	} else if out.Item == nil {
+		return nil, nil
+	} else {
+		return decodeWallet(out.Item)
+	}
+}
+
+// PutWallet replaces the wallet stored at the address of src, moving the key
+// reference count when the data encrypting key changes. Wallets are keyed by
+// address, so a wallet is expected to keep its address between backends.
+func (b *dynamoDBStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	now := time.Now()
+	address := src.Address()
+
+	w := wallet{
+		PK: walletPK(address),
+		SK: "wallet",
+		GSI1PK: accountPK(src.Account()),
+		GSI1SK: formatTime(src.Created()) + "#" + src.ID(),
+		ID_: src.ID(),
+		Account_: src.Account(),
+		Name_: src.Name(),
+		Address_: address.Hex(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedPrivateKey_: src.EncryptedPrivateKey(),
+		Permissions_: src.Permissions(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+		Expires_: src.Expires(),
+	}
+	if w.Expires_ != nil {
+		ttl := w.Expires_.Unix()
+		w.TTL = &ttl
+	}
+
+	item, err := attributevalue.MarshalMap(&w)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := b.getWalletItem(ctx, address)
+	if err != nil {
+		return nil, err
+	} else if existing != nil && existing.ID_ != w.ID_ && !expired(existing.TTL, now) {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+	}
+
+	put := &types.Put{
+		TableName: aws.String(b.table),
+		Item: item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	}
+	if existing != nil {
+		put.ConditionExpression = aws.String("#id = :id")
+		put.ExpressionAttributeNames = map[string]string{"#id": "id"}
+		put.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: existing.ID_},
+		}
+	}
+
+	items := []types.TransactWriteItem{{Put: put}}
+
+	if existing == nil || existing.DataEncryptingKey_ != w.DataEncryptingKey_ {
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(b.table),
+				Key: keyItemKey(w.DataEncryptingKey_),
+				UpdateExpression: aws.String("ADD refCount :one"),
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			},
+		})
+
+		if existing != nil {
+			if _, err := b.GetDataEncryptingKey(ctx, existing.DataEncryptingKey_); err == nil {
+				items = append(items, types.TransactWriteItem{
+					Update: &types.Update{
+						TableName: aws.String(b.table),
+						Key: keyItemKey(existing.DataEncryptingKey_),
+						UpdateExpression: aws.String("ADD refCount :one"),
+						ExpressionAttributeValues: map[string]types.AttributeValue{
+							":one": &types.AttributeValueMemberN{Value: "-1"},
+						},
+					},
+				})
+			}
+		}
+	} else {
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName: aws.String(b.table),
+				Key: keyItemKey(w.DataEncryptingKey_),
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+			},
+		})
+	}
+
+	if _, err := b.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}); err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) >= 2 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+			} else if aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, notFoundError("data encrypting key %s not found", w.DataEncryptingKey_)
+			}
+		}
+		return nil, err
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	if out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.table),
+		Key: walletItemKey(address),
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return nil, err
+	} else if out.Item == nil {
+		return nil, walletNotFound(account, address)
+	} else if w, err := decodeWallet(out.Item); err != nil {
+		return nil, err
+	} else if w.Account_ != account || expired(w.TTL, time.Now()) {
+		return nil, walletNotFound(account, address)
+	} else {
+		return w, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) walletsQuery(account interfaces.ID, now time.Time) *dynamodb.QueryInput {
+	values := notExpiredValues(now)
+	values[":pk"] = &types.AttributeValueMemberS{Value: accountPK(account)}
+
+	return &dynamodb.QueryInput{
+		TableName: aws.String(b.table),
+		IndexName: aws.String(accountIndex),
+		KeyConditionExpression: aws.String("gsi1pk = :pk"),
+		FilterExpression: aws.String(notExpiredFilter),
+		ExpressionAttributeNames: map[string]string{"#ttl": ttlAttribute},
+		ExpressionAttributeValues: values,
+	}
+}
+
+func (b *dynamoDBStorageBackend) ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	now := time.Now()
+
+	if total, err := b.count(ctx, b.walletsQuery(account, now)); err != nil {
+		return nil, err
+	} else if items, err := b.query(ctx, b.walletsQuery(account, now), offset, count); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, item := range items {
+			if w, err := decodeWallet(item); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, w)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) allWalletsScan(now time.Time) *dynamodb.ScanInput {
+	values := notExpiredValues(now)
+	values[":sk"] = &types.AttributeValueMemberS{Value: "wallet"}
+
+	return &dynamodb.ScanInput{
+		TableName: aws.String(b.table),
+		FilterExpression: aws.String("sk = :sk AND " + notExpiredFilter),
+		ExpressionAttributeNames: map[string]string{"#ttl": ttlAttribute},
+		ExpressionAttributeValues: values,
+		ConsistentRead: aws.Bool(true),
+	}
+}
+
+// ListAllWallets scans the table, it is intended for migrations rather than
+// serving requests. The scan resumes from the key of the wallet at after,
+// which DynamoDB accepts whether or not the wallet still exists.
+func (b *dynamoDBStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	now := time.Now()
+
+	input := b.allWalletsScan(now)
+	if after != (common.Address{}) {
+		input.ExclusiveStartKey = walletItemKey(after)
+	}
+
+	if total, err := b.scanCount(ctx, b.allWalletsScan(now)); err != nil {
+		return nil, err
+	} else if items, err := b.scan(ctx, input, 0, count); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, item := range items {
+			if w, err := decodeWallet(item); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, w)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *dynamoDBStorageBackend) updateWallet(ctx context.Context, account interfaces.ID, address common.Address, update string, names map[string]string, values map[string]types.AttributeValue) (interfaces.Wallet, error) {
+	values[":account"] = &types.AttributeValueMemberS{Value: account}
+	values[":updated"] = &types.AttributeValueMemberS{Value: formatTime(time.Now())}
+
+	if out, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(b.table),
+		Key: walletItemKey(address),
+		UpdateExpression: aws.String(update),
+		ConditionExpression: aws.String("attribute_exists(pk) AND account = :account"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: values,
+		ReturnValues: types.ReturnValueAllNew,
+	}); isConditionalCheckFailed(err) {
+		return nil, walletNotFound(account, address)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return decodeWallet(out.Attributes)
+	}
+}
+
+func (b *dynamoDBStorageBackend) UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (interfaces.Wallet, error) {
+	return b.updateWallet(ctx, account, address, "SET #name = :name, updated = :updated", map[string]string{"#name": "name"}, map[string]types.AttributeValue{
+		":name": &types.AttributeValueMemberS{Value: name},
+	})
+}
+
+func (b *dynamoDBStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	// empty lists are removed, as they are omitted when a wallet is put
+	if len(permissions) == 0 {
+		return b.updateWallet(ctx, account, address, "SET updated = :updated REMOVE #permissions", map[string]string{"#permissions": "permissions"}, map[string]types.AttributeValue{})
+	} else if value, err := attributevalue.Marshal(permissions); err != nil {
+		return nil, err
+	} else {
+		return b.updateWallet(ctx, account, address, "SET #permissions = :permissions, updated = :updated", map[string]string{"#permissions": "permissions"}, map[string]types.AttributeValue{
+			":permissions": value,
+		})
+	}
+}
+
+// UpdateWalletDataEncryptingKey moves the wallet and its key reference count to
+// another data encrypting key in a single transaction, conditional on the
+// wallet still referencing from.
+func (b *dynamoDBStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	items := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				TableName: aws.String(b.table),
+				Key: walletItemKey(address),
+				UpdateExpression: aws.String("SET dataEncryptingKey = :to, encryptedPrivateKey = :encryptedPrivateKey, updated = :updated"),
+				ConditionExpression: aws.String("attribute_exists(pk) AND account = :account AND dataEncryptingKey = :from"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":account": &types.AttributeValueMemberS{Value: account},
+					":from": &types.AttributeValueMemberS{Value: from},
+					":to": &types.AttributeValueMemberS{Value: to},
+					":encryptedPrivateKey": &types.AttributeValueMemberB{Value: encryptedPrivateKey},
+					":updated": &types.AttributeValueMemberS{Value: formatTime(time.Now())},
+				},
+			},
+		},
+		{
+			Update: &types.Update{
+				TableName: aws.String(b.table),
+				Key: keyItemKey(to),
+				UpdateExpression: aws.String("ADD refCount :one"),
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			},
+		},
+	}
+
+	// a transaction can't update the same item twice, and the reference count
+	// is unchanged when the wallet stays on its data encrypting key
+	if from == to {
+		items = items[:1]
+	} else if _, err := b.GetDataEncryptingKey(ctx, from); err == nil {
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(b.table),
+				Key: keyItemKey(from),
+				UpdateExpression: aws.String("ADD refCount :one"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "-1"},
+				},
+			},
+		})
+	}
+
+	if _, err := b.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}); err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) >= 2 {
+			if aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				if _, err := b.GetWallet(ctx, account, address); err != nil {
+					return nil, err
+				}
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s data encrypting key is no longer %s", address, from))
+			} else if aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, notFoundError("data encrypting key %s not found", to)
+			}
+		}
+		return nil, err
+	} else {
+		return b.GetWallet(ctx, account, address)
+	}
+}
+
+func (b *dynamoDBStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
+	expires := time.Now().Add(ttl)
+
+	return b.updateWallet(ctx, account, address, "SET expires = :expires, #ttl = :ttl, updated = :updated", map[string]string{"#ttl": ttlAttribute}, map[string]types.AttributeValue{
+		":expires": &types.AttributeValueMemberS{Value: formatTime(expires)},
+		":ttl": &types.AttributeValueMemberN{Value: fmt.Sprint(expires.Unix())},
+	})
+}
+
+func (b *dynamoDBStorageBackend) UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return b.updateWallet(ctx, account, address, "SET updated = :updated REMOVE expires, #ttl", map[string]string{"#ttl": ttlAttribute}, map[string]types.AttributeValue{})
+}
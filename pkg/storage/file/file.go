@@ -0,0 +1,276 @@
+package file
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	keysBucket = []byte("keys")
+	refCountsBucket = []byte("keys.refcount")
+	walletsBucket = []byte("wallets")
+	addressesBucket = []byte("wallets.address")
+	accountsBucket = []byte("wallets.account")
+	seedsBucket = []byte("seeds")
+	signaturesBucket = []byte("signatures")
+)
+
+type fileStorageBackend struct {
+	db *bolt.DB
+	vault interfaces.IVaultService
+}
+
+var _ interfaces.IStorageBackend = &fileStorageBackend{}
+
+func durationFromEnv(name string, d time.Duration) (time.Duration, error) {
+	if s := strings.TrimSpace(os.Getenv(name)); s == "" {
+		return d, nil
+	} else if d, err := time.ParseDuration(s); err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	} else {
+		return d, nil
+	}
+}
+
+func NewFileStorageBackend(vault interfaces.IVaultService) (interfaces.IStorageBackend, error) {
+	b := &fileStorageBackend{vault: vault}
+
+	path := strings.TrimSpace(os.Getenv("VAULT_FILE_PATH"))
+	if path == "" {
+		path = "vault.db"
+	}
+
+	sweepInterval, err := durationFromEnv("VAULT_FILE_SWEEP_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotInterval, err := durationFromEnv("VAULT_FILE_SNAPSHOT_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second}); err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", path, err)
+	} else {
+		b.db = db
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{keysBucket, refCountsBucket, walletsBucket, addressesBucket, accountsBucket, seedsBucket, signaturesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	go b.sweepLoop(sweepInterval)
+
+	if dir := strings.TrimSpace(os.Getenv("VAULT_FILE_SNAPSHOT_DIR")); dir != "" {
+		go b.snapshotLoop(dir, snapshotInterval)
+	}
+
+	return b, nil
+}
+
+// Snapshot writes a consistent copy of the database to w while the vault
+// continues to serve requests.
+func (b *fileStorageBackend) Snapshot(w io.Writer) (int64, error) {
+	var n int64
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+// SnapshotToFile writes a snapshot next to path and renames it into place once
+// it has been synced, so an interrupted snapshot never replaces a good one.
+func (b *fileStorageBackend) SnapshotToFile(path string) error {
+	tmp := path + ".tmp"
+
+	if f, err := os.OpenFile(tmp, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, 0600); err != nil {
+		return err
+	} else if _, err := b.Snapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	} else if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	} else if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	} else {
+		return os.Rename(tmp, path)
+	}
+}
+
+func (b *fileStorageBackend) snapshotLoop(dir string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Warnf("unable to create snapshot directory %s: %v", dir, err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		path := filepath.Join(dir, fmt.Sprintf("vault-%s.db", time.Now().UTC().Format("20060102T150405Z")))
+		if err := b.SnapshotToFile(path); err != nil {
+			log.Warnf("unable to write snapshot %s: %v", path, err)
+		}
+	}
+}
+
+// Sweep deletes expired wallets, keys and issued signatures, matching the TTL
+// indexes of the mongo backend. Reads already ignore expired records.
+func (b *fileStorageBackend) Sweep(ctx context.Context) error {
+	now := time.Now()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var expiredWallets []*wallet
+		var expiredKeys [][]byte
+		var expiredSignatures [][]byte
+
+		if err := tx.Bucket(walletsBucket).ForEach(func(k, v []byte) error {
+			if w, err := decodeWallet(v); err != nil {
+				return err
+			} else if expired(w.Expires_, now) {
+				expiredWallets = append(expiredWallets, w)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, w := range expiredWallets {
+			if err := b.deleteWallet(tx, w); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(keysBucket).ForEach(func(k, v []byte) error {
+			if dek, err := b.decodeDataEncryptingKey(v); err != nil {
+				return err
+			} else if expired(dek.Expires_, now) {
+				expiredKeys = append(expiredKeys, append([]byte{}, k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range expiredKeys {
+			if err := tx.Bucket(keysBucket).Delete(k); err != nil {
+				return err
+			} else if err := tx.Bucket(refCountsBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(signaturesBucket).ForEach(func(k, v []byte) error {
+			if s, err := decodeIssuedSignature(v); err != nil {
+				return err
+			} else if !s.Expires_.After(now) {
+				expiredSignatures = append(expiredSignatures, append([]byte{}, k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range expiredSignatures {
+			if err := tx.Bucket(signaturesBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *fileStorageBackend) sweepLoop(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := b.Sweep(context.Background()); err != nil {
+			log.Warnf("unable to sweep expired records: %v", err)
+		}
+	}
+}
+
+func expired(expires *time.Time, now time.Time) bool {
+	return expires != nil && !expires.After(now)
+}
+
+func refCount(tx *bolt.Tx, id interfaces.ID) int64 {
+	if v := tx.Bucket(refCountsBucket).Get([]byte(id)); len(v) == 8 {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func addRefCount(tx *bolt.Tx, id interfaces.ID, delta int64) error {
+	var v [8]byte
+	count := refCount(tx, id) + delta
+	if count < 0 {
+		count = 0
+	}
+	binary.BigEndian.PutUint64(v[:], uint64(count))
+	return tx.Bucket(refCountsBucket).Put([]byte(id), v[:])
+}
+
+func put(tx *bolt.Tx, bucket []byte, key string, v any) error {
+	if b, err := json.Marshal(v); err != nil {
+		return err
+	} else {
+		return tx.Bucket(bucket).Put([]byte(key), b)
+	}
+}
+
+func notFoundError(format string, args ...any) error {
+	return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf(format, args...))
+}
+
+// page returns the bounds of a page within total items, where a count of zero
+// returns every item from offset.
+func page(total int, offset int64, count int64) (int, int) {
+	start := int(offset)
+	if start > total {
+		start = total
+	}
+	end := total
+	if count > 0 && start + int(count) < total {
+		end = start + int(count)
+	}
+	return start, end
+}
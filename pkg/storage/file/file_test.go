@@ -0,0 +1,117 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/storagetest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestBackend opens a backend on a database in a temporary directory. The
+// sweep loop is disabled, tests call Sweep directly.
+func newTestBackend(t *testing.T, vault interfaces.IVaultService, path string) *fileStorageBackend {
+	t.Setenv("VAULT_FILE_PATH", path)
+	t.Setenv("VAULT_FILE_SWEEP_INTERVAL", "0")
+
+	backend, err := NewFileStorageBackend(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := backend.(*fileStorageBackend)
+	t.Cleanup(func() {
+		b.db.Close()
+	})
+	return b
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+		return newTestBackend(t, vault, filepath.Join(t.TempDir(), "vault.db"))
+	})
+}
+
+func count(t *testing.T, b *fileStorageBackend, bucket []byte) int {
+	t.Helper()
+
+	var n int
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSweep(t *testing.T) {
+	b := newTestBackend(t, nil, filepath.Join(t.TempDir(), "vault.db"))
+	ctx := context.Background()
+	account := "acct-sweep"
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, account, "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, account, "kept", common.HexToAddress("0x000000000000000000000000000000000000dEaD"), k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	} else if _, err := b.ExpireWallet(ctx, account, address, -time.Second); err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateIssuedSignature(ctx, account, address, address, common.Hash{}, []byte("{}"), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Sweep(ctx); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+
+	if n := count(t, b, walletsBucket); n != 1 {
+		t.Fatalf("expected 1 wallet after sweep, got %d", n)
+	} else if n := count(t, b, addressesBucket); n != 1 {
+		t.Fatalf("expected 1 address index entry after sweep, got %d", n)
+	} else if n := count(t, b, accountsBucket); n != 1 {
+		t.Fatalf("expected 1 account index entry after sweep, got %d", n)
+	} else if n := count(t, b, signaturesBucket); n != 0 {
+		t.Fatalf("expected no issued signatures after sweep, got %d", n)
+	} else if n, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("expected ref count 1 after sweep, got %d", n)
+	}
+}
+
+func TestSnapshotToFile(t *testing.T) {
+	dir := t.TempDir()
+	b := newTestBackend(t, nil, filepath.Join(dir, "vault.db"))
+	ctx := context.Background()
+	account := "acct-snapshot"
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, account, "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "snapshot.db")
+	if err := b.SnapshotToFile(path); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	snapshot := newTestBackend(t, nil, path)
+	if w, err := snapshot.GetWallet(ctx, account, address); err != nil {
+		t.Fatalf("get wallet from snapshot: %v", err)
+	} else if w.DataEncryptingKey() != k.ID() {
+		t.Fatalf("expected data encrypting key %s, got %s", k.ID(), w.DataEncryptingKey())
+	} else if _, err := snapshot.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatalf("get data encrypting key from snapshot: %v", err)
+	}
+}
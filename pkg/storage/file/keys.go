@@ -0,0 +1,271 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	bolt "go.etcd.io/bbolt"
+)
+
+type dataEncryptingKey struct {
+	backend *fileStorageBackend
+	ID_ interfaces.ID `json:"id"`
+	KeyEncryptingKey_ interfaces.ID `json:"keyEncryptingKey"`
+	EncryptedKey_ []byte `json:"encryptedKey"`
+	Expires_ *time.Time `json:"expires,omitempty"`
+}
+
+var _ interfaces.DataEncryptingKey = &dataEncryptingKey{}
+
+func (k *dataEncryptingKey) ID() interfaces.ID {
+	return k.ID_
+}
+
+func (k *dataEncryptingKey) KeyEncryptingKey() interfaces.ID {
+	return k.KeyEncryptingKey_
+}
+
+func (k *dataEncryptingKey) EncryptedKey() []byte {
+	return k.EncryptedKey_
+}
+
+func (k *dataEncryptingKey) Expires() *time.Time {
+	return k.Expires_
+}
+
+func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
+	var count int64
+
+	err := k.backend.db.View(func(tx *bolt.Tx) error {
+		count = refCount(tx, k.ID_)
+		return nil
+	})
+
+	return count, err
+}
+
+type listDataEncryptingKeysResult struct {
+	Count_ int64
+	Page_ []*dataEncryptingKey
+}
+
+var _ interfaces.ListDataEncryptingKeysResult = &listDataEncryptingKeysResult{}
+
+func (r *listDataEncryptingKeysResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listDataEncryptingKeysResult) Page() []interfaces.DataEncryptingKey {
+	out := make([]interfaces.DataEncryptingKey, len(r.Page_))
+	for i, k := range r.Page_ {
+		out[i] = k
+	}
+	return out
+}
+
+func (b *fileStorageBackend) decodeDataEncryptingKey(v []byte) (*dataEncryptingKey, error) {
+	var k dataEncryptingKey
+	if err := json.Unmarshal(v, &k); err != nil {
+		return nil, err
+	}
+	k.backend = b
+	return &k, nil
+}
+
+func (b *fileStorageBackend) getDataEncryptingKey(tx *bolt.Tx, id interfaces.ID) (*dataEncryptingKey, error) {
+	if v := tx.Bucket(keysBucket).Get([]byte(id)); v == nil {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if k, err := b.decodeDataEncryptingKey(v); err != nil {
+		return nil, err
+	} else if expired(k.Expires_, time.Now()) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else {
+		return k, nil
+	}
+}
+
+// activeDataEncryptingKeys returns keys which have not expired, in id order.
+func (b *fileStorageBackend) activeDataEncryptingKeys(tx *bolt.Tx) ([]*dataEncryptingKey, error) {
+	var out []*dataEncryptingKey
+	now := time.Now()
+
+	err := tx.Bucket(keysBucket).ForEach(func(_, v []byte) error {
+		if k, err := b.decodeDataEncryptingKey(v); err != nil {
+			return err
+		} else if !expired(k.Expires_, now) {
+			out = append(out, k)
+		}
+		return nil
+	})
+
+	return out, err
+}
+
+func (b *fileStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (interfaces.DataEncryptingKey, error) {
+	var candidates []*dataEncryptingKey
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		if keys, err := b.activeDataEncryptingKeys(tx); err != nil {
+			return err
+		} else {
+			for _, k := range keys {
+				// keys scheduled to expire are not handed out to new wallets
+				if k.Expires_ == nil && refCount(tx, k.ID_) < maxRefCount {
+					candidates = append(candidates, k)
+				}
+			}
+			return nil
+		}
+	}); err != nil {
+		return nil, err
+	} else if len(candidates) == 0 {
+		return b.vault.CreateDataEncryptingKey(ctx)
+	} else {
+		return candidates[rand.Intn(len(candidates))], nil
+	}
+}
+
+func (b *fileStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: id,
+		KeyEncryptingKey_: keyEncryptingKey,
+		EncryptedKey_: encryptedKey,
+		Expires_: nil,
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx, keysBucket, k.ID_, &k); err != nil {
+			return err
+		}
+		return addRefCount(tx, k.ID_, 0)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (b *fileStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: src.ID(),
+		KeyEncryptingKey_: src.KeyEncryptingKey(),
+		EncryptedKey_: src.EncryptedKey(),
+		Expires_: src.Expires(),
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx, keysBucket, k.ID_, &k); err != nil {
+			return err
+		}
+		return addRefCount(tx, k.ID_, 0)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (b *fileStorageBackend) GetDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	var k *dataEncryptingKey
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		k, err = b.getDataEncryptingKey(tx, id)
+		return err
+	}); err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+// ListDataEncryptingKeys lists data encrypting keys in id order.
+func (b *fileStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	var r listDataEncryptingKeysResult
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		if keys, err := b.activeDataEncryptingKeys(tx); err != nil {
+			return err
+		} else {
+			slices.SortFunc(keys, func(a *dataEncryptingKey, b *dataEncryptingKey) int {
+				return strings.Compare(a.ID_, b.ID_)
+			})
+
+			offset, found := slices.BinarySearchFunc(keys, after, func(k *dataEncryptingKey, id interfaces.ID) int {
+				return strings.Compare(k.ID_, id)
+			})
+			if found {
+				offset++
+			}
+
+			start, end := page(len(keys), int64(offset), count)
+			r.Count_ = int64(len(keys))
+			r.Page_ = keys[start:end]
+			return nil
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return &r, nil
+	}
+}
+
+func (b *fileStorageBackend) updateDataEncryptingKey(id interfaces.ID, update func(k *dataEncryptingKey)) (interfaces.DataEncryptingKey, error) {
+	var k *dataEncryptingKey
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if k, err = b.getDataEncryptingKey(tx, id); err != nil {
+			return err
+		}
+		update(k)
+		return put(tx, keysBucket, k.ID_, k)
+	}); err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+func (b *fileStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	var k *dataEncryptingKey
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if k, err = b.getDataEncryptingKey(tx, id); err != nil {
+			return err
+		} else if k.KeyEncryptingKey_ != from {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("data encrypting key %s is no longer wrapped by %s", id, from))
+		}
+		k.KeyEncryptingKey_ = keyEncryptingKey
+		k.EncryptedKey_ = encryptedKey
+		return put(tx, keysBucket, k.ID_, k)
+	}); err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+func (b *fileStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	expires := time.Now().Add(ttl)
+
+	return b.updateDataEncryptingKey(id, func(k *dataEncryptingKey) {
+		k.Expires_ = &expires
+	})
+}
+
+func (b *fileStorageBackend) UnexpireDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	return b.updateDataEncryptingKey(id, func(k *dataEncryptingKey) {
+		k.Expires_ = nil
+	})
+}
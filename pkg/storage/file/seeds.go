@@ -0,0 +1,226 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	bolt "go.etcd.io/bbolt"
+)
+
+type seed struct {
+	Account_ interfaces.ID `json:"account"`
+	DataEncryptingKey_ interfaces.ID `json:"dataEncryptingKey"`
+	EncryptedSeed_ []byte `json:"encryptedSeed"`
+	NextIndex_ int64 `json:"nextIndex"`
+	Created_ time.Time `json:"created"`
+	Updated_ time.Time `json:"updated"`
+}
+
+var _ interfaces.Seed = &seed{}
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func decodeSeed(v []byte) (*seed, error) {
+	var s seed
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (b *fileStorageBackend) getSeed(tx *bolt.Tx, account interfaces.ID) (*seed, error) {
+	if v := tx.Bucket(seedsBucket).Get([]byte(account)); v == nil {
+		return nil, notFoundError("seed not found for account %s", account)
+	} else {
+		return decodeSeed(v)
+	}
+}
+
+// putSeed writes s, replacing the seed of its account and moving the ref count
+// to its data encrypting key.
+func (b *fileStorageBackend) putSeed(tx *bolt.Tx, s *seed) error {
+	if _, err := b.getDataEncryptingKey(tx, s.DataEncryptingKey_); err != nil {
+		return err
+	}
+
+	if v := tx.Bucket(seedsBucket).Get([]byte(s.Account_)); v != nil {
+		if old, err := decodeSeed(v); err != nil {
+			return err
+		} else if err := addRefCount(tx, old.DataEncryptingKey_, -1); err != nil {
+			return err
+		}
+	}
+
+	if err := put(tx, seedsBucket, s.Account_, s); err != nil {
+		return err
+	} else {
+		return addRefCount(tx, s.DataEncryptingKey_, 1)
+	}
+}
+
+func (b *fileStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	s := seed{
+		Account_: account,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedSeed_: encryptedSeed,
+		NextIndex_: 0,
+		Created_: now,
+		Updated_: now,
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(seedsBucket).Get([]byte(account)); v != nil {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed already exists for account %s", account))
+		}
+		return b.putSeed(tx, &s)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *fileStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	s := seed{
+		Account_: src.Account(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedSeed_: src.EncryptedSeed(),
+		NextIndex_: src.NextIndex(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return b.putSeed(tx, &s)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *fileStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	var s *seed
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		s, err = b.getSeed(tx, account)
+		return err
+	}); err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+// ListAllSeeds lists seeds in account order.
+func (b *fileStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var r listSeedsResult
+	var seeds []*seed
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(seedsBucket).ForEach(func(_, v []byte) error {
+			if s, err := decodeSeed(v); err != nil {
+				return err
+			} else {
+				seeds = append(seeds, s)
+				return nil
+			}
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	start, end := page(len(seeds), offset, count)
+	r.Count_ = int64(len(seeds))
+	r.Page_ = seeds[start:end]
+
+	return &r, nil
+}
+
+func (b *fileStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	var s *seed
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if s, err = b.getSeed(tx, account); err != nil {
+			return err
+		}
+		s.NextIndex_ += count
+		s.Updated_ = time.Now()
+		return put(tx, seedsBucket, s.Account_, s)
+	}); err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+func (b *fileStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	var s *seed
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if s, err = b.getSeed(tx, account); err != nil {
+			return err
+		} else if s.DataEncryptingKey_ != from {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed data encrypting key for account %s is no longer %s", account, from))
+		}
+
+		s.DataEncryptingKey_ = to
+		s.EncryptedSeed_ = encryptedSeed
+		s.Updated_ = time.Now()
+		return b.putSeed(tx, s)
+	}); err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
@@ -0,0 +1,124 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	bolt "go.etcd.io/bbolt"
+)
+
+type issuedSignature struct {
+	Account_ interfaces.ID `json:"account"`
+	Wallet_ common.Address `json:"wallet"`
+	Sender_ common.Address `json:"sender"`
+	Uniq_ common.Hash `json:"uniq"`
+	Signature_ []byte `json:"signature"`
+	Created_ time.Time `json:"created"`
+	Expires_ time.Time `json:"expires"`
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return s.Wallet_
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return s.Sender_
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return s.Uniq_
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+func decodeIssuedSignature(v []byte) (*issuedSignature, error) {
+	var s issuedSignature
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func issuedSignatureKey(wallet common.Address, sender common.Address, uniq common.Hash) string {
+	return wallet.Hex() + ":" + sender.Hex() + ":" + uniq.Hex()
+}
+
+// getIssuedSignature returns the issued signature for key, or nil if there is
+// none or it has expired.
+func getIssuedSignature(tx *bolt.Tx, key string, now time.Time) (*issuedSignature, error) {
+	if v := tx.Bucket(signaturesBucket).Get([]byte(key)); v == nil {
+		return nil, nil
+	} else if s, err := decodeIssuedSignature(v); err != nil {
+		return nil, err
+	} else if !s.Expires_.After(now) {
+		return nil, nil
+	} else {
+		return s, nil
+	}
+}
+
+func (b *fileStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	s := issuedSignature{
+		Account_: account,
+		Wallet_: wallet,
+		Sender_: sender,
+		Uniq_: uniq,
+		Signature_: signature,
+		Created_: now,
+		Expires_: now.Add(ttl),
+	}
+	key := issuedSignatureKey(wallet, sender, uniq)
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		if existing, err := getIssuedSignature(tx, key, now); err != nil {
+			return err
+		} else if existing != nil {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("signature already issued for uniq %s", uniq))
+		} else {
+			return put(tx, signaturesBucket, key, &s)
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *fileStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	var s *issuedSignature
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		s, err = getIssuedSignature(tx, issuedSignatureKey(wallet, sender, uniq), time.Now())
+		return err
+	}); err != nil {
+		return nil, err
+	} else if s == nil || s.Account_ != account {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else {
+		return s, nil
+	}
+}
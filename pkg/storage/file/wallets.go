@@ -0,0 +1,388 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+	bolt "go.etcd.io/bbolt"
+)
+
+type wallet struct {
+	ID_ interfaces.ID `json:"id"`
+	Account_ interfaces.ID `json:"account"`
+	Name_ string `json:"name"`
+	Address_ common.Address `json:"address"`
+	DataEncryptingKey_ interfaces.ID `json:"dataEncryptingKey"`
+	EncryptedPrivateKey_ []byte `json:"encryptedPrivateKey"`
+	Permissions_ []string `json:"permissions,omitempty"`
+	Created_ time.Time `json:"created"`
+	Updated_ time.Time `json:"updated"`
+	Expires_ *time.Time `json:"expires,omitempty"`
+}
+
+var _ interfaces.Wallet = &wallet{}
+
+func (w *wallet) ID() interfaces.ID {
+	return w.ID_
+}
+
+func (w *wallet) Name() string {
+	return w.Name_
+}
+
+func (w *wallet) Account() interfaces.ID {
+	return w.Account_
+}
+
+func (w *wallet) Address() common.Address {
+	return w.Address_
+}
+
+func (w *wallet) DataEncryptingKey() interfaces.ID {
+	return w.DataEncryptingKey_
+}
+
+func (w *wallet) EncryptedPrivateKey() []byte {
+	return w.EncryptedPrivateKey_
+}
+
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
+func (w *wallet) Created() time.Time {
+	return w.Created_
+}
+
+func (w *wallet) Updated() time.Time {
+	return w.Updated_
+}
+
+func (w *wallet) Expires() *time.Time {
+	return w.Expires_
+}
+
+type listWalletsResult struct {
+	Count_ int64
+	Page_ []*wallet
+}
+
+var _ interfaces.ListWalletsResult = &listWalletsResult{}
+
+func (r *listWalletsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listWalletsResult) Page() []interfaces.Wallet {
+	out := make([]interfaces.Wallet, len(r.Page_))
+	for i, w := range r.Page_ {
+		out[i] = w
+	}
+	return out
+}
+
+func decodeWallet(v []byte) (*wallet, error) {
+	var w wallet
+	if err := json.Unmarshal(v, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func walletNotFound(account interfaces.ID, address common.Address) error {
+	return notFoundError("wallet %s not found for account %s", address, account)
+}
+
+// Addresses are unique per account, keyed by account and address.
+func addressKey(account interfaces.ID, address common.Address) []byte {
+	return append(append([]byte(account), 0), address.Bytes()...)
+}
+
+func accountPrefix(account interfaces.ID) []byte {
+	return append([]byte(account), 0)
+}
+
+// Wallets are listed in creation order, keyed by account, creation time and id.
+func accountKey(w *wallet) []byte {
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(w.Created_.UnixNano()))
+	return append(append(accountPrefix(w.Account_), t[:]...), []byte(w.ID_)...)
+}
+
+func (b *fileStorageBackend) getWallet(tx *bolt.Tx, account interfaces.ID, address common.Address) (*wallet, error) {
+	if id := tx.Bucket(addressesBucket).Get(addressKey(account, address)); id == nil {
+		return nil, walletNotFound(account, address)
+	} else if v := tx.Bucket(walletsBucket).Get(id); v == nil {
+		return nil, walletNotFound(account, address)
+	} else if w, err := decodeWallet(v); err != nil {
+		return nil, err
+	} else if expired(w.Expires_, time.Now()) {
+		return nil, walletNotFound(account, address)
+	} else {
+		return w, nil
+	}
+}
+
+func (b *fileStorageBackend) deleteWallet(tx *bolt.Tx, w *wallet) error {
+	if err := tx.Bucket(walletsBucket).Delete([]byte(w.ID_)); err != nil {
+		return err
+	} else if err := tx.Bucket(addressesBucket).Delete(addressKey(w.Account_, w.Address_)); err != nil {
+		return err
+	} else if err := tx.Bucket(accountsBucket).Delete(accountKey(w)); err != nil {
+		return err
+	} else {
+		return addRefCount(tx, w.DataEncryptingKey_, -1)
+	}
+}
+
+// putWallet writes w and its indexes. An existing wallet with the same id is
+// replaced, while a different wallet at the same address is a conflict unless
+// it has expired.
+func (b *fileStorageBackend) putWallet(tx *bolt.Tx, w *wallet) error {
+	if _, err := b.getDataEncryptingKey(tx, w.DataEncryptingKey_); err != nil {
+		return err
+	} else if existing, err := b.getWallet(tx, w.Account_, w.Address_); err == nil && existing.ID_ != w.ID_ {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", existing.Address_))
+	} else if existing := tx.Bucket(addressesBucket).Get(addressKey(w.Account_, w.Address_)); existing != nil {
+		// an expired wallet which has not been swept yet, or w itself
+		if v := tx.Bucket(walletsBucket).Get(existing); v != nil {
+			if old, err := decodeWallet(v); err != nil {
+				return err
+			} else if err := b.deleteWallet(tx, old); err != nil {
+				return err
+			}
+		}
+	}
+
+	if v := tx.Bucket(walletsBucket).Get([]byte(w.ID_)); v != nil {
+		if old, err := decodeWallet(v); err != nil {
+			return err
+		} else if err := b.deleteWallet(tx, old); err != nil {
+			return err
+		}
+	}
+
+	if err := put(tx, walletsBucket, w.ID_, w); err != nil {
+		return err
+	} else if err := tx.Bucket(addressesBucket).Put(addressKey(w.Account_, w.Address_), []byte(w.ID_)); err != nil {
+		return err
+	} else if err := tx.Bucket(accountsBucket).Put(accountKey(w), nil); err != nil {
+		return err
+	} else {
+		return addRefCount(tx, w.DataEncryptingKey_, 1)
+	}
+}
+
+func (b *fileStorageBackend) CreateWallet(ctx context.Context, account interfaces.ID, name string, address common.Address, dataEncryptingKey interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	now := time.Now()
+
+	w := wallet{
+		ID_: anonymize.NewIDWithPrefix("wlt"),
+		Account_: account,
+		Name_: name,
+		Address_: address,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedPrivateKey_: encryptedPrivateKey,
+		Created_: now,
+		Updated_: now,
+		Expires_: nil,
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return b.putWallet(tx, &w)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *fileStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	w := wallet{
+		ID_: src.ID(),
+		Account_: src.Account(),
+		Name_: src.Name(),
+		Address_: src.Address(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedPrivateKey_: src.EncryptedPrivateKey(),
+		Permissions_: src.Permissions(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+		Expires_: src.Expires(),
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return b.putWallet(tx, &w)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *fileStorageBackend) GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	var w *wallet
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		w, err = b.getWallet(tx, account, address)
+		return err
+	}); err != nil {
+		return nil, err
+	} else {
+		return w, nil
+	}
+}
+
+func (b *fileStorageBackend) ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	var wallets []*wallet
+	now := time.Now()
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := accountPrefix(account)
+		c := tx.Bucket(accountsBucket).Cursor()
+
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := k[len(prefix) + 8:]
+			if v := tx.Bucket(walletsBucket).Get(id); v == nil {
+				continue
+			} else if w, err := decodeWallet(v); err != nil {
+				return err
+			} else if !expired(w.Expires_, now) {
+				wallets = append(wallets, w)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	start, end := page(len(wallets), offset, count)
+	r.Count_ = int64(len(wallets))
+	r.Page_ = wallets[start:end]
+
+	return &r, nil
+}
+
+// ListAllWallets lists wallets of every account in address order.
+func (b *fileStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	var wallets []*wallet
+	now := time.Now()
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(walletsBucket).ForEach(func(_, v []byte) error {
+			if w, err := decodeWallet(v); err != nil {
+				return err
+			} else if !expired(w.Expires_, now) {
+				wallets = append(wallets, w)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(wallets, func(a *wallet, b *wallet) int {
+		return a.Address_.Cmp(b.Address_)
+	})
+
+	offset, found := slices.BinarySearchFunc(wallets, after, func(w *wallet, address common.Address) int {
+		return w.Address_.Cmp(address)
+	})
+	if found {
+		offset++
+	}
+
+	start, end := page(len(wallets), int64(offset), count)
+	r.Count_ = int64(len(wallets))
+	r.Page_ = wallets[start:end]
+
+	return &r, nil
+}
+
+func (b *fileStorageBackend) updateWallet(account interfaces.ID, address common.Address, update func(w *wallet)) (interfaces.Wallet, error) {
+	var w *wallet
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if w, err = b.getWallet(tx, account, address); err != nil {
+			return err
+		}
+		update(w)
+		w.Updated_ = time.Now()
+		return put(tx, walletsBucket, w.ID_, w)
+	}); err != nil {
+		return nil, err
+	} else {
+		return w, nil
+	}
+}
+
+func (b *fileStorageBackend) UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (interfaces.Wallet, error) {
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Name_ = name
+	})
+}
+
+func (b *fileStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Permissions_ = permissions
+	})
+}
+
+func (b *fileStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	var w *wallet
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if _, err = b.getDataEncryptingKey(tx, to); err != nil {
+			return err
+		} else if w, err = b.getWallet(tx, account, address); err != nil {
+			return err
+		} else if w.DataEncryptingKey_ != from {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s data encrypting key is no longer %s", address, from))
+		}
+
+		w.DataEncryptingKey_ = to
+		w.EncryptedPrivateKey_ = encryptedPrivateKey
+		w.Updated_ = time.Now()
+
+		if err := put(tx, walletsBucket, w.ID_, w); err != nil {
+			return err
+		} else if err := addRefCount(tx, from, -1); err != nil {
+			return err
+		} else {
+			return addRefCount(tx, to, 1)
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return w, nil
+	}
+}
+
+func (b *fileStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
+	expires := time.Now().Add(ttl)
+
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Expires_ = &expires
+	})
+}
+
+func (b *fileStorageBackend) UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Expires_ = nil
+	})
+}
@@ -1,11 +1,94 @@
 package firebase
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
 
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func NewFirebaseStorageBackend() (interfaces.IStorageBackend, error) {
-	return nil, fmt.Errorf("not implemented")
-}
\ No newline at end of file
+// Firestore TTL policies are configured on the project rather than through the
+// client, so the "expires" field of each collection must be registered once
+// per database:
+//
+//	gcloud firestore fields ttls update expires --collection-group=wallets --enable-ttl
+//	gcloud firestore fields ttls update expires --collection-group=keys --enable-ttl
+//	gcloud firestore fields ttls update expires --collection-group=signatures --enable-ttl
+//
+// ListWallets also requires a composite index on wallets (account, created).
+type firebaseStorageBackend struct {
+	client *firestore.Client
+	vault interfaces.IVaultService
+}
+
+var _ interfaces.IStorageBackend = &firebaseStorageBackend{}
+
+func NewFirebaseStorageBackend(vault interfaces.IVaultService) (interfaces.IStorageBackend, error) {
+	b := &firebaseStorageBackend{vault: vault}
+
+	project := strings.TrimSpace(os.Getenv("VAULT_FIREBASE_PROJECT"))
+	if project == "" {
+		project = firestore.DetectProjectID
+	}
+
+	if client, err := firestore.NewClient(context.Background(), project); err != nil {
+		return nil, err
+	} else {
+		b.client = client
+	}
+
+	return b, nil
+}
+
+func (b *firebaseStorageBackend) keys() *firestore.CollectionRef {
+	return b.client.Collection("keys")
+}
+
+func (b *firebaseStorageBackend) wallets() *firestore.CollectionRef {
+	return b.client.Collection("wallets")
+}
+
+func (b *firebaseStorageBackend) seeds() *firestore.CollectionRef {
+	return b.client.Collection("seeds")
+}
+
+func (b *firebaseStorageBackend) signatures() *firestore.CollectionRef {
+	return b.client.Collection("signatures")
+}
+
+func (b *firebaseStorageBackend) count(ctx context.Context, q firestore.Query) (int64, error) {
+	if r, err := q.NewAggregationQuery().WithCount("count").Get(ctx); err != nil {
+		return 0, err
+	} else if v, ok := r["count"].(*firestorepb.Value); !ok {
+		return 0, fmt.Errorf("invalid count aggregation result")
+	} else {
+		return v.GetIntegerValue(), nil
+	}
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+func isAlreadyExists(err error) bool {
+	return status.Code(err) == codes.AlreadyExists
+}
+
+func notFoundError(format string, args ...any) error {
+	return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf(format, args...))
+}
+
+func page(q firestore.Query, offset int64, count int64) firestore.Query {
+	q = q.Offset(int(offset))
+	if count > 0 {
+		q = q.Limit(int(count))
+	}
+	return q
+}
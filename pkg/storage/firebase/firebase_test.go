@@ -0,0 +1,106 @@
+package firebase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/storagetest"
+)
+
+// newTestBackend returns a backend on the Firestore emulator with its own
+// project, which the emulator keeps apart, e.g.
+//
+//	gcloud emulators firestore start --host-port=localhost:8080
+//	FIRESTORE_EMULATOR_HOST=localhost:8080 go test ./pkg/storage/firebase
+func newTestBackend(t *testing.T, vault interfaces.IVaultService) *firebaseStorageBackend {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	project := make([]byte, 8)
+	rand.Read(project)
+	t.Setenv("VAULT_FIREBASE_PROJECT", "signchain-vault-test-" + hex.EncodeToString(project))
+
+	backend, err := NewFirebaseStorageBackend(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := backend.(*firebaseStorageBackend)
+	t.Cleanup(func() {
+		b.client.Close()
+	})
+
+	return b
+}
+
+// TestConformance uses a project for each subtest.
+func TestConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+		return newTestBackend(t, vault)
+	})
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var e *fiber.Error
+	if !errors.As(err, &e) || e.Code != status {
+		t.Fatalf("expected status %d, got %v", status, err)
+	}
+}
+
+func TestWallets(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackend(t, nil)
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60))
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = b.CreateWallet(ctx, "account", "wallet", common.HexToAddress("0x01"), "missing", make([]byte, 60))
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	_, err = b.GetWallet(ctx, "other", address)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if r, err := b.ListWallets(ctx, "account", 0, 10); err != nil {
+		t.Fatal(err)
+	} else if r.Count() != 1 || len(r.Page()) != 1 || r.Page()[0].Address() != address {
+		t.Fatalf("expected the wallet to be listed, got %d", r.Count())
+	}
+
+	if count, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expected ref count 1, got %d", count)
+	}
+
+	// expired wallets are hidden before the TTL policy deletes them
+	if _, err := b.ExpireWallet(ctx, "account", address, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = b.GetWallet(ctx, "account", address)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
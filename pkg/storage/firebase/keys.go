@@ -0,0 +1,257 @@
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"google.golang.org/api/iterator"
+)
+
+type dataEncryptingKey struct {
+	backend *firebaseStorageBackend
+	ID_ interfaces.ID `firestore:"id"`
+	KeyEncryptingKey_ interfaces.ID `firestore:"keyEncryptingKey"`
+	EncryptedKey_ []byte `firestore:"encryptedKey"`
+	RefCount_ int64 `firestore:"refCount"`
+	Expires_ *time.Time `firestore:"expires,omitempty"`
+}
+
+var _ interfaces.DataEncryptingKey = &dataEncryptingKey{}
+
+func (k *dataEncryptingKey) ID() interfaces.ID {
+	return k.ID_
+}
+
+func (k *dataEncryptingKey) KeyEncryptingKey() interfaces.ID {
+	return k.KeyEncryptingKey_
+}
+
+func (k *dataEncryptingKey) EncryptedKey() []byte {
+	return k.EncryptedKey_
+}
+
+func (k *dataEncryptingKey) Expires() *time.Time {
+	return k.Expires_
+}
+
+// RefCount counts the wallets and seeds referencing the key rather than
+// reading the refCount field, which is not decremented when wallets are
+// removed by the TTL policy.
+func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
+	if wallets, err := k.backend.count(ctx, k.backend.wallets().Where("dataEncryptingKey", "==", k.ID_)); err != nil {
+		return 0, err
+	} else if seeds, err := k.backend.count(ctx, k.backend.seeds().Where("dataEncryptingKey", "==", k.ID_)); err != nil {
+		return 0, err
+	} else {
+		return wallets + seeds, nil
+	}
+}
+
+type listDataEncryptingKeysResult struct {
+	Count_ int64
+	Page_ []*dataEncryptingKey
+}
+
+var _ interfaces.ListDataEncryptingKeysResult = &listDataEncryptingKeysResult{}
+
+func (r *listDataEncryptingKeysResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listDataEncryptingKeysResult) Page() []interfaces.DataEncryptingKey {
+	out := make([]interfaces.DataEncryptingKey, len(r.Page_))
+	for i, k := range r.Page_ {
+		out[i] = k
+	}
+	return out
+}
+
+func (b *firebaseStorageBackend) decodeDataEncryptingKey(s *firestore.DocumentSnapshot) (*dataEncryptingKey, error) {
+	var k dataEncryptingKey
+	if err := s.DataTo(&k); err != nil {
+		return nil, err
+	}
+	k.backend = b
+	return &k, nil
+}
+
+func (b *firebaseStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (interfaces.DataEncryptingKey, error) {
+	var k *dataEncryptingKey
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var candidates []*dataEncryptingKey
+
+		k = nil
+		it := tx.Documents(b.keys().Where("refCount", "<", maxRefCount).Limit(100))
+		defer it.Stop()
+
+		for {
+			if s, err := it.Next(); err == iterator.Done {
+				break
+			} else if err != nil {
+				return err
+			} else if c, err := b.decodeDataEncryptingKey(s); err != nil {
+				return err
+			} else if c.Expires_ == nil {
+				// keys scheduled to expire are not handed out to new wallets
+				candidates = append(candidates, c)
+			}
+		}
+
+		if len(candidates) > 0 {
+			k = candidates[rand.Intn(len(candidates))]
+		}
+		return nil
+	}, firestore.ReadOnly); err != nil {
+		return nil, err
+	} else if k == nil {
+		return b.vault.CreateDataEncryptingKey(ctx)
+	} else {
+		return k, nil
+	}
+}
+
+func (b *firebaseStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: id,
+		KeyEncryptingKey_: keyEncryptingKey,
+		EncryptedKey_: encryptedKey,
+		RefCount_: 0,
+		Expires_: nil,
+	}
+
+	if _, err := b.keys().Doc(k.ID_).Create(ctx, &k); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+// PutDataEncryptingKey keeps the refCount of an existing key, which is
+// maintained by PutWallet and CreateWallet.
+func (b *firebaseStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: src.ID(),
+		KeyEncryptingKey_: src.KeyEncryptingKey(),
+		EncryptedKey_: src.EncryptedKey(),
+		RefCount_: 0,
+		Expires_: src.Expires(),
+	}
+
+	var expires any = firestore.Delete
+	if k.Expires_ != nil {
+		expires = *k.Expires_
+	}
+
+	doc := b.keys().Doc(k.ID_)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(doc); isNotFound(err) {
+			return tx.Create(doc, &k)
+		} else if err != nil {
+			return err
+		} else {
+			return tx.Update(doc, []firestore.Update{
+				{Path: "keyEncryptingKey", Value: k.KeyEncryptingKey_},
+				{Path: "encryptedKey", Value: k.EncryptedKey_},
+				{Path: "expires", Value: expires},
+			})
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (b *firebaseStorageBackend) GetDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if s, err := b.keys().Doc(id).Get(ctx); isNotFound(err) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return b.decodeDataEncryptingKey(s)
+	}
+}
+
+func (b *firebaseStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	var r listDataEncryptingKeysResult
+
+	q := b.keys().OrderBy(firestore.DocumentID, firestore.Asc)
+	p := q
+	if after != "" {
+		p = p.StartAfter(after)
+	}
+
+	if total, err := b.count(ctx, q); err != nil {
+		return nil, err
+	} else if snapshots, err := page(p, 0, count).Documents(ctx).GetAll(); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, s := range snapshots {
+			if k, err := b.decodeDataEncryptingKey(s); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, k)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *firebaseStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	doc := b.keys().Doc(id)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if s, err := tx.Get(doc); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", id)
+		} else if err != nil {
+			return err
+		} else if k, err := b.decodeDataEncryptingKey(s); err != nil {
+			return err
+		} else if k.KeyEncryptingKey_ != from {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("data encrypting key %s is no longer wrapped by %s", id, from))
+		} else {
+			return tx.Update(doc, []firestore.Update{
+				{Path: "keyEncryptingKey", Value: keyEncryptingKey},
+				{Path: "encryptedKey", Value: encryptedKey},
+			})
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return b.GetDataEncryptingKey(ctx, id)
+	}
+}
+
+func (b *firebaseStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	if _, err := b.keys().Doc(id).Update(ctx, []firestore.Update{
+		{Path: "expires", Value: time.Now().Add(ttl)},
+	}); isNotFound(err) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return b.GetDataEncryptingKey(ctx, id)
+	}
+}
+
+func (b *firebaseStorageBackend) UnexpireDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if _, err := b.keys().Doc(id).Update(ctx, []firestore.Update{
+		{Path: "expires", Value: firestore.Delete},
+	}); isNotFound(err) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return b.GetDataEncryptingKey(ctx, id)
+	}
+}
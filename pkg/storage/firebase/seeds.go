@@ -0,0 +1,282 @@
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type seed struct {
+	Account_ interfaces.ID `firestore:"account"`
+	DataEncryptingKey_ interfaces.ID `firestore:"dataEncryptingKey"`
+	EncryptedSeed_ []byte `firestore:"encryptedSeed"`
+	NextIndex_ int64 `firestore:"nextIndex"`
+	Created_ time.Time `firestore:"created"`
+	Updated_ time.Time `firestore:"updated"`
+}
+
+var _ interfaces.Seed = &seed{}
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func decodeSeed(s *firestore.DocumentSnapshot) (*seed, error) {
+	var out seed
+	if err := s.DataTo(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func seedNotFound(account interfaces.ID) error {
+	return notFoundError("seed not found for account %s", account)
+}
+
+// Seed documents are keyed by account, which allows a single seed per account.
+func (b *firebaseStorageBackend) seedDoc(account interfaces.ID) *firestore.DocumentRef {
+	return b.seeds().Doc(account)
+}
+
+func (b *firebaseStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	s := seed{
+		Account_: account,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedSeed_: encryptedSeed,
+		NextIndex_: 0,
+		Created_: now,
+		Updated_: now,
+	}
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		key := b.keys().Doc(dataEncryptingKey)
+
+		if _, err := tx.Get(key); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", dataEncryptingKey)
+		} else if err != nil {
+			return err
+		} else if err := tx.Create(b.seedDoc(account), &s); err != nil {
+			return err
+		} else {
+			return tx.Update(key, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(1)},
+			})
+		}
+	}); isAlreadyExists(err) {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed already exists for account %s", account))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *firebaseStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	s := seed{
+		Account_: src.Account(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedSeed_: src.EncryptedSeed(),
+		NextIndex_: src.NextIndex(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+	}
+
+	doc := b.seedDoc(s.Account_)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var release *firestore.DocumentRef
+
+		key := b.keys().Doc(s.DataEncryptingKey_)
+
+		if _, err := tx.Get(key); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", s.DataEncryptingKey_)
+		} else if err != nil {
+			return err
+		} else if snapshot, err := tx.Get(doc); err != nil && !isNotFound(err) {
+			return err
+		} else if err == nil {
+			// the key of a replaced seed which still exists has its reference released
+			if existing, err := decodeSeed(snapshot); err != nil {
+				return err
+			} else if _, err := tx.Get(b.keys().Doc(existing.DataEncryptingKey_)); err == nil {
+				release = b.keys().Doc(existing.DataEncryptingKey_)
+			} else if !isNotFound(err) {
+				return err
+			}
+		}
+
+		if release != nil {
+			if err := tx.Update(release, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(-1)},
+			}); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Set(doc, &s); err != nil {
+			return err
+		} else {
+			return tx.Update(key, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(1)},
+			})
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *firebaseStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	if s, err := b.seedDoc(account).Get(ctx); isNotFound(err) {
+		return nil, seedNotFound(account)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return decodeSeed(s)
+	}
+}
+
+// ListAllSeeds lists the seeds of every account in account order.
+func (b *firebaseStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var r listSeedsResult
+
+	q := b.seeds().OrderBy(firestore.DocumentID, firestore.Asc)
+
+	if total, err := b.count(ctx, q); err != nil {
+		return nil, err
+	} else if snapshots, err := page(q, offset, count).Documents(ctx).GetAll(); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, snapshot := range snapshots {
+			if s, err := decodeSeed(snapshot); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, s)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *firebaseStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	var s *seed
+	doc := b.seedDoc(account)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if snapshot, err := tx.Get(doc); isNotFound(err) {
+			return seedNotFound(account)
+		} else if err != nil {
+			return err
+		} else if s, err = decodeSeed(snapshot); err != nil {
+			return err
+		} else {
+			s.NextIndex_ += count
+			s.Updated_ = time.Now()
+			return tx.Update(doc, []firestore.Update{
+				{Path: "nextIndex", Value: s.NextIndex_},
+				{Path: "updated", Value: s.Updated_},
+			})
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+func (b *firebaseStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	doc := b.seedDoc(account)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		key := b.keys().Doc(to)
+		release := b.keys().Doc(from)
+
+		if _, err := tx.Get(key); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", to)
+		} else if err != nil {
+			return err
+		} else if snapshot, err := tx.Get(doc); isNotFound(err) {
+			return seedNotFound(account)
+		} else if err != nil {
+			return err
+		} else if s, err := decodeSeed(snapshot); err != nil {
+			return err
+		} else if s.DataEncryptingKey_ != from {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed data encrypting key for account %s is no longer %s", account, from))
+		} else if _, err := tx.Get(release); isNotFound(err) {
+			release = nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := tx.Update(doc, []firestore.Update{
+			{Path: "dataEncryptingKey", Value: to},
+			{Path: "encryptedSeed", Value: encryptedSeed},
+			{Path: "updated", Value: time.Now()},
+		}); err != nil {
+			return err
+		} else if from == to {
+			return nil
+		} else if err := tx.Update(key, []firestore.Update{
+			{Path: "refCount", Value: firestore.Increment(1)},
+		}); err != nil {
+			return err
+		} else if release != nil {
+			return tx.Update(release, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(-1)},
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return b.GetSeed(ctx, account)
+	}
+}
@@ -0,0 +1,114 @@
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type issuedSignature struct {
+	Account_ interfaces.ID `firestore:"account"`
+	Wallet_ string `firestore:"wallet"`
+	Sender_ string `firestore:"sender"`
+	Uniq_ string `firestore:"uniq"`
+	Signature_ []byte `firestore:"signature"`
+	Created_ time.Time `firestore:"created"`
+	Expires_ time.Time `firestore:"expires"`
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return common.HexToAddress(s.Wallet_)
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return common.HexToAddress(s.Sender_)
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return common.HexToHash(s.Uniq_)
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+func decodeIssuedSignature(s *firestore.DocumentSnapshot) (*issuedSignature, error) {
+	var out issuedSignature
+	if err := s.DataTo(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Issued signature documents are keyed by wallet, sender and uniq, which allows
+// a single signature per uniq.
+func (b *firebaseStorageBackend) signatureDoc(wallet common.Address, sender common.Address, uniq common.Hash) *firestore.DocumentRef {
+	return b.signatures().Doc(wallet.Hex() + ":" + sender.Hex() + ":" + uniq.Hex())
+}
+
+func (b *firebaseStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	s := issuedSignature{
+		Account_: account,
+		Wallet_: wallet.Hex(),
+		Sender_: sender.Hex(),
+		Uniq_: uniq.Hex(),
+		Signature_: signature,
+		Created_: now,
+		Expires_: now.Add(ttl),
+	}
+
+	doc := b.signatureDoc(wallet, sender, uniq)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// expired documents remain until the TTL policy deletes them
+		if snapshot, err := tx.Get(doc); err != nil && !isNotFound(err) {
+			return err
+		} else if err == nil {
+			if existing, err := decodeIssuedSignature(snapshot); err != nil {
+				return err
+			} else if existing.Expires_.After(now) {
+				return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("signature already issued for uniq %s", uniq))
+			}
+		}
+		return tx.Set(doc, &s)
+	}); err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *firebaseStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	if snapshot, err := b.signatureDoc(wallet, sender, uniq).Get(ctx); isNotFound(err) {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else if err != nil {
+		return nil, err
+	} else if s, err := decodeIssuedSignature(snapshot); err != nil {
+		return nil, err
+	} else if s.Account_ != account || !s.Expires_.After(time.Now()) {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else {
+		return s, nil
+	}
+}
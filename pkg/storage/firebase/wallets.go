@@ -0,0 +1,394 @@
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+)
+
+type wallet struct {
+	ID_ interfaces.ID `firestore:"id"`
+	Account_ interfaces.ID `firestore:"account"`
+	Name_ string `firestore:"name"`
+	Address_ string `firestore:"address"`
+	DataEncryptingKey_ interfaces.ID `firestore:"dataEncryptingKey"`
+	EncryptedPrivateKey_ []byte `firestore:"encryptedPrivateKey"`
+	Permissions_ []string `firestore:"permissions,omitempty"`
+	Created_ time.Time `firestore:"created"`
+	Updated_ time.Time `firestore:"updated"`
+	Expires_ *time.Time `firestore:"expires,omitempty"`
+}
+
+var _ interfaces.Wallet = &wallet{}
+
+func (w *wallet) ID() interfaces.ID {
+	return w.ID_
+}
+
+func (w *wallet) Name() string {
+	return w.Name_
+}
+
+func (w *wallet) Account() interfaces.ID {
+	return w.Account_
+}
+
+func (w *wallet) Address() common.Address {
+	return common.HexToAddress(w.Address_)
+}
+
+func (w *wallet) DataEncryptingKey() interfaces.ID {
+	return w.DataEncryptingKey_
+}
+
+func (w *wallet) EncryptedPrivateKey() []byte {
+	return w.EncryptedPrivateKey_
+}
+
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
+func (w *wallet) Created() time.Time {
+	return w.Created_
+}
+
+func (w *wallet) Updated() time.Time {
+	return w.Updated_
+}
+
+func (w *wallet) Expires() *time.Time {
+	return w.Expires_
+}
+
+type listWalletsResult struct {
+	Count_ int64
+	Page_ []*wallet
+}
+
+var _ interfaces.ListWalletsResult = &listWalletsResult{}
+
+func (r *listWalletsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listWalletsResult) Page() []interfaces.Wallet {
+	out := make([]interfaces.Wallet, len(r.Page_))
+	for i, w := range r.Page_ {
+		out[i] = w
+	}
+	return out
+}
+
+func decodeWallet(s *firestore.DocumentSnapshot) (*wallet, error) {
+	var w wallet
+	if err := s.DataTo(&w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func walletNotFound(account interfaces.ID, address common.Address) error {
+	return notFoundError("wallet %s not found for account %s", address, account)
+}
+
+// Wallet documents are keyed by address, which keeps addresses unique.
+func (b *firebaseStorageBackend) walletDoc(address common.Address) *firestore.DocumentRef {
+	return b.wallets().Doc(address.Hex())
+}
+
+func (b *firebaseStorageBackend) CreateWallet(ctx context.Context, account interfaces.ID, name string, address common.Address, dataEncryptingKey interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	now := time.Now()
+
+	w := wallet{
+		ID_: anonymize.NewIDWithPrefix("wlt"),
+		Account_: account,
+		Name_: name,
+		Address_: address.Hex(),
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedPrivateKey_: encryptedPrivateKey,
+		Created_: now,
+		Updated_: now,
+		Expires_: nil,
+	}
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		key := b.keys().Doc(dataEncryptingKey)
+
+		if _, err := tx.Get(key); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", dataEncryptingKey)
+		} else if err != nil {
+			return err
+		} else if err := tx.Create(b.walletDoc(address), &w); err != nil {
+			return err
+		} else {
+			return tx.Update(key, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(1)},
+			})
+		}
+	}); isAlreadyExists(err) {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *firebaseStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	w := wallet{
+		ID_: src.ID(),
+		Account_: src.Account(),
+		Name_: src.Name(),
+		Address_: src.Address().Hex(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedPrivateKey_: src.EncryptedPrivateKey(),
+		Permissions_: src.Permissions(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+		Expires_: src.Expires(),
+	}
+
+	doc := b.walletDoc(src.Address())
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var previous []*firestore.DocumentSnapshot
+
+		key := b.keys().Doc(w.DataEncryptingKey_)
+
+		if _, err := tx.Get(key); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", w.DataEncryptingKey_)
+		} else if err != nil {
+			return err
+		} else if s, err := tx.Get(doc); err != nil && !isNotFound(err) {
+			return err
+		} else if err == nil {
+			if existing, err := decodeWallet(s); err != nil {
+				return err
+			} else if existing.ID_ != w.ID_ && (existing.Expires_ == nil || existing.Expires_.After(time.Now())) {
+				return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", w.Address_))
+			} else {
+				previous = append(previous, s)
+			}
+		}
+
+		// the same wallet stored under a different address
+		if snapshots, err := tx.Documents(b.wallets().Where("id", "==", w.ID_)).GetAll(); err != nil {
+			return err
+		} else {
+			for _, s := range snapshots {
+				if s.Ref.ID != doc.ID {
+					previous = append(previous, s)
+				}
+			}
+		}
+
+		// keys of replaced wallets which still exist have their reference released
+		var releases []*firestore.DocumentRef
+		for _, s := range previous {
+			if existing, err := decodeWallet(s); err != nil {
+				return err
+			} else if _, err := tx.Get(b.keys().Doc(existing.DataEncryptingKey_)); err == nil {
+				releases = append(releases, b.keys().Doc(existing.DataEncryptingKey_))
+			} else if !isNotFound(err) {
+				return err
+			}
+		}
+
+		for _, release := range releases {
+			if err := tx.Update(release, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(-1)},
+			}); err != nil {
+				return err
+			}
+		}
+
+		for _, s := range previous {
+			if s.Ref.ID != doc.ID {
+				if err := tx.Delete(s.Ref); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Set(doc, &w); err != nil {
+			return err
+		} else {
+			return tx.Update(key, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(1)},
+			})
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *firebaseStorageBackend) GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	if s, err := b.walletDoc(address).Get(ctx); isNotFound(err) {
+		return nil, walletNotFound(account, address)
+	} else if err != nil {
+		return nil, err
+	} else if w, err := decodeWallet(s); err != nil {
+		return nil, err
+	} else if w.Account_ != account || (w.Expires_ != nil && !w.Expires_.After(time.Now())) {
+		// expired documents remain readable until the TTL policy deletes them
+		return nil, walletNotFound(account, address)
+	} else {
+		return w, nil
+	}
+}
+
+func (b *firebaseStorageBackend) ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+
+	q := b.wallets().Where("account", "==", account).OrderBy("created", firestore.Asc)
+
+	if total, err := b.count(ctx, q); err != nil {
+		return nil, err
+	} else if snapshots, err := page(q, offset, count).Documents(ctx).GetAll(); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, s := range snapshots {
+			if w, err := decodeWallet(s); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, w)
+			}
+		}
+		return &r, nil
+	}
+}
+
+// ListAllWallets lists wallets of every account in address order.
+func (b *firebaseStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+
+	q := b.wallets().OrderBy(firestore.DocumentID, firestore.Asc)
+	p := q
+	if after != (common.Address{}) {
+		p = p.StartAfter(b.walletDoc(after).ID)
+	}
+
+	if total, err := b.count(ctx, q); err != nil {
+		return nil, err
+	} else if snapshots, err := page(p, 0, count).Documents(ctx).GetAll(); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		for _, s := range snapshots {
+			if w, err := decodeWallet(s); err != nil {
+				return nil, err
+			} else {
+				r.Page_ = append(r.Page_, w)
+			}
+		}
+		return &r, nil
+	}
+}
+
+func (b *firebaseStorageBackend) updateWallet(ctx context.Context, account interfaces.ID, address common.Address, updates []firestore.Update) (interfaces.Wallet, error) {
+	doc := b.walletDoc(address)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if s, err := tx.Get(doc); isNotFound(err) {
+			return walletNotFound(account, address)
+		} else if err != nil {
+			return err
+		} else if w, err := decodeWallet(s); err != nil {
+			return err
+		} else if w.Account_ != account {
+			return walletNotFound(account, address)
+		} else {
+			return tx.Update(doc, append(updates, firestore.Update{Path: "updated", Value: time.Now()}))
+		}
+	}); err != nil {
+		return nil, err
+	} else {
+		return b.GetWallet(ctx, account, address)
+	}
+}
+
+func (b *firebaseStorageBackend) UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (interfaces.Wallet, error) {
+	return b.updateWallet(ctx, account, address, []firestore.Update{
+		{Path: "name", Value: name},
+	})
+}
+
+func (b *firebaseStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	return b.updateWallet(ctx, account, address, []firestore.Update{
+		{Path: "permissions", Value: permissions},
+	})
+}
+
+func (b *firebaseStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	doc := b.walletDoc(address)
+
+	if err := b.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		key := b.keys().Doc(to)
+		release := b.keys().Doc(from)
+
+		if _, err := tx.Get(key); isNotFound(err) {
+			return notFoundError("data encrypting key %s not found", to)
+		} else if err != nil {
+			return err
+		} else if s, err := tx.Get(doc); isNotFound(err) {
+			return walletNotFound(account, address)
+		} else if err != nil {
+			return err
+		} else if w, err := decodeWallet(s); err != nil {
+			return err
+		} else if w.Account_ != account {
+			return walletNotFound(account, address)
+		} else if w.DataEncryptingKey_ != from {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s data encrypting key is no longer %s", address, from))
+		} else if _, err := tx.Get(release); isNotFound(err) {
+			release = nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := tx.Update(doc, []firestore.Update{
+			{Path: "dataEncryptingKey", Value: to},
+			{Path: "encryptedPrivateKey", Value: encryptedPrivateKey},
+			{Path: "updated", Value: time.Now()},
+		}); err != nil {
+			return err
+		} else if from == to {
+			return nil
+		} else if err := tx.Update(key, []firestore.Update{
+			{Path: "refCount", Value: firestore.Increment(1)},
+		}); err != nil {
+			return err
+		} else if release != nil {
+			return tx.Update(release, []firestore.Update{
+				{Path: "refCount", Value: firestore.Increment(-1)},
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return b.GetWallet(ctx, account, address)
+	}
+}
+
+func (b *firebaseStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
+	return b.updateWallet(ctx, account, address, []firestore.Update{
+		{Path: "expires", Value: time.Now().Add(ttl)},
+	})
+}
+
+func (b *firebaseStorageBackend) UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return b.updateWallet(ctx, account, address, []firestore.Update{
+		{Path: "expires", Value: firestore.Delete},
+	})
+}
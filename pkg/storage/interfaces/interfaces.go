@@ -5,28 +5,90 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
 )
 
 type ID = string
 
+// NewDataEncryptingKeyID returns a new data encrypting key id. Ids are issued
+// before the key is wrapped so that key encrypting key providers can bind the
+// wrapped key to its id.
+func NewDataEncryptingKeyID() ID {
+	return anonymize.NewIDWithPrefix("dek")
+}
+
 type IVaultService interface {
 	CreateDataEncryptingKey(ctx context.Context) (DataEncryptingKey, error)
 }
 
 type IStorageBackend interface {
-	CreateDataEncryptingKey(ctx context.Context, keyEncryptingKey ID, encryptedKey []byte) (DataEncryptingKey, error)
+	CreateDataEncryptingKey(ctx context.Context, id ID, keyEncryptingKey ID, encryptedKey []byte) (DataEncryptingKey, error)
 	GetDataEncryptingKey(ctx context.Context, id ID) (DataEncryptingKey, error)
-	ListDataEncryptingKeys(ctx context.Context, offset int64, count int64) (ListDataEncryptingKeysResult, error)
+	// Lists data encrypting keys which follow the key with id after, or from the
+	// first key for an empty id, in an order fixed by the backend. Paging with
+	// the id of the last key of the previous page visits every key that exists
+	// throughout, whatever is created or removed in the meantime.
+	ListDataEncryptingKeys(ctx context.Context, after ID, count int64) (ListDataEncryptingKeysResult, error)
 	ExpireDataEncryptingKey(ctx context.Context, id ID, ttl time.Duration) (DataEncryptingKey, error)
 	UnexpireDataEncryptingKey(ctx context.Context, id ID) (DataEncryptingKey, error)
 	GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (DataEncryptingKey, error)
 
+	// Used to rewrap data encrypting keys. Replaces the encrypted key and key
+	// encrypting key id, failing with a conflict if the key is no longer
+	// wrapped by from.
+	UpdateDataEncryptingKey(ctx context.Context, id ID, from ID, keyEncryptingKey ID, encryptedKey []byte) (DataEncryptingKey, error)
+
 	CreateWallet(ctx context.Context, account ID, name string, address common.Address, dataEncryptingKey ID, encryptedPrivateKey []byte) (Wallet, error)
 	GetWallet(ctx context.Context, account ID, address common.Address) (Wallet, error)
 	ListWallets(ctx context.Context, account ID, offset int64, count int64) (ListWalletsResult, error)
 	UpdateWallet(ctx context.Context, account ID, address common.Address, name string) (Wallet, error)
 	ExpireWallet(ctx context.Context, account ID, address common.Address, ttl time.Duration) (Wallet, error)
 	UnexpireWallet(ctx context.Context, account ID, address common.Address) (Wallet, error)
+
+	// Replaces the permissions granted to a wallet. Permissions enable signing
+	// modes that are off by default, such as signing raw hashes.
+	UpdateWalletPermissions(ctx context.Context, account ID, address common.Address, permissions []string) (Wallet, error)
+
+	// Used to rotate data encrypting keys. Replaces the encrypted private key
+	// and moves the reference from one data encrypting key to another, failing
+	// with a conflict if the wallet no longer references from. When from and to
+	// are the same only the encrypted private key is replaced.
+	UpdateWalletDataEncryptingKey(ctx context.Context, account ID, address common.Address, from ID, to ID, encryptedPrivateKey []byte) (Wallet, error)
+
+	// Used by HD wallets, which are derived from the seed of their account.
+	// An account has at most one seed, creating another fails with a conflict.
+	// Seeds reference a data encrypting key as wallets do and count towards
+	// its ref count.
+	CreateSeed(ctx context.Context, account ID, dataEncryptingKey ID, encryptedSeed []byte) (Seed, error)
+	GetSeed(ctx context.Context, account ID) (Seed, error)
+
+	// Reserves count derivation indexes and returns the seed with its next
+	// index advanced past them, so that concurrent callers never derive the
+	// same index.
+	ReserveSeedIndexes(ctx context.Context, account ID, count int64) (Seed, error)
+
+	// Used to rotate data encrypting keys, as UpdateWalletDataEncryptingKey.
+	UpdateSeedDataEncryptingKey(ctx context.Context, account ID, from ID, to ID, encryptedSeed []byte) (Seed, error)
+
+	// Used by the replay protection ledger of the signer. Records the signature
+	// issued to sender for uniq by a wallet, failing with a conflict if one was
+	// issued for the same wallet, sender and uniq and has not yet expired. The
+	// signature is opaque to the backend.
+	CreateIssuedSignature(ctx context.Context, account ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (IssuedSignature, error)
+	GetIssuedSignature(ctx context.Context, account ID, wallet common.Address, sender common.Address, uniq common.Hash) (IssuedSignature, error)
+
+	// Used to copy a vault between backends, preserving ids, timestamps,
+	// expiry and data encrypting key references. Puts are idempotent.
+	PutDataEncryptingKey(ctx context.Context, k DataEncryptingKey) (DataEncryptingKey, error)
+	PutWallet(ctx context.Context, w Wallet) (Wallet, error)
+	PutSeed(ctx context.Context, s Seed) (Seed, error)
+	// Lists wallets of every account which follow the wallet at after, or from
+	// the first wallet for the zero address, in an order fixed by the backend.
+	// Paging with the address of the last wallet of the previous page visits
+	// every wallet that exists throughout, whatever is created or removed in
+	// the meantime.
+	ListAllWallets(ctx context.Context, after common.Address, count int64) (ListWalletsResult, error)
+	ListAllSeeds(ctx context.Context, offset int64, count int64) (ListSeedsResult, error)
 }
 
 type ListDataEncryptingKeysResult interface {
@@ -39,6 +101,11 @@ type ListWalletsResult interface {
 	Page() []Wallet
 }
 
+type ListSeedsResult interface {
+	Count() int64
+	Page() []Seed
+}
+
 type DataEncryptingKey interface {
 	ID() ID
 	KeyEncryptingKey() ID
@@ -54,7 +121,27 @@ type Wallet interface {
 	Address() common.Address
 	DataEncryptingKey() ID
 	EncryptedPrivateKey() []byte
+	Permissions() []string
 	Created() time.Time
 	Updated() time.Time
 	Expires() *time.Time
-}
\ No newline at end of file
+}
+
+type Seed interface {
+	Account() ID
+	DataEncryptingKey() ID
+	EncryptedSeed() []byte
+	NextIndex() int64
+	Created() time.Time
+	Updated() time.Time
+}
+
+type IssuedSignature interface {
+	Account() ID
+	Wallet() common.Address
+	Sender() common.Address
+	Uniq() common.Hash
+	Signature() []byte
+	Created() time.Time
+	Expires() time.Time
+}
@@ -0,0 +1,242 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type dataEncryptingKey struct {
+	backend *memoryStorageBackend
+	ID_ interfaces.ID
+	KeyEncryptingKey_ interfaces.ID
+	EncryptedKey_ []byte
+	Expires_ *time.Time
+}
+
+var _ interfaces.DataEncryptingKey = &dataEncryptingKey{}
+
+func (k *dataEncryptingKey) ID() interfaces.ID {
+	return k.ID_
+}
+
+func (k *dataEncryptingKey) KeyEncryptingKey() interfaces.ID {
+	return k.KeyEncryptingKey_
+}
+
+func (k *dataEncryptingKey) EncryptedKey() []byte {
+	return k.EncryptedKey_
+}
+
+func (k *dataEncryptingKey) Expires() *time.Time {
+	return k.Expires_
+}
+
+func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
+	k.backend.mutex.RLock()
+	defer k.backend.mutex.RUnlock()
+
+	return k.backend.refCount(k.ID_), nil
+}
+
+func (k *dataEncryptingKey) copy() *dataEncryptingKey {
+	c := *k
+	return &c
+}
+
+type listDataEncryptingKeysResult struct {
+	Count_ int64
+	Page_ []*dataEncryptingKey
+}
+
+var _ interfaces.ListDataEncryptingKeysResult = &listDataEncryptingKeysResult{}
+
+func (r *listDataEncryptingKeysResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listDataEncryptingKeysResult) Page() []interfaces.DataEncryptingKey {
+	out := make([]interfaces.DataEncryptingKey, len(r.Page_))
+	for i, k := range r.Page_ {
+		out[i] = k
+	}
+	return out
+}
+
+func (b *memoryStorageBackend) refCount(id interfaces.ID) int64 {
+	var count int64
+	now := time.Now()
+
+	for _, w := range b.wallets {
+		if w.DataEncryptingKey_ == id && !expired(w.Expires_, now) {
+			count++
+		}
+	}
+
+	for _, s := range b.seeds {
+		if s.DataEncryptingKey_ == id {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (b *memoryStorageBackend) getDataEncryptingKey(id interfaces.ID) (*dataEncryptingKey, error) {
+	if k, ok := b.keys[id]; !ok || expired(k.Expires_, time.Now()) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else {
+		return k, nil
+	}
+}
+
+func (b *memoryStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (interfaces.DataEncryptingKey, error) {
+	var candidates []*dataEncryptingKey
+
+	b.mutex.RLock()
+	for _, id := range b.keyOrder {
+		// keys scheduled to expire are not handed out to new wallets
+		if k := b.keys[id]; k.Expires_ == nil && b.refCount(id) < maxRefCount {
+			candidates = append(candidates, k.copy())
+		}
+	}
+	b.mutex.RUnlock()
+
+	if len(candidates) == 0 {
+		return b.vault.CreateDataEncryptingKey(ctx)
+	}
+
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+func (b *memoryStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: id,
+		KeyEncryptingKey_: keyEncryptingKey,
+		EncryptedKey_: append([]byte{}, encryptedKey...),
+		Expires_: nil,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	b.keys[k.ID_] = &k
+	b.keyOrder = append(b.keyOrder, k.ID_)
+
+	return k.copy(), nil
+}
+
+func (b *memoryStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: src.ID(),
+		KeyEncryptingKey_: src.KeyEncryptingKey(),
+		EncryptedKey_: append([]byte{}, src.EncryptedKey()...),
+		Expires_: src.Expires(),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, ok := b.keys[k.ID_]; !ok {
+		b.keyOrder = append(b.keyOrder, k.ID_)
+	}
+	b.keys[k.ID_] = &k
+
+	return k.copy(), nil
+}
+
+func (b *memoryStorageBackend) GetDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if k, err := b.getDataEncryptingKey(id); err != nil {
+		return nil, err
+	} else {
+		return k.copy(), nil
+	}
+}
+
+// ListDataEncryptingKeys lists data encrypting keys in id order.
+func (b *memoryStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	var r listDataEncryptingKeysResult
+	var keys []*dataEncryptingKey
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	now := time.Now()
+	for _, id := range b.keyOrder {
+		if k := b.keys[id]; !expired(k.Expires_, now) {
+			keys = append(keys, k.copy())
+		}
+	}
+	slices.SortFunc(keys, func(a *dataEncryptingKey, b *dataEncryptingKey) int {
+		return strings.Compare(a.ID_, b.ID_)
+	})
+
+	offset, found := slices.BinarySearchFunc(keys, after, func(k *dataEncryptingKey, id interfaces.ID) int {
+		return strings.Compare(k.ID_, id)
+	})
+	if found {
+		offset++
+	}
+
+	start, end := page(len(keys), int64(offset), count)
+	r.Count_ = int64(len(keys))
+	r.Page_ = keys[start:end]
+
+	return &r, nil
+}
+
+func (b *memoryStorageBackend) updateDataEncryptingKey(id interfaces.ID, update func(k *dataEncryptingKey)) (interfaces.DataEncryptingKey, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if k, err := b.getDataEncryptingKey(id); err != nil {
+		return nil, err
+	} else {
+		update(k)
+		return k.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if k, err := b.getDataEncryptingKey(id); err != nil {
+		return nil, err
+	} else if k.KeyEncryptingKey_ != from {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("data encrypting key %s is no longer wrapped by %s", id, from))
+	} else {
+		k.KeyEncryptingKey_ = keyEncryptingKey
+		k.EncryptedKey_ = append([]byte{}, encryptedKey...)
+		return k.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	expires := time.Now().Add(ttl)
+
+	return b.updateDataEncryptingKey(id, func(k *dataEncryptingKey) {
+		k.Expires_ = &expires
+	})
+}
+
+func (b *memoryStorageBackend) UnexpireDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	return b.updateDataEncryptingKey(id, func(k *dataEncryptingKey) {
+		k.Expires_ = nil
+	})
+}
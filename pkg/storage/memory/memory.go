@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// memoryStorageBackend keeps everything in process memory. It is intended for
+// tests and local development, all data is lost when the vault stops.
+type memoryStorageBackend struct {
+	mutex sync.RWMutex
+	vault interfaces.IVaultService
+	keys map[interfaces.ID]*dataEncryptingKey
+	keyOrder []interfaces.ID
+	wallets map[interfaces.ID]*wallet
+	walletOrder []interfaces.ID
+	addresses map[common.Address]interfaces.ID
+	seeds map[interfaces.ID]*seed
+	seedOrder []interfaces.ID
+	signatures map[issuedSignatureKey]*issuedSignature
+}
+
+var _ interfaces.IStorageBackend = &memoryStorageBackend{}
+
+func NewMemoryStorageBackend(vault interfaces.IVaultService) (interfaces.IStorageBackend, error) {
+	b := &memoryStorageBackend{
+		vault: vault,
+		keys: map[interfaces.ID]*dataEncryptingKey{},
+		wallets: map[interfaces.ID]*wallet{},
+		addresses: map[common.Address]interfaces.ID{},
+		seeds: map[interfaces.ID]*seed{},
+		signatures: map[issuedSignatureKey]*issuedSignature{},
+	}
+
+	return b, nil
+}
+
+func expired(expires *time.Time, now time.Time) bool {
+	return expires != nil && !expires.After(now)
+}
+
+// prune removes expired records, matching the TTL indexes of the mongo
+// backend. It must be called with the write lock held.
+func (b *memoryStorageBackend) prune() {
+	now := time.Now()
+
+	walletOrder := b.walletOrder[:0]
+	for _, id := range b.walletOrder {
+		if w := b.wallets[id]; expired(w.Expires_, now) {
+			delete(b.wallets, id)
+			delete(b.addresses, w.Address_)
+		} else {
+			walletOrder = append(walletOrder, id)
+		}
+	}
+	b.walletOrder = walletOrder
+
+	keyOrder := b.keyOrder[:0]
+	for _, id := range b.keyOrder {
+		if k := b.keys[id]; expired(k.Expires_, now) {
+			delete(b.keys, id)
+		} else {
+			keyOrder = append(keyOrder, id)
+		}
+	}
+	b.keyOrder = keyOrder
+
+	for key, s := range b.signatures {
+		if !s.Expires_.After(now) {
+			delete(b.signatures, key)
+		}
+	}
+}
+
+func notFoundError(format string, args ...any) error {
+	return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf(format, args...))
+}
+
+// page returns the bounds of a page within total items, where a count of zero
+// returns every item from offset.
+func page(total int, offset int64, count int64) (int, int) {
+	start := int(offset)
+	if start > total {
+		start = total
+	}
+	end := total
+	if count > 0 && start + int(count) < total {
+		end = start + int(count)
+	}
+	return start, end
+}
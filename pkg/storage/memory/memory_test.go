@@ -0,0 +1,18 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+		backend, err := NewMemoryStorageBackend(vault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	})
+}
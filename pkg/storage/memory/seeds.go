@@ -0,0 +1,188 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type seed struct {
+	Account_ interfaces.ID
+	DataEncryptingKey_ interfaces.ID
+	EncryptedSeed_ []byte
+	NextIndex_ int64
+	Created_ time.Time
+	Updated_ time.Time
+}
+
+var _ interfaces.Seed = &seed{}
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+func (s *seed) copy() *seed {
+	c := *s
+	return &c
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func (b *memoryStorageBackend) getSeed(account interfaces.ID) (*seed, error) {
+	if s, ok := b.seeds[account]; !ok {
+		return nil, notFoundError("seed not found for account %s", account)
+	} else {
+		return s, nil
+	}
+}
+
+func (b *memoryStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	s := seed{
+		Account_: account,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedSeed_: append([]byte{}, encryptedSeed...),
+		NextIndex_: 0,
+		Created_: now,
+		Updated_: now,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, err := b.getDataEncryptingKey(dataEncryptingKey); err != nil {
+		return nil, err
+	} else if _, ok := b.seeds[account]; ok {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed already exists for account %s", account))
+	} else {
+		b.seeds[account] = &s
+		b.seedOrder = append(b.seedOrder, account)
+		return s.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	s := seed{
+		Account_: src.Account(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedSeed_: append([]byte{}, src.EncryptedSeed()...),
+		NextIndex_: src.NextIndex(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, err := b.getDataEncryptingKey(s.DataEncryptingKey_); err != nil {
+		return nil, err
+	}
+
+	if _, ok := b.seeds[s.Account_]; !ok {
+		b.seedOrder = append(b.seedOrder, s.Account_)
+	}
+	b.seeds[s.Account_] = &s
+
+	return s.copy(), nil
+}
+
+func (b *memoryStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if s, err := b.getSeed(account); err != nil {
+		return nil, err
+	} else {
+		return s.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var r listSeedsResult
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	start, end := page(len(b.seedOrder), offset, count)
+	r.Count_ = int64(len(b.seedOrder))
+	for _, account := range b.seedOrder[start:end] {
+		r.Page_ = append(r.Page_, b.seeds[account].copy())
+	}
+
+	return &r, nil
+}
+
+func (b *memoryStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if s, err := b.getSeed(account); err != nil {
+		return nil, err
+	} else {
+		s.NextIndex_ += count
+		s.Updated_ = time.Now()
+		return s.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, err := b.getDataEncryptingKey(to); err != nil {
+		return nil, err
+	} else if s, err := b.getSeed(account); err != nil {
+		return nil, err
+	} else if s.DataEncryptingKey_ != from {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed data encrypting key for account %s is no longer %s", account, from))
+	} else {
+		s.DataEncryptingKey_ = to
+		s.EncryptedSeed_ = append([]byte{}, encryptedSeed...)
+		s.Updated_ = time.Now()
+		return s.copy(), nil
+	}
+}
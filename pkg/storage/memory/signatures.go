@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+type issuedSignatureKey struct {
+	Wallet common.Address
+	Sender common.Address
+	Uniq common.Hash
+}
+
+type issuedSignature struct {
+	Account_ interfaces.ID
+	Wallet_ common.Address
+	Sender_ common.Address
+	Uniq_ common.Hash
+	Signature_ []byte
+	Created_ time.Time
+	Expires_ time.Time
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return s.Wallet_
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return s.Sender_
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return s.Uniq_
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+func (s *issuedSignature) copy() *issuedSignature {
+	c := *s
+	c.Signature_ = append([]byte{}, s.Signature_...)
+	return &c
+}
+
+func (b *memoryStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	s := issuedSignature{
+		Account_: account,
+		Wallet_: wallet,
+		Sender_: sender,
+		Uniq_: uniq,
+		Signature_: append([]byte{}, signature...),
+		Created_: now,
+		Expires_: now.Add(ttl),
+	}
+	key := issuedSignatureKey{Wallet: wallet, Sender: sender, Uniq: uniq}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, ok := b.signatures[key]; ok {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("signature already issued for uniq %s", uniq))
+	} else {
+		b.signatures[key] = &s
+		return s.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if s, ok := b.signatures[issuedSignatureKey{Wallet: wallet, Sender: sender, Uniq: uniq}]; !ok || s.Account_ != account || !s.Expires_.After(time.Now()) {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else {
+		return s.copy(), nil
+	}
+}
@@ -0,0 +1,297 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+)
+
+type wallet struct {
+	ID_ interfaces.ID
+	Account_ interfaces.ID
+	Name_ string
+	Address_ common.Address
+	DataEncryptingKey_ interfaces.ID
+	EncryptedPrivateKey_ []byte
+	Permissions_ []string
+	Created_ time.Time
+	Updated_ time.Time
+	Expires_ *time.Time
+}
+
+var _ interfaces.Wallet = &wallet{}
+
+func (w *wallet) ID() interfaces.ID {
+	return w.ID_
+}
+
+func (w *wallet) Name() string {
+	return w.Name_
+}
+
+func (w *wallet) Account() interfaces.ID {
+	return w.Account_
+}
+
+func (w *wallet) Address() common.Address {
+	return w.Address_
+}
+
+func (w *wallet) DataEncryptingKey() interfaces.ID {
+	return w.DataEncryptingKey_
+}
+
+func (w *wallet) EncryptedPrivateKey() []byte {
+	return w.EncryptedPrivateKey_
+}
+
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
+func (w *wallet) Created() time.Time {
+	return w.Created_
+}
+
+func (w *wallet) Updated() time.Time {
+	return w.Updated_
+}
+
+func (w *wallet) Expires() *time.Time {
+	return w.Expires_
+}
+
+func (w *wallet) copy() *wallet {
+	c := *w
+	return &c
+}
+
+type listWalletsResult struct {
+	Count_ int64
+	Page_ []*wallet
+}
+
+var _ interfaces.ListWalletsResult = &listWalletsResult{}
+
+func (r *listWalletsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listWalletsResult) Page() []interfaces.Wallet {
+	out := make([]interfaces.Wallet, len(r.Page_))
+	for i, w := range r.Page_ {
+		out[i] = w
+	}
+	return out
+}
+
+func (b *memoryStorageBackend) getWallet(account interfaces.ID, address common.Address) (*wallet, error) {
+	if id, ok := b.addresses[address]; !ok {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("wallet %s not found for account %s", address, account))
+	} else if w := b.wallets[id]; w.Account_ != account || expired(w.Expires_, time.Now()) {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("wallet %s not found for account %s", address, account))
+	} else {
+		return w, nil
+	}
+}
+
+func (b *memoryStorageBackend) CreateWallet(ctx context.Context, account interfaces.ID, name string, address common.Address, dataEncryptingKey interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	now := time.Now()
+
+	w := wallet{
+		ID_: anonymize.NewIDWithPrefix("wlt"),
+		Account_: account,
+		Name_: name,
+		Address_: address,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedPrivateKey_: append([]byte{}, encryptedPrivateKey...),
+		Created_: now,
+		Updated_: now,
+		Expires_: nil,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, err := b.getDataEncryptingKey(dataEncryptingKey); err != nil {
+		return nil, err
+	} else if _, ok := b.addresses[address]; ok {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+	} else {
+		b.wallets[w.ID_] = &w
+		b.walletOrder = append(b.walletOrder, w.ID_)
+		b.addresses[address] = w.ID_
+		return w.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	w := wallet{
+		ID_: src.ID(),
+		Account_: src.Account(),
+		Name_: src.Name(),
+		Address_: src.Address(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedPrivateKey_: append([]byte{}, src.EncryptedPrivateKey()...),
+		Permissions_: append([]string{}, src.Permissions()...),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+		Expires_: src.Expires(),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, err := b.getDataEncryptingKey(w.DataEncryptingKey_); err != nil {
+		return nil, err
+	} else if id, ok := b.addresses[w.Address_]; ok && id != w.ID_ {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", w.Address_))
+	}
+
+	if existing, ok := b.wallets[w.ID_]; ok {
+		delete(b.addresses, existing.Address_)
+	} else {
+		b.walletOrder = append(b.walletOrder, w.ID_)
+	}
+	b.wallets[w.ID_] = &w
+	b.addresses[w.Address_] = w.ID_
+
+	return w.copy(), nil
+}
+
+func (b *memoryStorageBackend) GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if w, err := b.getWallet(account, address); err != nil {
+		return nil, err
+	} else {
+		return w.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) listWallets(filter func(w *wallet) bool, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	var wallets []*wallet
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	now := time.Now()
+	for _, id := range b.walletOrder {
+		if w := b.wallets[id]; filter(w) && !expired(w.Expires_, now) {
+			wallets = append(wallets, w.copy())
+		}
+	}
+
+	start, end := page(len(wallets), offset, count)
+	r.Count_ = int64(len(wallets))
+	r.Page_ = wallets[start:end]
+
+	return &r, nil
+}
+
+func (b *memoryStorageBackend) ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	return b.listWallets(func(w *wallet) bool {
+		return w.Account_ == account
+	}, offset, count)
+}
+
+// ListAllWallets lists wallets of every account in address order.
+func (b *memoryStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	var wallets []*wallet
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	now := time.Now()
+	for _, w := range b.wallets {
+		if !expired(w.Expires_, now) {
+			wallets = append(wallets, w.copy())
+		}
+	}
+	slices.SortFunc(wallets, func(a *wallet, b *wallet) int {
+		return a.Address_.Cmp(b.Address_)
+	})
+
+	offset, found := slices.BinarySearchFunc(wallets, after, func(w *wallet, address common.Address) int {
+		return w.Address_.Cmp(address)
+	})
+	if found {
+		offset++
+	}
+
+	start, end := page(len(wallets), int64(offset), count)
+	r.Count_ = int64(len(wallets))
+	r.Page_ = wallets[start:end]
+
+	return &r, nil
+}
+
+func (b *memoryStorageBackend) updateWallet(account interfaces.ID, address common.Address, update func(w *wallet)) (interfaces.Wallet, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if w, err := b.getWallet(account, address); err != nil {
+		return nil, err
+	} else {
+		update(w)
+		w.Updated_ = time.Now()
+		return w.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (interfaces.Wallet, error) {
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Name_ = name
+	})
+}
+
+func (b *memoryStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Permissions_ = append([]string{}, permissions...)
+	})
+}
+
+func (b *memoryStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune()
+	if _, err := b.getDataEncryptingKey(to); err != nil {
+		return nil, err
+	} else if w, err := b.getWallet(account, address); err != nil {
+		return nil, err
+	} else if w.DataEncryptingKey_ != from {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s data encrypting key is no longer %s", address, from))
+	} else {
+		w.DataEncryptingKey_ = to
+		w.EncryptedPrivateKey_ = append([]byte{}, encryptedPrivateKey...)
+		w.Updated_ = time.Now()
+		return w.copy(), nil
+	}
+}
+
+func (b *memoryStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
+	expires := time.Now().Add(ttl)
+
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Expires_ = &expires
+	})
+}
+
+func (b *memoryStorageBackend) UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return b.updateWallet(account, address, func(w *wallet) {
+		w.Expires_ = nil
+	})
+}
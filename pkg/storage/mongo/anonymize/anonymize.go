@@ -121,3 +121,8 @@ func ObjectIDFromStringWithPrefix(prefix string, id string) (ObjectID, error) {
 		return o, nil
 	}
 }
+
+func NewIDWithPrefix(prefix string) string {
+	o := ObjectID(primitive.NewObjectID())
+	return o.StringWithPrefix(prefix)
+}
@@ -2,13 +2,17 @@ package mongo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -78,10 +82,12 @@ func (k *dataEncryptingKey) Expires() *time.Time {
 }
 
 func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
-	if count, err := k.backend.db.Collection("wallets").CountDocuments(ctx, bson.M{"dataEncryptingKey": k.ID_}); err != nil {
+	if wallets, err := k.backend.db.Collection("wallets").CountDocuments(ctx, bson.M{"dataEncryptingKey": k.ID_}); err != nil {
+		return 0, err
+	} else if seeds, err := k.backend.db.Collection("seeds").CountDocuments(ctx, bson.M{"dataEncryptingKey": k.ID_}); err != nil {
 		return 0, err
 	} else {
-		return count, nil
+		return wallets + seeds, nil
 	}
 }
 
@@ -113,6 +119,8 @@ func (m *mongoStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCo
 	var r dataEncryptingKeyRefCount
 
 	pipeline := bson.A{
+		// keys scheduled to expire are not handed out to new wallets
+		bson.M{"$match": bson.M{"expires": bson.M{"$exists": false}}},
 		bson.M{
 			"$lookup": bson.M{
 				"from": "wallets",
@@ -140,19 +148,43 @@ func (m *mongoStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCo
 	}
 }
 
-func (m *mongoStorageBackend) CreateDataEncryptingKey(ctx context.Context, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
-	k := dataEncryptingKey{
-		backend: m,
-		ID_: DataEncryptingKeyID(primitive.NewObjectID()),
-		KeyEncryptingKey_: keyEncryptingKey,
-		EncryptedKey_: encryptedKey,
-		Expires_: nil,
+func (m *mongoStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	if oid, err := DataEncryptingKeyIDFromString(id); err != nil {
+		return nil, err
+	} else {
+		k := dataEncryptingKey{
+			backend: m,
+			ID_: oid,
+			KeyEncryptingKey_: keyEncryptingKey,
+			EncryptedKey_: encryptedKey,
+			Expires_: nil,
+		}
+
+		if _, err := m.db.Collection("keys").InsertOne(ctx, &k); err != nil {
+			return nil, err
+		} else {
+			return &k, nil
+		}
 	}
+}
 
-	if _, err := m.db.Collection("keys").InsertOne(ctx, &k); err != nil {
+func (m *mongoStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	if id, err := DataEncryptingKeyIDFromString(src.ID()); err != nil {
 		return nil, err
 	} else {
-		return &k, nil
+		k := dataEncryptingKey{
+			backend: m,
+			ID_: id,
+			KeyEncryptingKey_: src.KeyEncryptingKey(),
+			EncryptedKey_: src.EncryptedKey(),
+			Expires_: src.Expires(),
+		}
+
+		if _, err := m.db.Collection("keys").ReplaceOne(ctx, bson.M{"_id": id}, &k, options.Replace().SetUpsert(true)); err != nil {
+			return nil, err
+		} else {
+			return &k, nil
+		}
 	}
 }
 
@@ -162,6 +194,9 @@ func (m *mongoStorageBackend) GetDataEncryptingKey(ctx context.Context, id inter
 	if _id, err := DataEncryptingKeyIDFromString(id); err != nil {
 		return nil, err
 	} else if err := m.db.Collection("keys").FindOne(ctx, bson.M{"_id": _id}).Decode(&k); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("data encrypting key %s not found", id))
+		}
 		return nil, err
 	} else {
 		k.backend = m
@@ -169,17 +204,17 @@ func (m *mongoStorageBackend) GetDataEncryptingKey(ctx context.Context, id inter
 	}
 }
 
-func (m *mongoStorageBackend) ListDataEncryptingKeys(ctx context.Context, offset int64, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+func (m *mongoStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
 	var r listDataEncryptingKeysResult
 
-	if count, err := m.db.Collection("keys").CountDocuments(ctx, bson.M{}); err != nil {
+	if total, err := m.db.Collection("keys").CountDocuments(ctx, bson.M{}); err != nil {
 		return nil, err
-	} else if cursor, err := m.db.Collection("keys").Find(ctx, bson.M{}, options.Find().SetSkip(offset).SetLimit(count)); err != nil {
+	} else if cursor, err := m.db.Collection("keys").Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(count)); err != nil {
 		return nil, err
 	} else if err := cursor.All(ctx, &r.Page_); err != nil {
 		return nil, err
 	} else {
-		r.Count_ = count
+		r.Count_ = total
 		for _, k := range r.Page_ {
 			k.backend = m
 		}
@@ -187,6 +222,20 @@ func (m *mongoStorageBackend) ListDataEncryptingKeys(ctx context.Context, offset
 	}
 }
 
+func (m *mongoStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	if _id, err := DataEncryptingKeyIDFromString(id); err != nil {
+		return nil, err
+	} else if result, err := m.db.Collection("keys").UpdateOne(ctx, bson.M{"_id": _id, "keyEncryptingKey": from}, bson.M{"$set": bson.M{"keyEncryptingKey": keyEncryptingKey, "encryptedKey": encryptedKey}}); err != nil {
+		return nil, err
+	} else if k, err := m.GetDataEncryptingKey(ctx, id); err != nil {
+		return nil, err
+	} else if result.MatchedCount == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("data encrypting key %s is no longer wrapped by %s", id, from))
+	} else {
+		return k, nil
+	}
+}
+
 func (m *mongoStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
 	if _id, err := DataEncryptingKeyIDFromString(id); err != nil {
 		return nil, err
@@ -45,6 +45,13 @@ func NewMongoStorageBackend(vault interfaces.IVaultService) (interfaces.IStorage
 		return nil, err
 	}
 
+	if err := b.EnsureIndex(context.Background(), "signatures", mongo.IndexModel{
+		Keys: bson.M{"expires": 1},
+		Options: options.Index().SetName("expires").SetExpireAfterSeconds(1),
+	}); err != nil {
+		return nil, err
+	}
+
 	if err := b.EnsureIndex(context.Background(), "wallets", mongo.IndexModel{
 		Keys: bson.M{"address": 1},
 		Options: options.Index().SetName("address").SetUnique(true),
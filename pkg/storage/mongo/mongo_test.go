@@ -0,0 +1,51 @@
+package mongo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/storagetest"
+)
+
+// TestConformance runs against the server at VAULT_MONGO_URL, e.g.
+//
+//	docker run -p 27017:27017 mongo
+//	VAULT_MONGO_URL=mongodb://localhost:27017 go test ./pkg/storage/mongo
+//
+// Each subtest uses its own database, dropped when the test ends.
+func TestConformance(t *testing.T) {
+	base := os.Getenv("VAULT_MONGO_URL")
+	if base == "" {
+		t.Skip("VAULT_MONGO_URL not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+		suffix := make([]byte, 8)
+		rand.Read(suffix)
+
+		u, err := url.Parse(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.Path = "/signchain-vault-test-" + hex.EncodeToString(suffix)
+		t.Setenv("VAULT_MONGO_URL", u.String())
+
+		backend, err := NewMongoStorageBackend(vault)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := backend.(*mongoStorageBackend).db
+		t.Cleanup(func() {
+			db.Drop(context.Background())
+			db.Client().Disconnect(context.Background())
+		})
+
+		return backend
+	})
+}
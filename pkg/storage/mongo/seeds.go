@@ -0,0 +1,176 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type seed struct {
+	Account_ interfaces.ID `bson:"_id"`
+	DataEncryptingKey_ DataEncryptingKeyID `bson:"dataEncryptingKey"`
+	EncryptedSeed_ []byte `bson:"encryptedSeed"`
+	NextIndex_ int64 `bson:"nextIndex"`
+	Created_ time.Time `bson:"created"`
+	Updated_ time.Time `bson:"updated"`
+}
+
+var _ interfaces.Seed = &seed{}
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_.String()
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func (m *mongoStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	if _dataEncryptingKey, err := DataEncryptingKeyIDFromString(dataEncryptingKey); err != nil {
+		return nil, err
+	} else if _, err := m.GetDataEncryptingKey(ctx, dataEncryptingKey); err != nil {
+		return nil, err
+	} else {
+		s := seed{
+			Account_: account,
+			DataEncryptingKey_: _dataEncryptingKey,
+			EncryptedSeed_: encryptedSeed,
+			NextIndex_: 0,
+			Created_: now,
+			Updated_: now,
+		}
+
+		if _, err := m.db.Collection("seeds").InsertOne(ctx, &s); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed already exists for account %s", account))
+			}
+			return nil, err
+		} else {
+			return &s, nil
+		}
+	}
+}
+
+func (m *mongoStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	if dataEncryptingKey, err := DataEncryptingKeyIDFromString(src.DataEncryptingKey()); err != nil {
+		return nil, err
+	} else {
+		s := seed{
+			Account_: src.Account(),
+			DataEncryptingKey_: dataEncryptingKey,
+			EncryptedSeed_: src.EncryptedSeed(),
+			NextIndex_: src.NextIndex(),
+			Created_: src.Created(),
+			Updated_: src.Updated(),
+		}
+
+		if _, err := m.db.Collection("seeds").ReplaceOne(ctx, bson.M{"_id": s.Account_}, &s, options.Replace().SetUpsert(true)); err != nil {
+			return nil, err
+		} else {
+			return &s, nil
+		}
+	}
+}
+
+func (m *mongoStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	var s seed
+
+	if err := m.db.Collection("seeds").FindOne(ctx, bson.M{"_id": account}).Decode(&s); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("seed not found for account %s", account))
+		}
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (m *mongoStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var r listSeedsResult
+
+	if total, err := m.db.Collection("seeds").CountDocuments(ctx, bson.M{}); err != nil {
+		return nil, err
+	} else if cursor, err := m.db.Collection("seeds").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}).SetSkip(offset).SetLimit(count)); err != nil {
+		return nil, err
+	} else if err := cursor.All(ctx, &r.Page_); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
+		return &r, nil
+	}
+}
+
+func (m *mongoStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	var s seed
+
+	if err := m.db.Collection("seeds").FindOneAndUpdate(ctx, bson.M{"_id": account}, bson.M{"$inc": bson.M{"nextIndex": count}, "$set": bson.M{"updated": time.Now()}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&s); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("seed not found for account %s", account))
+		}
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (m *mongoStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	if _from, err := DataEncryptingKeyIDFromString(from); err != nil {
+		return nil, err
+	} else if _to, err := DataEncryptingKeyIDFromString(to); err != nil {
+		return nil, err
+	} else if _, err := m.GetDataEncryptingKey(ctx, to); err != nil {
+		return nil, err
+	} else if result, err := m.db.Collection("seeds").UpdateOne(ctx, bson.M{"_id": account, "dataEncryptingKey": _from}, bson.M{"$set": bson.M{"updated": time.Now(), "dataEncryptingKey": _to, "encryptedSeed": encryptedSeed}}); err != nil {
+		return nil, err
+	} else if s, err := m.GetSeed(ctx, account); err != nil {
+		return nil, err
+	} else if result.MatchedCount == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed data encrypting key for account %s is no longer %s", account, from))
+	} else {
+		return s, nil
+	}
+}
@@ -0,0 +1,99 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type issuedSignature struct {
+	ID_ string `bson:"_id"`
+	Account_ interfaces.ID `bson:"account"`
+	Wallet_ common.Address `bson:"wallet"`
+	Sender_ common.Address `bson:"sender"`
+	Uniq_ common.Hash `bson:"uniq"`
+	Signature_ []byte `bson:"signature"`
+	Created_ time.Time `bson:"created"`
+	Expires_ time.Time `bson:"expires"`
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return s.Wallet_
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return s.Sender_
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return s.Uniq_
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+func issuedSignatureID(wallet common.Address, sender common.Address, uniq common.Hash) string {
+	return wallet.Hex() + ":" + sender.Hex() + ":" + uniq.Hex()
+}
+
+func (m *mongoStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	s := issuedSignature{
+		ID_: issuedSignatureID(wallet, sender, uniq),
+		Account_: account,
+		Wallet_: wallet,
+		Sender_: sender,
+		Uniq_: uniq,
+		Signature_: signature,
+		Created_: now,
+		Expires_: now.Add(ttl),
+	}
+
+	// replaces an expired signature the TTL index has not removed yet, the
+	// upsert fails with a duplicate key if an unexpired one exists
+	if _, err := m.db.Collection("signatures").ReplaceOne(ctx, bson.M{"_id": s.ID_, "expires": bson.M{"$lte": now}}, &s, options.Replace().SetUpsert(true)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("signature already issued for uniq %s", uniq))
+		}
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (m *mongoStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	var s issuedSignature
+
+	if err := m.db.Collection("signatures").FindOne(ctx, bson.M{"_id": issuedSignatureID(wallet, sender, uniq), "account": account, "expires": bson.M{"$gt": time.Now()}}).Decode(&s); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("signature not found for uniq %s", uniq))
+		}
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
@@ -55,6 +55,7 @@ type wallet struct {
 	Address_ common.Address `bson:"address"`
 	DataEncryptingKey_ DataEncryptingKeyID `bson:"dataEncryptingKey"`
 	EncryptedPrivateKey_ []byte `bson:"encryptedPrivateKey"`
+	Permissions_ []string `bson:"permissions,omitempty"`
 	Created_ time.Time `bson:"created"`
 	Updated_ time.Time `bson:"updated"`
 	Expires_ *time.Time `bson:"expires,omitempty"`
@@ -86,6 +87,10 @@ func (w *wallet) EncryptedPrivateKey() []byte {
 	return w.EncryptedPrivateKey_
 }
 
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
 func (w *wallet) Created() time.Time {
 	return w.Created_
 }
@@ -144,6 +149,39 @@ func (m *mongoStorageBackend) CreateWallet(ctx context.Context, account interfac
 		}
 
 		if _, err := m.db.Collection("wallets").InsertOne(ctx, &w); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+			}
+			return nil, err
+		} else {
+			return &w, nil
+		}
+	}
+}
+
+func (m *mongoStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	if id, err := WalletIDFromString(src.ID()); err != nil {
+		return nil, err
+	} else if dataEncryptingKey, err := DataEncryptingKeyIDFromString(src.DataEncryptingKey()); err != nil {
+		return nil, err
+	} else {
+		w := wallet{
+			ID_: id,
+			Account_: src.Account(),
+			Name_: src.Name(),
+			Address_: src.Address(),
+			DataEncryptingKey_: dataEncryptingKey,
+			EncryptedPrivateKey_: src.EncryptedPrivateKey(),
+			Permissions_: src.Permissions(),
+			Created_: src.Created(),
+			Updated_: src.Updated(),
+			Expires_: src.Expires(),
+		}
+
+		if _, err := m.db.Collection("wallets").ReplaceOne(ctx, bson.M{"_id": id}, &w, options.Replace().SetUpsert(true)); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", w.Address_))
+			}
 			return nil, err
 		} else {
 			return &w, nil
@@ -171,14 +209,31 @@ func (m *mongoStorageBackend) ListWallets(ctx context.Context, account interface
 
 	filter["account"] = account
 
-	if count, err := m.db.Collection("wallets").CountDocuments(ctx, filter); err != nil {
+	if total, err := m.db.Collection("wallets").CountDocuments(ctx, filter); err != nil {
 		return nil, err
 	} else if cursor, err := m.db.Collection("wallets").Find(ctx, filter, options.Find().SetSkip(offset).SetLimit(count)); err != nil {
 		return nil, err
 	} else if err := cursor.All(ctx, &r.Page_); err != nil {
 		return nil, err
 	} else {
-		r.Count_ = count
+		r.Count_ = total
+		return &r, nil
+	}
+}
+
+// ListAllWallets lists wallets of every account in address order, which is
+// covered by the unique address index.
+func (m *mongoStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+
+	if total, err := m.db.Collection("wallets").CountDocuments(ctx, bson.M{}); err != nil {
+		return nil, err
+	} else if cursor, err := m.db.Collection("wallets").Find(ctx, bson.M{"address": bson.M{"$gt": after}}, options.Find().SetSort(bson.M{"address": 1}).SetLimit(count)); err != nil {
+		return nil, err
+	} else if err := cursor.All(ctx, &r.Page_); err != nil {
+		return nil, err
+	} else {
+		r.Count_ = total
 		return &r, nil
 	}
 }
@@ -191,6 +246,32 @@ func (m *mongoStorageBackend) UpdateWallet(ctx context.Context, account interfac
 	}
 }
 
+func (m *mongoStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	if _, err := m.db.Collection("wallets").UpdateOne(ctx, bson.M{"account": account, "address": address}, bson.M{"$set": bson.M{"updated": time.Now(), "permissions": permissions}}); err != nil {
+		return nil, err
+	} else {
+		return m.GetWallet(ctx, account, address)
+	}
+}
+
+func (m *mongoStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	if _from, err := DataEncryptingKeyIDFromString(from); err != nil {
+		return nil, err
+	} else if _to, err := DataEncryptingKeyIDFromString(to); err != nil {
+		return nil, err
+	} else if _, err := m.GetDataEncryptingKey(ctx, to); err != nil {
+		return nil, err
+	} else if result, err := m.db.Collection("wallets").UpdateOne(ctx, bson.M{"account": account, "address": address, "dataEncryptingKey": _from}, bson.M{"$set": bson.M{"updated": time.Now(), "dataEncryptingKey": _to, "encryptedPrivateKey": encryptedPrivateKey}}); err != nil {
+		return nil, err
+	} else if w, err := m.GetWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if result.MatchedCount == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s data encrypting key is no longer %s", address, from))
+	} else {
+		return w, nil
+	}
+}
+
 func (m *mongoStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
 	if _, err := m.db.Collection("wallets").UpdateOne(ctx, bson.M{"account": account, "address": address}, bson.M{"$set": bson.M{"updated": time.Now(), "expires": time.Now().Add(ttl)}}); err != nil {
 		return nil, err
@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/jackc/pgx/v5"
+)
+
+type dataEncryptingKey struct {
+	backend *postgresStorageBackend
+	ID_ interfaces.ID `db:"id"`
+	KeyEncryptingKey_ interfaces.ID `db:"key_encrypting_key"`
+	EncryptedKey_ []byte `db:"encrypted_key"`
+	Expires_ *time.Time `db:"expires"`
+}
+
+var _ interfaces.DataEncryptingKey = &dataEncryptingKey{}
+
+const dataEncryptingKeyColumns = "id, key_encrypting_key, encrypted_key, expires"
+
+func (k *dataEncryptingKey) ID() interfaces.ID {
+	return k.ID_
+}
+
+func (k *dataEncryptingKey) KeyEncryptingKey() interfaces.ID {
+	return k.KeyEncryptingKey_
+}
+
+func (k *dataEncryptingKey) EncryptedKey() []byte {
+	return k.EncryptedKey_
+}
+
+func (k *dataEncryptingKey) Expires() *time.Time {
+	return k.Expires_
+}
+
+func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
+	var count int64
+
+	if err := k.backend.pool.QueryRow(ctx, `
+		SELECT (SELECT count(*) FROM wallets WHERE data_encrypting_key = $1 AND `+notExpired+`)
+			+ (SELECT count(*) FROM seeds WHERE data_encrypting_key = $1)`,
+		k.ID_,
+	).Scan(&count); err != nil {
+		return 0, err
+	} else {
+		return count, nil
+	}
+}
+
+type listDataEncryptingKeysResult struct {
+	Count_ int64
+	Page_ []*dataEncryptingKey
+}
+
+var _ interfaces.ListDataEncryptingKeysResult = &listDataEncryptingKeysResult{}
+
+func (r *listDataEncryptingKeysResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listDataEncryptingKeysResult) Page() []interfaces.DataEncryptingKey {
+	out := make([]interfaces.DataEncryptingKey, len(r.Page_))
+	for i, k := range r.Page_ {
+		out[i] = k
+	}
+	return out
+}
+
+func (b *postgresStorageBackend) collectDataEncryptingKeys(rows pgx.Rows) ([]*dataEncryptingKey, error) {
+	if keys, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[dataEncryptingKey]); err != nil {
+		return nil, err
+	} else {
+		for _, k := range keys {
+			k.backend = b
+		}
+		return keys, nil
+	}
+}
+
+func (b *postgresStorageBackend) queryDataEncryptingKey(ctx context.Context, sql string, args ...any) (*dataEncryptingKey, error) {
+	if rows, err := b.pool.Query(ctx, sql, args...); err != nil {
+		return nil, err
+	} else if k, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[dataEncryptingKey]); err != nil {
+		return nil, err
+	} else {
+		k.backend = b
+		return k, nil
+	}
+}
+
+func (b *postgresStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (interfaces.DataEncryptingKey, error) {
+	// keys scheduled to expire are not handed out to new wallets
+	if k, err := b.queryDataEncryptingKey(ctx, `
+		SELECT `+dataEncryptingKeyColumns+` FROM data_encrypting_keys k
+		WHERE k.expires IS NULL
+		AND (
+			SELECT count(*) FROM wallets w
+			WHERE w.data_encrypting_key = k.id AND (w.expires IS NULL OR w.expires > now())
+		) + (
+			SELECT count(*) FROM seeds s WHERE s.data_encrypting_key = k.id
+		) < $1
+		ORDER BY random()
+		LIMIT 1
+	`, maxRefCount); errors.Is(err, pgx.ErrNoRows) {
+		return b.vault.CreateDataEncryptingKey(ctx)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+func (b *postgresStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: id,
+		KeyEncryptingKey_: keyEncryptingKey,
+		EncryptedKey_: encryptedKey,
+		Expires_: nil,
+	}
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO data_encrypting_keys (id, key_encrypting_key, encrypted_key) VALUES ($1, $2, $3)
+	`, k.ID_, k.KeyEncryptingKey_, k.EncryptedKey_); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (b *postgresStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: b,
+		ID_: src.ID(),
+		KeyEncryptingKey_: src.KeyEncryptingKey(),
+		EncryptedKey_: src.EncryptedKey(),
+		Expires_: src.Expires(),
+	}
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO data_encrypting_keys (id, key_encrypting_key, encrypted_key, expires) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET key_encrypting_key = $2, encrypted_key = $3, expires = $4
+	`, k.ID_, k.KeyEncryptingKey_, k.EncryptedKey_, k.Expires_); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (b *postgresStorageBackend) GetDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if k, err := b.queryDataEncryptingKey(ctx, `
+		SELECT `+dataEncryptingKeyColumns+` FROM data_encrypting_keys WHERE id = $1 AND `+notExpired,
+		id,
+	); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+func (b *postgresStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	var r listDataEncryptingKeysResult
+
+	if err := b.pool.QueryRow(ctx, `SELECT count(*) FROM data_encrypting_keys WHERE `+notExpired).Scan(&r.Count_); err != nil {
+		return nil, err
+	} else if rows, err := b.pool.Query(ctx, `
+		SELECT `+dataEncryptingKeyColumns+` FROM data_encrypting_keys WHERE `+notExpired+` AND id > $1
+		ORDER BY id
+		LIMIT $2
+	`, after, limit(count)); err != nil {
+		return nil, err
+	} else if keys, err := b.collectDataEncryptingKeys(rows); err != nil {
+		return nil, err
+	} else {
+		r.Page_ = keys
+		return &r, nil
+	}
+}
+
+func (b *postgresStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	if k, err := b.queryDataEncryptingKey(ctx, `
+		UPDATE data_encrypting_keys SET key_encrypting_key = $3, encrypted_key = $4
+		WHERE id = $1 AND key_encrypting_key = $2 AND `+notExpired+`
+		RETURNING `+dataEncryptingKeyColumns,
+		id, from, keyEncryptingKey, encryptedKey,
+	); errors.Is(err, pgx.ErrNoRows) {
+		// either the key does not exist or it was rewrapped concurrently
+		if _, err := b.GetDataEncryptingKey(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("data encrypting key %s is no longer wrapped by %s", id, from))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+func (b *postgresStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	if k, err := b.queryDataEncryptingKey(ctx, `
+		UPDATE data_encrypting_keys SET expires = $2 WHERE id = $1 AND `+notExpired+`
+		RETURNING `+dataEncryptingKeyColumns,
+		id, time.Now().Add(ttl),
+	); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
+
+func (b *postgresStorageBackend) UnexpireDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if k, err := b.queryDataEncryptingKey(ctx, `
+		UPDATE data_encrypting_keys SET expires = NULL WHERE id = $1 AND `+notExpired+`
+		RETURNING `+dataEncryptingKeyColumns,
+		id,
+	); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError("data encrypting key %s not found", id)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return k, nil
+	}
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock is the advisory lock held while migrating, so that vaults
+// starting concurrently against the same database apply each migration once.
+const migrationLock = 0x5369676e636861
+
+type migration struct {
+	Version int
+	Name string
+	SQL string
+}
+
+func loadMigrations() ([]migration, error) {
+	var out []migration
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration name: %s", name)
+		} else if version, err := strconv.Atoi(prefix); err != nil {
+			return nil, fmt.Errorf("invalid migration version: %s", name)
+		} else if sql, err := migrations.ReadFile(path.Join("migrations", name)); err != nil {
+			return nil, err
+		} else {
+			out = append(out, migration{Version: version, Name: name, SQL: string(sql)})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Version < out[j].Version
+	})
+
+	for i := 1; i < len(out); i++ {
+		if out[i].Version == out[i - 1].Version {
+			return nil, fmt.Errorf("duplicate migration version: %d", out[i].Version)
+		}
+	}
+
+	return out, nil
+}
+
+// Migrate applies embedded migrations that have not yet been recorded in
+// schema_migrations, each in its own transaction.
+func (b *postgresStorageBackend) Migrate(ctx context.Context) error {
+	ms, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`); err != nil {
+		return err
+	}
+
+	applied := map[int]bool{}
+	if rows, err := conn.Query(ctx, `SELECT version FROM schema_migrations`); err != nil {
+		return err
+	} else if versions, err := pgx.CollectRows(rows, pgx.RowTo[int]); err != nil {
+		return err
+	} else {
+		for _, v := range versions {
+			applied[v] = true
+		}
+	}
+
+	for _, m := range ms {
+		if applied[m.Version] {
+			continue
+		}
+
+		if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %s: %v", m.Name, err)
+		}
+
+		log.Infof("applied postgres migration %s", m.Name)
+	}
+
+	return nil
+}
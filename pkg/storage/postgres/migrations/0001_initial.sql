@@ -0,0 +1,27 @@
+CREATE TABLE data_encrypting_keys (
+	id TEXT PRIMARY KEY,
+	key_encrypting_key TEXT NOT NULL,
+	encrypted_key BYTEA NOT NULL,
+	created TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires TIMESTAMPTZ
+);
+
+CREATE INDEX data_encrypting_keys_created ON data_encrypting_keys (created, id);
+CREATE INDEX data_encrypting_keys_expires ON data_encrypting_keys (expires) WHERE expires IS NOT NULL;
+
+CREATE TABLE wallets (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	address TEXT NOT NULL,
+	data_encrypting_key TEXT NOT NULL REFERENCES data_encrypting_keys (id),
+	encrypted_private_key BYTEA NOT NULL,
+	created TIMESTAMPTZ NOT NULL,
+	updated TIMESTAMPTZ NOT NULL,
+	expires TIMESTAMPTZ,
+	CONSTRAINT wallets_address_unique UNIQUE (address)
+);
+
+CREATE INDEX wallets_account_created ON wallets (account, created, id);
+CREATE INDEX wallets_data_encrypting_key ON wallets (data_encrypting_key);
+CREATE INDEX wallets_expires ON wallets (expires) WHERE expires IS NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE seeds (
+	account TEXT PRIMARY KEY,
+	data_encrypting_key TEXT NOT NULL REFERENCES data_encrypting_keys (id),
+	encrypted_seed BYTEA NOT NULL,
+	next_index BIGINT NOT NULL DEFAULT 0,
+	created TIMESTAMPTZ NOT NULL,
+	updated TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX seeds_data_encrypting_key ON seeds (data_encrypting_key);
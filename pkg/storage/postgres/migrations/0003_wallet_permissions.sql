@@ -0,0 +1 @@
+ALTER TABLE wallets ADD COLUMN permissions TEXT[];
@@ -0,0 +1,12 @@
+CREATE TABLE issued_signatures (
+	account TEXT NOT NULL,
+	wallet TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	uniq TEXT NOT NULL,
+	signature BYTEA NOT NULL,
+	created TIMESTAMPTZ NOT NULL,
+	expires TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (wallet, sender, uniq)
+);
+
+CREATE INDEX issued_signatures_expires ON issued_signatures (expires);
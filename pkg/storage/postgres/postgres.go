@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresStorageBackend struct {
+	pool *pgxpool.Pool
+	vault interfaces.IVaultService
+}
+
+var _ interfaces.IStorageBackend = &postgresStorageBackend{}
+
+func NewPostgresStorageBackend(vault interfaces.IVaultService) (interfaces.IStorageBackend, error) {
+	b := &postgresStorageBackend{vault: vault}
+
+	purgeInterval := time.Minute
+	if s := strings.TrimSpace(os.Getenv("VAULT_POSTGRES_PURGE_INTERVAL")); s != "" {
+		if d, err := time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid VAULT_POSTGRES_PURGE_INTERVAL: %v", err)
+		} else {
+			purgeInterval = d
+		}
+	}
+
+	if pool, err := pgxpool.New(context.Background(), os.Getenv("VAULT_POSTGRES_URL")); err != nil {
+		return nil, err
+	} else {
+		b.pool = pool
+	}
+
+	if err := b.Migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("unable to migrate postgres schema: %v", err)
+	}
+
+	go b.purgeLoop(purgeInterval)
+
+	return b, nil
+}
+
+// Postgres has no native TTL, so expired rows are excluded by every query and
+// deleted periodically. Keys are only deleted once no wallet or seed
+// references them.
+func (b *postgresStorageBackend) Purge(ctx context.Context) error {
+	if _, err := b.pool.Exec(ctx, `DELETE FROM wallets WHERE expires <= now()`); err != nil {
+		return err
+	} else if _, err := b.pool.Exec(ctx, `DELETE FROM issued_signatures WHERE expires <= now()`); err != nil {
+		return err
+	} else if _, err := b.pool.Exec(ctx, `
+		DELETE FROM data_encrypting_keys k
+		WHERE k.expires <= now()
+		AND NOT EXISTS (SELECT 1 FROM wallets w WHERE w.data_encrypting_key = k.id)
+		AND NOT EXISTS (SELECT 1 FROM seeds s WHERE s.data_encrypting_key = k.id)
+	`); err != nil {
+		return err
+	} else {
+		return nil
+	}
+}
+
+func (b *postgresStorageBackend) purgeLoop(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := b.Purge(context.Background()); err != nil {
+			log.Warnf("unable to purge expired rows: %v", err)
+		}
+	}
+}
+
+const notExpired = "(expires IS NULL OR expires > now())"
+
+const (
+	uniqueViolation = "23505"
+	foreignKeyViolation = "23503"
+)
+
+func pgErrorCode(err error) string {
+	var e *pgconn.PgError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+func notFoundError(format string, args ...any) error {
+	return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf(format, args...))
+}
+
+// limit converts a page size into a LIMIT argument, where NULL means no limit.
+func limit(count int64) *int64 {
+	if count <= 0 {
+		return nil
+	}
+	return &count
+}
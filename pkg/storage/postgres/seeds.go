@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/jackc/pgx/v5"
+)
+
+type seed struct {
+	Account_ interfaces.ID `db:"account"`
+	DataEncryptingKey_ interfaces.ID `db:"data_encrypting_key"`
+	EncryptedSeed_ []byte `db:"encrypted_seed"`
+	NextIndex_ int64 `db:"next_index"`
+	Created_ time.Time `db:"created"`
+	Updated_ time.Time `db:"updated"`
+}
+
+var _ interfaces.Seed = &seed{}
+
+const seedColumns = "account, data_encrypting_key, encrypted_seed, next_index, created, updated"
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func (b *postgresStorageBackend) querySeed(ctx context.Context, account interfaces.ID, sql string, args ...any) (*seed, error) {
+	if rows, err := b.pool.Query(ctx, sql, args...); err != nil {
+		return nil, err
+	} else if s, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[seed]); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError("seed not found for account %s", account)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+func (b *postgresStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	s := seed{
+		Account_: account,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedSeed_: encryptedSeed,
+		NextIndex_: 0,
+		Created_: now,
+		Updated_: now,
+	}
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO seeds (`+seedColumns+`) VALUES ($1, $2, $3, $4, $5, $6)
+	`, s.Account_, s.DataEncryptingKey_, s.EncryptedSeed_, s.NextIndex_, s.Created_, s.Updated_); err != nil {
+		switch pgErrorCode(err) {
+		case uniqueViolation:
+			return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed already exists for account %s", account))
+		case foreignKeyViolation:
+			return nil, notFoundError("data encrypting key %s not found", dataEncryptingKey)
+		default:
+			return nil, err
+		}
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *postgresStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	s := seed{
+		Account_: src.Account(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedSeed_: src.EncryptedSeed(),
+		NextIndex_: src.NextIndex(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+	}
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO seeds (`+seedColumns+`) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (account) DO UPDATE SET
+			data_encrypting_key = $2, encrypted_seed = $3, next_index = $4, created = $5, updated = $6
+	`, s.Account_, s.DataEncryptingKey_, s.EncryptedSeed_, s.NextIndex_, s.Created_, s.Updated_); pgErrorCode(err) == foreignKeyViolation {
+		return nil, notFoundError("data encrypting key %s not found", s.DataEncryptingKey_)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return &s, nil
+	}
+}
+
+func (b *postgresStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	return b.querySeed(ctx, account, `SELECT `+seedColumns+` FROM seeds WHERE account = $1`, account)
+}
+
+// ListAllSeeds lists seeds in account order.
+func (b *postgresStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var r listSeedsResult
+
+	if err := b.pool.QueryRow(ctx, `SELECT count(*) FROM seeds`).Scan(&r.Count_); err != nil {
+		return nil, err
+	} else if rows, err := b.pool.Query(ctx, `
+		SELECT `+seedColumns+` FROM seeds
+		ORDER BY account
+		OFFSET $1 LIMIT $2
+	`, offset, limit(count)); err != nil {
+		return nil, err
+	} else if seeds, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[seed]); err != nil {
+		return nil, err
+	} else {
+		r.Page_ = seeds
+		return &r, nil
+	}
+}
+
+func (b *postgresStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	return b.querySeed(ctx, account, `
+		UPDATE seeds SET next_index = next_index + $2, updated = now() WHERE account = $1
+		RETURNING `+seedColumns,
+		account, count,
+	)
+}
+
+func (b *postgresStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	var e *fiber.Error
+
+	if s, err := b.querySeed(ctx, account, `
+		UPDATE seeds SET data_encrypting_key = $3, encrypted_seed = $4, updated = now()
+		WHERE account = $1 AND data_encrypting_key = $2
+		RETURNING `+seedColumns,
+		account, from, to, encryptedSeed,
+	); pgErrorCode(err) == foreignKeyViolation {
+		return nil, notFoundError("data encrypting key %s not found", to)
+	} else if errors.As(err, &e) && e.Code == fiber.StatusNotFound {
+		// either the seed does not exist or it was rotated concurrently
+		if _, err := b.GetSeed(ctx, account); err != nil {
+			return nil, err
+		}
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("seed data encrypting key for account %s is no longer %s", account, from))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/jackc/pgx/v5"
+)
+
+type issuedSignature struct {
+	Account_ interfaces.ID `db:"account"`
+	Wallet_ string `db:"wallet"`
+	Sender_ string `db:"sender"`
+	Uniq_ string `db:"uniq"`
+	Signature_ []byte `db:"signature"`
+	Created_ time.Time `db:"created"`
+	Expires_ time.Time `db:"expires"`
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+const issuedSignatureColumns = "account, wallet, sender, uniq, signature, created, expires"
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return common.HexToAddress(s.Wallet_)
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return common.HexToAddress(s.Sender_)
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return common.HexToHash(s.Uniq_)
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+func (b *postgresStorageBackend) queryIssuedSignature(ctx context.Context, sql string, args ...any) (*issuedSignature, error) {
+	if rows, err := b.pool.Query(ctx, sql, args...); err != nil {
+		return nil, err
+	} else {
+		return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[issuedSignature])
+	}
+}
+
+func (b *postgresStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	// an expired row which has not been purged yet is replaced
+	if s, err := b.queryIssuedSignature(ctx, `
+		INSERT INTO issued_signatures (`+issuedSignatureColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (wallet, sender, uniq) DO UPDATE SET account = $1, signature = $5, created = $6, expires = $7
+		WHERE issued_signatures.expires <= now()
+		RETURNING `+issuedSignatureColumns,
+		account, wallet.Hex(), sender.Hex(), uniq.Hex(), signature, now, now.Add(ttl),
+	); errors.Is(err, pgx.ErrNoRows) {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("signature already issued for uniq %s", uniq))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+func (b *postgresStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	if s, err := b.queryIssuedSignature(ctx, `
+		SELECT `+issuedSignatureColumns+` FROM issued_signatures
+		WHERE account = $1 AND wallet = $2 AND sender = $3 AND uniq = $4 AND expires > now()`,
+		account, wallet.Hex(), sender.Hex(), uniq.Hex(),
+	); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError("signature not found for uniq %s", uniq)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
@@ -0,0 +1,279 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+	"github.com/jackc/pgx/v5"
+)
+
+type wallet struct {
+	ID_ interfaces.ID `db:"id"`
+	Account_ interfaces.ID `db:"account"`
+	Name_ string `db:"name"`
+	Address_ string `db:"address"`
+	DataEncryptingKey_ interfaces.ID `db:"data_encrypting_key"`
+	EncryptedPrivateKey_ []byte `db:"encrypted_private_key"`
+	Permissions_ []string `db:"permissions"`
+	Created_ time.Time `db:"created"`
+	Updated_ time.Time `db:"updated"`
+	Expires_ *time.Time `db:"expires"`
+}
+
+var _ interfaces.Wallet = &wallet{}
+
+const walletColumns = "id, account, name, address, data_encrypting_key, encrypted_private_key, created, updated, expires, permissions"
+
+func (w *wallet) ID() interfaces.ID {
+	return w.ID_
+}
+
+func (w *wallet) Name() string {
+	return w.Name_
+}
+
+func (w *wallet) Account() interfaces.ID {
+	return w.Account_
+}
+
+func (w *wallet) Address() common.Address {
+	return common.HexToAddress(w.Address_)
+}
+
+func (w *wallet) DataEncryptingKey() interfaces.ID {
+	return w.DataEncryptingKey_
+}
+
+func (w *wallet) EncryptedPrivateKey() []byte {
+	return w.EncryptedPrivateKey_
+}
+
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
+func (w *wallet) Created() time.Time {
+	return w.Created_
+}
+
+func (w *wallet) Updated() time.Time {
+	return w.Updated_
+}
+
+func (w *wallet) Expires() *time.Time {
+	return w.Expires_
+}
+
+type listWalletsResult struct {
+	Count_ int64
+	Page_ []*wallet
+}
+
+var _ interfaces.ListWalletsResult = &listWalletsResult{}
+
+func (r *listWalletsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listWalletsResult) Page() []interfaces.Wallet {
+	out := make([]interfaces.Wallet, len(r.Page_))
+	for i, w := range r.Page_ {
+		out[i] = w
+	}
+	return out
+}
+
+func walletNotFound(account interfaces.ID, address common.Address) error {
+	return notFoundError("wallet %s not found for account %s", address, account)
+}
+
+func (b *postgresStorageBackend) queryWallet(ctx context.Context, account interfaces.ID, address common.Address, sql string, args ...any) (*wallet, error) {
+	if rows, err := b.pool.Query(ctx, sql, args...); err != nil {
+		return nil, err
+	} else if w, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[wallet]); errors.Is(err, pgx.ErrNoRows) {
+		return nil, walletNotFound(account, address)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return w, nil
+	}
+}
+
+func (b *postgresStorageBackend) CreateWallet(ctx context.Context, account interfaces.ID, name string, address common.Address, dataEncryptingKey interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	now := time.Now()
+
+	w := wallet{
+		ID_: anonymize.NewIDWithPrefix("wlt"),
+		Account_: account,
+		Name_: name,
+		Address_: address.Hex(),
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedPrivateKey_: encryptedPrivateKey,
+		Created_: now,
+		Updated_: now,
+		Expires_: nil,
+	}
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO wallets (id, account, name, address, data_encrypting_key, encrypted_private_key, created, updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, w.ID_, w.Account_, w.Name_, w.Address_, w.DataEncryptingKey_, w.EncryptedPrivateKey_, w.Created_, w.Updated_); err != nil {
+		switch pgErrorCode(err) {
+		case uniqueViolation:
+			return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", address))
+		case foreignKeyViolation:
+			return nil, notFoundError("data encrypting key %s not found", dataEncryptingKey)
+		default:
+			return nil, err
+		}
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *postgresStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	w := wallet{
+		ID_: src.ID(),
+		Account_: src.Account(),
+		Name_: src.Name(),
+		Address_: src.Address().Hex(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedPrivateKey_: src.EncryptedPrivateKey(),
+		Permissions_: src.Permissions(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+		Expires_: src.Expires(),
+	}
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO wallets (`+walletColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET
+			account = $2, name = $3, address = $4, data_encrypting_key = $5, encrypted_private_key = $6,
+			created = $7, updated = $8, expires = $9, permissions = $10
+	`, w.ID_, w.Account_, w.Name_, w.Address_, w.DataEncryptingKey_, w.EncryptedPrivateKey_, w.Created_, w.Updated_, w.Expires_, w.Permissions_); err != nil {
+		switch pgErrorCode(err) {
+		case uniqueViolation:
+			return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s already exists", w.Address_))
+		case foreignKeyViolation:
+			return nil, notFoundError("data encrypting key %s not found", w.DataEncryptingKey_)
+		default:
+			return nil, err
+		}
+	} else {
+		return &w, nil
+	}
+}
+
+func (b *postgresStorageBackend) GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return b.queryWallet(ctx, account, address, `
+		SELECT `+walletColumns+` FROM wallets WHERE account = $1 AND address = $2 AND `+notExpired,
+		account, address.Hex(),
+	)
+}
+
+func (b *postgresStorageBackend) ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+
+	if err := b.pool.QueryRow(ctx, `SELECT count(*) FROM wallets WHERE account = $1 AND `+notExpired, account).Scan(&r.Count_); err != nil {
+		return nil, err
+	} else if rows, err := b.pool.Query(ctx, `
+		SELECT `+walletColumns+` FROM wallets WHERE account = $1 AND `+notExpired+`
+		ORDER BY created, id
+		OFFSET $2 LIMIT $3
+	`, account, offset, limit(count)); err != nil {
+		return nil, err
+	} else if wallets, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[wallet]); err != nil {
+		return nil, err
+	} else {
+		r.Page_ = wallets
+		return &r, nil
+	}
+}
+
+// ListAllWallets lists wallets of every account in address order, which is
+// indexed by the unique address constraint.
+func (b *postgresStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var r listWalletsResult
+	var cursor string
+
+	if after != (common.Address{}) {
+		cursor = after.Hex()
+	}
+
+	if err := b.pool.QueryRow(ctx, `SELECT count(*) FROM wallets WHERE `+notExpired).Scan(&r.Count_); err != nil {
+		return nil, err
+	} else if rows, err := b.pool.Query(ctx, `
+		SELECT `+walletColumns+` FROM wallets WHERE `+notExpired+` AND address > $1
+		ORDER BY address
+		LIMIT $2
+	`, cursor, limit(count)); err != nil {
+		return nil, err
+	} else if wallets, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[wallet]); err != nil {
+		return nil, err
+	} else {
+		r.Page_ = wallets
+		return &r, nil
+	}
+}
+
+func (b *postgresStorageBackend) UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (interfaces.Wallet, error) {
+	return b.queryWallet(ctx, account, address, `
+		UPDATE wallets SET name = $3, updated = now() WHERE account = $1 AND address = $2 AND `+notExpired+`
+		RETURNING `+walletColumns,
+		account, address.Hex(), name,
+	)
+}
+
+func (b *postgresStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	return b.queryWallet(ctx, account, address, `
+		UPDATE wallets SET permissions = $3, updated = now() WHERE account = $1 AND address = $2 AND `+notExpired+`
+		RETURNING `+walletColumns,
+		account, address.Hex(), permissions,
+	)
+}
+
+func (b *postgresStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	var e *fiber.Error
+
+	if w, err := b.queryWallet(ctx, account, address, `
+		UPDATE wallets SET data_encrypting_key = $4, encrypted_private_key = $5, updated = now()
+		WHERE account = $1 AND address = $2 AND data_encrypting_key = $3 AND `+notExpired+`
+		RETURNING `+walletColumns,
+		account, address.Hex(), from, to, encryptedPrivateKey,
+	); pgErrorCode(err) == foreignKeyViolation {
+		return nil, notFoundError("data encrypting key %s not found", to)
+	} else if errors.As(err, &e) && e.Code == fiber.StatusNotFound {
+		// either the wallet does not exist or it was rotated concurrently
+		if _, err := b.GetWallet(ctx, account, address); err != nil {
+			return nil, err
+		}
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("wallet %s data encrypting key is no longer %s", address, from))
+	} else if err != nil {
+		return nil, err
+	} else {
+		return w, nil
+	}
+}
+
+func (b *postgresStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
+	return b.queryWallet(ctx, account, address, `
+		UPDATE wallets SET expires = $3, updated = now() WHERE account = $1 AND address = $2 AND `+notExpired+`
+		RETURNING `+walletColumns,
+		account, address.Hex(), time.Now().Add(ttl),
+	)
+}
+
+func (b *postgresStorageBackend) UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return b.queryWallet(ctx, account, address, `
+		UPDATE wallets SET expires = NULL, updated = now() WHERE account = $1 AND address = $2 AND `+notExpired+`
+		RETURNING `+walletColumns,
+		account, address.Hex(),
+	)
+}
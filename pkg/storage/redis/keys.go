@@ -0,0 +1,243 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/redis/go-redis/v9"
+)
+
+type dataEncryptingKey struct {
+	backend *redisStorageBackend
+	ID_ interfaces.ID
+	KeyEncryptingKey_ interfaces.ID
+	EncryptedKey_ []byte
+	Expires_ *time.Time
+}
+
+var _ interfaces.DataEncryptingKey = &dataEncryptingKey{}
+
+func (k *dataEncryptingKey) ID() interfaces.ID {
+	return k.ID_
+}
+
+func (k *dataEncryptingKey) KeyEncryptingKey() interfaces.ID {
+	return k.KeyEncryptingKey_
+}
+
+func (k *dataEncryptingKey) EncryptedKey() []byte {
+	return k.EncryptedKey_
+}
+
+func (k *dataEncryptingKey) Expires() *time.Time {
+	return k.Expires_
+}
+
+func (k *dataEncryptingKey) RefCount(ctx context.Context) (int64, error) {
+	r := k.backend
+
+	if err := r.prune(ctx); err != nil {
+		return 0, err
+	} else if count, err := r.client.ZScore(ctx, r.key("keys", "refcount"), k.ID_).Result(); errors.Is(err, redis.Nil) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	} else {
+		return int64(count), nil
+	}
+}
+
+func (k *dataEncryptingKey) fields() map[string]any {
+	f := map[string]any{
+		"id": k.ID_,
+		"keyEncryptingKey": k.KeyEncryptingKey_,
+		"encryptedKey": k.EncryptedKey_,
+	}
+	if k.Expires_ != nil {
+		f["expires"] = formatTime(*k.Expires_)
+	}
+	return f
+}
+
+func (r *redisStorageBackend) decodeDataEncryptingKey(h map[string]string) (*dataEncryptingKey, error) {
+	if expires, err := parseOptionalTime(h["expires"]); err != nil {
+		return nil, err
+	} else {
+		return &dataEncryptingKey{
+			backend: r,
+			ID_: h["id"],
+			KeyEncryptingKey_: h["keyEncryptingKey"],
+			EncryptedKey_: []byte(h["encryptedKey"]),
+			Expires_: expires,
+		}, nil
+	}
+}
+
+type listDataEncryptingKeysResult struct {
+	Count_ int64
+	Page_ []*dataEncryptingKey
+}
+
+var _ interfaces.ListDataEncryptingKeysResult = &listDataEncryptingKeysResult{}
+
+func (r *listDataEncryptingKeysResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listDataEncryptingKeysResult) Page() []interfaces.DataEncryptingKey {
+	out := make([]interfaces.DataEncryptingKey, len(r.Page_))
+	for i, k := range r.Page_ {
+		out[i] = k
+	}
+	return out
+}
+
+func (r *redisStorageBackend) GetOrCreateRandomKey(ctx context.Context, maxRefCount int64) (interfaces.DataEncryptingKey, error) {
+	if err := r.prune(ctx); err != nil {
+		return nil, err
+	} else if id, err := randomKeyScript.Run(ctx, r.client, []string{r.key("keys", "refcount")}, maxRefCount, rand.Int63(), r.key("key", "")).Text(); errors.Is(err, redis.Nil) {
+		return r.vault.CreateDataEncryptingKey(ctx)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return r.GetDataEncryptingKey(ctx, id)
+	}
+}
+
+func (r *redisStorageBackend) CreateDataEncryptingKey(ctx context.Context, id interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: r,
+		ID_: id,
+		KeyEncryptingKey_: keyEncryptingKey,
+		EncryptedKey_: encryptedKey,
+		Expires_: nil,
+	}
+
+	if _, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, r.key("key", k.ID_), k.fields())
+		p.ZAdd(ctx, r.key("keys"), redis.Z{Score: float64(time.Now().UnixMicro()), Member: k.ID_})
+		p.ZAdd(ctx, r.key("keys", "refcount"), redis.Z{Score: 0, Member: k.ID_})
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (r *redisStorageBackend) PutDataEncryptingKey(ctx context.Context, src interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, error) {
+	k := dataEncryptingKey{
+		backend: r,
+		ID_: src.ID(),
+		KeyEncryptingKey_: src.KeyEncryptingKey(),
+		EncryptedKey_: src.EncryptedKey(),
+		Expires_: src.Expires(),
+	}
+
+	if _, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, r.key("key", k.ID_))
+		p.HSet(ctx, r.key("key", k.ID_), k.fields())
+		p.ZAddNX(ctx, r.key("keys"), redis.Z{Score: float64(time.Now().UnixMicro()), Member: k.ID_})
+		p.ZAddNX(ctx, r.key("keys", "refcount"), redis.Z{Score: 0, Member: k.ID_})
+		if k.Expires_ != nil {
+			p.PExpireAt(ctx, r.key("key", k.ID_), *k.Expires_)
+			p.ZAdd(ctx, r.key("keys", "expires"), redis.Z{Score: float64(k.Expires_.UnixMilli()), Member: k.ID_})
+		} else {
+			p.ZRem(ctx, r.key("keys", "expires"), k.ID_)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return &k, nil
+	}
+}
+
+func (r *redisStorageBackend) GetDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if h, err := r.client.HGetAll(ctx, r.key("key", id)).Result(); err != nil {
+		return nil, err
+	} else if len(h) == 0 {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("data encrypting key %s not found", id))
+	} else {
+		return r.decodeDataEncryptingKey(h)
+	}
+}
+
+// ListDataEncryptingKeys lists data encrypting keys in id order. The keys
+// index is ordered by creation time, so ids are sorted here, which is cheap as
+// each key is shared by many wallets.
+func (r *redisStorageBackend) ListDataEncryptingKeys(ctx context.Context, after interfaces.ID, count int64) (interfaces.ListDataEncryptingKeysResult, error) {
+	var res listDataEncryptingKeysResult
+
+	if err := r.prune(ctx); err != nil {
+		return nil, err
+	} else if ids, err := r.client.ZRange(ctx, r.key("keys"), 0, -1).Result(); err != nil {
+		return nil, err
+	} else {
+		res.Count_ = int64(len(ids))
+
+		slices.Sort(ids)
+		offset, found := slices.BinarySearch(ids, after)
+		if found {
+			offset++
+		}
+		ids = ids[offset:]
+		if count > 0 && int64(len(ids)) > count {
+			ids = ids[:count]
+		}
+
+		cmds := make([]*redis.MapStringStringCmd, len(ids))
+		if _, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, id := range ids {
+				cmds[i] = p.HGetAll(ctx, r.key("key", id))
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, cmd := range cmds {
+			if h := cmd.Val(); len(h) == 0 {
+				continue
+			} else if k, err := r.decodeDataEncryptingKey(h); err != nil {
+				return nil, err
+			} else {
+				res.Page_ = append(res.Page_, k)
+			}
+		}
+
+		return &res, nil
+	}
+}
+
+func (r *redisStorageBackend) UpdateDataEncryptingKey(ctx context.Context, id interfaces.ID, from interfaces.ID, keyEncryptingKey interfaces.ID, encryptedKey []byte) (interfaces.DataEncryptingKey, error) {
+	if err := rewrapKeyScript.Run(ctx, r.client, []string{r.key("key", id)}, from, keyEncryptingKey, encryptedKey).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return r.GetDataEncryptingKey(ctx, id)
+	}
+}
+
+func (r *redisStorageBackend) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	expires := time.Now().Add(ttl)
+
+	if err := expireKeyScript.Run(ctx, r.client, []string{r.key("key", id), r.key("keys", "expires")}, id, formatTime(expires), expires.UnixMilli()).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return r.GetDataEncryptingKey(ctx, id)
+	}
+}
+
+func (r *redisStorageBackend) UnexpireDataEncryptingKey(ctx context.Context, id interfaces.ID) (interfaces.DataEncryptingKey, error) {
+	if err := unexpireKeyScript.Run(ctx, r.client, []string{r.key("key", id), r.key("keys", "expires")}, id).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return r.GetDataEncryptingKey(ctx, id)
+	}
+}
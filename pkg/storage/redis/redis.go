@@ -1,11 +1,313 @@
 package redis
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/redis/go-redis/v9"
 )
 
-func NewRedisStorageBackend() (interfaces.IStorageBackend, error) {
-	return nil, fmt.Errorf("not implemented")
-}
\ No newline at end of file
+// The scripts below build key names from their arguments, which Redis Cluster
+// can't route, so the backend runs against a single Redis server or a
+// replicated primary, not a cluster.
+const defaultPrefix = "{signchain-vault}:"
+
+type redisStorageBackend struct {
+	client redis.UniversalClient
+	prefix string
+	vault interfaces.IVaultService
+}
+
+var _ interfaces.IStorageBackend = &redisStorageBackend{}
+
+// createWalletScript claims the address index and writes the wallet hash,
+// account and wallet indexes and data encrypting key reference count
+// atomically.
+//
+// KEYS: address, wallet, account wallets, key refcounts, key, wallets
+// ARGV: wallet id, score, data encrypting key id, hash field/value pairs...
+var createWalletScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[5]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX') then
+	return redis.error_reply('CONFLICT wallet address already exists')
+end
+redis.call('HSET', KEYS[2], unpack(ARGV, 4))
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[6], ARGV[2], ARGV[1])
+redis.call('ZINCRBY', KEYS[4], 1, ARGV[3])
+return 1
+`)
+
+// putWalletScript writes a wallet copied from another backend, replacing any
+// wallet with the same id and moving its indexes and reference count.
+//
+// KEYS: address, wallet, account wallets, key refcounts, key, wallets,
+//       wallets expires, wallets expiring
+// ARGV: wallet id, score, data encrypting key id, expires in milliseconds or
+//       empty, expiring index, account prefix, address prefix, hash
+//       field/value pairs...
+var putWalletScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[5]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+local existing = redis.call('GET', KEYS[1])
+if existing and existing ~= ARGV[1] then
+	return redis.error_reply('CONFLICT wallet address already exists')
+end
+local old = redis.call('HMGET', KEYS[2], 'account', 'address', 'dataEncryptingKey')
+if old[1] then
+	redis.call('ZREM', ARGV[6] .. old[1] .. ':wallets', ARGV[1])
+	redis.call('DEL', ARGV[7] .. old[2])
+	redis.call('ZINCRBY', KEYS[4], -1, old[3])
+	redis.call('DEL', KEYS[2])
+end
+redis.call('HSET', KEYS[2], unpack(ARGV, 8))
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[6], ARGV[2], ARGV[1])
+redis.call('ZINCRBY', KEYS[4], 1, ARGV[3])
+if ARGV[4] ~= '' then
+	redis.call('PEXPIREAT', KEYS[2], ARGV[4])
+	redis.call('PEXPIREAT', KEYS[1], ARGV[4])
+	redis.call('HSET', KEYS[8], ARGV[1], ARGV[5])
+	redis.call('ZADD', KEYS[7], ARGV[4], ARGV[1])
+else
+	redis.call('HDEL', KEYS[8], ARGV[1])
+	redis.call('ZREM', KEYS[7], ARGV[1])
+end
+return 1
+`)
+
+// rotateWalletScript moves a wallet to another data encrypting key if it still
+// references the expected one, replacing its encrypted private key and moving
+// the reference count. The expiring index is rewritten for wallets scheduled to
+// expire so that pruning releases the new key.
+//
+// KEYS: wallet, key refcounts, key, wallets expiring
+// ARGV: wallet id, from key id, to key id, encrypted private key, updated,
+//       expiring index
+var rotateWalletScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+local current = redis.call('HGET', KEYS[1], 'dataEncryptingKey')
+if not current then
+	return redis.error_reply('NOTFOUND wallet not found')
+elseif current ~= ARGV[2] then
+	return redis.error_reply('CONFLICT wallet data encrypting key has changed')
+end
+redis.call('HSET', KEYS[1], 'dataEncryptingKey', ARGV[3], 'encryptedPrivateKey', ARGV[4], 'updated', ARGV[5])
+redis.call('ZINCRBY', KEYS[2], -1, ARGV[2])
+redis.call('ZINCRBY', KEYS[2], 1, ARGV[3])
+if redis.call('HEXISTS', KEYS[4], ARGV[1]) == 1 then
+	redis.call('HSET', KEYS[4], ARGV[1], ARGV[6])
+end
+return 1
+`)
+
+// rewrapKeyScript replaces the wrapped key of a data encrypting key if it is
+// still wrapped by the expected key encrypting key.
+//
+// KEYS: key
+// ARGV: from key encrypting key id, key encrypting key id, encrypted key
+var rewrapKeyScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'keyEncryptingKey')
+if not current then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+elseif current ~= ARGV[1] then
+	return redis.error_reply('CONFLICT data encrypting key is no longer wrapped by the expected key encrypting key')
+end
+redis.call('HSET', KEYS[1], 'keyEncryptingKey', ARGV[2], 'encryptedKey', ARGV[3])
+return 1
+`)
+
+// expireKeyScript schedules a data encrypting key to expire, if it exists.
+//
+// KEYS: key, keys expires
+// ARGV: key id, expires, expires in milliseconds
+var expireKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+redis.call('HSET', KEYS[1], 'expires', ARGV[2])
+redis.call('PEXPIREAT', KEYS[1], ARGV[3])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+// unexpireKeyScript cancels the expiry of a data encrypting key, if it still
+// exists.
+//
+// KEYS: key, keys expires
+// ARGV: key id
+var unexpireKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+redis.call('HDEL', KEYS[1], 'expires')
+redis.call('PERSIST', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return 1
+`)
+
+// randomKeyScript returns a random data encrypting key with a reference count
+// below the maximum which is not scheduled to expire.
+//
+// KEYS: key refcounts
+// ARGV: max refcount, random seed, key prefix
+var randomKeyScript = redis.NewScript(`
+local candidates = {}
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])) do
+	local key = ARGV[3] .. id
+	if redis.call('EXISTS', key) == 1 and redis.call('HEXISTS', key, 'expires') == 0 then
+		table.insert(candidates, id)
+	end
+end
+if #candidates == 0 then
+	return false
+end
+math.randomseed(tonumber(ARGV[2]))
+return candidates[math.random(#candidates)]
+`)
+
+// pruneScript removes index entries for wallets and keys whose hashes have
+// been evicted by their native TTL.
+//
+// KEYS: wallets expires, wallets expiring, keys expires, keys, key refcounts,
+//       wallets
+// ARGV: now in milliseconds, wallet prefix, key prefix, account prefix
+var pruneScript = redis.NewScript(`
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])) do
+	if redis.call('EXISTS', ARGV[2] .. id) == 0 then
+		local info = redis.call('HGET', KEYS[2], id)
+		if info then
+			local index = cjson.decode(info)
+			redis.call('ZREM', ARGV[4] .. index.account .. ':wallets', id)
+			redis.call('ZINCRBY', KEYS[5], -1, index.dataEncryptingKey)
+		end
+		redis.call('ZREM', KEYS[6], id)
+		redis.call('ZREM', KEYS[1], id)
+		redis.call('HDEL', KEYS[2], id)
+	end
+end
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[1])) do
+	if redis.call('EXISTS', ARGV[3] .. id) == 0 then
+		redis.call('ZREM', KEYS[3], id)
+		redis.call('ZREM', KEYS[4], id)
+		redis.call('ZREM', KEYS[5], id)
+	end
+end
+return 1
+`)
+
+func NewRedisStorageBackend(vault interfaces.IVaultService) (interfaces.IStorageBackend, error) {
+	b := &redisStorageBackend{vault: vault, prefix: defaultPrefix}
+
+	if p, ok := os.LookupEnv("VAULT_REDIS_PREFIX"); ok {
+		b.prefix = p
+	}
+
+	if opts, err := redis.ParseURL(strings.TrimSpace(os.Getenv("VAULT_REDIS_URL"))); err != nil {
+		return nil, err
+	} else {
+		b.client = redis.NewClient(opts)
+	}
+
+	if err := b.client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("unable to connect to redis: %v", err)
+	} else if err := b.indexWallets(context.Background()); err != nil {
+		return nil, fmt.Errorf("unable to index wallets: %v", err)
+	}
+
+	return b, nil
+}
+
+// indexWallets adds wallets created before the global wallet index existed to
+// it. It runs once per database, recording completion in wallets:indexed.
+func (r *redisStorageBackend) indexWallets(ctx context.Context) error {
+	if n, err := r.client.Exists(ctx, r.key("wallets", "indexed")).Result(); err != nil {
+		return err
+	} else if n > 0 {
+		return nil
+	}
+
+	iter := r.client.Scan(ctx, 0, r.key("wallet", "*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		if h, err := r.client.HMGet(ctx, iter.Val(), "id", "created").Result(); err != nil {
+			return err
+		} else if id, ok := h[0].(string); !ok {
+			continue
+		} else if created, err := parseTime(fmt.Sprint(h[1])); err != nil {
+			return err
+		} else if err := r.client.ZAddNX(ctx, r.key("wallets"), redis.Z{Score: float64(created.UnixMicro()), Member: id}).Err(); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return r.client.Set(ctx, r.key("wallets", "indexed"), formatTime(time.Now()), 0).Err()
+}
+
+func (r *redisStorageBackend) key(parts ...string) string {
+	return r.prefix + strings.Join(parts, ":")
+}
+
+func (r *redisStorageBackend) prune(ctx context.Context) error {
+	return pruneScript.Run(ctx, r.client, []string{
+		r.key("wallets", "expires"),
+		r.key("wallets", "expiring"),
+		r.key("keys", "expires"),
+		r.key("keys"),
+		r.key("keys", "refcount"),
+		r.key("wallets"),
+	}, time.Now().UnixMilli(), r.key("wallet", ""), r.key("key", ""), r.key("account", "")).Err()
+}
+
+func scriptError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	if strings.HasPrefix(message, "CONFLICT ") {
+		return fiber.NewError(fiber.StatusConflict, strings.TrimPrefix(message, "CONFLICT "))
+	} else if strings.HasPrefix(message, "NOTFOUND ") {
+		return fiber.NewError(fiber.StatusNotFound, strings.TrimPrefix(message, "NOTFOUND "))
+	}
+	return err
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func parseOptionalTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	} else if t, err := parseTime(s); err != nil {
+		return nil, err
+	} else {
+		return &t, nil
+	}
+}
+
+func rangeStop(offset int64, count int64) int64 {
+	if count <= 0 {
+		return -1
+	}
+	return offset + count - 1
+}
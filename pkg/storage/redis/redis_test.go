@@ -0,0 +1,200 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/storagetest"
+)
+
+// testVault creates data encrypting keys with placeholder key material, as the
+// backend never decrypts them.
+type testVault struct {
+	backend interfaces.IStorageBackend
+}
+
+func (v *testVault) CreateDataEncryptingKey(ctx context.Context) (interfaces.DataEncryptingKey, error) {
+	return v.backend.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+}
+
+func newTestBackend(t *testing.T) (*redisStorageBackend, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	t.Setenv("VAULT_REDIS_URL", "redis://" + server.Addr())
+
+	vault := &testVault{}
+	backend, err := NewRedisStorageBackend(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.backend = backend
+	return backend.(*redisStorageBackend), server
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+		t.Setenv("VAULT_REDIS_URL", "redis://" + miniredis.RunT(t).Addr())
+
+		backend, err := NewRedisStorageBackend(vault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	})
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var e *fiber.Error
+	if !errors.As(err, &e) || e.Code != status {
+		t.Fatalf("expected status %d, got %v", status, err)
+	}
+}
+
+func TestWallets(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBackend(t)
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60))
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = b.CreateWallet(ctx, "account", "wallet", common.HexToAddress("0x01"), "missing", make([]byte, 60))
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if w, err := b.UpdateWallet(ctx, "account", address, "renamed"); err != nil {
+		t.Fatal(err)
+	} else if w.Name() != "renamed" {
+		t.Fatalf("expected name renamed, got %s", w.Name())
+	}
+
+	_, err = b.GetWallet(ctx, "other", address)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if r, err := b.ListWallets(ctx, "account", 0, 10); err != nil {
+		t.Fatal(err)
+	} else if r.Count() != 1 || len(r.Page()) != 1 || r.Page()[0].Address() != address {
+		t.Fatalf("expected the wallet to be listed, got %d", r.Count())
+	}
+
+	if count, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expected ref count 1, got %d", count)
+	}
+}
+
+// TestExpiredWalletsArePruned checks that index entries of a wallet evicted by
+// its native TTL are removed and no longer count towards its key.
+func TestExpiredWalletsArePruned(t *testing.T) {
+	ctx := context.Background()
+	b, server := newTestBackend(t)
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	} else if _, err := b.ExpireWallet(ctx, "account", address, 10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	server.FastForward(time.Second)
+
+	if count, err := k.RefCount(ctx); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("expected ref count 0 once the wallet expired, got %d", count)
+	}
+
+	if r, err := b.ListWallets(ctx, "account", 0, 10); err != nil {
+		t.Fatal(err)
+	} else if r.Count() != 0 {
+		t.Fatalf("expected no wallets, got %d", r.Count())
+	}
+
+	// the address can be claimed again
+	if _, err := b.CreateWallet(ctx, "account", "wallet", address, k.ID(), make([]byte, 60)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetOrCreateRandomKey(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBackend(t)
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if random, err := b.GetOrCreateRandomKey(ctx, 1); err != nil {
+		t.Fatal(err)
+	} else if random.ID() != k.ID() {
+		t.Fatalf("expected key %s, got %s", k.ID(), random.ID())
+	}
+
+	// keys scheduled to expire are not handed out, so a new key is created
+	if _, err := b.ExpireDataEncryptingKey(ctx, k.ID(), time.Hour); err != nil {
+		t.Fatal(err)
+	} else if random, err := b.GetOrCreateRandomKey(ctx, 1); err != nil {
+		t.Fatal(err)
+	} else if random.ID() == k.ID() {
+		t.Fatalf("expected a new key, got %s", random.ID())
+	}
+}
+
+func TestExpireDataEncryptingKey(t *testing.T) {
+	ctx := context.Background()
+	b, server := newTestBackend(t)
+
+	k, err := b.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), "kek-1", make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expired, err := b.ExpireDataEncryptingKey(ctx, k.ID(), time.Hour); err != nil {
+		t.Fatal(err)
+	} else if expired.Expires() == nil {
+		t.Fatalf("expected key to be scheduled to expire")
+	} else if ttl := server.TTL(b.key("key", k.ID())); ttl <= 0 {
+		t.Fatalf("expected key hash to have a ttl, got %s", ttl)
+	}
+
+	if unexpired, err := b.UnexpireDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatal(err)
+	} else if unexpired.Expires() != nil {
+		t.Fatalf("expected key expiry to be cancelled")
+	} else if ttl := server.TTL(b.key("key", k.ID())); ttl != 0 {
+		t.Fatalf("expected key hash to persist, got ttl %s", ttl)
+	}
+
+	// a missing key is not recreated as a hash holding only its expiry
+	_, err = b.ExpireDataEncryptingKey(ctx, "missing", time.Hour)
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = b.UnexpireDataEncryptingKey(ctx, "missing")
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if server.Exists(b.key("key", "missing")) {
+		t.Fatalf("expected no hash for a missing key")
+	} else if _, err := server.ZScore(b.key("keys", "expires"), "missing"); err == nil {
+		t.Fatalf("expected no expiry index entry for a missing key")
+	}
+}
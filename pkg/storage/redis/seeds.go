@@ -0,0 +1,291 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/redis/go-redis/v9"
+)
+
+// createSeedScript writes the seed hash, seed index and data encrypting key
+// reference count atomically, if the account has no seed.
+//
+// KEYS: seed, key refcounts, key, seeds
+// ARGV: account, score, data encrypting key id, hash field/value pairs...
+var createSeedScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.error_reply('CONFLICT seed already exists for account')
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+redis.call('ZINCRBY', KEYS[2], 1, ARGV[3])
+return 1
+`)
+
+// putSeedScript writes a seed copied from another backend, replacing the seed
+// of the account and moving its reference count.
+//
+// KEYS: seed, key refcounts, key, seeds
+// ARGV: account, score, data encrypting key id, hash field/value pairs...
+var putSeedScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+local old = redis.call('HGET', KEYS[1], 'dataEncryptingKey')
+if old then
+	redis.call('ZINCRBY', KEYS[2], -1, old)
+	redis.call('DEL', KEYS[1])
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+redis.call('ZINCRBY', KEYS[2], 1, ARGV[3])
+return 1
+`)
+
+// rotateSeedScript moves a seed to another data encrypting key if it still
+// references the expected one, replacing its encrypted seed and moving the
+// reference count.
+//
+// KEYS: seed, key refcounts, key
+// ARGV: from key id, to key id, encrypted seed, updated
+var rotateSeedScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	return redis.error_reply('NOTFOUND data encrypting key not found')
+end
+local current = redis.call('HGET', KEYS[1], 'dataEncryptingKey')
+if not current then
+	return redis.error_reply('NOTFOUND seed not found')
+elseif current ~= ARGV[1] then
+	return redis.error_reply('CONFLICT seed data encrypting key has changed')
+end
+redis.call('HSET', KEYS[1], 'dataEncryptingKey', ARGV[2], 'encryptedSeed', ARGV[3], 'updated', ARGV[4])
+redis.call('ZINCRBY', KEYS[2], -1, ARGV[1])
+redis.call('ZINCRBY', KEYS[2], 1, ARGV[2])
+return 1
+`)
+
+// reserveSeedIndexesScript advances the next index of a seed and returns it.
+//
+// KEYS: seed
+// ARGV: count, updated
+var reserveSeedIndexesScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOTFOUND seed not found')
+end
+local next = redis.call('HINCRBY', KEYS[1], 'nextIndex', ARGV[1])
+redis.call('HSET', KEYS[1], 'updated', ARGV[2])
+return next
+`)
+
+type seed struct {
+	Account_ interfaces.ID
+	DataEncryptingKey_ interfaces.ID
+	EncryptedSeed_ []byte
+	NextIndex_ int64
+	Created_ time.Time
+	Updated_ time.Time
+}
+
+var _ interfaces.Seed = &seed{}
+
+func (s *seed) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *seed) DataEncryptingKey() interfaces.ID {
+	return s.DataEncryptingKey_
+}
+
+func (s *seed) EncryptedSeed() []byte {
+	return s.EncryptedSeed_
+}
+
+func (s *seed) NextIndex() int64 {
+	return s.NextIndex_
+}
+
+func (s *seed) Created() time.Time {
+	return s.Created_
+}
+
+func (s *seed) Updated() time.Time {
+	return s.Updated_
+}
+
+func (s *seed) fields() []any {
+	return []any{
+		"account", s.Account_,
+		"dataEncryptingKey", s.DataEncryptingKey_,
+		"encryptedSeed", s.EncryptedSeed_,
+		"nextIndex", s.NextIndex_,
+		"created", formatTime(s.Created_),
+		"updated", formatTime(s.Updated_),
+	}
+}
+
+func decodeSeed(h map[string]string) (*seed, error) {
+	if created, err := parseTime(h["created"]); err != nil {
+		return nil, err
+	} else if updated, err := parseTime(h["updated"]); err != nil {
+		return nil, err
+	} else if nextIndex, err := strconv.ParseInt(h["nextIndex"], 10, 64); err != nil {
+		return nil, err
+	} else {
+		return &seed{
+			Account_: h["account"],
+			DataEncryptingKey_: h["dataEncryptingKey"],
+			EncryptedSeed_: []byte(h["encryptedSeed"]),
+			NextIndex_: nextIndex,
+			Created_: created,
+			Updated_: updated,
+		}, nil
+	}
+}
+
+type listSeedsResult struct {
+	Count_ int64
+	Page_ []*seed
+}
+
+var _ interfaces.ListSeedsResult = &listSeedsResult{}
+
+func (r *listSeedsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listSeedsResult) Page() []interfaces.Seed {
+	out := make([]interfaces.Seed, len(r.Page_))
+	for i, s := range r.Page_ {
+		out[i] = s
+	}
+	return out
+}
+
+func (r *redisStorageBackend) CreateSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	now := time.Now()
+
+	s := seed{
+		Account_: account,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedSeed_: encryptedSeed,
+		NextIndex_: 0,
+		Created_: now,
+		Updated_: now,
+	}
+
+	if err := createSeedScript.Run(ctx, r.client, []string{
+		r.key("seed", account),
+		r.key("keys", "refcount"),
+		r.key("key", dataEncryptingKey),
+		r.key("seeds"),
+	}, append([]any{account, now.UnixMicro(), dataEncryptingKey}, s.fields()...)...).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return &s, nil
+	}
+}
+
+func (r *redisStorageBackend) PutSeed(ctx context.Context, src interfaces.Seed) (interfaces.Seed, error) {
+	s := seed{
+		Account_: src.Account(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedSeed_: src.EncryptedSeed(),
+		NextIndex_: src.NextIndex(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+	}
+
+	if err := putSeedScript.Run(ctx, r.client, []string{
+		r.key("seed", s.Account_),
+		r.key("keys", "refcount"),
+		r.key("key", s.DataEncryptingKey_),
+		r.key("seeds"),
+	}, append([]any{s.Account_, s.Created_.UnixMicro(), s.DataEncryptingKey_}, s.fields()...)...).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return &s, nil
+	}
+}
+
+func (r *redisStorageBackend) getSeed(ctx context.Context, account interfaces.ID) (*seed, error) {
+	if h, err := r.client.HGetAll(ctx, r.key("seed", account)).Result(); err != nil {
+		return nil, err
+	} else if len(h) == 0 {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("seed not found for account %s", account))
+	} else {
+		return decodeSeed(h)
+	}
+}
+
+func (r *redisStorageBackend) GetSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	return r.getSeed(ctx, account)
+}
+
+// ListAllSeeds lists seeds in creation order.
+func (r *redisStorageBackend) ListAllSeeds(ctx context.Context, offset int64, count int64) (interfaces.ListSeedsResult, error) {
+	var res listSeedsResult
+
+	if total, err := r.client.ZCard(ctx, r.key("seeds")).Result(); err != nil {
+		return nil, err
+	} else if accounts, err := r.client.ZRange(ctx, r.key("seeds"), offset, rangeStop(offset, count)).Result(); err != nil {
+		return nil, err
+	} else {
+		res.Count_ = total
+
+		cmds := make([]*redis.MapStringStringCmd, len(accounts))
+		if _, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, account := range accounts {
+				cmds[i] = p.HGetAll(ctx, r.key("seed", account))
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, cmd := range cmds {
+			if h := cmd.Val(); len(h) == 0 {
+				continue
+			} else if s, err := decodeSeed(h); err != nil {
+				return nil, err
+			} else {
+				res.Page_ = append(res.Page_, s)
+			}
+		}
+
+		return &res, nil
+	}
+}
+
+// ReserveSeedIndexes returns the next index set by the script, as the seed
+// read afterwards may include later reservations.
+func (r *redisStorageBackend) ReserveSeedIndexes(ctx context.Context, account interfaces.ID, count int64) (interfaces.Seed, error) {
+	if next, err := reserveSeedIndexesScript.Run(ctx, r.client, []string{
+		r.key("seed", account),
+	}, count, formatTime(time.Now())).Int64(); err != nil {
+		return nil, scriptError(err)
+	} else if s, err := r.getSeed(ctx, account); err != nil {
+		return nil, err
+	} else {
+		s.NextIndex_ = next
+		return s, nil
+	}
+}
+
+func (r *redisStorageBackend) UpdateSeedDataEncryptingKey(ctx context.Context, account interfaces.ID, from interfaces.ID, to interfaces.ID, encryptedSeed []byte) (interfaces.Seed, error) {
+	if err := rotateSeedScript.Run(ctx, r.client, []string{
+		r.key("seed", account),
+		r.key("keys", "refcount"),
+		r.key("key", to),
+	}, from, to, encryptedSeed, formatTime(time.Now())).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return r.GetSeed(ctx, account)
+	}
+}
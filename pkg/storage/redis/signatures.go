@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/redis/go-redis/v9"
+)
+
+// createIssuedSignatureScript writes the issued signature hash, expiring it
+// natively, if no signature has been issued for the same key.
+//
+// KEYS: signature
+// ARGV: expires in milliseconds, hash field/value pairs...
+var createIssuedSignatureScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.error_reply('CONFLICT signature already issued for uniq')
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+redis.call('PEXPIREAT', KEYS[1], ARGV[1])
+return 1
+`)
+
+type issuedSignature struct {
+	Account_ interfaces.ID
+	Wallet_ common.Address
+	Sender_ common.Address
+	Uniq_ common.Hash
+	Signature_ []byte
+	Created_ time.Time
+	Expires_ time.Time
+}
+
+var _ interfaces.IssuedSignature = &issuedSignature{}
+
+func (s *issuedSignature) Account() interfaces.ID {
+	return s.Account_
+}
+
+func (s *issuedSignature) Wallet() common.Address {
+	return s.Wallet_
+}
+
+func (s *issuedSignature) Sender() common.Address {
+	return s.Sender_
+}
+
+func (s *issuedSignature) Uniq() common.Hash {
+	return s.Uniq_
+}
+
+func (s *issuedSignature) Signature() []byte {
+	return s.Signature_
+}
+
+func (s *issuedSignature) Created() time.Time {
+	return s.Created_
+}
+
+func (s *issuedSignature) Expires() time.Time {
+	return s.Expires_
+}
+
+func (s *issuedSignature) fields() []any {
+	return []any{
+		"account", s.Account_,
+		"wallet", s.Wallet_.Hex(),
+		"sender", s.Sender_.Hex(),
+		"uniq", s.Uniq_.Hex(),
+		"signature", s.Signature_,
+		"created", formatTime(s.Created_),
+		"expires", formatTime(s.Expires_),
+	}
+}
+
+func decodeIssuedSignature(h map[string]string) (*issuedSignature, error) {
+	if created, err := parseTime(h["created"]); err != nil {
+		return nil, err
+	} else if expires, err := parseTime(h["expires"]); err != nil {
+		return nil, err
+	} else {
+		return &issuedSignature{
+			Account_: h["account"],
+			Wallet_: common.HexToAddress(h["wallet"]),
+			Sender_: common.HexToAddress(h["sender"]),
+			Uniq_: common.HexToHash(h["uniq"]),
+			Signature_: []byte(h["signature"]),
+			Created_: created,
+			Expires_: expires,
+		}, nil
+	}
+}
+
+func (r *redisStorageBackend) signatureKey(wallet common.Address, sender common.Address, uniq common.Hash) string {
+	return r.key("signature", wallet.Hex(), sender.Hex(), uniq.Hex())
+}
+
+func (r *redisStorageBackend) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	now := time.Now()
+
+	s := issuedSignature{
+		Account_: account,
+		Wallet_: wallet,
+		Sender_: sender,
+		Uniq_: uniq,
+		Signature_: signature,
+		Created_: now,
+		Expires_: now.Add(ttl),
+	}
+
+	if err := createIssuedSignatureScript.Run(ctx, r.client, []string{
+		r.signatureKey(wallet, sender, uniq),
+	}, append([]any{s.Expires_.UnixMilli()}, s.fields()...)...).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return &s, nil
+	}
+}
+
+func (r *redisStorageBackend) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	if h, err := r.client.HGetAll(ctx, r.signatureKey(wallet, sender, uniq)).Result(); err != nil {
+		return nil, err
+	} else if len(h) == 0 || h["account"] != account {
+		return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("signature not found for uniq %s", uniq))
+	} else {
+		return decodeIssuedSignature(h)
+	}
+}
@@ -0,0 +1,440 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+	"github.com/redis/go-redis/v9"
+)
+
+type wallet struct {
+	ID_ interfaces.ID
+	Account_ interfaces.ID
+	Name_ string
+	Address_ common.Address
+	DataEncryptingKey_ interfaces.ID
+	EncryptedPrivateKey_ []byte
+	Permissions_ []string
+	Created_ time.Time
+	Updated_ time.Time
+	Expires_ *time.Time
+}
+
+var _ interfaces.Wallet = &wallet{}
+
+func (w *wallet) ID() interfaces.ID {
+	return w.ID_
+}
+
+func (w *wallet) Name() string {
+	return w.Name_
+}
+
+func (w *wallet) Account() interfaces.ID {
+	return w.Account_
+}
+
+func (w *wallet) Address() common.Address {
+	return w.Address_
+}
+
+func (w *wallet) DataEncryptingKey() interfaces.ID {
+	return w.DataEncryptingKey_
+}
+
+func (w *wallet) EncryptedPrivateKey() []byte {
+	return w.EncryptedPrivateKey_
+}
+
+func (w *wallet) Permissions() []string {
+	return w.Permissions_
+}
+
+func (w *wallet) Created() time.Time {
+	return w.Created_
+}
+
+func (w *wallet) Updated() time.Time {
+	return w.Updated_
+}
+
+func (w *wallet) Expires() *time.Time {
+	return w.Expires_
+}
+
+func (w *wallet) fields() []any {
+	f := []any{
+		"id", w.ID_,
+		"account", w.Account_,
+		"name", w.Name_,
+		"address", w.Address_.Hex(),
+		"dataEncryptingKey", w.DataEncryptingKey_,
+		"encryptedPrivateKey", w.EncryptedPrivateKey_,
+		"created", formatTime(w.Created_),
+		"updated", formatTime(w.Updated_),
+	}
+	if len(w.Permissions_) > 0 {
+		f = append(f, "permissions", strings.Join(w.Permissions_, ","))
+	}
+	if w.Expires_ != nil {
+		f = append(f, "expires", formatTime(*w.Expires_))
+	}
+	return f
+}
+
+func decodeWallet(h map[string]string) (*wallet, error) {
+	if created, err := parseTime(h["created"]); err != nil {
+		return nil, err
+	} else if updated, err := parseTime(h["updated"]); err != nil {
+		return nil, err
+	} else if expires, err := parseOptionalTime(h["expires"]); err != nil {
+		return nil, err
+	} else {
+		var permissions []string
+		if h["permissions"] != "" {
+			permissions = strings.Split(h["permissions"], ",")
+		}
+
+		return &wallet{
+			ID_: h["id"],
+			Account_: h["account"],
+			Name_: h["name"],
+			Address_: common.HexToAddress(h["address"]),
+			DataEncryptingKey_: h["dataEncryptingKey"],
+			EncryptedPrivateKey_: []byte(h["encryptedPrivateKey"]),
+			Permissions_: permissions,
+			Created_: created,
+			Updated_: updated,
+			Expires_: expires,
+		}, nil
+	}
+}
+
+type listWalletsResult struct {
+	Count_ int64
+	Page_ []*wallet
+}
+
+var _ interfaces.ListWalletsResult = &listWalletsResult{}
+
+func (r *listWalletsResult) Count() int64 {
+	return r.Count_
+}
+
+func (r *listWalletsResult) Page() []interfaces.Wallet {
+	out := make([]interfaces.Wallet, len(r.Page_))
+	for i, w := range r.Page_ {
+		out[i] = w
+	}
+	return out
+}
+
+// walletIndex is kept for wallets scheduled to expire, so that the account
+// index and key reference count can be cleaned up once the wallet hash is
+// evicted.
+type walletIndex struct {
+	Account interfaces.ID `json:"account"`
+	DataEncryptingKey interfaces.ID `json:"dataEncryptingKey"`
+}
+
+func (r *redisStorageBackend) CreateWallet(ctx context.Context, account interfaces.ID, name string, address common.Address, dataEncryptingKey interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	now := time.Now()
+
+	w := wallet{
+		ID_: anonymize.NewIDWithPrefix("wlt"),
+		Account_: account,
+		Name_: name,
+		Address_: address,
+		DataEncryptingKey_: dataEncryptingKey,
+		EncryptedPrivateKey_: encryptedPrivateKey,
+		Created_: now,
+		Updated_: now,
+		Expires_: nil,
+	}
+
+	args := append([]any{w.ID_, now.UnixMicro(), dataEncryptingKey}, w.fields()...)
+
+	if err := createWalletScript.Run(ctx, r.client, []string{
+		r.key("address", address.Hex()),
+		r.key("wallet", w.ID_),
+		r.key("account", account, "wallets"),
+		r.key("keys", "refcount"),
+		r.key("key", dataEncryptingKey),
+		r.key("wallets"),
+	}, args...).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return &w, nil
+	}
+}
+
+func (r *redisStorageBackend) PutWallet(ctx context.Context, src interfaces.Wallet) (interfaces.Wallet, error) {
+	w := wallet{
+		ID_: src.ID(),
+		Account_: src.Account(),
+		Name_: src.Name(),
+		Address_: src.Address(),
+		DataEncryptingKey_: src.DataEncryptingKey(),
+		EncryptedPrivateKey_: src.EncryptedPrivateKey(),
+		Permissions_: src.Permissions(),
+		Created_: src.Created(),
+		Updated_: src.Updated(),
+		Expires_: src.Expires(),
+	}
+
+	expires := ""
+	if w.Expires_ != nil {
+		expires = fmt.Sprint(w.Expires_.UnixMilli())
+	}
+
+	if index, err := json.Marshal(walletIndex{Account: w.Account_, DataEncryptingKey: w.DataEncryptingKey_}); err != nil {
+		return nil, err
+	} else if err := putWalletScript.Run(ctx, r.client, []string{
+		r.key("address", w.Address_.Hex()),
+		r.key("wallet", w.ID_),
+		r.key("account", w.Account_, "wallets"),
+		r.key("keys", "refcount"),
+		r.key("key", w.DataEncryptingKey_),
+		r.key("wallets"),
+		r.key("wallets", "expires"),
+		r.key("wallets", "expiring"),
+	}, append([]any{w.ID_, w.Created_.UnixMicro(), w.DataEncryptingKey_, expires, string(index), r.key("account", ""), r.key("address", "")}, w.fields()...)...).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return &w, nil
+	}
+}
+
+func (r *redisStorageBackend) getWallet(ctx context.Context, account interfaces.ID, address common.Address) (*wallet, error) {
+	notFound := fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("wallet %s not found for account %s", address, account))
+
+	if id, err := r.client.Get(ctx, r.key("address", address.Hex())).Result(); errors.Is(err, redis.Nil) {
+		return nil, notFound
+	} else if err != nil {
+		return nil, err
+	} else if h, err := r.client.HGetAll(ctx, r.key("wallet", id)).Result(); err != nil {
+		return nil, err
+	} else if len(h) == 0 || h["account"] != account {
+		return nil, notFound
+	} else {
+		return decodeWallet(h)
+	}
+}
+
+func (r *redisStorageBackend) GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	return r.getWallet(ctx, account, address)
+}
+
+func (r *redisStorageBackend) listWallets(ctx context.Context, index string, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	var res listWalletsResult
+
+	if err := r.prune(ctx); err != nil {
+		return nil, err
+	} else if total, err := r.client.ZCard(ctx, index).Result(); err != nil {
+		return nil, err
+	} else if ids, err := r.client.ZRange(ctx, index, offset, rangeStop(offset, count)).Result(); err != nil {
+		return nil, err
+	} else if wallets, err := r.getWallets(ctx, ids); err != nil {
+		return nil, err
+	} else {
+		res.Count_ = total
+		res.Page_ = wallets
+		return &res, nil
+	}
+}
+
+// getWallets reads wallets by id, skipping those removed since the ids were
+// read.
+func (r *redisStorageBackend) getWallets(ctx context.Context, ids []string) ([]*wallet, error) {
+	var wallets []*wallet
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	if _, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HGetAll(ctx, r.key("wallet", id))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range cmds {
+		if h := cmd.Val(); len(h) == 0 {
+			continue
+		} else if w, err := decodeWallet(h); err != nil {
+			return nil, err
+		} else {
+			wallets = append(wallets, w)
+		}
+	}
+
+	return wallets, nil
+}
+
+func (r *redisStorageBackend) ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (interfaces.ListWalletsResult, error) {
+	return r.listWallets(ctx, r.key("account", account, "wallets"), offset, count)
+}
+
+// ListAllWallets lists wallets of every account in creation order, resuming
+// from the creation time of the wallet at after, which stays put however many
+// wallets are created or removed. Listing starts again from the first wallet
+// if that wallet has since been removed, which lists wallets more than once
+// but never skips one.
+func (r *redisStorageBackend) ListAllWallets(ctx context.Context, after common.Address, count int64) (interfaces.ListWalletsResult, error) {
+	var res listWalletsResult
+	var id string
+	var score float64
+	var ties int64
+	index := r.key("wallets")
+	min := "-inf"
+
+	if err := r.prune(ctx); err != nil {
+		return nil, err
+	}
+
+	if after != (common.Address{}) {
+		if v, err := r.client.Get(ctx, r.key("address", after.Hex())).Result(); errors.Is(err, redis.Nil) {
+			id = ""
+		} else if err != nil {
+			return nil, err
+		} else if s, err := r.client.ZScore(ctx, index, v).Result(); errors.Is(err, redis.Nil) {
+			id = ""
+		} else if err != nil {
+			return nil, err
+		} else {
+			id, score, min = v, s, strconv.FormatFloat(s, 'f', -1, 64)
+		}
+	}
+
+	if id != "" {
+		if n, err := r.client.ZCount(ctx, index, min, min).Result(); err != nil {
+			return nil, err
+		} else {
+			ties = n
+		}
+	}
+
+	// wallets created at the same time as the wallet at after are ordered by
+	// id, so those up to and including it are dropped from the range
+	args := redis.ZRangeArgs{Key: index, Start: min, Stop: "+inf", ByScore: true}
+	if count > 0 {
+		args.Count = count + ties
+	}
+
+	if total, err := r.client.ZCard(ctx, index).Result(); err != nil {
+		return nil, err
+	} else if members, err := r.client.ZRangeArgsWithScores(ctx, args).Result(); err != nil {
+		return nil, err
+	} else {
+		var ids []string
+		for _, m := range members {
+			if member, _ := m.Member.(string); id != "" && m.Score == score && member <= id {
+				continue
+			} else if count <= 0 || int64(len(ids)) < count {
+				ids = append(ids, member)
+			}
+		}
+
+		if wallets, err := r.getWallets(ctx, ids); err != nil {
+			return nil, err
+		} else {
+			res.Count_ = total
+			res.Page_ = wallets
+			return &res, nil
+		}
+	}
+}
+
+func (r *redisStorageBackend) UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (interfaces.Wallet, error) {
+	if w, err := r.getWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if err := r.client.HSet(ctx, r.key("wallet", w.ID_), "name", name, "updated", formatTime(time.Now())).Err(); err != nil {
+		return nil, err
+	} else {
+		return r.GetWallet(ctx, account, address)
+	}
+}
+
+func (r *redisStorageBackend) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (interfaces.Wallet, error) {
+	if w, err := r.getWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if _, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, r.key("wallet", w.ID_), "updated", formatTime(time.Now()))
+		if len(permissions) > 0 {
+			p.HSet(ctx, r.key("wallet", w.ID_), "permissions", strings.Join(permissions, ","))
+		} else {
+			p.HDel(ctx, r.key("wallet", w.ID_), "permissions")
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return r.GetWallet(ctx, account, address)
+	}
+}
+
+func (r *redisStorageBackend) UpdateWalletDataEncryptingKey(ctx context.Context, account interfaces.ID, address common.Address, from interfaces.ID, to interfaces.ID, encryptedPrivateKey []byte) (interfaces.Wallet, error) {
+	if w, err := r.getWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if index, err := json.Marshal(walletIndex{Account: w.Account_, DataEncryptingKey: to}); err != nil {
+		return nil, err
+	} else if err := rotateWalletScript.Run(ctx, r.client, []string{
+		r.key("wallet", w.ID_),
+		r.key("keys", "refcount"),
+		r.key("key", to),
+		r.key("wallets", "expiring"),
+	}, w.ID_, from, to, encryptedPrivateKey, formatTime(time.Now()), string(index)).Err(); err != nil {
+		return nil, scriptError(err)
+	} else {
+		return r.GetWallet(ctx, account, address)
+	}
+}
+
+func (r *redisStorageBackend) ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (interfaces.Wallet, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+
+	if w, err := r.getWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if index, err := json.Marshal(walletIndex{Account: w.Account_, DataEncryptingKey: w.DataEncryptingKey_}); err != nil {
+		return nil, err
+	} else if _, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, r.key("wallet", w.ID_), "updated", formatTime(now), "expires", formatTime(expires))
+		p.PExpireAt(ctx, r.key("wallet", w.ID_), expires)
+		p.PExpireAt(ctx, r.key("address", address.Hex()), expires)
+		p.HSet(ctx, r.key("wallets", "expiring"), w.ID_, string(index))
+		p.ZAdd(ctx, r.key("wallets", "expires"), redis.Z{Score: float64(expires.UnixMilli()), Member: w.ID_})
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return r.GetWallet(ctx, account, address)
+	}
+}
+
+func (r *redisStorageBackend) UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (interfaces.Wallet, error) {
+	if w, err := r.getWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if _, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, r.key("wallet", w.ID_), "updated", formatTime(time.Now()))
+		p.HDel(ctx, r.key("wallet", w.ID_), "expires")
+		p.Persist(ctx, r.key("wallet", w.ID_))
+		p.Persist(ctx, r.key("address", address.Hex()))
+		p.HDel(ctx, r.key("wallets", "expiring"), w.ID_)
+		p.ZRem(ctx, r.key("wallets", "expires"), w.ID_)
+		return nil
+	}); err != nil {
+		return nil, err
+	} else {
+		return r.GetWallet(ctx, account, address)
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"strings"
 
 	"github.com/grexie/signchain-vault/v2/pkg/storage/dynamodb"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/file"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/firebase"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/memory"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/postgres"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/redis"
 )
 
@@ -22,11 +25,17 @@ func NewStorage(vault interfaces.IVaultService) (interfaces.IStorageBackend, err
 	case "mongo":
 		return mongo.NewMongoStorageBackend(vault)
 	case "redis":
-		return redis.NewRedisStorageBackend()
+		return redis.NewRedisStorageBackend(vault)
 	case "dynamodb":
-		return dynamodb.NewDynamoDBStorageBackend()
+		return dynamodb.NewDynamoDBStorageBackend(vault)
 	case "firebase":
-		return firebase.NewFirebaseStorageBackend()
+		return firebase.NewFirebaseStorageBackend(vault)
+	case "postgres":
+		return postgres.NewPostgresStorageBackend(vault)
+	case "file":
+		return file.NewFileStorageBackend(vault)
+	case "memory":
+		return memory.NewMemoryStorageBackend(vault)
 	default:
 		return nil, fmt.Errorf("invalid storage backend: %s, check online documentation for environment variable VAULT_STORAGE_BACKEND", backend)
 	}
@@ -0,0 +1,229 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+func testDataEncryptingKeys(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	encryptedKey := randomBytes(60)
+
+	created, err := backend.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), KeyEncryptingKey, encryptedKey)
+	if err != nil {
+		t.Fatalf("create data encrypting key: %v", err)
+	} else if created.ID() == "" {
+		t.Fatalf("expected data encrypting key id")
+	}
+
+	k, err := backend.GetDataEncryptingKey(ctx, created.ID())
+	if err != nil {
+		t.Fatalf("get data encrypting key: %v", err)
+	} else if k.ID() != created.ID() {
+		t.Fatalf("expected id %s, got %s", created.ID(), k.ID())
+	} else if k.KeyEncryptingKey() != KeyEncryptingKey {
+		t.Fatalf("expected key encrypting key %s, got %s", KeyEncryptingKey, k.KeyEncryptingKey())
+	} else if !bytes.Equal(k.EncryptedKey(), encryptedKey) {
+		t.Fatalf("encrypted key does not round trip")
+	} else if k.Expires() != nil {
+		t.Fatalf("expected new data encrypting key not to expire, got %s", k.Expires())
+	} else if count := refCount(t, ctx, k); count != 0 {
+		t.Fatalf("expected ref count 0, got %d", count)
+	}
+
+	other := createDataEncryptingKey(t, ctx, backend)
+	if other.ID() == created.ID() {
+		t.Fatalf("expected unique data encrypting key ids, got %s twice", other.ID())
+	}
+
+	_, err = backend.GetDataEncryptingKey(ctx, missingDataEncryptingKey())
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+// testListDataEncryptingKeys pages through keys while one on the first page
+// expires and another is created, which would skip a key with offsets.
+func testListDataEncryptingKeys(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	const total = 5
+
+	ids := map[interfaces.ID]bool{}
+	for i := 0; i < total; i++ {
+		ids[createDataEncryptingKey(t, ctx, backend).ID()] = false
+	}
+
+	if r, err := backend.ListDataEncryptingKeys(ctx, "", 0); err != nil {
+		t.Fatalf("list data encrypting keys: %v", err)
+	} else if r.Count() != total || len(r.Page()) != total {
+		t.Fatalf("expected %d data encrypting keys with no limit, got count %d and page of %d", total, r.Count(), len(r.Page()))
+	}
+
+	var after interfaces.ID
+	var removed interfaces.ID
+	seen := map[interfaces.ID]bool{}
+
+	for page := 0; ; page++ {
+		r, err := backend.ListDataEncryptingKeys(ctx, after, 2)
+		if err != nil {
+			t.Fatalf("list data encrypting keys: %v", err)
+		} else if page == 0 && r.Count() != total {
+			t.Fatalf("expected count %d, got %d", total, r.Count())
+		} else if len(r.Page()) == 0 {
+			break
+		} else if len(r.Page()) > 2 {
+			t.Fatalf("expected page of at most 2, got %d", len(r.Page()))
+		}
+
+		for _, k := range r.Page() {
+			if seen[k.ID()] {
+				t.Fatalf("data encrypting key %s returned on more than one page", k.ID())
+			}
+			seen[k.ID()] = true
+			if _, ok := ids[k.ID()]; ok {
+				ids[k.ID()] = true
+			}
+		}
+
+		if page == 0 {
+			// backends may delete a key which is already expired
+			var e *fiber.Error
+			k := r.Page()[0]
+			if _, err := backend.ExpireDataEncryptingKey(ctx, k.ID(), -time.Second); err != nil && !(errors.As(err, &e) && e.Code == fiber.StatusNotFound) {
+				t.Fatalf("expire data encrypting key: %v", err)
+			}
+			removed = k.ID()
+			createDataEncryptingKey(t, ctx, backend)
+		}
+
+		after = r.Page()[len(r.Page()) - 1].ID()
+	}
+
+	for id, seen := range ids {
+		if !seen && id != removed {
+			t.Fatalf("data encrypting key %s missing from pages", id)
+		}
+	}
+}
+
+func testExpireDataEncryptingKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	k := createDataEncryptingKey(t, ctx, backend)
+
+	if expired, err := backend.ExpireDataEncryptingKey(ctx, k.ID(), time.Hour); err != nil {
+		t.Fatalf("expire data encrypting key: %v", err)
+	} else {
+		requireExpires(t, expired.Expires(), time.Hour)
+	}
+
+	if got, err := backend.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatalf("get data encrypting key before ttl: %v", err)
+	} else {
+		requireExpires(t, got.Expires(), time.Hour)
+	}
+
+	if unexpired, err := backend.UnexpireDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatalf("unexpire data encrypting key: %v", err)
+	} else if unexpired.Expires() != nil {
+		t.Fatalf("expected expires to be cleared, got %s", unexpired.Expires())
+	}
+
+	if got, err := backend.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatalf("get data encrypting key: %v", err)
+	} else if got.Expires() != nil {
+		t.Fatalf("expected expires to be cleared, got %s", got.Expires())
+	}
+
+	_, err := backend.ExpireDataEncryptingKey(ctx, missingDataEncryptingKey(), time.Hour)
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = backend.UnexpireDataEncryptingKey(ctx, missingDataEncryptingKey())
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testUpdateDataEncryptingKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	k := createDataEncryptingKey(t, ctx, backend)
+	createWallet(t, ctx, backend, randomAccount(), k.ID())
+	encrypted := randomBytes(60)
+
+	if updated, err := backend.UpdateDataEncryptingKey(ctx, k.ID(), KeyEncryptingKey, "rewrapped", encrypted); err != nil {
+		t.Fatalf("update data encrypting key: %v", err)
+	} else if updated.KeyEncryptingKey() != "rewrapped" || !bytes.Equal(updated.EncryptedKey(), encrypted) {
+		t.Fatalf("expected data encrypting key to be wrapped by rewrapped")
+	}
+
+	if got, err := backend.GetDataEncryptingKey(ctx, k.ID()); err != nil {
+		t.Fatalf("get data encrypting key: %v", err)
+	} else if got.KeyEncryptingKey() != "rewrapped" || !bytes.Equal(got.EncryptedKey(), encrypted) {
+		t.Fatalf("unexpected data encrypting key after update")
+	} else if count := refCount(t, ctx, got); count != 1 {
+		t.Fatalf("expected ref count 1, got %d", count)
+	}
+
+	// a second rewrap from the old key encrypting key lost the race
+	_, err := backend.UpdateDataEncryptingKey(ctx, k.ID(), KeyEncryptingKey, "rewrapped", encrypted)
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = backend.UpdateDataEncryptingKey(ctx, missingDataEncryptingKey(), KeyEncryptingKey, "rewrapped", encrypted)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testGetOrCreateRandomKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	const maxRefCount = 2
+	account := randomAccount()
+
+	// an empty backend creates a key through the vault service
+	first, err := backend.GetOrCreateRandomKey(ctx, maxRefCount)
+	if err != nil {
+		t.Fatalf("get or create random key: %v", err)
+	} else if first.KeyEncryptingKey() != KeyEncryptingKey {
+		t.Fatalf("expected key to be created through the vault service")
+	}
+
+	for i := 0; i < maxRefCount; i++ {
+		createWallet(t, ctx, backend, account, first.ID())
+	}
+
+	if count := refCount(t, ctx, first); count != maxRefCount {
+		t.Fatalf("expected ref count %d, got %d", maxRefCount, count)
+	}
+
+	// a full key is never handed out again, every key returned has capacity
+	// and creating wallets on each one fills it
+	seen := map[interfaces.ID]bool{first.ID(): true}
+	for i := 0; i < 4 * maxRefCount; i++ {
+		k, err := backend.GetOrCreateRandomKey(ctx, maxRefCount)
+		if err != nil {
+			t.Fatalf("get or create random key: %v", err)
+		} else if k.ID() == first.ID() {
+			t.Fatalf("key %s returned with ref count at the limit", k.ID())
+		} else if count := refCount(t, ctx, k); count >= maxRefCount {
+			t.Fatalf("key %s returned with ref count %d, limit %d", k.ID(), count, maxRefCount)
+		}
+
+		seen[k.ID()] = true
+		createWallet(t, ctx, backend, account, k.ID())
+	}
+
+	if expected := 5; len(seen) < expected {
+		t.Fatalf("expected at least %d keys for %d wallets, got %d", expected, 5 * maxRefCount, len(seen))
+	}
+
+	// keys scheduled to expire are not handed out to new wallets
+	expiring, err := backend.GetOrCreateRandomKey(ctx, maxRefCount)
+	if err != nil {
+		t.Fatalf("get or create random key: %v", err)
+	}
+	createWallet(t, ctx, backend, account, expiring.ID())
+	if _, err := backend.ExpireDataEncryptingKey(ctx, expiring.ID(), time.Hour); err != nil {
+		t.Fatalf("expire data encrypting key: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if k, err := backend.GetOrCreateRandomKey(ctx, maxRefCount); err != nil {
+			t.Fatalf("get or create random key: %v", err)
+		} else if k.ID() == expiring.ID() {
+			t.Fatalf("expiring key %s handed out to a new wallet", k.ID())
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/mongo/anonymize"
+)
+
+// record stands in for data encrypting keys and wallets read from another
+// backend during a migration.
+type record struct {
+	id interfaces.ID
+	account interfaces.ID
+	address common.Address
+	dataEncryptingKey interfaces.ID
+	encrypted []byte
+	permissions []string
+	created time.Time
+	expires *time.Time
+}
+
+var _ interfaces.DataEncryptingKey = &record{}
+var _ interfaces.Wallet = &record{}
+
+func (r *record) ID() interfaces.ID {
+	return r.id
+}
+
+func (r *record) KeyEncryptingKey() interfaces.ID {
+	return KeyEncryptingKey
+}
+
+func (r *record) EncryptedKey() []byte {
+	return r.encrypted
+}
+
+func (r *record) RefCount(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (r *record) Account() interfaces.ID {
+	return r.account
+}
+
+func (r *record) Name() string {
+	return "copied"
+}
+
+func (r *record) Address() common.Address {
+	return r.address
+}
+
+func (r *record) DataEncryptingKey() interfaces.ID {
+	return r.dataEncryptingKey
+}
+
+func (r *record) EncryptedPrivateKey() []byte {
+	return r.encrypted
+}
+
+func (r *record) Permissions() []string {
+	return r.permissions
+}
+
+func (r *record) Created() time.Time {
+	return r.created
+}
+
+func (r *record) Updated() time.Time {
+	return r.created
+}
+
+func (r *record) Expires() *time.Time {
+	return r.expires
+}
+
+func newKeyRecord() *record {
+	return &record{
+		id: interfaces.NewDataEncryptingKeyID(),
+		encrypted: randomBytes(32),
+	}
+}
+
+func newWalletRecord(account interfaces.ID, dataEncryptingKey interfaces.ID) *record {
+	return &record{
+		id: anonymize.NewIDWithPrefix("wlt"),
+		account: account,
+		address: randomAddress(),
+		dataEncryptingKey: dataEncryptingKey,
+		encrypted: randomBytes(60),
+		permissions: []string{"sign-hash"},
+		created: time.Now().Add(-24 * time.Hour).Truncate(time.Millisecond),
+	}
+}
+
+func testPutDataEncryptingKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	src := newKeyRecord()
+	expires := time.Now().Add(time.Hour)
+	src.expires = &expires
+
+	for i := 0; i < 2; i++ {
+		if _, err := backend.PutDataEncryptingKey(ctx, src); err != nil {
+			t.Fatalf("put data encrypting key: %v", err)
+		}
+	}
+
+	if k, err := backend.GetDataEncryptingKey(ctx, src.ID()); err != nil {
+		t.Fatalf("get data encrypting key: %v", err)
+	} else if k.ID() != src.ID() {
+		t.Fatalf("expected id %s, got %s", src.ID(), k.ID())
+	} else if !bytes.Equal(k.EncryptedKey(), src.EncryptedKey()) {
+		t.Fatalf("encrypted key does not round trip")
+	} else {
+		requireExpires(t, k.Expires(), time.Hour)
+	}
+
+	if r, err := backend.ListDataEncryptingKeys(ctx, "", 0); err != nil {
+		t.Fatalf("list data encrypting keys: %v", err)
+	} else if r.Count() != 1 {
+		t.Fatalf("expected 1 data encrypting key after repeated puts, got %d", r.Count())
+	}
+
+	src.expires = nil
+	if _, err := backend.PutDataEncryptingKey(ctx, src); err != nil {
+		t.Fatalf("put data encrypting key: %v", err)
+	} else if k, err := backend.GetDataEncryptingKey(ctx, src.ID()); err != nil {
+		t.Fatalf("get data encrypting key: %v", err)
+	} else if k.Expires() != nil {
+		t.Fatalf("expected put to clear expires, got %s", k.Expires())
+	}
+}
+
+func testPutWallet(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k, err := backend.PutDataEncryptingKey(ctx, newKeyRecord())
+	if err != nil {
+		t.Fatalf("put data encrypting key: %v", err)
+	}
+
+	src := newWalletRecord(account, k.ID())
+
+	for i := 0; i < 2; i++ {
+		if _, err := backend.PutWallet(ctx, src); err != nil {
+			t.Fatalf("put wallet: %v", err)
+		}
+	}
+
+	if w, err := backend.GetWallet(ctx, account, src.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.ID() != src.ID() {
+		t.Fatalf("expected id %s, got %s", src.ID(), w.ID())
+	} else if w.DataEncryptingKey() != k.ID() {
+		t.Fatalf("expected data encrypting key %s, got %s", k.ID(), w.DataEncryptingKey())
+	} else if !bytes.Equal(w.EncryptedPrivateKey(), src.EncryptedPrivateKey()) {
+		t.Fatalf("encrypted private key does not round trip")
+	} else if !slices.Equal(w.Permissions(), src.Permissions()) {
+		t.Fatalf("expected permissions %v to be preserved, got %v", src.Permissions(), w.Permissions())
+	} else if d := w.Created().Sub(src.Created()); d < -time.Second || d > time.Second {
+		t.Fatalf("expected created %s to be preserved, got %s", src.Created(), w.Created())
+	}
+
+	if count := refCount(t, ctx, k); count != 1 {
+		t.Fatalf("expected ref count 1 after repeated puts, got %d", count)
+	}
+
+	// copied keys are shared with wallets created in the target
+	createWallet(t, ctx, backend, account, k.ID())
+
+	conflict := newWalletRecord(account, k.ID())
+	conflict.address = src.Address()
+	_, err = backend.PutWallet(ctx, conflict)
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = backend.PutWallet(ctx, newWalletRecord(account, missingDataEncryptingKey()))
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if count := refCount(t, ctx, k); count != 2 {
+		t.Fatalf("expected ref count 2, got %d", count)
+	}
+}
+
+// testListAllWallets pages through wallets while one on an earlier page is
+// removed and another is created, which would skip a wallet with offsets.
+func testListAllWallets(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	const total = 5
+	k := createDataEncryptingKey(t, ctx, backend)
+
+	ids := map[interfaces.ID]bool{}
+	for i := 0; i < total; i++ {
+		ids[createWallet(t, ctx, backend, randomAccount(), k.ID()).ID()] = false
+	}
+
+	var after common.Address
+	var removed interfaces.ID
+	seen := map[interfaces.ID]bool{}
+
+	for page := 0; ; page++ {
+		r, err := backend.ListAllWallets(ctx, after, 2)
+		if err != nil {
+			t.Fatalf("list all wallets: %v", err)
+		} else if page == 0 && r.Count() != total {
+			t.Fatalf("expected count %d, got %d", total, r.Count())
+		} else if len(r.Page()) == 0 {
+			break
+		}
+
+		for _, w := range r.Page() {
+			if seen[w.ID()] {
+				t.Fatalf("wallet %s returned on more than one page", w.ID())
+			}
+			seen[w.ID()] = true
+			if _, ok := ids[w.ID()]; ok {
+				ids[w.ID()] = true
+			}
+		}
+
+		if page == 0 {
+			// backends may not return a wallet which is already expired
+			var e *fiber.Error
+			w := r.Page()[0]
+			if _, err := backend.ExpireWallet(ctx, w.Account(), w.Address(), -time.Second); err != nil && !(errors.As(err, &e) && e.Code == fiber.StatusNotFound) {
+				t.Fatalf("expire wallet: %v", err)
+			}
+			removed = w.ID()
+			createWallet(t, ctx, backend, randomAccount(), k.ID())
+		}
+
+		after = r.Page()[len(r.Page()) - 1].Address()
+	}
+
+	for id, seen := range ids {
+		if !seen && id != removed {
+			t.Fatalf("wallet %s missing from pages", id)
+		}
+	}
+}
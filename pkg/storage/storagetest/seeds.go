@@ -0,0 +1,263 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// seedRecord stands in for seeds read from another backend during a
+// migration.
+type seedRecord struct {
+	account interfaces.ID
+	dataEncryptingKey interfaces.ID
+	encrypted []byte
+	nextIndex int64
+	created time.Time
+}
+
+var _ interfaces.Seed = &seedRecord{}
+
+func (r *seedRecord) Account() interfaces.ID {
+	return r.account
+}
+
+func (r *seedRecord) DataEncryptingKey() interfaces.ID {
+	return r.dataEncryptingKey
+}
+
+func (r *seedRecord) EncryptedSeed() []byte {
+	return r.encrypted
+}
+
+func (r *seedRecord) NextIndex() int64 {
+	return r.nextIndex
+}
+
+func (r *seedRecord) Created() time.Time {
+	return r.created
+}
+
+func (r *seedRecord) Updated() time.Time {
+	return r.created
+}
+
+func createSeed(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend, account interfaces.ID, dataEncryptingKey interfaces.ID) interfaces.Seed {
+	t.Helper()
+
+	if s, err := backend.CreateSeed(ctx, account, dataEncryptingKey, randomBytes(92)); err != nil {
+		t.Fatalf("create seed: %v", err)
+		return nil
+	} else {
+		return s
+	}
+}
+
+func testSeeds(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	encryptedSeed := randomBytes(92)
+
+	_, err := backend.GetSeed(ctx, account)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	created, err := backend.CreateSeed(ctx, account, k.ID(), encryptedSeed)
+	if err != nil {
+		t.Fatalf("create seed: %v", err)
+	} else if created.NextIndex() != 0 {
+		t.Fatalf("expected next index 0, got %d", created.NextIndex())
+	} else if created.Created().IsZero() || created.Updated().IsZero() {
+		t.Fatalf("expected created and updated to be set")
+	}
+
+	if s, err := backend.GetSeed(ctx, account); err != nil {
+		t.Fatalf("get seed: %v", err)
+	} else if s.Account() != account {
+		t.Fatalf("expected account %s, got %s", account, s.Account())
+	} else if s.DataEncryptingKey() != k.ID() {
+		t.Fatalf("expected data encrypting key %s, got %s", k.ID(), s.DataEncryptingKey())
+	} else if !bytes.Equal(s.EncryptedSeed(), encryptedSeed) {
+		t.Fatalf("encrypted seed does not round trip")
+	} else if s.NextIndex() != 0 {
+		t.Fatalf("expected next index 0, got %d", s.NextIndex())
+	}
+
+	// seeds hold a reference to their key alongside wallets
+	createWallet(t, ctx, backend, account, k.ID())
+	if count := refCount(t, ctx, k); count != 2 {
+		t.Fatalf("expected ref count 2, got %d", count)
+	}
+
+	_, err = backend.CreateSeed(ctx, account, k.ID(), randomBytes(92))
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = backend.CreateSeed(ctx, randomAccount(), missingDataEncryptingKey(), randomBytes(92))
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if count := refCount(t, ctx, k); count != 2 {
+		t.Fatalf("expected ref count 2 after failed creates, got %d", count)
+	}
+}
+
+func testReserveSeedIndexes(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	const workers = 8
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	createSeed(t, ctx, backend, account, k.ID())
+
+	if s, err := backend.ReserveSeedIndexes(ctx, account, 3); err != nil {
+		t.Fatalf("reserve seed indexes: %v", err)
+	} else if s.NextIndex() != 3 {
+		t.Fatalf("expected next index 3, got %d", s.NextIndex())
+	}
+
+	// concurrent reservations never hand out the same range twice
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := map[int64]bool{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s, err := backend.ReserveSeedIndexes(ctx, account, 2); err != nil {
+				t.Errorf("reserve seed indexes: %v", err)
+			} else {
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[s.NextIndex()] {
+					t.Errorf("next index %d reserved twice", s.NextIndex())
+				}
+				seen[s.NextIndex()] = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s, err := backend.GetSeed(ctx, account); err != nil {
+		t.Fatalf("get seed: %v", err)
+	} else if expected := int64(3 + 2 * workers); s.NextIndex() != expected {
+		t.Fatalf("expected next index %d, got %d", expected, s.NextIndex())
+	}
+
+	_, err := backend.ReserveSeedIndexes(ctx, randomAccount(), 1)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testUpdateSeedDataEncryptingKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	from := createDataEncryptingKey(t, ctx, backend)
+	to := createDataEncryptingKey(t, ctx, backend)
+	created := createSeed(t, ctx, backend, account, from.ID())
+	encrypted := randomBytes(92)
+
+	if _, err := backend.ReserveSeedIndexes(ctx, account, 5); err != nil {
+		t.Fatalf("reserve seed indexes: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if updated, err := backend.UpdateSeedDataEncryptingKey(ctx, account, from.ID(), to.ID(), encrypted); err != nil {
+		t.Fatalf("update seed data encrypting key: %v", err)
+	} else if updated.DataEncryptingKey() != to.ID() || !bytes.Equal(updated.EncryptedSeed(), encrypted) {
+		t.Fatalf("expected seed to reference %s", to.ID())
+	} else if updated.NextIndex() != 5 {
+		t.Fatalf("expected next index 5 to be preserved, got %d", updated.NextIndex())
+	} else if !updated.Updated().After(created.Updated()) {
+		t.Fatalf("expected updated to advance from %s, got %s", created.Updated(), updated.Updated())
+	}
+
+	if count := refCount(t, ctx, from); count != 0 {
+		t.Fatalf("expected ref count 0 for %s, got %d", from.ID(), count)
+	} else if count := refCount(t, ctx, to); count != 1 {
+		t.Fatalf("expected ref count 1 for %s, got %d", to.ID(), count)
+	}
+
+	// a second rotation from the old key lost the race
+	_, err := backend.UpdateSeedDataEncryptingKey(ctx, account, from.ID(), to.ID(), encrypted)
+	requireStatus(t, err, fiber.StatusConflict)
+
+	_, err = backend.UpdateSeedDataEncryptingKey(ctx, account, to.ID(), missingDataEncryptingKey(), encrypted)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	_, err = backend.UpdateSeedDataEncryptingKey(ctx, randomAccount(), to.ID(), from.ID(), encrypted)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testPutSeed(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k, err := backend.PutDataEncryptingKey(ctx, newKeyRecord())
+	if err != nil {
+		t.Fatalf("put data encrypting key: %v", err)
+	}
+
+	src := &seedRecord{
+		account: account,
+		dataEncryptingKey: k.ID(),
+		encrypted: randomBytes(92),
+		nextIndex: 7,
+		created: time.Now().Add(-time.Hour),
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := backend.PutSeed(ctx, src); err != nil {
+			t.Fatalf("put seed: %v", err)
+		}
+	}
+
+	if s, err := backend.GetSeed(ctx, account); err != nil {
+		t.Fatalf("get seed: %v", err)
+	} else if s.DataEncryptingKey() != k.ID() || !bytes.Equal(s.EncryptedSeed(), src.EncryptedSeed()) {
+		t.Fatalf("seed does not round trip")
+	} else if s.NextIndex() != 7 {
+		t.Fatalf("expected next index 7 to be preserved, got %d", s.NextIndex())
+	} else if d := s.Created().Sub(src.Created()); d < -time.Second || d > time.Second {
+		t.Fatalf("expected created %s to be preserved, got %s", src.Created(), s.Created())
+	}
+
+	if count := refCount(t, ctx, k); count != 1 {
+		t.Fatalf("expected ref count 1 after repeated puts, got %d", count)
+	}
+
+	_, err = backend.PutSeed(ctx, &seedRecord{account: randomAccount(), dataEncryptingKey: missingDataEncryptingKey(), encrypted: randomBytes(92), created: time.Now()})
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testListAllSeeds(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	const total = 5
+	k := createDataEncryptingKey(t, ctx, backend)
+
+	accounts := map[interfaces.ID]bool{}
+	for i := 0; i < total; i++ {
+		accounts[createSeed(t, ctx, backend, randomAccount(), k.ID()).Account()] = false
+	}
+
+	for offset := int64(0); offset < total; offset += 2 {
+		r, err := backend.ListAllSeeds(ctx, offset, 2)
+		if err != nil {
+			t.Fatalf("list all seeds: %v", err)
+		} else if r.Count() != total {
+			t.Fatalf("expected count %d, got %d", total, r.Count())
+		}
+
+		for _, s := range r.Page() {
+			if seen, ok := accounts[s.Account()]; !ok {
+				t.Fatalf("unexpected seed for account %s", s.Account())
+			} else if seen {
+				t.Fatalf("seed for account %s listed twice", s.Account())
+			} else {
+				accounts[s.Account()] = true
+			}
+		}
+	}
+
+	for account, seen := range accounts {
+		if !seen {
+			t.Fatalf("seed for account %s not listed", account)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+func testIssuedSignatures(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	wallet := randomAddress()
+	sender := randomAddress()
+	uniq := common.BytesToHash(randomBytes(32))
+	signature := randomBytes(97)
+
+	_, err := backend.GetIssuedSignature(ctx, account, wallet, sender, uniq)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if s, err := backend.CreateIssuedSignature(ctx, account, wallet, sender, uniq, signature, time.Hour); err != nil {
+		t.Fatalf("create issued signature: %v", err)
+	} else if s.Created().IsZero() {
+		t.Fatalf("expected created to be set")
+	} else {
+		expires := s.Expires()
+		requireExpires(t, &expires, time.Hour)
+	}
+
+	if s, err := backend.GetIssuedSignature(ctx, account, wallet, sender, uniq); err != nil {
+		t.Fatalf("get issued signature: %v", err)
+	} else if s.Account() != account {
+		t.Fatalf("expected account %s, got %s", account, s.Account())
+	} else if s.Wallet() != wallet || s.Sender() != sender || s.Uniq() != uniq {
+		t.Fatalf("expected %s %s %s, got %s %s %s", wallet, sender, uniq, s.Wallet(), s.Sender(), s.Uniq())
+	} else if !bytes.Equal(s.Signature(), signature) {
+		t.Fatalf("signature does not round trip")
+	} else {
+		expires := s.Expires()
+		requireExpires(t, &expires, time.Hour)
+	}
+
+	// the ledger refuses a second signature for the same uniq
+	_, err = backend.CreateIssuedSignature(ctx, account, wallet, sender, uniq, randomBytes(97), time.Hour)
+	requireStatus(t, err, fiber.StatusConflict)
+
+	if s, err := backend.GetIssuedSignature(ctx, account, wallet, sender, uniq); err != nil {
+		t.Fatalf("get issued signature: %v", err)
+	} else if !bytes.Equal(s.Signature(), signature) {
+		t.Fatalf("expected the first signature to be kept")
+	}
+
+	// the same uniq may be signed for another sender or by another wallet
+	if _, err := backend.CreateIssuedSignature(ctx, account, wallet, randomAddress(), uniq, randomBytes(97), time.Hour); err != nil {
+		t.Fatalf("create issued signature for another sender: %v", err)
+	} else if _, err := backend.CreateIssuedSignature(ctx, account, randomAddress(), sender, uniq, randomBytes(97), time.Hour); err != nil {
+		t.Fatalf("create issued signature for another wallet: %v", err)
+	}
+
+	_, err = backend.GetIssuedSignature(ctx, randomAccount(), wallet, sender, uniq)
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = backend.GetIssuedSignature(ctx, account, wallet, sender, common.BytesToHash(randomBytes(32)))
+	requireStatus(t, err, fiber.StatusNotFound)
+}
@@ -0,0 +1,164 @@
+// Package storagetest is a conformance suite for storage backends. A backend
+// passes when Run succeeds against it, e.g.
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend {
+//			backend, err := memory.NewMemoryStorageBackend(vault)
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return backend
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// KeyEncryptingKey is the key encrypting key recorded against data encrypting
+// keys created by the suite.
+const KeyEncryptingKey interfaces.ID = "storagetest"
+
+// Factory returns a new, empty storage backend. The backend must create data
+// encrypting keys through vault when GetOrCreateRandomKey has no key to offer.
+type Factory func(t *testing.T, vault interfaces.IVaultService) interfaces.IStorageBackend
+
+// vault stands in for the vault service, creating data encrypting keys with
+// random key material directly in the backend under test.
+type vault struct {
+	backend interfaces.IStorageBackend
+}
+
+var _ interfaces.IVaultService = &vault{}
+
+func (v *vault) CreateDataEncryptingKey(ctx context.Context) (interfaces.DataEncryptingKey, error) {
+	return v.backend.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), KeyEncryptingKey, randomBytes(32))
+}
+
+// Run runs the conformance suite against backends returned by factory. Each
+// subtest is given its own backend.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend)
+	}{
+		{"DataEncryptingKeys", testDataEncryptingKeys},
+		{"ListDataEncryptingKeys", testListDataEncryptingKeys},
+		{"ExpireDataEncryptingKey", testExpireDataEncryptingKey},
+		{"GetOrCreateRandomKey", testGetOrCreateRandomKey},
+		{"UpdateDataEncryptingKey", testUpdateDataEncryptingKey},
+		{"Wallets", testWallets},
+		{"ListWallets", testListWallets},
+		{"UpdateWallet", testUpdateWallet},
+		{"UpdateWalletPermissions", testUpdateWalletPermissions},
+		{"ExpireWallet", testExpireWallet},
+		{"AddressUniqueness", testAddressUniqueness},
+		{"AccountIsolation", testAccountIsolation},
+		{"PutDataEncryptingKey", testPutDataEncryptingKey},
+		{"PutWallet", testPutWallet},
+		{"ListAllWallets", testListAllWallets},
+		{"UpdateWalletDataEncryptingKey", testUpdateWalletDataEncryptingKey},
+		{"Seeds", testSeeds},
+		{"ReserveSeedIndexes", testReserveSeedIndexes},
+		{"UpdateSeedDataEncryptingKey", testUpdateSeedDataEncryptingKey},
+		{"PutSeed", testPutSeed},
+		{"ListAllSeeds", testListAllSeeds},
+		{"IssuedSignatures", testIssuedSignatures},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &vault{}
+			v.backend = factory(t, v)
+			tt.test(t, context.Background(), v.backend)
+		})
+	}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func randomAccount() interfaces.ID {
+	return "acct-" + hex.EncodeToString(randomBytes(12))
+}
+
+// missingDataEncryptingKey returns a well formed id which no backend has issued.
+func missingDataEncryptingKey() interfaces.ID {
+	return interfaces.NewDataEncryptingKeyID()
+}
+
+func randomAddress() common.Address {
+	return common.BytesToAddress(randomBytes(common.AddressLength))
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var e *fiber.Error
+	if err == nil {
+		t.Fatalf("expected error with status %d, got nil", status)
+	} else if !errors.As(err, &e) {
+		t.Fatalf("expected fiber error with status %d, got %v", status, err)
+	} else if e.Code != status {
+		t.Fatalf("expected status %d, got %d: %v", status, e.Code, err)
+	}
+}
+
+func createDataEncryptingKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) interfaces.DataEncryptingKey {
+	t.Helper()
+
+	if k, err := backend.CreateDataEncryptingKey(ctx, interfaces.NewDataEncryptingKeyID(), KeyEncryptingKey, randomBytes(32)); err != nil {
+		t.Fatalf("create data encrypting key: %v", err)
+		return nil
+	} else {
+		return k
+	}
+}
+
+func createWallet(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend, account interfaces.ID, dataEncryptingKey interfaces.ID) interfaces.Wallet {
+	t.Helper()
+
+	if w, err := backend.CreateWallet(ctx, account, "wallet", randomAddress(), dataEncryptingKey, randomBytes(60)); err != nil {
+		t.Fatalf("create wallet: %v", err)
+		return nil
+	} else {
+		return w
+	}
+}
+
+func refCount(t *testing.T, ctx context.Context, k interfaces.DataEncryptingKey) int64 {
+	t.Helper()
+
+	if count, err := k.RefCount(ctx); err != nil {
+		t.Fatalf("ref count for %s: %v", k.ID(), err)
+		return 0
+	} else {
+		return count
+	}
+}
+
+func requireExpires(t *testing.T, expires *time.Time, ttl time.Duration) {
+	t.Helper()
+
+	// allow for clock skew and the storage precision of the backend
+	if expires == nil {
+		t.Fatalf("expected expires to be set")
+	} else if d := time.Until(*expires); d < ttl - time.Minute || d > ttl + time.Minute {
+		t.Fatalf("expected expires in %s, got %s", ttl, d)
+	}
+}
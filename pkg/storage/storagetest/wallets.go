@@ -0,0 +1,318 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+func testWallets(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	address := randomAddress()
+	encryptedPrivateKey := randomBytes(60)
+
+	created, err := backend.CreateWallet(ctx, account, "wallet", address, k.ID(), encryptedPrivateKey)
+	if err != nil {
+		t.Fatalf("create wallet: %v", err)
+	} else if created.ID() == "" {
+		t.Fatalf("expected wallet id")
+	} else if created.Created().IsZero() || created.Updated().IsZero() {
+		t.Fatalf("expected created and updated to be set")
+	}
+
+	w, err := backend.GetWallet(ctx, account, address)
+	if err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.ID() != created.ID() {
+		t.Fatalf("expected id %s, got %s", created.ID(), w.ID())
+	} else if w.Account() != account {
+		t.Fatalf("expected account %s, got %s", account, w.Account())
+	} else if w.Name() != "wallet" {
+		t.Fatalf("expected name wallet, got %s", w.Name())
+	} else if w.Address() != address {
+		t.Fatalf("expected address %s, got %s", address, w.Address())
+	} else if w.DataEncryptingKey() != k.ID() {
+		t.Fatalf("expected data encrypting key %s, got %s", k.ID(), w.DataEncryptingKey())
+	} else if !bytes.Equal(w.EncryptedPrivateKey(), encryptedPrivateKey) {
+		t.Fatalf("encrypted private key does not round trip")
+	} else if w.Expires() != nil {
+		t.Fatalf("expected new wallet not to expire, got %s", w.Expires())
+	} else if d := w.Created().Sub(created.Created()); d < -time.Second || d > time.Second {
+		t.Fatalf("expected created %s, got %s", created.Created(), w.Created())
+	}
+
+	if count := refCount(t, ctx, k); count != 1 {
+		t.Fatalf("expected ref count 1, got %d", count)
+	}
+
+	_, err = backend.GetWallet(ctx, account, randomAddress())
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testListWallets(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	const total = 5
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+
+	ids := map[interfaces.ID]bool{}
+	for i := 0; i < total; i++ {
+		ids[createWallet(t, ctx, backend, account, k.ID()).ID()] = false
+	}
+
+	if r, err := backend.ListWallets(ctx, account, 0, 0); err != nil {
+		t.Fatalf("list wallets: %v", err)
+	} else if r.Count() != total || len(r.Page()) != total {
+		t.Fatalf("expected %d wallets with no limit, got count %d and page of %d", total, r.Count(), len(r.Page()))
+	}
+
+	for offset := int64(0); offset < total; offset += 2 {
+		r, err := backend.ListWallets(ctx, account, offset, 2)
+		if err != nil {
+			t.Fatalf("list wallets: %v", err)
+		} else if r.Count() != total {
+			t.Fatalf("expected count %d, got %d", total, r.Count())
+		} else if expected := min(2, total - offset); int64(len(r.Page())) != expected {
+			t.Fatalf("expected page of %d at offset %d, got %d", expected, offset, len(r.Page()))
+		}
+
+		for _, w := range r.Page() {
+			if seen, ok := ids[w.ID()]; !ok {
+				t.Fatalf("unexpected wallet %s", w.ID())
+			} else if seen {
+				t.Fatalf("wallet %s returned on more than one page", w.ID())
+			} else {
+				ids[w.ID()] = true
+			}
+		}
+	}
+
+	for id, seen := range ids {
+		if !seen {
+			t.Fatalf("wallet %s missing from pages", id)
+		}
+	}
+
+	if r, err := backend.ListWallets(ctx, account, total, 2); err != nil {
+		t.Fatalf("list wallets: %v", err)
+	} else if len(r.Page()) != 0 {
+		t.Fatalf("expected empty page past the end, got %d", len(r.Page()))
+	}
+}
+
+func testUpdateWallet(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	created := createWallet(t, ctx, backend, account, k.ID())
+
+	// allow for backends which store timestamps with millisecond precision
+	time.Sleep(10 * time.Millisecond)
+
+	if updated, err := backend.UpdateWallet(ctx, account, created.Address(), "renamed"); err != nil {
+		t.Fatalf("update wallet: %v", err)
+	} else if updated.Name() != "renamed" {
+		t.Fatalf("expected name renamed, got %s", updated.Name())
+	} else if !updated.Updated().After(created.Updated()) {
+		t.Fatalf("expected updated to advance from %s, got %s", created.Updated(), updated.Updated())
+	}
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.Name() != "renamed" {
+		t.Fatalf("expected name renamed, got %s", w.Name())
+	} else if w.ID() != created.ID() || w.DataEncryptingKey() != created.DataEncryptingKey() || !bytes.Equal(w.EncryptedPrivateKey(), created.EncryptedPrivateKey()) {
+		t.Fatalf("update changed more than the name")
+	}
+
+	_, err := backend.UpdateWallet(ctx, account, randomAddress(), "renamed")
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testUpdateWalletPermissions(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	created := createWallet(t, ctx, backend, account, k.ID())
+
+	if len(created.Permissions()) != 0 {
+		t.Fatalf("expected new wallet to have no permissions, got %v", created.Permissions())
+	}
+
+	permissions := []string{"sign-hash", "other"}
+	if updated, err := backend.UpdateWalletPermissions(ctx, account, created.Address(), permissions); err != nil {
+		t.Fatalf("update wallet permissions: %v", err)
+	} else if !slices.Equal(updated.Permissions(), permissions) {
+		t.Fatalf("expected permissions %v, got %v", permissions, updated.Permissions())
+	}
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if !slices.Equal(w.Permissions(), permissions) {
+		t.Fatalf("expected permissions %v, got %v", permissions, w.Permissions())
+	} else if w.Name() != created.Name() || !bytes.Equal(w.EncryptedPrivateKey(), created.EncryptedPrivateKey()) {
+		t.Fatalf("update changed more than the permissions")
+	}
+
+	if _, err := backend.UpdateWalletPermissions(ctx, account, created.Address(), nil); err != nil {
+		t.Fatalf("update wallet permissions: %v", err)
+	} else if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if len(w.Permissions()) != 0 {
+		t.Fatalf("expected permissions to be cleared, got %v", w.Permissions())
+	}
+
+	_, err := backend.UpdateWalletPermissions(ctx, randomAccount(), created.Address(), permissions)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testUpdateWalletDataEncryptingKey(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	from := createDataEncryptingKey(t, ctx, backend)
+	to := createDataEncryptingKey(t, ctx, backend)
+	created := createWallet(t, ctx, backend, account, from.ID())
+	encrypted := randomBytes(60)
+
+	time.Sleep(10 * time.Millisecond)
+
+	if updated, err := backend.UpdateWalletDataEncryptingKey(ctx, account, created.Address(), from.ID(), to.ID(), encrypted); err != nil {
+		t.Fatalf("update wallet data encrypting key: %v", err)
+	} else if updated.DataEncryptingKey() != to.ID() || !bytes.Equal(updated.EncryptedPrivateKey(), encrypted) {
+		t.Fatalf("expected wallet to reference %s", to.ID())
+	} else if !updated.Updated().After(created.Updated()) {
+		t.Fatalf("expected updated to advance from %s, got %s", created.Updated(), updated.Updated())
+	}
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.ID() != created.ID() || w.Name() != created.Name() || w.DataEncryptingKey() != to.ID() || !bytes.Equal(w.EncryptedPrivateKey(), encrypted) {
+		t.Fatalf("unexpected wallet after update")
+	}
+
+	if count := refCount(t, ctx, from); count != 0 {
+		t.Fatalf("expected ref count 0 for %s, got %d", from.ID(), count)
+	} else if count := refCount(t, ctx, to); count != 1 {
+		t.Fatalf("expected ref count 1 for %s, got %d", to.ID(), count)
+	}
+
+	// a second rotation from the old key lost the race
+	_, err := backend.UpdateWalletDataEncryptingKey(ctx, account, created.Address(), from.ID(), to.ID(), encrypted)
+	requireStatus(t, err, fiber.StatusConflict)
+
+	// re-encrypting under the same key leaves the reference count alone
+	reencrypted := randomBytes(61)
+	if updated, err := backend.UpdateWalletDataEncryptingKey(ctx, account, created.Address(), to.ID(), to.ID(), reencrypted); err != nil {
+		t.Fatalf("update wallet encrypted private key: %v", err)
+	} else if updated.DataEncryptingKey() != to.ID() || !bytes.Equal(updated.EncryptedPrivateKey(), reencrypted) {
+		t.Fatalf("expected wallet encrypted private key to be replaced")
+	} else if count := refCount(t, ctx, to); count != 1 {
+		t.Fatalf("expected ref count 1 for %s, got %d", to.ID(), count)
+	}
+
+	_, err = backend.UpdateWalletDataEncryptingKey(ctx, account, created.Address(), to.ID(), missingDataEncryptingKey(), encrypted)
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	_, err = backend.UpdateWalletDataEncryptingKey(ctx, randomAccount(), created.Address(), to.ID(), from.ID(), encrypted)
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testExpireWallet(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	created := createWallet(t, ctx, backend, account, k.ID())
+
+	if expired, err := backend.ExpireWallet(ctx, account, created.Address(), time.Hour); err != nil {
+		t.Fatalf("expire wallet: %v", err)
+	} else {
+		requireExpires(t, expired.Expires(), time.Hour)
+	}
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet before ttl: %v", err)
+	} else {
+		requireExpires(t, w.Expires(), time.Hour)
+	}
+
+	if unexpired, err := backend.UnexpireWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("unexpire wallet: %v", err)
+	} else if unexpired.Expires() != nil {
+		t.Fatalf("expected expires to be cleared, got %s", unexpired.Expires())
+	}
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.Expires() != nil {
+		t.Fatalf("expected expires to be cleared, got %s", w.Expires())
+	}
+
+	_, err := backend.ExpireWallet(ctx, account, randomAddress(), time.Hour)
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = backend.UnexpireWallet(ctx, account, randomAddress())
+	requireStatus(t, err, fiber.StatusNotFound)
+}
+
+func testAddressUniqueness(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account := randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	created := createWallet(t, ctx, backend, account, k.ID())
+
+	_, err := backend.CreateWallet(ctx, account, "duplicate", created.Address(), k.ID(), randomBytes(60))
+	requireStatus(t, err, fiber.StatusConflict)
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.ID() != created.ID() || w.Name() != created.Name() {
+		t.Fatalf("duplicate create replaced wallet %s", created.ID())
+	}
+
+	if r, err := backend.ListWallets(ctx, account, 0, 0); err != nil {
+		t.Fatalf("list wallets: %v", err)
+	} else if r.Count() != 1 {
+		t.Fatalf("expected 1 wallet after duplicate create, got %d", r.Count())
+	}
+
+	if count := refCount(t, ctx, k); count != 1 {
+		t.Fatalf("expected ref count 1 after duplicate create, got %d", count)
+	}
+}
+
+func testAccountIsolation(t *testing.T, ctx context.Context, backend interfaces.IStorageBackend) {
+	account, other := randomAccount(), randomAccount()
+	k := createDataEncryptingKey(t, ctx, backend)
+	created := createWallet(t, ctx, backend, account, k.ID())
+	createWallet(t, ctx, backend, other, k.ID())
+
+	_, err := backend.GetWallet(ctx, other, created.Address())
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = backend.UpdateWallet(ctx, other, created.Address(), "renamed")
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = backend.ExpireWallet(ctx, other, created.Address(), time.Hour)
+	requireStatus(t, err, fiber.StatusNotFound)
+	_, err = backend.UnexpireWallet(ctx, other, created.Address())
+	requireStatus(t, err, fiber.StatusNotFound)
+
+	if w, err := backend.GetWallet(ctx, account, created.Address()); err != nil {
+		t.Fatalf("get wallet: %v", err)
+	} else if w.Name() != created.Name() || w.Expires() != nil {
+		t.Fatalf("wallet %s modified through another account", created.ID())
+	}
+
+	for _, a := range []interfaces.ID{account, other} {
+		if r, err := backend.ListWallets(ctx, a, 0, 0); err != nil {
+			t.Fatalf("list wallets: %v", err)
+		} else if r.Count() != 1 || len(r.Page()) != 1 {
+			t.Fatalf("expected 1 wallet for account %s, got %d", a, r.Count())
+		} else if r.Page()[0].Account() != a {
+			t.Fatalf("account %s listed wallet of account %s", a, r.Page()[0].Account())
+		}
+	}
+
+	if r, err := backend.ListWallets(ctx, randomAccount(), 0, 0); err != nil {
+		t.Fatalf("list wallets: %v", err)
+	} else if r.Count() != 0 || len(r.Page()) != 0 {
+		t.Fatalf("expected no wallets for a new account, got %d", r.Count())
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 )
 
-func encrypt(secretKey []byte, plaintext []byte) ([]byte, error) {
+func encrypt(secretKey []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
     aes, err := aes.NewCipher([]byte(secretKey))
     if err != nil {
       return nil, err
@@ -23,12 +24,12 @@ func encrypt(secretKey []byte, plaintext []byte) ([]byte, error) {
       return nil, err
     }
 
-    ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+    ciphertext := gcm.Seal(nonce, nonce, plaintext, additionalData)
 
     return ciphertext, err
 }
 
-func decrypt(secretKey []byte, ciphertext []byte) ([]byte, error) {
+func decrypt(secretKey []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
     aes, err := aes.NewCipher(secretKey)
     if err != nil {
       return nil, err
@@ -40,9 +41,12 @@ func decrypt(secretKey []byte, ciphertext []byte) ([]byte, error) {
     }
 
     nonceSize := gcm.NonceSize()
+    if len(ciphertext) < nonceSize {
+      return nil, errors.New("ciphertext too short")
+    }
     nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
-    plaintext, err := gcm.Open(nil, []byte(nonce), ciphertext, nil)
+    plaintext, err := gcm.Open(nil, []byte(nonce), ciphertext, additionalData)
     if err != nil {
       return nil, err
     }
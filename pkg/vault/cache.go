@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+const (
+	defaultKeyCacheSize = 1024
+	defaultKeyCacheTTL = 5 * time.Minute
+)
+
+// cachedKey holds an unwrapped data encrypting key until it is evicted, when
+// the key is zeroed. Readers copy the key under the mutex, so a key is never
+// zeroed while it is being read.
+type cachedKey struct {
+	mutex sync.Mutex
+	key []byte
+}
+
+func (k *cachedKey) zero() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	clear(k.key)
+	k.key = nil
+}
+
+// keyCache caches unwrapped data encrypting keys, which are shared by up to
+// 1000 wallets, to save a key encrypting key call for every wallet read or
+// created. Entries are evicted when the cache is full or after the ttl.
+//
+// A purge or an expiry advances the generation. Callers read the generation
+// before they unwrap a key and add it with that generation, so a key unwrapped
+// before the vault was sealed, or before the key was scheduled to expire, is
+// not cached after the purge or expiry that followed.
+type keyCache struct {
+	mutex sync.Mutex
+	lru *expirable.LRU[interfaces.ID, *cachedKey]
+	generation_ uint64
+}
+
+// newKeyCacheFromEnv sizes the cache with VAULT_DEK_CACHE_SIZE and
+// VAULT_DEK_CACHE_TTL. A size of 0 disables the cache and returns nil.
+func newKeyCacheFromEnv() (*keyCache, error) {
+	size := defaultKeyCacheSize
+	ttl := defaultKeyCacheTTL
+
+	if s := os.Getenv("VAULT_DEK_CACHE_SIZE"); s != "" {
+		if n, err := strconv.Atoi(s); err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid VAULT_DEK_CACHE_SIZE %s", s)
+		} else {
+			size = n
+		}
+	}
+
+	if s := os.Getenv("VAULT_DEK_CACHE_TTL"); s != "" {
+		if d, err := time.ParseDuration(s); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid VAULT_DEK_CACHE_TTL %s", s)
+		} else {
+			ttl = d
+		}
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	return &keyCache{
+		lru: expirable.NewLRU(size, func(id interfaces.ID, k *cachedKey) {
+			k.zero()
+		}, ttl),
+	}, nil
+}
+
+// get returns a copy of the cached key, which the caller should clear once
+// used.
+func (c *keyCache) get(id interfaces.ID) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	} else if k, ok := c.lru.Get(id); !ok {
+		return nil, false
+	} else {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+
+		if k.key == nil {
+			return nil, false
+		}
+		return append([]byte{}, k.key...), true
+	}
+}
+
+// generation returns the generation to add keys unwrapped from now on with.
+func (c *keyCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.generation_
+}
+
+// add caches a copy of key unless the cache was purged since generation was
+// read. An entry replaced by add is not passed to the eviction callback, so it
+// is zeroed here.
+func (c *keyCache) add(id interfaces.ID, key []byte, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation_ {
+		return
+	}
+
+	previous, ok := c.lru.Peek(id)
+	c.lru.Add(id, &cachedKey{key: append([]byte{}, key...)})
+	if ok {
+		previous.zero()
+	}
+}
+
+// purge zeroes and removes every cached key, and stops keys unwrapped before
+// the purge from being added.
+func (c *keyCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation_++
+	c.lru.Purge()
+}
+
+// expire zeroes and removes a key scheduled to expire, and stops keys unwrapped
+// before it was scheduled from being added.
+func (c *keyCache) expire(id interfaces.ID) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation_++
+	c.lru.Remove(id)
+}
+
+func (c *keyCache) remove(id interfaces.ID) {
+	if c == nil {
+		return
+	}
+
+	c.lru.Remove(id)
+}
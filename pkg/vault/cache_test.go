@@ -0,0 +1,167 @@
+package vault
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func newTestKeyCache(t *testing.T, size string, ttl string) *keyCache {
+	t.Setenv("VAULT_DEK_CACHE_SIZE", size)
+	t.Setenv("VAULT_DEK_CACHE_TTL", ttl)
+
+	c, err := newKeyCacheFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestKeyCache(t *testing.T) {
+	c := newTestKeyCache(t, "2", "1m")
+	key := bytes.Repeat([]byte{1}, 32)
+
+	c.add("dek-1", key, c.generation())
+	clear(key)
+
+	// the cache holds its own copy and hands out copies
+	got, ok := c.get("dek-1")
+	if !ok || !bytes.Equal(got, bytes.Repeat([]byte{1}, 32)) {
+		t.Fatalf("expected key to be cached")
+	}
+	clear(got)
+	if got, ok := c.get("dek-1"); !ok || !bytes.Equal(got, bytes.Repeat([]byte{1}, 32)) {
+		t.Fatalf("expected clearing a copy to leave the cached key")
+	}
+
+	cached, _ := c.lru.Peek("dek-1")
+	c.add("dek-1", bytes.Repeat([]byte{2}, 32), c.generation())
+	if cached.key != nil {
+		t.Fatalf("expected replaced key to be zeroed")
+	}
+
+	cached, _ = c.lru.Peek("dek-1")
+	c.remove("dek-1")
+	if _, ok := c.get("dek-1"); ok {
+		t.Fatalf("expected removed key not to be cached")
+	} else if cached.key != nil {
+		t.Fatalf("expected removed key to be zeroed")
+	}
+
+	// adding a third key evicts the least recently used
+	c.add("dek-1", key, c.generation())
+	cached, _ = c.lru.Peek("dek-1")
+	c.add("dek-2", key, c.generation())
+	c.add("dek-3", key, c.generation())
+	if _, ok := c.get("dek-1"); ok {
+		t.Fatalf("expected least recently used key to be evicted")
+	} else if cached.key != nil {
+		t.Fatalf("expected evicted key to be zeroed")
+	}
+}
+
+func TestKeyCacheTTL(t *testing.T) {
+	c := newTestKeyCache(t, "16", "10ms")
+
+	c.add("dek-1", bytes.Repeat([]byte{1}, 32), c.generation())
+	cached, _ := c.lru.Peek("dek-1")
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.get("dek-1"); ok {
+		t.Fatalf("expected key to expire")
+	}
+
+	// expired entries are evicted in the background
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		cached.mutex.Lock()
+		zeroed := cached.key == nil
+		cached.mutex.Unlock()
+
+		if zeroed {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected expired key to be zeroed")
+		}
+	}
+}
+
+func TestKeyCacheDisabled(t *testing.T) {
+	c := newTestKeyCache(t, "0", "1m")
+	if c != nil {
+		t.Fatalf("expected a size of 0 to disable the cache")
+	}
+
+	c.add("dek-1", bytes.Repeat([]byte{1}, 32), c.generation())
+	if _, ok := c.get("dek-1"); ok {
+		t.Fatalf("expected disabled cache to cache nothing")
+	}
+	c.remove("dek-1")
+}
+
+func TestKeyCacheFromEnv(t *testing.T) {
+	for _, env := range [][2]string{{"-1", "1m"}, {"many", "1m"}, {"16", "0s"}, {"16", "soon"}} {
+		t.Setenv("VAULT_DEK_CACHE_SIZE", env[0])
+		t.Setenv("VAULT_DEK_CACHE_TTL", env[1])
+
+		if _, err := newKeyCacheFromEnv(); err == nil {
+			t.Errorf("expected size %s and ttl %s to be rejected", env[0], env[1])
+		}
+	}
+}
+
+// TestKeyCachePurge checks that a key unwrapped before a purge, as by a
+// request racing a seal, is not cached once the purge has run.
+func TestKeyCachePurge(t *testing.T) {
+	t.Setenv("VAULT_DEK_CACHE_SIZE", "16")
+
+	c, err := newKeyCacheFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{1}, 32)
+
+	c.add("dek-cached", key, c.generation())
+	if got, ok := c.get("dek-cached"); !ok || !bytes.Equal(got, key) {
+		t.Fatalf("expected key to be cached")
+	}
+
+	generation := c.generation()
+	c.purge()
+
+	if _, ok := c.get("dek-cached"); ok {
+		t.Fatalf("expected purge to drop cached keys")
+	}
+
+	c.add("dek-unwrapped", key, generation)
+	if _, ok := c.get("dek-unwrapped"); ok {
+		t.Fatalf("expected key unwrapped before the purge not to be cached")
+	}
+
+	c.add("dek-unwrapped", key, c.generation())
+	if _, ok := c.get("dek-unwrapped"); !ok {
+		t.Fatalf("expected key unwrapped after the purge to be cached")
+	}
+}
+
+// TestKeyCacheExpire checks that a key unwrapped before it was scheduled to
+// expire, as by a request racing the expiry, is not cached once it expires.
+func TestKeyCacheExpire(t *testing.T) {
+	c := newTestKeyCache(t, "16", "1m")
+	key := bytes.Repeat([]byte{1}, 32)
+
+	generation := c.generation()
+	c.add("dek-1", key, generation)
+	cached, _ := c.lru.Peek("dek-1")
+
+	c.expire("dek-1")
+	if _, ok := c.get("dek-1"); ok {
+		t.Fatalf("expected expired key not to be cached")
+	} else if cached.key != nil {
+		t.Fatalf("expected expired key to be zeroed")
+	}
+
+	c.add("dek-1", key, generation)
+	if _, ok := c.get("dek-1"); ok {
+		t.Fatalf("expected key unwrapped before the expiry not to be cached")
+	}
+}
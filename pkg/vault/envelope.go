@@ -0,0 +1,104 @@
+package vault
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+const (
+	envelopeLegacy byte = 0
+	envelopeV1 byte = 1
+	// envelopeHD marks a wallet derived from the seed of its account, which
+	// stores the derivation index in place of an encrypted private key
+	envelopeHD byte = 2
+	envelopeSeed byte = 3
+
+	// legacy envelopes are a 12 byte nonce, a 32 byte private key and a 16 byte
+	// tag, with no version and no additional authenticated data
+	legacyEnvelopeSize = 12 + 32 + 16
+	hdEnvelopeSize = 1 + 4
+)
+
+// walletAdditionalData binds an encrypted private key to the account, address
+// and data encrypting key of its wallet, so that it can't be moved to another
+// wallet. Fields are length prefixed so that they can't be shifted into one
+// another.
+func walletAdditionalData(account interfaces.ID, address common.Address, dataEncryptingKey interfaces.ID) []byte {
+	return additionalData(envelopeV1, []byte(account), address.Bytes(), []byte(dataEncryptingKey))
+}
+
+// seedAdditionalData binds an encrypted seed to its account and data
+// encrypting key. The envelope version keeps seeds and private keys from being
+// opened as one another.
+func seedAdditionalData(account interfaces.ID, dataEncryptingKey interfaces.ID) []byte {
+	return additionalData(envelopeSeed, []byte(account), []byte(dataEncryptingKey))
+}
+
+func additionalData(version byte, fields ...[]byte) []byte {
+	additionalData := []byte{version}
+	for _, field := range fields {
+		additionalData = binary.BigEndian.AppendUint32(additionalData, uint32(len(field)))
+		additionalData = append(additionalData, field...)
+	}
+	return additionalData
+}
+
+// sealPrivateKey encrypts a wallet private key in a v1 envelope, which is the
+// version byte followed by the AES-GCM nonce and ciphertext.
+func sealPrivateKey(key []byte, account interfaces.ID, address common.Address, dataEncryptingKey interfaces.ID, privateKey []byte) ([]byte, error) {
+	if ciphertext, err := encrypt(key, privateKey, walletAdditionalData(account, address, dataEncryptingKey)); err != nil {
+		return nil, err
+	} else {
+		return append([]byte{envelopeV1}, ciphertext...), nil
+	}
+}
+
+// openPrivateKey decrypts a wallet private key and returns the version of its
+// envelope, so that legacy envelopes can be upgraded.
+func openPrivateKey(key []byte, account interfaces.ID, address common.Address, dataEncryptingKey interfaces.ID, envelope []byte) ([]byte, byte, error) {
+	if len(envelope) == legacyEnvelopeSize {
+		privateKey, err := decrypt(key, envelope, nil)
+		return privateKey, envelopeLegacy, err
+	} else if len(envelope) > 0 && envelope[0] == envelopeV1 {
+		privateKey, err := decrypt(key, envelope[1:], walletAdditionalData(account, address, dataEncryptingKey))
+		return privateKey, envelopeV1, err
+	} else {
+		return nil, 0, fmt.Errorf("unsupported private key envelope for wallet %s", address)
+	}
+}
+
+// sealSeed encrypts the seed of an account in a seed envelope, which is the
+// version byte followed by the AES-GCM nonce and ciphertext.
+func sealSeed(key []byte, account interfaces.ID, dataEncryptingKey interfaces.ID, seed []byte) ([]byte, error) {
+	if ciphertext, err := encrypt(key, seed, seedAdditionalData(account, dataEncryptingKey)); err != nil {
+		return nil, err
+	} else {
+		return append([]byte{envelopeSeed}, ciphertext...), nil
+	}
+}
+
+func openSeed(key []byte, account interfaces.ID, dataEncryptingKey interfaces.ID, envelope []byte) ([]byte, error) {
+	if len(envelope) > 0 && envelope[0] == envelopeSeed {
+		return decrypt(key, envelope[1:], seedAdditionalData(account, dataEncryptingKey))
+	} else {
+		return nil, fmt.Errorf("unsupported seed envelope for account %s", account)
+	}
+}
+
+// hdEnvelope records the derivation index of a wallet derived from the seed of
+// its account. The index isn't secret, a wallet moved to another index or
+// account fails to derive to its address.
+func hdEnvelope(index uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{envelopeHD}, index)
+}
+
+func derivationIndex(envelope []byte) (uint32, bool) {
+	if len(envelope) == hdEnvelopeSize && envelope[0] == envelopeHD {
+		return binary.BigEndian.Uint32(envelope[1:]), true
+	} else {
+		return 0, false
+	}
+}
@@ -0,0 +1,255 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/memory"
+)
+
+// plainKeyEncryptingKeyProvider stores data encrypting keys as they are, as
+// envelopes don't depend on how their keys are wrapped.
+type plainKeyEncryptingKeyProvider struct{}
+
+func (p plainKeyEncryptingKeyProvider) Encrypt(ctx context.Context, dataEncryptingKey interfaces.ID, plaintext []byte) (interfaces.ID, []byte, error) {
+	return "kek-plain", append([]byte{}, plaintext...), nil
+}
+
+func (p plainKeyEncryptingKeyProvider) Decrypt(ctx context.Context, dataEncryptingKey interfaces.ID, keyEncryptingKey interfaces.ID, ciphertext []byte) ([]byte, error) {
+	return append([]byte{}, ciphertext...), nil
+}
+
+// testWallet is a stored wallet with the given envelope, which need not match
+// its account, address or data encrypting key.
+type testWallet struct {
+	account interfaces.ID
+	address common.Address
+	dataEncryptingKey interfaces.ID
+	envelope []byte
+}
+
+func (w *testWallet) ID() interfaces.ID {
+	return "wallet"
+}
+
+func (w *testWallet) Account() interfaces.ID {
+	return w.account
+}
+
+func (w *testWallet) Name() string {
+	return "wallet"
+}
+
+func (w *testWallet) Address() common.Address {
+	return w.address
+}
+
+func (w *testWallet) DataEncryptingKey() interfaces.ID {
+	return w.dataEncryptingKey
+}
+
+func (w *testWallet) EncryptedPrivateKey() []byte {
+	return w.envelope
+}
+
+func (w *testWallet) Permissions() []string {
+	return nil
+}
+
+func (w *testWallet) Created() time.Time {
+	return time.Time{}
+}
+
+func (w *testWallet) Updated() time.Time {
+	return time.Time{}
+}
+
+func (w *testWallet) Expires() *time.Time {
+	return nil
+}
+
+func newTestVault(t *testing.T) *vault {
+	t.Setenv("VAULT_DEK_CACHE_SIZE", "0")
+
+	v, err := NewVault(plainKeyEncryptingKeyProvider{})
+	if err != nil {
+		t.Fatal(err)
+	} else if storage, err := memory.NewMemoryStorageBackend(v); err != nil {
+		t.Fatal(err)
+	} else if err := v.SetStorageBackend(storage); err != nil {
+		t.Fatal(err)
+	}
+	return v.(*vault)
+}
+
+func TestSealOpenPrivateKey(t *testing.T) {
+	key, otherKey := make([]byte, 32), make([]byte, 32)
+	rand.Read(key)
+	rand.Read(otherKey)
+	privateKey := bytes.Repeat([]byte{7}, 32)
+	address := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+
+	sealed, err := sealPrivateKey(key, "account", address, "dek-1", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := encrypt(key, privateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(legacy) != legacyEnvelopeSize {
+		t.Fatalf("expected a %d byte legacy envelope, got %d", legacyEnvelopeSize, len(legacy))
+	}
+	seed, err := sealSeed(key, "account", "dek-1", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered) - 1] ^= 1
+
+	for _, c := range []struct {
+		name string
+		key []byte
+		account interfaces.ID
+		address common.Address
+		dataEncryptingKey interfaces.ID
+		envelope []byte
+		version byte
+		ok bool
+	}{
+		{"v1 envelope", key, "account", address, "dek-1", sealed, envelopeV1, true},
+		{"another account", key, "other", address, "dek-1", sealed, 0, false},
+		{"another address", key, "account", common.HexToAddress("0x01"), "dek-1", sealed, 0, false},
+		{"another data encrypting key id", key, "account", address, "dek-2", sealed, 0, false},
+		{"another data encrypting key", otherKey, "account", address, "dek-1", sealed, 0, false},
+		{"tampered envelope", key, "account", address, "dek-1", tampered, 0, false},
+		{"legacy envelope", key, "account", address, "dek-1", legacy, envelopeLegacy, true},
+		// legacy envelopes aren't bound, openWallet checks the address
+		{"legacy envelope of another wallet", key, "other", common.HexToAddress("0x01"), "dek-2", legacy, envelopeLegacy, true},
+		{"seed envelope", key, "account", address, "dek-1", seed, 0, false},
+		{"hd envelope", key, "account", address, "dek-1", hdEnvelope(1), 0, false},
+		{"empty envelope", key, "account", address, "dek-1", nil, 0, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			opened, version, err := openPrivateKey(c.key, c.account, c.address, c.dataEncryptingKey, c.envelope)
+			if !c.ok {
+				if err == nil {
+					t.Fatal("expected the envelope not to open")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if version != c.version {
+				t.Fatalf("expected envelope version %d, got %d", c.version, version)
+			} else if !bytes.Equal(opened, privateKey) {
+				t.Fatal("opened private key differs from the sealed private key")
+			}
+		})
+	}
+}
+
+func TestOpenWallet(t *testing.T) {
+	ctx := context.Background()
+	v := newTestVault(t)
+
+	dataEncryptingKey, err := v.CreateDataEncryptingKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDataEncryptingKey, err := v.CreateDataEncryptingKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, _ := crypto.GenerateKey()
+	otherPrivateKey, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+	otherAddress := crypto.PubkeyToAddress(otherPrivateKey.PublicKey)
+
+	sealed, err := v.sealWallet(ctx, "account", address, dataEncryptingKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a private key sealed for the address which doesn't derive to it
+	mismatched, err := v.sealWallet(ctx, "account", address, dataEncryptingKey, otherPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := encrypt(dataEncryptingKey.EncryptedKey(), crypto.FromECDSA(privateKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		wallet testWallet
+		version byte
+		ok bool
+	}{
+		{"v1 envelope", testWallet{"account", address, dataEncryptingKey.ID(), sealed}, envelopeV1, true},
+		{"v1 envelope under another account", testWallet{"other", address, dataEncryptingKey.ID(), sealed}, 0, false},
+		{"v1 envelope under another address", testWallet{"account", otherAddress, dataEncryptingKey.ID(), sealed}, 0, false},
+		{"v1 envelope under another data encrypting key", testWallet{"account", address, otherDataEncryptingKey.ID(), sealed}, 0, false},
+		{"v1 envelope of another private key", testWallet{"account", address, dataEncryptingKey.ID(), mismatched}, 0, false},
+		{"legacy envelope", testWallet{"account", address, dataEncryptingKey.ID(), legacy}, envelopeLegacy, true},
+		{"legacy envelope under another address", testWallet{"account", otherAddress, dataEncryptingKey.ID(), legacy}, 0, false},
+		{"missing data encrypting key", testWallet{"account", address, "dek-missing", sealed}, 0, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			opened, version, err := v.openWallet(ctx, &c.wallet)
+			if !c.ok {
+				if err == nil {
+					t.Fatal("expected the wallet not to open")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if version != c.version {
+				t.Fatalf("expected envelope version %d, got %d", c.version, version)
+			} else if !opened.Equal(privateKey) {
+				t.Fatal("opened private key differs from the sealed private key")
+			}
+		})
+	}
+}
+
+// TestUpgradeLegacyEnvelope reads a wallet stored in a legacy envelope, which
+// is sealed in a v1 envelope as it is read.
+func TestUpgradeLegacyEnvelope(t *testing.T) {
+	ctx := context.Background()
+	v := newTestVault(t)
+
+	dataEncryptingKey, err := v.CreateDataEncryptingKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	if legacy, err := encrypt(dataEncryptingKey.EncryptedKey(), crypto.FromECDSA(privateKey), nil); err != nil {
+		t.Fatal(err)
+	} else if _, err := v.storage.CreateWallet(ctx, "account", "wallet", address, dataEncryptingKey.ID(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	if w, err := v.GetWallet(ctx, "account", address); err != nil {
+		t.Fatal(err)
+	} else if opened, err := w.PrivateKey(ctx); err != nil {
+		t.Fatal(err)
+	} else if !opened.Equal(privateKey) {
+		t.Fatal("opened private key differs from the stored private key")
+	}
+
+	if w, err := v.storage.GetWallet(ctx, "account", address); err != nil {
+		t.Fatal(err)
+	} else if envelope := w.EncryptedPrivateKey(); len(envelope) == 0 || envelope[0] != envelopeV1 {
+		t.Fatal("expected the private key to be upgraded to a v1 envelope")
+	} else if _, version, err := v.openWallet(ctx, w); err != nil {
+		t.Fatal(err)
+	} else if version != envelopeV1 {
+		t.Fatalf("expected envelope version %d, got %d", envelopeV1, version)
+	}
+}
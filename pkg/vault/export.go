@@ -0,0 +1,35 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+const minExportPassphraseLength = 12
+
+// ExportWallet decrypts the private key of a wallet and returns it in a V3
+// keystore encrypted with passphrase, for disaster recovery or migration to
+// another signer.
+func (v *vault) ExportWallet(ctx context.Context, account interfaces.ID, address common.Address, passphrase string) ([]byte, error) {
+	if len(passphrase) < minExportPassphraseLength {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("passphrase must be at least %d characters", minExportPassphraseLength))
+	} else if w, err := v.GetWallet(ctx, account, address); err != nil {
+		return nil, err
+	} else if privateKey, err := w.PrivateKey(ctx); err != nil {
+		return nil, err
+	} else {
+		defer clear(privateKey.D.Bits())
+		return keystore.EncryptKey(&keystore.Key{
+			Id: uuid.New(),
+			Address: crypto.PubkeyToAddress(privateKey.PublicKey),
+			PrivateKey: &privateKey,
+		}, passphrase, keystore.StandardScryptN, keystore.StandardScryptP)
+	}
+}
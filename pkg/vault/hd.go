@@ -0,0 +1,202 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/hd"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+const (
+	// seedSize is the longest seed allowed by BIP-32.
+	seedSize = 64
+	maxDeriveCount = 1000
+)
+
+// DeriveWallets derives the next count wallets from the seed of the account at
+// m/44'/60'/0'/0/index, creating the seed when the account has none. Indexes
+// are reserved before the wallets are derived, so concurrent calls derive
+// distinct wallets, and an index reserved by a call which fails is skipped.
+func (v *vault) DeriveWallets(ctx context.Context, account interfaces.ID, name string, count int64) ([]Wallet, error) {
+	if count < 1 || count > maxDeriveCount {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxDeriveCount))
+	} else if _, err := v.getOrCreateSeed(ctx, account); err != nil {
+		return nil, err
+	}
+
+	seed, err := v.storage.ReserveSeedIndexes(ctx, account, count)
+	if err != nil {
+		return nil, err
+	} else if seed.NextIndex() > hd.HardenedOffset {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("account %s has no more wallets to derive", account))
+	}
+
+	master, err := v.openMasterKey(ctx, seed)
+	if err != nil {
+		return nil, err
+	}
+	defer master.Zero()
+
+	wallets := make([]Wallet, 0, count)
+	for index := uint32(seed.NextIndex() - count); index < uint32(seed.NextIndex()); index++ {
+		privateKey, err := derivePrivateKey(master, index)
+		if err != nil {
+			return nil, err
+		}
+		address := crypto.PubkeyToAddress(privateKey.PublicKey)
+		clear(privateKey.D.Bits())
+
+		if w, err := v.storage.CreateWallet(ctx, account, name, address, seed.DataEncryptingKey(), hdEnvelope(index)); err != nil {
+			return nil, err
+		} else {
+			wallets = append(wallets, &wallet{vault: v, wallet: w})
+		}
+	}
+
+	return wallets, nil
+}
+
+// RotateSeed re-encrypts the seed of an account under the data encrypting key
+// to and moves the seed to it. Wallets derived from the seed follow it when
+// they are rotated. The move fails with a conflict if the seed was moved to
+// another data encrypting key since s was read.
+func (v *vault) RotateSeed(ctx context.Context, s interfaces.Seed, to interfaces.DataEncryptingKey) (interfaces.Seed, error) {
+	var seed []byte
+	defer func() {
+		clear(seed)
+	}()
+
+	var err error
+	if seed, err = v.openSeed(ctx, s); err != nil {
+		return nil, err
+	} else if b, err := v.sealSeed(ctx, s.Account(), to, seed); err != nil {
+		return nil, err
+	} else {
+		return v.storage.UpdateSeedDataEncryptingKey(ctx, s.Account(), s.DataEncryptingKey(), to.ID(), b)
+	}
+}
+
+// getOrCreateSeed returns the seed of the account, creating a random seed when
+// the account has none. Seeds are shared by every wallet derived in the
+// account, so a seed created concurrently by another call is used instead.
+func (v *vault) getOrCreateSeed(ctx context.Context, account interfaces.ID) (interfaces.Seed, error) {
+	var e *fiber.Error
+
+	if s, err := v.storage.GetSeed(ctx, account); err == nil {
+		return s, nil
+	} else if !errors.As(err, &e) || e.Code != fiber.StatusNotFound {
+		return nil, err
+	}
+
+	seed := make([]byte, seedSize)
+	defer clear(seed)
+
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	} else if dataEncryptingKey, err := v.storage.GetOrCreateRandomKey(ctx, 1000); err != nil {
+		return nil, err
+	} else if b, err := v.sealSeed(ctx, account, dataEncryptingKey, seed); err != nil {
+		return nil, err
+	} else if s, err := v.storage.CreateSeed(ctx, account, dataEncryptingKey.ID(), b); errors.As(err, &e) && e.Code == fiber.StatusConflict {
+		return v.storage.GetSeed(ctx, account)
+	} else if err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+// openDerivedWallet derives the private key of a wallet from the seed of its
+// account and checks that it derives to the wallet address.
+func (v *vault) openDerivedWallet(ctx context.Context, w interfaces.Wallet, index uint32) (*ecdsa.PrivateKey, error) {
+	if seed, err := v.storage.GetSeed(ctx, w.Account()); err != nil {
+		return nil, err
+	} else if master, err := v.openMasterKey(ctx, seed); err != nil {
+		return nil, err
+	} else {
+		defer master.Zero()
+
+		if privateKey, err := derivePrivateKey(master, index); err != nil {
+			return nil, err
+		} else if crypto.PubkeyToAddress(privateKey.PublicKey) != w.Address() {
+			clear(privateKey.D.Bits())
+			return nil, fmt.Errorf("private key of wallet %s does not derive to the wallet address", w.Address())
+		} else {
+			return privateKey, nil
+		}
+	}
+}
+
+// rotateDerivedWallet moves a derived wallet to the data encrypting key of its
+// seed, first rotating the seed to to when the seed is still encrypted under
+// the data encrypting key of the wallet. Derived wallets hold no ciphertext,
+// their data encrypting key only keeps the key of the seed referenced.
+func (v *vault) rotateDerivedWallet(ctx context.Context, w interfaces.Wallet, to interfaces.DataEncryptingKey) (Wallet, error) {
+	seed, err := v.storage.GetSeed(ctx, w.Account())
+	if err != nil {
+		return nil, err
+	} else if seed.DataEncryptingKey() == w.DataEncryptingKey() {
+		if seed, err = v.RotateSeed(ctx, seed, to); err != nil {
+			return nil, err
+		}
+	}
+
+	if w, err := v.storage.UpdateWalletDataEncryptingKey(ctx, w.Account(), w.Address(), w.DataEncryptingKey(), seed.DataEncryptingKey(), w.EncryptedPrivateKey()); err != nil {
+		return nil, err
+	} else {
+		return &wallet{vault: v, wallet: w}, nil
+	}
+}
+
+func (v *vault) openMasterKey(ctx context.Context, s interfaces.Seed) (*hd.Key, error) {
+	if seed, err := v.openSeed(ctx, s); err != nil {
+		return nil, err
+	} else {
+		defer clear(seed)
+		return hd.NewMasterKey(seed)
+	}
+}
+
+// openSeed decrypts the seed of an account, which the caller should clear once
+// used.
+func (v *vault) openSeed(ctx context.Context, s interfaces.Seed) ([]byte, error) {
+	var key []byte
+	defer func() {
+		clear(key)
+	}()
+
+	if dataEncryptingKey, err := v.storage.GetDataEncryptingKey(ctx, s.DataEncryptingKey()); err != nil {
+		return nil, err
+	} else if key, err = v.unwrapDataEncryptingKey(ctx, dataEncryptingKey); err != nil {
+		return nil, err
+	} else if seed, err := openSeed(key, s.Account(), s.DataEncryptingKey(), s.EncryptedSeed()); err != nil {
+		return nil, fmt.Errorf("unable to decrypt seed of account %s: %v", s.Account(), err)
+	} else {
+		return seed, nil
+	}
+}
+
+// sealSeed encrypts the seed of an account under the data encrypting key.
+func (v *vault) sealSeed(ctx context.Context, account interfaces.ID, dataEncryptingKey interfaces.DataEncryptingKey, seed []byte) ([]byte, error) {
+	if key, err := v.unwrapDataEncryptingKey(ctx, dataEncryptingKey); err != nil {
+		return nil, err
+	} else {
+		defer clear(key)
+		return sealSeed(key, account, dataEncryptingKey.ID(), seed)
+	}
+}
+
+func derivePrivateKey(master *hd.Key, index uint32) (*ecdsa.PrivateKey, error) {
+	if key, err := master.Derive(hd.AddressPath(index)); err != nil {
+		return nil, err
+	} else {
+		defer key.Zero()
+		return key.PrivateKey()
+	}
+}
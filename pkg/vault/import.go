@@ -0,0 +1,115 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/hd"
+	"github.com/grexie/signchain-vault/v2/pkg/keystore"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// ImportKey is an existing private key to import as a wallet. Exactly one of
+// PrivateKey, Keystore or Mnemonic is set.
+type ImportKey struct {
+	// PrivateKey is a hex private key, with or without 0x.
+	PrivateKey string `json:"privateKey,omitempty"`
+
+	// Keystore is a V3 keystore, as a JSON object or a string, encrypted with
+	// Password.
+	Keystore json.RawMessage `json:"keystore,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Mnemonic is a BIP-39 mnemonic with an optional Passphrase. The key at
+	// Path is imported, m/44'/60'/0'/0/0 by default.
+	Mnemonic string `json:"mnemonic,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// Clear zeroes the secrets of k which are held in byte slices. Strings can't
+// be cleared, so they are only dropped.
+func (k *ImportKey) Clear() {
+	clear(k.Keystore)
+	*k = ImportKey{}
+}
+
+func (k *ImportKey) privateKey() (*ecdsa.PrivateKey, error) {
+	sources := 0
+	for _, set := range []bool{k.PrivateKey != "", len(k.Keystore) > 0, k.Mnemonic != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "exactly one of privateKey, keystore or mnemonic is required")
+	}
+
+	switch {
+	case k.PrivateKey != "":
+		if privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(k.PrivateKey), "0x")); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid private key: %v", err))
+		} else {
+			return privateKey, nil
+		}
+	case len(k.Keystore) > 0:
+		b := []byte(k.Keystore)
+		var s string
+		if b[0] == '"' {
+			if err := json.Unmarshal(b, &s); err != nil {
+				return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid keystore: %v", err))
+			}
+			b = []byte(s)
+			defer clear(b)
+		}
+
+		if privateKey, err := keystore.Decrypt(b, k.Password); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else {
+			return privateKey, nil
+		}
+	default:
+		path := hd.DefaultPath
+		if k.Path != "" {
+			path = k.Path
+		}
+
+		var seed []byte
+		defer func() {
+			clear(seed)
+		}()
+
+		if p, err := hd.ParsePath(path); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if seed, err = bip39.NewSeedWithErrorChecking(strings.Join(strings.Fields(k.Mnemonic), " "), k.Passphrase); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid mnemonic: %v", err))
+		} else if master, err := hd.NewMasterKey(seed); err != nil {
+			return nil, err
+		} else if key, err := master.Derive(p); err != nil {
+			master.Zero()
+			return nil, err
+		} else {
+			master.Zero()
+			defer key.Zero()
+			return key.PrivateKey()
+		}
+	}
+}
+
+// ImportWallet encrypts an existing private key under a data encrypting key,
+// as CreateWallet does for generated keys. Importing an address which already
+// has a wallet in the account fails with a conflict.
+func (v *vault) ImportWallet(ctx context.Context, account interfaces.ID, name string, key ImportKey) (Wallet, error) {
+	if privateKey, err := key.privateKey(); err != nil {
+		return nil, err
+	} else {
+		defer clear(privateKey.D.Bits())
+		return v.createWallet(ctx, account, name, privateKey)
+	}
+}
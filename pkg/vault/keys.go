@@ -3,21 +3,111 @@ package vault
 import (
 	"context"
 	"crypto/rand"
+	"time"
 
-	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 )
 
 func (v *vault) CreateDataEncryptingKey(ctx context.Context) (interfaces.DataEncryptingKey, error) {
-	var req EncryptRequest
-	var res interop.APIResponse[EncryptResponse]
-	req.Data = make([]byte, 32)
+	id := interfaces.NewDataEncryptingKeyID()
+	key := make([]byte, 32)
+	generation := v.cache.generation()
 
-	if _, err := rand.Read(req.Data); err != nil {
+	if _, err := rand.Read(key); err != nil {
 		return nil, err
-	} else if err := v.auth.Post("/vault/encrypt", &req, &res); err != nil {
+	} else if keyEncryptingKey, encryptedKey, err := v.kek.Encrypt(ctx, id, key); err != nil {
 		return nil, err
+	} else if k, err := v.storage.CreateDataEncryptingKey(ctx, id, keyEncryptingKey, encryptedKey); err != nil {
+		return nil, err
+	} else {
+		// new keys are handed out to the wallet being created straight away
+		v.cache.add(id, key, generation)
+		clear(key)
+		return k, nil
+	}
+}
+
+// unwrapDataEncryptingKey returns a copy of the unwrapped key, which the caller
+// should clear once used. Keys scheduled to expire are unwrapped on every use
+// and dropped from the cache.
+func (v *vault) unwrapDataEncryptingKey(ctx context.Context, dataEncryptingKey interfaces.DataEncryptingKey) ([]byte, error) {
+	generation := v.cache.generation()
+
+	if dataEncryptingKey.Expires() != nil {
+		v.cache.remove(dataEncryptingKey.ID())
+		return v.kek.Decrypt(ctx, dataEncryptingKey.ID(), dataEncryptingKey.KeyEncryptingKey(), dataEncryptingKey.EncryptedKey())
+	} else if key, ok := v.cache.get(dataEncryptingKey.ID()); ok {
+		return key, nil
+	} else if key, err := v.kek.Decrypt(ctx, dataEncryptingKey.ID(), dataEncryptingKey.KeyEncryptingKey(), dataEncryptingKey.EncryptedKey()); err != nil {
+		return nil, err
+	} else {
+		v.cache.add(dataEncryptingKey.ID(), key, generation)
+		return key, nil
+	}
+}
+
+// ExpireDataEncryptingKey schedules the key to expire and drops it from the
+// cache. The key is dropped again once scheduled, as a request which read it
+// before may have cached it in the meantime.
+func (v *vault) ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error) {
+	v.cache.remove(id)
+
+	if k, err := v.storage.ExpireDataEncryptingKey(ctx, id, ttl); err != nil {
+		return nil, err
+	} else {
+		v.cache.expire(id)
+		return k, nil
+	}
+}
+
+// RewrapDataEncryptingKey wraps k with the key encrypting key the provider
+// currently wraps new keys with, leaving the data encrypting key and the
+// wallets encrypted with it unchanged. Providers which can rewrap without
+// exposing the data encrypting key do so. When source is not nil, k is
+// unwrapped with source, which moves keys from another provider to the current
+// one. Returns false when k is already wrapped by the current key encrypting
+// key.
+func (v *vault) RewrapDataEncryptingKey(ctx context.Context, source kek.KeyEncryptingKeyProvider, k interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, bool, error) {
+	var keyEncryptingKey kek.ID
+	var encryptedKey []byte
+
+	// keys the source can't unwrap, such as keys created by the current
+	// provider since the rewrap started, are rewrapped by the current provider
+	if source != nil {
+		if key, err := source.Decrypt(ctx, k.ID(), k.KeyEncryptingKey(), k.EncryptedKey()); err == nil {
+			keyEncryptingKey, encryptedKey, err = v.kek.Encrypt(ctx, k.ID(), key)
+			clear(key)
+			if err != nil {
+				return nil, false, err
+			} else if k, err := v.storage.UpdateDataEncryptingKey(ctx, k.ID(), k.KeyEncryptingKey(), keyEncryptingKey, encryptedKey); err != nil {
+				return nil, false, err
+			} else {
+				return k, true, nil
+			}
+		}
+	}
+
+	if rewrapper, ok := v.kek.(kek.KeyEncryptingKeyRewrapper); ok {
+		var err error
+		if keyEncryptingKey, encryptedKey, err = rewrapper.Rewrap(ctx, k.ID(), k.KeyEncryptingKey(), k.EncryptedKey()); err != nil {
+			return nil, false, err
+		}
+	} else if key, err := v.unwrapDataEncryptingKey(ctx, k); err != nil {
+		return nil, false, err
+	} else {
+		keyEncryptingKey, encryptedKey, err = v.kek.Encrypt(ctx, k.ID(), key)
+		clear(key)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if keyEncryptingKey == k.KeyEncryptingKey() {
+		return k, false, nil
+	} else if k, err := v.storage.UpdateDataEncryptingKey(ctx, k.ID(), k.KeyEncryptingKey(), keyEncryptingKey, encryptedKey); err != nil {
+		return nil, false, err
 	} else {
-		return v.storage.CreateDataEncryptingKey(ctx, res.Data.KeyEncryptingKey, res.Data.EncryptedData)
+		return k, true, nil
 	}
-}
\ No newline at end of file
+}
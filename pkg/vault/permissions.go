@@ -0,0 +1,47 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofiber/fiber/v2"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// Permissions enable signing modes on a wallet which are off by default.
+const (
+	// PermissionSignHash allows signing a precomputed 32 byte digest. The vault
+	// can't tell what it is signing, which could be a transaction or any other
+	// message, so it is granted per wallet.
+	PermissionSignHash = "sign-hash"
+)
+
+var permissions = []string{
+	PermissionSignHash,
+}
+
+// UpdateWalletPermissions replaces the permissions granted to a wallet.
+// Unknown permissions are rejected, and duplicates are dropped.
+func (v *vault) UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, granted []string) (Wallet, error) {
+	out := []string{}
+	for _, permission := range granted {
+		if !slices.Contains(permissions, permission) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown permission %s", permission))
+		} else if !slices.Contains(out, permission) {
+			out = append(out, permission)
+		}
+	}
+	slices.Sort(out)
+
+	if w, err := v.storage.UpdateWalletPermissions(ctx, account, address, out); err != nil {
+		return nil, err
+	} else {
+		w := wallet{
+			vault: v,
+			wallet: w,
+		}
+		return &w, nil
+	}
+}
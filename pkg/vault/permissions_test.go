@@ -0,0 +1,45 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUpdateWalletPermissions(t *testing.T) {
+	ctx := context.Background()
+	v := newTestVault(t)
+
+	w, err := v.CreateWallet(ctx, "account", "wallet")
+	if err != nil {
+		t.Fatal(err)
+	} else if w.HasPermission(PermissionSignHash) {
+		t.Fatal("expected no permissions on a new wallet")
+	}
+
+	if w, err := v.UpdateWalletPermissions(ctx, "account", w.Address(), []string{PermissionSignHash, PermissionSignHash}); err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(w.Permissions(), []string{PermissionSignHash}) {
+		t.Fatalf("expected duplicates to be dropped, got %v", w.Permissions())
+	} else if !w.HasPermission(PermissionSignHash) {
+		t.Fatal("expected the permission to be granted")
+	}
+
+	var e *fiber.Error
+	if _, err := v.UpdateWalletPermissions(ctx, "account", w.Address(), []string{PermissionSignHash, "sign-anything"}); !errors.As(err, &e) || e.Code != fiber.StatusBadRequest {
+		t.Fatalf("expected status %d for an unknown permission, got %v", fiber.StatusBadRequest, err)
+	} else if stored, err := v.GetWallet(ctx, "account", w.Address()); err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(stored.Permissions(), []string{PermissionSignHash}) {
+		t.Fatalf("expected the permissions to be unchanged, got %v", stored.Permissions())
+	}
+
+	if w, err := v.UpdateWalletPermissions(ctx, "account", w.Address(), nil); err != nil {
+		t.Fatal(err)
+	} else if w.HasPermission(PermissionSignHash) {
+		t.Fatal("expected the permission to be revoked")
+	}
+}
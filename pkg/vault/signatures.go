@@ -0,0 +1,19 @@
+package vault
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+)
+
+// CreateIssuedSignature records a signature in the replay protection ledger of
+// the signer, failing with a conflict if one was already issued for uniq.
+func (v *vault) CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error) {
+	return v.storage.CreateIssuedSignature(ctx, account, wallet, sender, uniq, signature, ttl)
+}
+
+func (v *vault) GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error) {
+	return v.storage.GetIssuedSignature(ctx, account, wallet, sender, uniq)
+}
@@ -3,34 +3,72 @@ package vault
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	kek "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 )
 
 type Vault interface {
 	SetStorageBackend(storage interfaces.IStorageBackend) error
+	PurgeCache()
 	interfaces.IVaultService
+	ExpireDataEncryptingKey(ctx context.Context, id interfaces.ID, ttl time.Duration) (interfaces.DataEncryptingKey, error)
+	RewrapDataEncryptingKey(ctx context.Context, source kek.KeyEncryptingKeyProvider, k interfaces.DataEncryptingKey) (interfaces.DataEncryptingKey, bool, error)
 
 	CreateWallet(ctx context.Context, account interfaces.ID, name string) (Wallet, error)
+	DeriveWallets(ctx context.Context, account interfaces.ID, name string, count int64) ([]Wallet, error)
+	ImportWallet(ctx context.Context, account interfaces.ID, name string, key ImportKey) (Wallet, error)
+	ExportWallet(ctx context.Context, account interfaces.ID, address common.Address, passphrase string) ([]byte, error)
 	GetWallet(ctx context.Context, account interfaces.ID, address common.Address) (Wallet, error)
 	ListWallets(ctx context.Context, account interfaces.ID, offset int64, count int64) (ListWalletsResult, error)
 	UpdateWallet(ctx context.Context, account interfaces.ID, address common.Address, name string) (Wallet, error)
 	ExpireWallet(ctx context.Context, account interfaces.ID, address common.Address, ttl time.Duration) (Wallet, error)
 	UnexpireWallet(ctx context.Context, account interfaces.ID, address common.Address) (Wallet, error)
+	UpdateWalletPermissions(ctx context.Context, account interfaces.ID, address common.Address, permissions []string) (Wallet, error)
+	RotateWallet(ctx context.Context, w interfaces.Wallet, to interfaces.DataEncryptingKey) (Wallet, error)
+	UpgradeWallet(ctx context.Context, w interfaces.Wallet) (Wallet, bool, error)
+	RotateSeed(ctx context.Context, s interfaces.Seed, to interfaces.DataEncryptingKey) (interfaces.Seed, error)
+
+	CreateIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash, signature []byte, ttl time.Duration) (interfaces.IssuedSignature, error)
+	GetIssuedSignature(ctx context.Context, account interfaces.ID, wallet common.Address, sender common.Address, uniq common.Hash) (interfaces.IssuedSignature, error)
 }
 
+const (
+	walletModeRandom = "random"
+	walletModeHD = "hd"
+)
+
 type vault struct {
-	auth auth.Auth
+	kek kek.KeyEncryptingKeyProvider
 	storage interfaces.IStorageBackend
+	cache *keyCache
+	walletMode string
 }
 
 var _ Vault = &vault{}
 
-func NewVault(auth auth.Auth) (Vault, error) {
-	v := vault{auth: auth}
+func NewVault(kek kek.KeyEncryptingKeyProvider) (Vault, error) {
+	v := vault{kek: kek, walletMode: walletModeRandom}
+
+	// VAULT_WALLET_MODE=hd derives new wallets from a seed per account, so a
+	// backup of the seed covers every wallet created in the account
+	switch mode := strings.TrimSpace(os.Getenv("VAULT_WALLET_MODE")); mode {
+	case "", walletModeRandom:
+	case walletModeHD:
+		v.walletMode = walletModeHD
+	default:
+		return nil, fmt.Errorf("invalid VAULT_WALLET_MODE %s, expected %s or %s", mode, walletModeRandom, walletModeHD)
+	}
+
+	if cache, err := newKeyCacheFromEnv(); err != nil {
+		return nil, err
+	} else {
+		v.cache = cache
+	}
 
 	return &v, nil
 }
@@ -43,4 +81,10 @@ func (v *vault) SetStorageBackend(storage interfaces.IStorageBackend) error {
 	return nil
 }
 
+// PurgeCache zeroes every cached data encrypting key, used when the key
+// encrypting key provider is sealed.
+func (v *vault) PurgeCache() {
+	v.cache.purge()
+}
+
 
@@ -4,11 +4,14 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/grexie/signchain-vault/v2/pkg/hd"
 	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
 )
 
@@ -17,6 +20,7 @@ type Wallet interface {
 	Address() common.Address
 	PublicKey(ctx context.Context) (ecdsa.PublicKey, error)
 	PrivateKey(ctx context.Context) (ecdsa.PrivateKey, error)
+	HasPermission(permission string) bool
 
 	interfaces.Wallet
 }
@@ -30,14 +34,21 @@ var _ Wallet = &wallet{}
 var _ json.Marshaler = &wallet{}
 
 func (w *wallet) MarshalJSON() ([]byte, error) {
-	return json.Marshal(map[string]any{
+	out := map[string]any{
 		"id": w.ID(),
 		"name": w.Name(),
 		"address": w.Address(),
 		"created": w.Created(),
 		"updated": w.Updated(),
 		"expires": w.Expires(),
-	})
+	}
+	if index, ok := derivationIndex(w.EncryptedPrivateKey()); ok {
+		out["path"] = hd.AddressPath(index).String()
+	}
+	if permissions := w.Permissions(); len(permissions) > 0 {
+		out["permissions"] = permissions
+	}
+	return json.Marshal(out)
 }
 
 func (w *wallet) ID() interfaces.ID {
@@ -64,6 +75,14 @@ func (w *wallet) EncryptedPrivateKey() []byte {
 	return w.wallet.EncryptedPrivateKey()
 }
 
+func (w *wallet) Permissions() []string {
+	return w.wallet.Permissions()
+}
+
+func (w *wallet) HasPermission(permission string) bool {
+	return slices.Contains(w.wallet.Permissions(), permission)
+}
+
 func (w *wallet) Created() time.Time {
 	return w.wallet.Created()
 }
@@ -84,24 +103,18 @@ func (w *wallet) PublicKey(ctx context.Context) (ecdsa.PublicKey, error) {
 	}
 }
 
+// PrivateKey decrypts the private key of the wallet. Private keys in a legacy
+// envelope are upgraded to the current envelope as they are read.
 func (w *wallet) PrivateKey(ctx context.Context) (ecdsa.PrivateKey, error) {
-	var req DecryptRequest
-	var res interop.APIResponse[DecryptResponse]
-	if dataEncryptingKey, err := w.vault.storage.GetDataEncryptingKey(ctx, w.wallet.DataEncryptingKey()); err != nil {
+	if privateKey, version, err := w.vault.openWallet(ctx, w.wallet); err != nil {
 		return ecdsa.PrivateKey{}, err
 	} else {
-		req.KeyEncryptingKey = dataEncryptingKey.KeyEncryptingKey()
-		req.EncryptedData = dataEncryptingKey.EncryptedKey()
-
-		if err := w.vault.auth.Post("/vault/decrypt", &req, &res); err != nil {
-			return ecdsa.PrivateKey{}, err
-		} else if b, err := decrypt(res.Data, w.wallet.EncryptedPrivateKey()); err != nil {
-			return ecdsa.PrivateKey{}, err
-		} else if privateKey, err := crypto.ToECDSA(b); err != nil {
-			return ecdsa.PrivateKey{}, err
-		} else {
-			return *privateKey, nil
+		if version == envelopeLegacy {
+			if _, err := w.vault.upgradeWallet(ctx, w.wallet, privateKey); err != nil {
+				log.Warnf("unable to upgrade private key envelope of wallet %s: %v", w.wallet.Address(), err)
+			}
 		}
+		return *privateKey, nil
 	}
 }
 
@@ -129,35 +142,38 @@ func (r *listWalletsResult) Page() []Wallet {
 	return out
 }
 
+// CreateWallet creates a wallet with a random private key, or derives the next
+// wallet from the seed of the account when the vault is in hd mode.
 func (v *vault) CreateWallet(ctx context.Context, account string, name string) (Wallet, error) {
-	if privateKey, err := crypto.GenerateKey(); err != nil {
+	if v.walletMode == walletModeHD {
+		if wallets, err := v.DeriveWallets(ctx, account, name, 1); err != nil {
+			return nil, err
+		} else {
+			return wallets[0], nil
+		}
+	} else if privateKey, err := crypto.GenerateKey(); err != nil {
 		return nil, err
 	} else {
-		privateKeyBytes := crypto.FromECDSA(privateKey)
+		return v.createWallet(ctx, account, name, privateKey)
+	}
+}
 
-		var req DecryptRequest
-		var res interop.APIResponse[DecryptResponse]
-		if dataEncryptingKey, err := v.storage.GetOrCreateRandomKey(ctx, 1000); err != nil {
-			return nil, err
-		} else {
-			req.KeyEncryptingKey = dataEncryptingKey.KeyEncryptingKey()
-			req.EncryptedData = dataEncryptingKey.EncryptedKey()
-
-			if err := v.auth.Post("/vault/decrypt", &req, &res); err != nil {
-				return nil, err
-			} else if b, err := encrypt(res.Data, privateKeyBytes); err != nil {
-				return nil, err
-			} else if w, err := v.storage.CreateWallet(ctx, account, name, crypto.PubkeyToAddress(privateKey.PublicKey), dataEncryptingKey.ID(), b); err != nil {
-				return nil, err
-			} else {
-				w := wallet{
-					vault:  v,
-					wallet: w,
-				}
-
-				return &w, nil
-			}
+func (v *vault) createWallet(ctx context.Context, account interfaces.ID, name string, privateKey *ecdsa.PrivateKey) (Wallet, error) {
+	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	if dataEncryptingKey, err := v.storage.GetOrCreateRandomKey(ctx, 1000); err != nil {
+		return nil, err
+	} else if b, err := v.sealWallet(ctx, account, address, dataEncryptingKey, privateKey); err != nil {
+		return nil, err
+	} else if w, err := v.storage.CreateWallet(ctx, account, name, address, dataEncryptingKey.ID(), b); err != nil {
+		return nil, err
+	} else {
+		w := wallet{
+			vault:  v,
+			wallet: w,
 		}
+
+		return &w, nil
 	}
 }
 
@@ -231,3 +247,96 @@ func (v *vault) UnexpireWallet(ctx context.Context, account interfaces.ID, addre
 		return &w, nil
 	}
 }
+
+// RotateWallet re-encrypts the private key of w under the data encrypting key
+// to and moves the wallet to it. The move fails with a conflict if the wallet
+// was moved to another data encrypting key since w was read. Derived wallets
+// move with the seed of their account instead.
+func (v *vault) RotateWallet(ctx context.Context, w interfaces.Wallet, to interfaces.DataEncryptingKey) (Wallet, error) {
+	if _, ok := derivationIndex(w.EncryptedPrivateKey()); ok {
+		return v.rotateDerivedWallet(ctx, w, to)
+	} else if privateKey, _, err := v.openWallet(ctx, w); err != nil {
+		return nil, err
+	} else if b, err := v.sealWallet(ctx, w.Account(), w.Address(), to, privateKey); err != nil {
+		return nil, err
+	} else if w, err := v.storage.UpdateWalletDataEncryptingKey(ctx, w.Account(), w.Address(), w.DataEncryptingKey(), to.ID(), b); err != nil {
+		return nil, err
+	} else {
+		w := wallet{
+			vault: v,
+			wallet: w,
+		}
+		return &w, nil
+	}
+}
+
+// UpgradeWallet re-encrypts a private key in a legacy envelope in the current
+// envelope under the same data encrypting key. Returns false when the wallet
+// is already in the current envelope or is derived.
+func (v *vault) UpgradeWallet(ctx context.Context, w interfaces.Wallet) (Wallet, bool, error) {
+	if privateKey, version, err := v.openWallet(ctx, w); err != nil {
+		return nil, false, err
+	} else if version != envelopeLegacy {
+		return &wallet{vault: v, wallet: w}, false, nil
+	} else if w, err := v.upgradeWallet(ctx, w, privateKey); err != nil {
+		return nil, false, err
+	} else {
+		return &wallet{vault: v, wallet: w}, true, nil
+	}
+}
+
+func (v *vault) upgradeWallet(ctx context.Context, w interfaces.Wallet, privateKey *ecdsa.PrivateKey) (interfaces.Wallet, error) {
+	if dataEncryptingKey, err := v.storage.GetDataEncryptingKey(ctx, w.DataEncryptingKey()); err != nil {
+		return nil, err
+	} else if b, err := v.sealWallet(ctx, w.Account(), w.Address(), dataEncryptingKey, privateKey); err != nil {
+		return nil, err
+	} else {
+		return v.storage.UpdateWalletDataEncryptingKey(ctx, w.Account(), w.Address(), dataEncryptingKey.ID(), dataEncryptingKey.ID(), b)
+	}
+}
+
+// openWallet decrypts the private key of w, or derives it when w is derived,
+// and checks that it derives to the wallet address, which catches private
+// keys in a legacy envelope moved between wallets.
+func (v *vault) openWallet(ctx context.Context, w interfaces.Wallet) (*ecdsa.PrivateKey, byte, error) {
+	var key, privateKeyBytes []byte
+	var version byte
+	defer func() {
+		clear(key)
+		clear(privateKeyBytes)
+	}()
+
+	if index, ok := derivationIndex(w.EncryptedPrivateKey()); ok {
+		privateKey, err := v.openDerivedWallet(ctx, w, index)
+		return privateKey, envelopeHD, err
+	} else if dataEncryptingKey, err := v.storage.GetDataEncryptingKey(ctx, w.DataEncryptingKey()); err != nil {
+		return nil, 0, err
+	} else if key, err = v.unwrapDataEncryptingKey(ctx, dataEncryptingKey); err != nil {
+		return nil, 0, err
+	} else if privateKeyBytes, version, err = openPrivateKey(key, w.Account(), w.Address(), w.DataEncryptingKey(), w.EncryptedPrivateKey()); err != nil {
+		return nil, 0, fmt.Errorf("unable to decrypt private key of wallet %s: %v", w.Address(), err)
+	} else if privateKey, err := crypto.ToECDSA(privateKeyBytes); err != nil {
+		return nil, 0, err
+	} else if crypto.PubkeyToAddress(privateKey.PublicKey) != w.Address() {
+		return nil, 0, fmt.Errorf("private key of wallet %s does not derive to the wallet address", w.Address())
+	} else {
+		return privateKey, version, nil
+	}
+}
+
+// sealWallet encrypts a private key for the wallet with address under the data
+// encrypting key.
+func (v *vault) sealWallet(ctx context.Context, account interfaces.ID, address common.Address, dataEncryptingKey interfaces.DataEncryptingKey, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	key, err := v.unwrapDataEncryptingKey(ctx, dataEncryptingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyBytes := crypto.FromECDSA(privateKey)
+	defer func() {
+		clear(key)
+		clear(privateKeyBytes)
+	}()
+
+	return sealPrivateKey(key, account, address, dataEncryptingKey.ID(), privateKeyBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	"github.com/grexie/signchain-vault/v2/pkg/kek"
+	kekinterfaces "github.com/grexie/signchain-vault/v2/pkg/kek/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/rotation"
+)
+
+// newKeyEncryptingKeyProviderFromEnv creates the key encrypting key provider
+// configured by the environment file at filename, used as the source of a
+// rewrap between providers.
+func newKeyEncryptingKeyProviderFromEnv(filename string) (kekinterfaces.KeyEncryptingKeyProvider, error) {
+	var provider kekinterfaces.KeyEncryptingKeyProvider
+
+	err := withEnv(filename, func() error {
+		if auth, err := auth.NewAuth(); err != nil {
+			return err
+		} else if provider, err = kek.NewKeyEncryptingKeyProvider(auth); err != nil {
+			return err
+		} else if sealer, ok := provider.(kekinterfaces.KeyEncryptingKeySealer); ok && sealer.SealStatus().Sealed {
+			return fmt.Errorf("source key encrypting key provider is sealed, use VAULT_LOCAL_KEKS or VAULT_LOCAL_KEK_FILES to rewrap from local keys")
+		} else {
+			return nil
+		}
+	})
+	return provider, err
+}
+
+func runRewrap(args []string) {
+	options, err := rotation.NewRewrapOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("rewrap", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: vault rewrap [options]\n\n")
+		fmt.Fprintf(flags.Output(), "Rewraps data encrypting keys with the key encrypting key VAULT_KEK_PROVIDER\n")
+		fmt.Fprintf(flags.Output(), "currently wraps new keys with. Keys are unwrapped with the same provider, which\n")
+		fmt.Fprintf(flags.Output(), "covers key versions and retired keys it still holds. To move keys from another\n")
+		fmt.Fprintf(flags.Output(), "provider, configure it in an environment file passed with -source.\n\n")
+		flags.PrintDefaults()
+	}
+	source := flags.String("source", "", "environment file configuring the key encrypting key provider to unwrap keys with, VAULT_KEK_PROVIDER when empty")
+	from := flags.String("from", strings.Join(options.From, ","), "comma separated key encrypting key ids to rewrap from, all data encrypting keys are rewrapped when empty")
+	flags.StringVar(&options.CheckpointPath, "checkpoint", options.CheckpointPath, "file recording progress, used to resume an interrupted rewrap")
+	flags.Int64Var(&options.BatchSize, "batch", options.BatchSize, "number of data encrypting keys read at a time")
+	flags.Float64Var(&options.Rate, "rate", options.Rate, "maximum data encrypting keys rewrapped per second, 0 for no limit")
+	flags.Parse(args)
+
+	options.From = nil
+	for _, id := range strings.Split(*from, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			options.From = append(options.From, id)
+		}
+	}
+
+	// stop between keys on interrupt, so the next run resumes from the checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *source != "" {
+		if options.Source, err = newKeyEncryptingKeyProviderFromEnv(*source); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if vault, storage, err := newVaultFromEnv(); err != nil {
+		log.Fatal(err)
+	} else if r, err := rotation.NewRewrap(vault, storage, options); err != nil {
+		log.Fatal(err)
+	} else if err := r.Run(ctx); err != nil {
+		log.Fatalf("rewrap interrupted, run again to resume: %v", err)
+	} else {
+		c := r.Checkpoint()
+		log.Printf("✅ rewrapped %d of %d data encrypting keys", c.Rewrapped, c.Keys)
+	}
+}
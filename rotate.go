@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+	"github.com/grexie/signchain-vault/v2/pkg/kek"
+	"github.com/grexie/signchain-vault/v2/pkg/rotation"
+	"github.com/grexie/signchain-vault/v2/pkg/storage"
+	"github.com/grexie/signchain-vault/v2/pkg/storage/interfaces"
+	"github.com/grexie/signchain-vault/v2/pkg/vault"
+)
+
+// newVaultFromEnv creates the vault and storage backend configured by the
+// environment, as the server does.
+func newVaultFromEnv() (vault.Vault, interfaces.IStorageBackend, error) {
+	if auth, err := auth.NewAuth(); err != nil {
+		return nil, nil, err
+	} else if kek, err := kek.NewKeyEncryptingKeyProvider(auth); err != nil {
+		return nil, nil, err
+	} else if vault, err := vault.NewVault(kek); err != nil {
+		return nil, nil, err
+	} else if storage, err := storage.NewStorage(vault); err != nil {
+		return nil, nil, err
+	} else if err := vault.SetStorageBackend(storage); err != nil {
+		return nil, nil, err
+	} else {
+		return vault, storage, nil
+	}
+}
+
+func runRotate(args []string) {
+	options, err := rotation.NewOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+	flags.StringVar(&options.CheckpointPath, "checkpoint", options.CheckpointPath, "file recording progress, used to resume an interrupted rotation")
+	flags.Int64Var(&options.BatchSize, "batch", options.BatchSize, "number of wallets read at a time")
+	flags.Float64Var(&options.Rate, "rate", options.Rate, "maximum wallets rotated per second, 0 for no limit")
+	flags.DurationVar(&options.KeyTTL, "key-ttl", options.KeyTTL, "time retired data encrypting keys remain readable before they expire")
+	flags.Parse(args)
+
+	// stop between wallets on interrupt, so the next run resumes from the checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if vault, storage, err := newVaultFromEnv(); err != nil {
+		log.Fatal(err)
+	} else if r, err := rotation.NewRotation(vault, storage, options); err != nil {
+		log.Fatal(err)
+	} else if err := r.Run(ctx); err != nil {
+		log.Fatalf("rotation interrupted, run again to resume: %v", err)
+	} else {
+		c := r.Checkpoint()
+		log.Printf("✅ rotated %d wallets and %d seeds, expired %d data encrypting keys", c.Rotated, c.Seeds, c.Expired)
+	}
+}
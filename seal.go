@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grexie/signchain-vault/v2/pkg/api"
+	"github.com/grexie/signchain-vault/v2/pkg/api/interop"
+	"github.com/grexie/signchain-vault/v2/pkg/auth"
+)
+
+// defaultAddr is the address the vault listens on, as configured by PORT and
+// VAULT_INSECURE_HTTP.
+func defaultAddr() string {
+	port := "443"
+	if p, ok := os.LookupEnv("PORT"); ok {
+		port = p
+	}
+
+	if os.Getenv("VAULT_INSECURE_HTTP") == "true" {
+		return fmt.Sprintf("http://localhost:%s", port)
+	} else {
+		return fmt.Sprintf("https://localhost:%s", port)
+	}
+}
+
+// requestSigner adds a signature header for body to an admin request.
+type requestSigner func(req *http.Request, body []byte) error
+
+// operatorSigner signs admin requests with VAULT_OPERATOR_SECRET_KEY.
+func operatorSigner() (requestSigner, error) {
+	key := auth.AuthSecretKey(strings.TrimSpace(os.Getenv("VAULT_OPERATOR_SECRET_KEY")))
+	if key == "" {
+		return nil, fmt.Errorf("VAULT_OPERATOR_SECRET_KEY not configured, it is required for admin commands")
+	}
+
+	return func(req *http.Request, body []byte) error {
+		if signature, err := key.Sign(time.Now(), auth.OperatorSignatureData(req.Method, req.URL.EscapedPath(), body)); err != nil {
+			return err
+		} else {
+			req.Header.Add("X-Vault-Operator-Signature", signature.String())
+			return nil
+		}
+	}, nil
+}
+
+// adminRequest calls an admin endpoint of the running vault at addr, signed
+// with the first vault key, VAULT_AUTH_SECRET_KEY when configured and any
+// additional signers. The vault serves a self-signed certificate, which is
+// only trusted on loopback addresses.
+func adminRequest[E any](addr string, method string, path string, body any, signers ...requestSigner) (E, error) {
+	var res interop.APIResponse[E]
+
+	a, err := auth.NewAuth()
+	if err != nil {
+		return res.Data, err
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return res.Data, fmt.Errorf("invalid vault address %s: %v", addr, err)
+	}
+
+	client := http.Client{Timeout: time.Minute}
+	if ip := net.ParseIP(u.Hostname()); u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback()) {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	if b, err := json.Marshal(body); err != nil {
+		return res.Data, err
+	} else if vaultKey, err := a.VaultKeys().First(); err != nil {
+		return res.Data, err
+	} else if signature, err := vaultKey.Sign(time.Now(), b); err != nil {
+		return res.Data, err
+	} else if req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/") + "/api/v1" + path, bytes.NewReader(b)); err != nil {
+		return res.Data, err
+	} else {
+		req.Header.Add("X-Vault-Key-Hash", vaultKey.HashString())
+		req.Header.Add("X-Vault-Signature", signature.String())
+		req.Header.Add("Content-Type", "application/json")
+
+		if s := strings.TrimSpace(os.Getenv("VAULT_AUTH_SECRET_KEY")); s != "" {
+			if signature, err := auth.AuthSecretKey(s).Sign(time.Now(), b); err != nil {
+				return res.Data, err
+			} else {
+				req.Header.Add("X-Vault-Auth-Signature", signature.String())
+			}
+		}
+
+		for _, signer := range signers {
+			if err := signer(req, b); err != nil {
+				return res.Data, err
+			}
+		}
+
+		r, err := client.Do(req)
+		if err != nil {
+			return res.Data, err
+		}
+		defer r.Body.Close()
+
+		if b, err := io.ReadAll(r.Body); err != nil {
+			return res.Data, err
+		} else if err := json.Unmarshal(b, &res); err != nil {
+			return res.Data, fmt.Errorf("unexpected response from vault: %s", r.Status)
+		} else if !res.Success {
+			if res.Error != nil {
+				return res.Data, fmt.Errorf("%s", *res.Error)
+			}
+			return res.Data, fmt.Errorf("unexpected response from vault: %s", r.Status)
+		} else {
+			return res.Data, nil
+		}
+	}
+}
+
+func printSealStatus(status api.SealStatusResponse) {
+	if !status.Initialized {
+		log.Printf("vault not initialized")
+	} else if status.Sealed {
+		log.Printf("🔒 vault is sealed, %d of %d unseal shares submitted", status.Progress, status.Threshold)
+	} else {
+		log.Printf("🔓 vault is unsealed")
+	}
+}
+
+func runInit(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	addr := flags.String("addr", defaultAddr(), "address of the running vault")
+	shares := flags.Int("shares", 5, "number of unseal shares to split the master key into")
+	threshold := flags.Int("threshold", 3, "number of unseal shares required to unseal the vault")
+	flags.Parse(args)
+
+	signer, err := operatorSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if res, err := adminRequest[api.InitResponse](*addr, "POST", "/admin/init", api.InitRequest{Shares: *shares, Threshold: *threshold}, signer); err != nil {
+		log.Fatal(err)
+	} else {
+		for i, share := range res.Shares {
+			fmt.Printf("Unseal share %d: %s\n", i + 1, base64.StdEncoding.EncodeToString(share))
+		}
+		fmt.Printf("\nDistribute the shares to separate operators. They are not stored by the vault and can't be recovered, %d are required to unseal the vault.\n\n", res.Status.Threshold)
+		printSealStatus(res.Status)
+	}
+}
+
+func runUnseal(args []string) {
+	flags := flag.NewFlagSet("unseal", flag.ExitOnError)
+	addr := flags.String("addr", defaultAddr(), "address of the running vault")
+	flags.Parse(args)
+
+	signer, err := operatorSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// read the share from stdin when not given, which keeps it out of the shell history
+	share := flags.Arg(0)
+	if share == "" {
+		if share, err = readLine("Unseal share: "); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(share)); err != nil {
+		log.Fatalf("invalid unseal share: %v", err)
+	} else if status, err := adminRequest[api.SealStatusResponse](*addr, "POST", "/admin/unseal", api.UnsealRequest{Share: b}, signer); err != nil {
+		log.Fatal(err)
+	} else {
+		printSealStatus(status)
+	}
+}
+
+func runSeal(args []string) {
+	flags := flag.NewFlagSet("seal", flag.ExitOnError)
+	addr := flags.String("addr", defaultAddr(), "address of the running vault")
+	flags.Parse(args)
+
+	signer, err := operatorSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if status, err := adminRequest[api.SealStatusResponse](*addr, "POST", "/admin/seal", nil, signer); err != nil {
+		log.Fatal(err)
+	} else {
+		printSealStatus(status)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/grexie/signchain-vault/v2/pkg/rotation"
+)
+
+func runUpgrade(args []string) {
+	options, err := rotation.NewUpgradeOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	flags.StringVar(&options.CheckpointPath, "checkpoint", options.CheckpointPath, "file recording progress, used to resume an interrupted upgrade")
+	flags.Int64Var(&options.BatchSize, "batch", options.BatchSize, "number of wallets read at a time")
+	flags.Float64Var(&options.Rate, "rate", options.Rate, "maximum wallets upgraded per second, 0 for no limit")
+	flags.Parse(args)
+
+	// stop between wallets on interrupt, so the next run resumes from the checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if vault, storage, err := newVaultFromEnv(); err != nil {
+		log.Fatal(err)
+	} else if u, err := rotation.NewUpgrade(vault, storage, options); err != nil {
+		log.Fatal(err)
+	} else if err := u.Run(ctx); err != nil {
+		log.Fatalf("upgrade interrupted, run again to resume: %v", err)
+	} else {
+		c := u.Checkpoint()
+		log.Printf("✅ upgraded %d of %d wallets", c.Upgraded, c.Wallets)
+	}
+}